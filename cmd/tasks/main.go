@@ -1,30 +1,109 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/SevvyP/tasks_v1/internal/config"
+	"github.com/SevvyP/tasks_v1/internal/logging"
 	"github.com/SevvyP/tasks_v1/internal/server"
 )
 
+// defaultConfigFile is read when no config file is given, if it exists. The deploy mounts the config here.
+const defaultConfigFile = "/etc/tasks_v1/config.json"
+
 func main() {
-	configFile := flag.String("c", "/etc/tasks_v1/config.json", "path to config file")
-	flag.Parse()
-	fmt.Println("Starting server with config file:", *configFile)
-	bytes, err := os.ReadFile(*configFile)
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfig(os.Args[2:]))
+	}
+
+	flags := flag.NewFlagSet("tasks", flag.ExitOnError)
+	configFile := flags.String("c", "", "path to a JSON or YAML config file (default "+defaultConfigFile+" if it exists)")
+	server.AddConfigFlags(flags)
+	flags.Parse(os.Args[1:])
+
+	// Log with the default settings until the config has been read.
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	fatal := func(msg string, err error) {
+		logger.Error(msg, "error", err)
+		os.Exit(1)
+	}
+	path := configPath(*configFile)
+	c, err := server.LoadConfig(path, flags)
+	if err != nil {
+		fatal("Failed to load config", err)
+	}
+	logger, err = logging.New(c.LoggingConfig, os.Stderr)
+	if err != nil {
+		fatal("Failed to set up logging", err)
+	}
+	// Libraries that log with the standard library log package go through the same logger.
+	slog.SetDefault(logger)
+	logger.Info("Starting server", "config_file", path, "addr", c.HTTPConfig.Addr, "grpc_addr", c.HTTPConfig.GRPCAddr)
+	resolver := server.NewResolver(c, logger)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- resolver.Resolve()
+	}()
+
+	// Stop gracefully when the container is stopped, so that in-flight requests finish and the database is closed.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err = <-errs:
+		fatal("Failed to start server", err)
+	case sig := <-signals:
+		logger.Info("Shutting down", "signal", sig.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolver.ShutdownTimeout)
+	err = resolver.Shutdown(ctx)
+	cancel()
 	if err != nil {
-		log.Fatalf("Failed to read config file: %v", err)
+		fatal("Failed to shut down cleanly", err)
+	}
+}
+
+// runConfig runs the config subcommand and returns the exit code. "tasks config print" loads the config
+// from the same file, environment variables and flags the server would, and writes it as JSON.
+// With --redacted, secrets such as the database password are left out.
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: tasks config print [--redacted] [-c file] [flags]")
+		return 2
 	}
-	var c server.Config
-	err = json.Unmarshal(bytes, &c)
+	flags := flag.NewFlagSet("tasks config print", flag.ExitOnError)
+	configFile := flags.String("c", "", "path to a JSON or YAML config file (default "+defaultConfigFile+" if it exists)")
+	redacted := flags.Bool("redacted", false, "replace secrets with "+config.Redacted)
+	server.AddConfigFlags(flags)
+	flags.Parse(args[1:])
+
+	c, err := server.LoadConfig(configPath(*configFile), flags)
 	if err != nil {
-		log.Fatalf("Failed to unmarshal config: %v", err)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
-	err = server.NewResolver(&c).Resolve()
+	err = config.Print(os.Stdout, c, *redacted)
 	if err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// configPath returns the config file to read: the one given, or else the default one if it exists.
+// An empty path means the config comes from the defaults, environment variables and flags alone.
+func configPath(given string) string {
+	if given != "" {
+		return given
+	}
+	if _, err := os.Stat(defaultConfigFile); err == nil {
+		return defaultConfigFile
 	}
+	return ""
 }
@@ -0,0 +1,169 @@
+// Package authtest mints JWTs for tests, so that they can run requests through the real authentication
+// middleware instead of replacing it. An Issuer signs tokens with a key of its own and provides the AuthConfig
+// that accepts them, which checks tokens without reaching the network.
+package authtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/go-jose/go-jose.v2"
+	"gopkg.in/go-jose/go-jose.v2/jwt"
+
+	"github.com/SevvyP/tasks_v1/internal/middleware"
+)
+
+// Defaults of the tokens minted by an Issuer.
+const (
+	IssuerURL = "https://issuer.tasks.test/"
+	Audience  = "tasks"
+	// Lifetime is how long minted tokens are valid for.
+	Lifetime = time.Hour
+)
+
+// keyID is the kid of the signing key of an Issuer created with New.
+const keyID = "authtest"
+
+// Issuer mints tokens for tests.
+type Issuer struct {
+	signer jose.Signer
+	config *middleware.AuthConfig
+}
+
+// New creates an Issuer signing tokens with a new ES256 key, whose public half is written to a JWKS file in a
+// temporary directory that is removed when the test ends.
+func New(t testing.TB) *Issuer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate signing key: %v", err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", keyID))
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	keySet := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &key.PublicKey, KeyID: keyID, Algorithm: string(jose.ES256), Use: "sig"},
+	}}
+	data, err := json.Marshal(keySet)
+	if err != nil {
+		t.Fatalf("Failed to encode JWKS: %v", err)
+	}
+	return &Issuer{
+		signer: signer,
+		config: &middleware.AuthConfig{IssuerURL: IssuerURL, Audience: Audience, JWKSFile: writeFile(t, "jwks.json", data)},
+	}
+}
+
+// NewPublicKey creates an Issuer signing tokens with a new EdDSA key, whose public half is written to a PEM file
+// in a temporary directory that is removed when the test ends.
+func NewPublicKey(t testing.TB) *Issuer {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate signing key: %v", err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.EdDSA, Key: privateKey},
+		(&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatalf("Failed to encode public key: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	return &Issuer{
+		signer: signer,
+		config: &middleware.AuthConfig{IssuerURL: IssuerURL, Audience: Audience, PublicKeyFile: writeFile(t, "public.pem", data)},
+	}
+}
+
+// NewHMAC creates an Issuer signing tokens with HS256 and secret, as accepted by a config with that hmac_secret.
+func NewHMAC(t testing.TB, secret string) *Issuer {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: []byte(secret)},
+		(&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return &Issuer{
+		signer: signer,
+		config: &middleware.AuthConfig{IssuerURL: IssuerURL, Audience: Audience, HMACSecret: secret},
+	}
+}
+
+// AuthConfig returns a copy of the config that accepts the Issuer's tokens.
+func (i *Issuer) AuthConfig() *middleware.AuthConfig {
+	config := *i.config
+	return &config
+}
+
+// IssuerConfig returns the config of an issuer in AuthConfig.Issuers that accepts the Issuer's tokens.
+// Issuers created with NewHMAC have none, as only AuthConfig's own fields can hold an HS256 secret.
+func (i *Issuer) IssuerConfig() middleware.IssuerConfig {
+	return middleware.IssuerConfig{
+		URL:           i.config.Issuer(),
+		Audience:      i.config.Audience,
+		JWKSFile:      i.config.JWKSFile,
+		PublicKeyFile: i.config.PublicKeyFile,
+	}
+}
+
+// WithURL returns a copy of the Issuer whose tokens name url as their issuer.
+func (i *Issuer) WithURL(url string) *Issuer {
+	config := *i.config
+	config.IssuerURL = url
+	return &Issuer{signer: i.signer, config: &config}
+}
+
+// Claims returns the claims of a valid token for subject, which tests can change before passing them to Sign.
+func (i *Issuer) Claims(subject string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss": i.config.Issuer(),
+		"aud": []string{i.config.Audience},
+		"sub": subject,
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(Lifetime).Unix(),
+	}
+}
+
+// Token returns a valid token for subject.
+func (i *Issuer) Token(t testing.TB, subject string) string {
+	t.Helper()
+	return i.Sign(t, i.Claims(subject))
+}
+
+// Sign returns a token with the given claims.
+func (i *Issuer) Sign(t testing.TB, claims map[string]interface{}) string {
+	t.Helper()
+	token, err := jwt.Signed(i.signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return token
+}
+
+// writeFile writes data to a file in a temporary directory of the test and returns its path.
+func writeFile(t testing.TB, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
@@ -0,0 +1,260 @@
+// Package config loads settings into a config struct in layers: the defaults already in the struct, then a JSON
+// or YAML file, then environment variables, then command-line flags. Each setting is named by the path of JSON keys
+// leading to it, so postgres.password in a file is TASKS_POSTGRES_PASSWORD in the environment and -postgres.password
+// on the command line.
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// EnvPrefix is prepended to the name of every environment variable read by Load.
+const EnvPrefix = "TASKS_"
+
+// Redacted replaces the value of secret settings printed by Print.
+const Redacted = "REDACTED"
+
+var (
+	jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// setting is a field of a config struct that holds a value rather than more settings.
+type setting struct {
+	// path is the JSON key of the field and of each struct it is nested in.
+	path []string
+	// index is the field index of the field and of each struct it is nested in.
+	index []int
+	// secret is set for fields tagged `secret:"true"`, which Print can redact.
+	secret bool
+}
+
+// name returns the dotted name of the setting, such as "postgres.password", which is also its flag.
+func (s setting) name() string {
+	return strings.Join(s.path, ".")
+}
+
+// env returns the environment variable the setting is read from, such as "TASKS_POSTGRES_PASSWORD".
+func (s setting) env() string {
+	return EnvPrefix + strings.ToUpper(strings.Join(s.path, "_"))
+}
+
+// settings returns every setting of a config struct type. Fields without a JSON key are not settings.
+func settings(t reflect.Type) []setting {
+	return appendSettings(nil, t, nil, nil)
+}
+
+func appendSettings(settings []setting, t reflect.Type, path []string, index []int) []setting {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || key == "" || key == "-" {
+			continue
+		}
+		fieldPath := append(append([]string{}, path...), key)
+		fieldIndex := append(append([]int{}, index...), i)
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() == reflect.Struct && !isValue(fieldType) {
+			settings = appendSettings(settings, fieldType, fieldPath, fieldIndex)
+			continue
+		}
+		settings = append(settings, setting{path: fieldPath, index: fieldIndex, secret: field.Tag.Get("secret") == "true"})
+	}
+	return settings
+}
+
+// isValue reports whether a struct type decodes itself, and so is a single setting rather than a group of them.
+func isValue(t reflect.Type) bool {
+	pointer := reflect.PointerTo(t)
+	return pointer.Implements(jsonUnmarshaler) || pointer.Implements(textUnmarshaler)
+}
+
+// set parses raw as the value of a setting of the struct target points to, allocating any nil struct it is nested in.
+// Strings, durations and other values that decode from JSON strings are used as they are, lists are separated
+// by commas and anything else is parsed as JSON, such as "true" or "30".
+func (s setting) set(target reflect.Value, raw string) error {
+	v := target.Elem()
+	for _, i := range s.index {
+		if v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+
+	valueType := v.Type()
+	if valueType.Kind() == reflect.Pointer {
+		valueType = valueType.Elem()
+	}
+	var data []byte
+	var err error
+	switch {
+	case valueType.Kind() == reflect.String || isValue(valueType):
+		data, err = json.Marshal(raw)
+	case valueType.Kind() == reflect.Slice && valueType.Elem().Kind() == reflect.String:
+		items := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		data, err = json.Marshal(items)
+	default:
+		data = []byte(raw)
+	}
+	if err == nil {
+		err = json.Unmarshal(data, v.Addr().Interface())
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value %q: %v", raw, err)
+	}
+	return nil
+}
+
+// settingFlag is the flag for a setting. It holds the value it was given until Load applies it over the other layers.
+type settingFlag struct {
+	setting setting
+	value   string
+}
+
+func (f *settingFlag) String() string {
+	return f.value
+}
+
+func (f *settingFlag) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+// AddFlags adds a flag to fs for every setting of target, a pointer to a config struct.
+// The flags are only applied by Load, after the config file and environment variables.
+func AddFlags(fs *flag.FlagSet, target interface{}) {
+	for _, s := range settings(reflect.TypeOf(target).Elem()) {
+		fs.Var(&settingFlag{setting: s}, s.name(), "overrides "+s.name()+" and $"+s.env())
+	}
+}
+
+// Load layers settings into target, a pointer to a config struct holding the defaults. The file at path is read
+// first if path is not empty, as YAML if it ends in .yaml or .yml and as JSON otherwise, and must not contain
+// unknown keys. Then each setting with an environment variable is replaced, and then each one given a flag on fs,
+// which may be nil, by AddFlags.
+func Load(target interface{}, path string, fs *flag.FlagSet) error {
+	if path != "" {
+		err := loadFile(target, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	v := reflect.ValueOf(target)
+	for _, s := range settings(v.Type().Elem()) {
+		raw, ok := os.LookupEnv(s.env())
+		if !ok {
+			continue
+		}
+		err := s.set(v, raw)
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.env(), err)
+		}
+	}
+
+	if fs == nil {
+		return nil
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		sf, ok := f.Value.(*settingFlag)
+		if !ok || err != nil {
+			return
+		}
+		err = sf.setting.set(v, sf.value)
+		if err != nil {
+			err = fmt.Errorf("-%s: %v", f.Name, err)
+		}
+	})
+	return err
+}
+
+// loadFile decodes a JSON or YAML config file into target.
+func loadFile(target interface{}, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		data, err = yaml.YAMLToJSON(data)
+		if err != nil {
+			return fmt.Errorf("failed to parse config file %s: %v", path, err)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(target)
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Print writes the config target points to as indented JSON. If redacted is set, every secret setting
+// that is not empty is replaced by Redacted.
+func Print(w io.Writer, target interface{}, redacted bool) error {
+	data, err := json.Marshal(target)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %v", err)
+	}
+	var values map[string]interface{}
+	err = json.Unmarshal(data, &values)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %v", err)
+	}
+
+	if redacted {
+		for _, s := range settings(reflect.TypeOf(target).Elem()) {
+			if s.secret {
+				redact(values, s.path)
+			}
+		}
+	}
+
+	data, err = json.MarshalIndent(values, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %v", err)
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// redact replaces the value at path in decoded JSON, unless it is missing or empty.
+func redact(values map[string]interface{}, path []string) {
+	for _, key := range path[:len(path)-1] {
+		nested, ok := values[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		values = nested
+	}
+	key := path[len(path)-1]
+	if value, ok := values[key]; ok && value != nil && value != "" {
+		values[key] = Redacted
+	}
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testDuration decodes from a JSON string, as durations in config files do.
+type testDuration time.Duration
+
+func (d *testDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	*d = testDuration(duration)
+	return err
+}
+
+type testDatabase struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Password string `json:"password" secret:"true"`
+}
+
+type testConfig struct {
+	Database *testDatabase `json:"database"`
+	Timeout  testDuration  `json:"timeout"`
+	Brokers  []string      `json:"brokers"`
+	Ratio    *float64      `json:"ratio"`
+	Debug    bool          `json:"debug"`
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		fileName    string
+		env         map[string]string
+		args        []string
+		expected    testConfig
+		expectedErr string
+	}{
+		{
+			name:     "Defaults",
+			expected: testConfig{Database: &testDatabase{Host: "localhost", Port: 5432}, Timeout: testDuration(time.Second)},
+		},
+		{
+			name:     "JSONFile",
+			fileName: "config.json",
+			file:     `{"database": {"host": "db", "password": "file"}, "debug": true}`,
+			expected: testConfig{Database: &testDatabase{Host: "db", Port: 5432, Password: "file"}, Timeout: testDuration(time.Second), Debug: true},
+		},
+		{
+			name:     "YAMLFile",
+			fileName: "config.yaml",
+			file:     "database:\n  host: db\ntimeout: 1m\nbrokers: [a, b]\n",
+			expected: testConfig{Database: &testDatabase{Host: "db", Port: 5432}, Timeout: testDuration(time.Minute), Brokers: []string{"a", "b"}},
+		},
+		{
+			name:     "EnvOverridesFile",
+			fileName: "config.json",
+			file:     `{"database": {"host": "db", "password": "file"}}`,
+			env: map[string]string{
+				"TASKS_DATABASE_PASSWORD": "env",
+				"TASKS_DATABASE_PORT":     "6432",
+				"TASKS_TIMEOUT":           "5s",
+				"TASKS_BROKERS":           "a:9092, b:9092",
+				"TASKS_RATIO":             "0.5",
+			},
+			expected: testConfig{Database: &testDatabase{Host: "db", Port: 6432, Password: "env"}, Timeout: testDuration(5 * time.Second), Brokers: []string{"a:9092", "b:9092"}, Ratio: ptr(0.5)},
+		},
+		{
+			name:     "FlagsOverrideEnv",
+			env:      map[string]string{"TASKS_DATABASE_HOST": "env", "TASKS_DEBUG": "true"},
+			args:     []string{"-database.host", "flag", "--debug=false"},
+			expected: testConfig{Database: &testDatabase{Host: "flag", Port: 5432}, Timeout: testDuration(time.Second)},
+		},
+		{
+			name:        "UnknownKey",
+			fileName:    "config.json",
+			file:        `{"database": {"hots": "db"}}`,
+			expectedErr: `json: unknown field "hots"`,
+		},
+		{
+			name:        "InvalidEnv",
+			env:         map[string]string{"TASKS_DATABASE_PORT": "five"},
+			expectedErr: `TASKS_DATABASE_PORT: invalid value "five"`,
+		},
+		{
+			name:        "InvalidFlag",
+			args:        []string{"-timeout", "soon"},
+			expectedErr: `-timeout: invalid value "soon"`,
+		},
+		{
+			name:        "MissingFile",
+			fileName:    "missing.json",
+			expectedErr: "failed to read config file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			path := ""
+			if tt.fileName != "" {
+				path = filepath.Join(t.TempDir(), tt.fileName)
+				if tt.file != "" {
+					path = writeFile(t, tt.fileName, tt.file)
+				}
+			}
+			c := testConfig{Database: &testDatabase{Host: "localhost", Port: 5432}, Timeout: testDuration(time.Second)}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			AddFlags(fs, &c)
+			assert.NoError(t, fs.Parse(tt.args))
+
+			err := Load(&c, path, fs)
+			if tt.expectedErr != "" {
+				assert.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, c)
+		})
+	}
+}
+
+func TestLoadAllocatesNestedConfig(t *testing.T) {
+	t.Setenv("TASKS_DATABASE_HOST", "db")
+	var c testConfig
+	assert.NoError(t, Load(&c, "", nil))
+	assert.Equal(t, &testDatabase{Host: "db"}, c.Database)
+}
+
+func TestPrint(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   testConfig
+		redacted bool
+		expected string
+	}{
+		{
+			name:     "Redacted",
+			config:   testConfig{Database: &testDatabase{Host: "db", Password: "secret"}},
+			redacted: true,
+			expected: `"password": "REDACTED"`,
+		},
+		{
+			name:     "RedactedEmpty",
+			config:   testConfig{Database: &testDatabase{Host: "db"}},
+			redacted: true,
+			expected: `"password": ""`,
+		},
+		{
+			name:     "NotRedacted",
+			config:   testConfig{Database: &testDatabase{Host: "db", Password: "secret"}},
+			expected: `"password": "secret"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			assert.NoError(t, Print(&output, &tt.config, tt.redacted))
+			assert.Contains(t, output.String(), tt.expected)
+			assert.Contains(t, output.String(), `"host": "db"`)
+		})
+	}
+}
+
+func ptr(f float64) *float64 {
+	return &f
+}
@@ -0,0 +1,243 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/SevvyP/tasks_v1/internal/config"
+)
+
+// SSL modes that can be selected in PostgresConfig.
+const (
+	SSLModeDisable    = "disable"
+	SSLModeRequire    = "require"
+	SSLModeVerifyCA   = "verify-ca"
+	SSLModeVerifyFull = "verify-full"
+)
+
+// Default connection settings, used for any setting left out of PostgresConfig.
+const (
+	defaultPort            = "5432"
+	defaultMaxOpenConns    = 20
+	defaultMaxIdleConns    = 10
+	defaultConnMaxLifetime = 30 * time.Minute
+	defaultConnMaxIdleTime = 5 * time.Minute
+	defaultConnectAttempts = 5
+	defaultConnectTimeout  = 5 * time.Second
+	defaultConnectBackoff  = time.Second
+	defaultMaxReplicaLag   = 5 * time.Second
+	defaultReplicaCheck    = 5 * time.Second
+	defaultReadYourWrites  = 15 * time.Second
+	// maxConnectBackoff caps the wait between connection attempts at startup.
+	maxConnectBackoff = 30 * time.Second
+)
+
+// PostgresConfig contains the connection settings of the database.
+// PasswordFile and DSNFile name files holding the password and DSN, such as mounted secrets, and are read in place
+// of Password and DSN.
+type PostgresConfig struct {
+	// DSN is a complete connection string, either a URL or key=value pairs, used in place of the connection
+	// and TLS settings. The pool and startup settings still apply.
+	DSN          string `json:"dsn" secret:"true"`
+	DSNFile      string `json:"dsn_file"`
+	Host         string `json:"host"`
+	Port         string `json:"port"`
+	Username     string `json:"username"`
+	Password     string `json:"password" secret:"true"`
+	PasswordFile string `json:"password_file"`
+	Database     string `json:"database"`
+	// SSLMode is "disable", "require", "verify-ca" or "verify-full". It defaults to "disable" if Host is localhost
+	// and to "require" otherwise. "require" encrypts the connection without checking the server's certificate.
+	SSLMode string `json:"sslmode"`
+	// SSLRootCert is the path of the CA certificates the server's certificate is checked against
+	// in the verify-ca and verify-full modes.
+	SSLRootCert string `json:"sslrootcert"`
+	// SSLCert and SSLKey are the paths of a client certificate and its key, for servers that require one.
+	SSLCert string `json:"sslcert"`
+	SSLKey  string `json:"sslkey"`
+	// MaxOpenConns and MaxIdleConns limit the connections in the pool, and ConnMaxLifetime and ConnMaxIdleTime
+	// limit how long a connection is reused. Zero selects the default.
+	MaxOpenConns    int             `json:"max_open_conns"`
+	MaxIdleConns    int             `json:"max_idle_conns"`
+	ConnMaxLifetime config.Duration `json:"conn_max_lifetime"`
+	ConnMaxIdleTime config.Duration `json:"conn_max_idle_time"`
+	// ConnectAttempts is how many times the database is pinged at startup before giving up. The first retry
+	// waits ConnectBackoff, and each one after that waits twice as long as the last. Each ping is given ConnectTimeout.
+	ConnectAttempts int             `json:"connect_attempts"`
+	ConnectTimeout  config.Duration `json:"connect_timeout"`
+	ConnectBackoff  config.Duration `json:"connect_backoff"`
+	// SkipMigrations turns off applying pending migrations at startup, for databases migrated separately
+	// or connected to with a role that cannot change the schema.
+	SkipMigrations bool `json:"skip_migrations"`
+	// Replicas are the hosts of read replicas, with the same credentials, database and TLS settings as Host.
+	// A host may include a port, and otherwise uses Port. Task reads are spread over the replicas, and the lag of each
+	// one is checked every ReplicaCheckInterval. A replica more than MaxReplicaLag behind, or that cannot be reached,
+	// is removed from the rotation until it catches up. Clients that wrote within ReadYourWritesWindow read from
+	// the primary, so the window should be longer than MaxReplicaLag and ReplicaCheckInterval together.
+	Replicas             []string        `json:"replicas"`
+	MaxReplicaLag        config.Duration `json:"max_replica_lag"`
+	ReplicaCheckInterval config.Duration `json:"replica_check_interval"`
+	ReadYourWritesWindow config.Duration `json:"read_your_writes_window"`
+}
+
+// WithDefaults returns a copy of the config with the default for every setting that is not set.
+func (c *PostgresConfig) WithDefaults() PostgresConfig {
+	result := PostgresConfig{}
+	if c != nil {
+		result = *c
+	}
+	if result.Port == "" {
+		result.Port = defaultPort
+	}
+	if result.MaxOpenConns == 0 {
+		result.MaxOpenConns = defaultMaxOpenConns
+	}
+	if result.MaxIdleConns == 0 {
+		result.MaxIdleConns = defaultMaxIdleConns
+	}
+	if result.ConnMaxLifetime == 0 {
+		result.ConnMaxLifetime = config.Duration(defaultConnMaxLifetime)
+	}
+	if result.ConnMaxIdleTime == 0 {
+		result.ConnMaxIdleTime = config.Duration(defaultConnMaxIdleTime)
+	}
+	if result.ConnectAttempts == 0 {
+		result.ConnectAttempts = defaultConnectAttempts
+	}
+	if result.ConnectTimeout == 0 {
+		result.ConnectTimeout = config.Duration(defaultConnectTimeout)
+	}
+	if result.ConnectBackoff == 0 {
+		result.ConnectBackoff = config.Duration(defaultConnectBackoff)
+	}
+	if result.MaxReplicaLag == 0 {
+		result.MaxReplicaLag = config.Duration(defaultMaxReplicaLag)
+	}
+	if result.ReplicaCheckInterval == 0 {
+		result.ReplicaCheckInterval = config.Duration(defaultReplicaCheck)
+	}
+	if result.ReadYourWritesWindow == 0 {
+		result.ReadYourWritesWindow = config.Duration(defaultReadYourWrites)
+	}
+	return result
+}
+
+// connectionString returns the DSN if there is one, and otherwise a URL built from the connection and TLS settings.
+func (c *PostgresConfig) connectionString() string {
+	if c.DSN != "" {
+		return c.DSN
+	}
+
+	sslMode := c.SSLMode
+	if sslMode == "" {
+		sslMode = SSLModeRequire
+		if c.Host == "localhost" {
+			sslMode = SSLModeDisable
+		}
+	}
+	query := url.Values{"sslmode": {sslMode}}
+	if c.SSLRootCert != "" {
+		query.Set("sslrootcert", c.SSLRootCert)
+	}
+	if c.SSLCert != "" {
+		query.Set("sslcert", c.SSLCert)
+	}
+	if c.SSLKey != "" {
+		query.Set("sslkey", c.SSLKey)
+	}
+	connURL := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.Username, c.Password),
+		Host:     net.JoinHostPort(c.Host, c.Port),
+		Path:     "/" + c.Database,
+		RawQuery: query.Encode(),
+	}
+	return connURL.String()
+}
+
+// NewDatabase opens a connection pool to the configured database and waits until the database answers a ping,
+// retrying with backoff, so that a server that cannot reach it fails at startup rather than on its first request.
+// It then applies any pending migrations, unless SkipMigrations is set.
+// Replicas that cannot be reached do not fail startup, but are left out of the rotation until they can.
+// Errors that cannot be returned, such as those of the task event listener, are written to logger.
+func NewDatabase(config *PostgresConfig, logger *slog.Logger) (*PostgresDatabase, error) {
+	if config == nil {
+		return nil, fmt.Errorf("config is nil")
+	}
+	settings := config.WithDefaults()
+	db, err := openPool(settings)
+	if err != nil {
+		return nil, err
+	}
+	d := &PostgresDatabase{
+		db:                   db,
+		connStr:              settings.connectionString(),
+		logger:               logger,
+		maxReplicaLag:        time.Duration(settings.MaxReplicaLag),
+		replicaCheckTimeout:  time.Duration(settings.ConnectTimeout),
+		readYourWritesWindow: time.Duration(settings.ReadYourWritesWindow),
+		stopMonitor:          func() {},
+	}
+	err = d.waitForConnection(settings)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if !settings.SkipMigrations {
+		err = d.Migrate(context.Background())
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	d.replicas, err = openReplicas(settings)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if len(d.replicas) > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		d.stopMonitor = cancel
+		d.checkReplicas(ctx)
+		go d.monitorReplicas(ctx, time.Duration(settings.ReplicaCheckInterval))
+	}
+	return d, nil
+}
+
+// openPool opens a connection pool with the connection and pool settings of the config.
+func openPool(settings PostgresConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", settings.connectionString())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+	db.SetMaxOpenConns(settings.MaxOpenConns)
+	db.SetMaxIdleConns(settings.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(settings.ConnMaxLifetime))
+	db.SetConnMaxIdleTime(time.Duration(settings.ConnMaxIdleTime))
+	return db, nil
+}
+
+// waitForConnection pings the database until it answers or the configured attempts run out.
+func (d *PostgresDatabase) waitForConnection(settings PostgresConfig) error {
+	backoff := time.Duration(settings.ConnectBackoff)
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(settings.ConnectTimeout))
+		err := d.Ping(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if attempt >= settings.ConnectAttempts {
+			return fmt.Errorf("failed to connect to database after %d attempts: %v", attempt, err)
+		}
+		d.logger.Warn("Failed to connect to database, retrying", "attempt", attempt, "retry_in", backoff.String(), "error", err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxConnectBackoff)
+	}
+}
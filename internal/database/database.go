@@ -1,55 +1,169 @@
 package database
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"net/url"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+	"time"
 
 	"github.com/SevvyP/tasks_v1/pkg/model"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
-type PostgresConfig struct {
-	Host     string `json:"host"`
-	Port     string `json:"port"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Database string `json:"database"`
+type TaskDatabase interface {
+	GetTasks(ctx context.Context) (*[]model.Task, error)
+	GetTaskByID(ctx context.Context, id string) (*model.Task, error)
+	GetTaskByIDFromPrimary(ctx context.Context, id string) (*model.Task, error)
+	GetTasksByUserID(ctx context.Context, userID string) (*[]model.Task, error)
+	GetTasksByIDs(ctx context.Context, ids []string) (*[]model.Task, error)
+	GetTasksByParentIDs(ctx context.Context, parentIDs []string) (*[]model.Task, error)
+	GetRemindersByIDs(ctx context.Context, ids []string) (*[]model.Reminder, error)
+	CreateTask(ctx context.Context, task model.Task) error
+	UpdateTask(ctx context.Context, task model.Task) error
+	DeleteTask(ctx context.Context, task model.Task) error
+	ReserveIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (*model.IdempotencyRecord, error)
+	SaveIdempotencyRecord(ctx context.Context, record model.IdempotencyRecord) error
+	ReleaseIdempotencyKey(ctx context.Context, userID string, key string) error
+	TakeRateLimitToken(ctx context.Context, key string, capacity float64, refillPerSecond float64) (float64, bool, error)
+	PruneRateLimitBuckets(ctx context.Context, updatedBefore time.Time) (int64, error)
+	GetTaskEvents(ctx context.Context, userID string, afterID int64) (*[]model.TaskEvent, error)
+	GetLatestTaskEventID(ctx context.Context, userID string) (int64, error)
+	ListenTaskEvents(ctx context.Context) (<-chan string, error)
+	GetTaskChanges(ctx context.Context, userID string, since int64) (*model.TaskChanges, error)
+	GetWebhooksByUserID(ctx context.Context, userID string) (*[]model.Webhook, error)
+	GetWebhookByID(ctx context.Context, id string) (*model.Webhook, error)
+	CreateWebhook(ctx context.Context, webhook model.Webhook) error
+	UpdateWebhook(ctx context.Context, webhook model.Webhook) error
+	DeleteWebhook(ctx context.Context, webhook model.Webhook) error
+	CreateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error
+	GetWebhookDeliveries(ctx context.Context, webhookID string, status string) (*[]model.WebhookDelivery, error)
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) (*[]model.WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error
+	GetAPITokensByUserID(ctx context.Context, userID string) (*[]model.APIToken, error)
+	GetAPITokenByID(ctx context.Context, id string) (*model.APIToken, error)
+	GetAPITokenByHash(ctx context.Context, hash string) (*model.APIToken, error)
+	CreateAPIToken(ctx context.Context, token model.APIToken) error
+	DeleteAPIToken(ctx context.Context, token model.APIToken) error
+	TouchAPIToken(ctx context.Context, id string, usedAt time.Time) error
+	GetUser(ctx context.Context, id string) (*model.User, error)
+	UpsertUser(ctx context.Context, user model.User) error
+	UpdateUser(ctx context.Context, user model.User) error
+	ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) (*[]model.OutboxMessage, error)
+	MarkOutboxMessagesPublished(ctx context.Context, ids []string) error
+	PruneOutboxMessages(ctx context.Context, publishedBefore time.Time) (int64, error)
+	Ping(ctx context.Context) error
+	GetSchemaVersion(ctx context.Context) (int, error)
+	Close() error
 }
 
-type TaskDatabase interface {
-	GetTasks() (*[]model.Task, error)
-	GetTaskByID(id string) (*model.Task, error)
-	GetTasksByUserID(userID string) (*[]model.Task, error)
-	CreateTask(task model.Task) error
-	UpdateTask(task model.Task) error
-	DeleteTask(task model.Task) error
+// SchemaVersion is the version of the schema that this code expects, the version of the latest migration.
+// Whenever the schema changes, add a migration for the next version, bump it, and update local/tasks.sql to match.
+const SchemaVersion = 5
+
+// ErrTaskChanged is returned by UpdateTask and DeleteTask when the task was given a Version and it no longer
+// has that version, because it changed or was deleted since it was read.
+var ErrTaskChanged = errors.New("task changed since it was read")
+
+// taskEventsChannel is the Postgres NOTIFY channel used to announce new task events.
+// The payload is the ID of the user the event belongs to.
+const taskEventsChannel = "task_events"
+
+// outboxTopicPrefix is prepended to the task event type to form the topic of its outbox message, such as "tasks.created".
+const outboxTopicPrefix = "tasks."
+
+// taskEventsBatchSize is the maximum number of task events returned by a single GetTaskEvents call.
+const taskEventsBatchSize = 500
+
+// taskColumns lists the task columns in the order scanTask reads them.
+const taskColumns = "id, user_id, body, completed, parent, reminder, change_seq, updated_at"
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
+// scanTask reads a row selected with taskColumns into a task.
+func scanTask(row rowScanner) (model.Task, error) {
+	var task model.Task
+	var updatedAt time.Time
+	err := row.Scan(&task.ID, &task.UserID, &task.Body, &task.Completed, &task.Parent, &task.Reminder, &task.Version, &updatedAt)
+	if err != nil {
+		return task, err
+	}
+	task.UpdatedAt = &updatedAt
+	return task, nil
+}
+
+// PostgresDatabase is the TaskDatabase backed by Postgres.
 type PostgresDatabase struct {
-	db *sql.DB
+	db      *sql.DB
+	connStr string
+	logger  *slog.Logger
+
+	replicas []*replica
+	// nextReplica picks the replica the next read starts looking from, to spread reads over them.
+	nextReplica          atomic.Uint64
+	maxReplicaLag        time.Duration
+	replicaCheckTimeout  time.Duration
+	readYourWritesWindow time.Duration
+	// stopMonitor stops checking the lag of the replicas.
+	stopMonitor context.CancelFunc
 }
 
-func NewDatabase(config *PostgresConfig) (*PostgresDatabase, error) {
-	if config == nil {
-		return nil, fmt.Errorf("config is nil")
-	}
-	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s", config.Username, url.QueryEscape(config.Password), config.Host, config.Port, config.Database)
-	if config.Host == "localhost" {
-		connStr += "?sslmode=disable"
+// Stats returns the statistics of the connection pool.
+func (d *PostgresDatabase) Stats() sql.DBStats {
+	return d.db.Stats()
+}
+
+// Ping checks that the database can be reached.
+func (d *PostgresDatabase) Ping(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "Ping")
+	defer endSpan(span, &err)
+
+	err = d.db.PingContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to ping database: %v", err)
 	}
-	db, err := sql.Open("postgres", connStr)
+	return nil
+}
+
+// GetSchemaVersion returns the latest schema version applied to the database.
+func (d *PostgresDatabase) GetSchemaVersion(ctx context.Context) (_ int, err error) {
+	ctx, span := startSpan(ctx, "GetSchemaVersion")
+	defer endSpan(span, &err)
+
+	var version int
+	err = d.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %v", err)
+		return 0, fmt.Errorf("failed to get schema version: %v", err)
 	}
+	return version, nil
+}
 
-	return &PostgresDatabase{
-		db: db,
-	}, nil
+// Close closes the connection pool, waiting for queries in progress to finish.
+func (d *PostgresDatabase) Close() error {
+	d.stopMonitor()
+	for _, replica := range d.replicas {
+		replica.db.Close()
+	}
+	err := d.db.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close database: %v", err)
+	}
+	return nil
 }
 
-func (d *PostgresDatabase) GetTasks() (*[]model.Task, error) {
-	rows, err := d.db.Query("SELECT * FROM tasks")
+func (d *PostgresDatabase) GetTasks(ctx context.Context) (_ *[]model.Task, err error) {
+	ctx, span := startSpan(ctx, "GetTasks")
+	defer endSpan(span, &err)
+
+	rows, err := d.reader(ctx).QueryContext(ctx, "SELECT "+taskColumns+" FROM tasks")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get tasks: %v", err)
 	}
@@ -57,8 +171,7 @@ func (d *PostgresDatabase) GetTasks() (*[]model.Task, error) {
 
 	var tasks []model.Task
 	for rows.Next() {
-		var task model.Task
-		err := rows.Scan(&task.ID, &task.UserID, &task.Body, &task.Completed, &task.Parent, &task.Reminder)
+		task, err := scanTask(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan task: %v", err)
 		}
@@ -68,11 +181,27 @@ func (d *PostgresDatabase) GetTasks() (*[]model.Task, error) {
 	return &tasks, nil
 }
 
-func (d *PostgresDatabase) GetTaskByID(id string) (*model.Task, error) {
-	row := d.db.QueryRow("SELECT * FROM tasks WHERE id = $1", id)
+func (d *PostgresDatabase) GetTaskByID(ctx context.Context, id string) (_ *model.Task, err error) {
+	ctx, span := startSpan(ctx, "GetTaskByID")
+	defer endSpan(span, &err)
 
-	var task model.Task
-	err := row.Scan(&task.ID, &task.UserID, &task.Body, &task.Completed, &task.Parent, &task.Reminder)
+	return getTask(ctx, d.reader(ctx), id)
+}
+
+// GetTaskByIDFromPrimary is GetTaskByID reading from the primary, for checks that a write relies on,
+// which must not be made against a replica that has not caught up.
+func (d *PostgresDatabase) GetTaskByIDFromPrimary(ctx context.Context, id string) (_ *model.Task, err error) {
+	ctx, span := startSpan(ctx, "GetTaskByIDFromPrimary")
+	defer endSpan(span, &err)
+
+	return getTask(ctx, d.db, id)
+}
+
+// getTask returns the task with the given ID from db, or nil if there is none.
+func getTask(ctx context.Context, db *sql.DB, id string) (*model.Task, error) {
+	row := db.QueryRowContext(ctx, "SELECT "+taskColumns+" FROM tasks WHERE id = $1", id)
+
+	task, err := scanTask(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -83,16 +212,39 @@ func (d *PostgresDatabase) GetTaskByID(id string) (*model.Task, error) {
 	return &task, nil
 }
 
-func (d *PostgresDatabase) GetTasksByUserID(userID string) (*[]model.Task, error) {
-	rows, err := d.db.Query("SELECT * FROM tasks WHERE user_id = $1", userID)
+func (d *PostgresDatabase) GetTasksByUserID(ctx context.Context, userID string) (_ *[]model.Task, err error) {
+	ctx, span := startSpan(ctx, "GetTasksByUserID")
+	defer endSpan(span, &err)
+
+	rows, err := d.reader(ctx).QueryContext(ctx, "SELECT "+taskColumns+" FROM tasks WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tasks: %v", err)
+	}
+	defer rows.Close()
+	tasks := []model.Task{}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan task: %v", err)
+		}
+		tasks = append(tasks, task)
+	}
+	return &tasks, nil
+}
+
+// GetTasksByIDs returns the tasks with any of the given IDs. IDs without a task are left out.
+func (d *PostgresDatabase) GetTasksByIDs(ctx context.Context, ids []string) (_ *[]model.Task, err error) {
+	ctx, span := startSpan(ctx, "GetTasksByIDs")
+	defer endSpan(span, &err)
+
+	rows, err := d.db.QueryContext(ctx, "SELECT "+taskColumns+" FROM tasks WHERE id = ANY($1)", pq.Array(ids))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get tasks: %v", err)
 	}
 	defer rows.Close()
 	tasks := []model.Task{}
 	for rows.Next() {
-		var task model.Task
-		err := rows.Scan(&task.ID, &task.UserID, &task.Body, &task.Completed, &task.Parent, &task.Reminder)
+		task, err := scanTask(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan task: %v", err)
 		}
@@ -101,31 +253,979 @@ func (d *PostgresDatabase) GetTasksByUserID(userID string) (*[]model.Task, error
 	return &tasks, nil
 }
 
-func (d *PostgresDatabase) CreateTask(task model.Task) error {
-	_, err := d.db.Exec("INSERT INTO tasks (id, user_id, body, completed, parent, reminder) VALUES ($1, $2, $3, $4, $5, $6)",
+// GetTasksByParentIDs returns the subtasks of all of the given tasks.
+func (d *PostgresDatabase) GetTasksByParentIDs(ctx context.Context, parentIDs []string) (_ *[]model.Task, err error) {
+	ctx, span := startSpan(ctx, "GetTasksByParentIDs")
+	defer endSpan(span, &err)
+
+	rows, err := d.db.QueryContext(ctx, "SELECT "+taskColumns+" FROM tasks WHERE parent = ANY($1)", pq.Array(parentIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subtasks: %v", err)
+	}
+	defer rows.Close()
+	tasks := []model.Task{}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan task: %v", err)
+		}
+		tasks = append(tasks, task)
+	}
+	return &tasks, nil
+}
+
+// GetRemindersByIDs returns the reminders with any of the given IDs. IDs without a reminder are left out.
+func (d *PostgresDatabase) GetRemindersByIDs(ctx context.Context, ids []string) (_ *[]model.Reminder, err error) {
+	ctx, span := startSpan(ctx, "GetRemindersByIDs")
+	defer endSpan(span, &err)
+
+	rows, err := d.db.QueryContext(ctx, "SELECT id, COALESCE(date, 0), COALESCE(send_alert, FALSE) FROM reminders WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reminders: %v", err)
+	}
+	defer rows.Close()
+	reminders := []model.Reminder{}
+	for rows.Next() {
+		var reminder model.Reminder
+		err := rows.Scan(&reminder.ID, &reminder.Date, &reminder.SendAlert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan reminder: %v", err)
+		}
+		reminders = append(reminders, reminder)
+	}
+	return &reminders, nil
+}
+
+func (d *PostgresDatabase) CreateTask(ctx context.Context, task model.Task) (err error) {
+	ctx, span := startSpan(ctx, "CreateTask")
+	defer endSpan(span, &err)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create task: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = lockUserChanges(ctx, tx, task.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO tasks (id, user_id, body, completed, parent, reminder) VALUES ($1, $2, $3, $4, $5, $6)",
 		task.ID, task.UserID, task.Body, task.Completed, task.Parent, task.Reminder)
 	if err != nil {
 		return fmt.Errorf("failed to create task: %v", err)
 	}
 
+	_, err = tx.ExecContext(ctx, "DELETE FROM task_tombstones WHERE task_id = $1", task.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create task: %v", err)
+	}
+
+	err = recordTaskEvent(ctx, tx, model.TaskCreated, task.UserID, task.ID, &task)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueWebhookDeliveries(ctx, tx, model.WebhookTaskCreated, task.UserID, task.ID, &task)
+	if err != nil {
+		return err
+	}
+	if task.Completed {
+		err = enqueueWebhookDeliveries(ctx, tx, model.WebhookTaskCompleted, task.UserID, task.ID, &task)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to create task: %v", err)
+	}
+	markWrite(ctx)
+
 	return nil
 }
 
-func (d *PostgresDatabase) UpdateTask(updatedTask model.Task) error {
-	_, err := d.db.Exec("UPDATE tasks SET user_id = $1, body = $2, completed = $3, parent = $4, reminder = $5 WHERE id = $6",
-		updatedTask.UserID, updatedTask.Body, updatedTask.Completed, updatedTask.Parent, updatedTask.Reminder, updatedTask.ID)
+// UpdateTask replaces the body, completion, parent and reminder of a task, keeping its owner.
+// If the task has a Version it is only updated while it still has that version, and ErrTaskChanged is returned otherwise.
+func (d *PostgresDatabase) UpdateTask(ctx context.Context, updatedTask model.Task) (err error) {
+	ctx, span := startSpan(ctx, "UpdateTask")
+	defer endSpan(span, &err)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update task: %v", err)
+	}
+	defer tx.Rollback()
+
+	var userID sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT user_id FROM tasks WHERE id = $1", updatedTask.ID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return taskGone(updatedTask)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to update task: %v", err)
 	}
 
+	err = lockUserChanges(ctx, tx, userID.String)
+	if err != nil {
+		return err
+	}
+
+	var wasCompleted sql.NullBool
+	err = tx.QueryRowContext(ctx, "SELECT completed FROM tasks WHERE id = $1 FOR UPDATE", updatedTask.ID).Scan(&wasCompleted)
+	if err == sql.ErrNoRows {
+		return taskGone(updatedTask)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update task: %v", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE tasks SET body = $1, completed = $2, parent = $3, reminder = $4, change_seq = nextval('task_change_seq'), updated_at = now()
+		WHERE id = $5 AND ($6::bigint = 0 OR change_seq = $6)`,
+		updatedTask.Body, updatedTask.Completed, updatedTask.Parent, updatedTask.Reminder, updatedTask.ID, updatedTask.Version)
+	if err != nil {
+		return fmt.Errorf("failed to update task: %v", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update task: %v", err)
+	}
+	if updated == 0 {
+		return ErrTaskChanged
+	}
+
+	updatedTask.UserID = userID.String
+	updatedTask.Version = 0
+	err = recordTaskEvent(ctx, tx, model.TaskUpdated, updatedTask.UserID, updatedTask.ID, &updatedTask)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueWebhookDeliveries(ctx, tx, model.WebhookTaskUpdated, updatedTask.UserID, updatedTask.ID, &updatedTask)
+	if err != nil {
+		return err
+	}
+	if updatedTask.Completed && !wasCompleted.Bool {
+		err = enqueueWebhookDeliveries(ctx, tx, model.WebhookTaskCompleted, updatedTask.UserID, updatedTask.ID, &updatedTask)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to update task: %v", err)
+	}
+	markWrite(ctx)
+
 	return nil
 }
 
-func (d *PostgresDatabase) DeleteTask(taskToDelete model.Task) error {
-	_, err := d.db.Exec("DELETE FROM tasks WHERE id = $1", taskToDelete.ID)
+// DeleteTask deletes a task and leaves a tombstone for sync clients.
+// If the task has a Version it is only deleted while it still has that version, and ErrTaskChanged is returned otherwise.
+func (d *PostgresDatabase) DeleteTask(ctx context.Context, taskToDelete model.Task) (err error) {
+	ctx, span := startSpan(ctx, "DeleteTask")
+	defer endSpan(span, &err)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete task: %v", err)
+	}
+	defer tx.Rollback()
+
+	var userID sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT user_id FROM tasks WHERE id = $1", taskToDelete.ID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return taskGone(taskToDelete)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete task: %v", err)
+	}
+
+	err = lockUserChanges(ctx, tx, userID.String)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM tasks WHERE id = $1 AND ($2::bigint = 0 OR change_seq = $2)", taskToDelete.ID, taskToDelete.Version)
+	if err != nil {
+		return fmt.Errorf("failed to delete task: %v", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete task: %v", err)
+	}
+	if deleted == 0 && taskToDelete.Version != 0 {
+		return ErrTaskChanged
+	}
+	if deleted > 0 {
+		_, err = tx.ExecContext(ctx, `INSERT INTO task_tombstones (task_id, user_id) VALUES ($1, $2)
+			ON CONFLICT (task_id) DO UPDATE SET user_id = EXCLUDED.user_id, change_seq = nextval('task_change_seq'), deleted_at = now()`,
+			taskToDelete.ID, userID)
+		if err != nil {
+			return fmt.Errorf("failed to delete task: %v", err)
+		}
+
+		err = recordTaskEvent(ctx, tx, model.TaskDeleted, userID.String, taskToDelete.ID, nil)
+		if err != nil {
+			return err
+		}
+
+		err = enqueueWebhookDeliveries(ctx, tx, model.WebhookTaskDeleted, userID.String, taskToDelete.ID, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return fmt.Errorf("failed to delete task: %v", err)
 	}
+	markWrite(ctx)
+
+	return nil
+}
+
+// taskGone is what UpdateTask and DeleteTask return when the task does not exist: nothing, unless the caller
+// expected a version of it.
+func taskGone(task model.Task) error {
+	if task.Version != 0 {
+		return ErrTaskChanged
+	}
+	return nil
+}
+
+// lockUserChanges serializes write transactions for a user until the transaction ends. Change sequence
+// numbers are then committed in order for each user, so a sync reader never skips a change that commits late.
+func lockUserChanges(ctx context.Context, tx *sql.Tx, userID string) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", userID)
+	if err != nil {
+		return fmt.Errorf("failed to lock user changes: %v", err)
+	}
+
+	return nil
+}
+
+// recordTaskEvent appends an event to the task_events log and the outbox, and notifies listeners once the transaction commits.
+func recordTaskEvent(ctx context.Context, tx *sql.Tx, eventType string, userID string, taskID string, task *model.Task) error {
+	event := model.TaskEvent{
+		Type:   eventType,
+		UserID: userID,
+		TaskID: taskID,
+		Task:   task,
+	}
+
+	var payload sql.NullString
+	if task != nil {
+		bytes, err := json.Marshal(task)
+		if err != nil {
+			return fmt.Errorf("failed to encode task event: %v", err)
+		}
+		payload = sql.NullString{String: string(bytes), Valid: true}
+	}
+
+	err := tx.QueryRowContext(ctx, "INSERT INTO task_events (type, user_id, task_id, task) VALUES ($1, $2, $3, $4) RETURNING id, created_at",
+		eventType, userID, taskID, payload).Scan(&event.ID, &event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record task event: %v", err)
+	}
+
+	bytes, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode task event: %v", err)
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO outbox (topic, key, payload) VALUES ($1, $2, $3)", outboxTopicPrefix+eventType, userID, string(bytes))
+	if err != nil {
+		return fmt.Errorf("failed to write task event to outbox: %v", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", taskEventsChannel, userID)
+	if err != nil {
+		return fmt.Errorf("failed to notify task event: %v", err)
+	}
+
+	return nil
+}
+
+// enqueueWebhookDeliveries queues a delivery of the event to each of the user's webhooks subscribed to it.
+// The deliveries are only sent once the transaction commits.
+func enqueueWebhookDeliveries(ctx context.Context, tx *sql.Tx, event string, userID string, taskID string, task *model.Task) error {
+	payload, err := json.Marshal(model.WebhookPayload{
+		Event:     event,
+		TaskID:    taskID,
+		Task:      task,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %v", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO webhook_deliveries (webhook_id, event, payload) SELECT id, $1, $2 FROM webhooks WHERE user_id = $3 AND $1 = ANY(events)",
+		event, string(payload), userID)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue webhook deliveries: %v", err)
+	}
+
+	return nil
+}
+
+// ReserveIdempotencyKey stores a record for a request that is about to run, with a status code of 0 and an expiry
+// that lets the key be taken over if the request never finishes. It returns nil if the key was reserved, or the
+// live record of an earlier request using the key, which is still in progress if its status code is 0.
+func (d *PostgresDatabase) ReserveIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (_ *model.IdempotencyRecord, err error) {
+	ctx, span := startSpan(ctx, "ReserveIdempotencyKey")
+	defer endSpan(span, &err)
+
+	// An expired record for the same key is taken over, a live one is returned. A record reserved by a request
+	// that has not committed when this one starts is waited for, but is not visible to the select, so a key that
+	// is neither reserved nor found is still in progress.
+	rows, err := d.db.QueryContext(ctx, `WITH reserved AS (
+			INSERT INTO idempotency_keys (user_id, key, fingerprint, status_code, body, expires_at) VALUES ($1, $2, $3, 0, NULL, $4)
+			ON CONFLICT (user_id, key) DO UPDATE SET fingerprint = EXCLUDED.fingerprint, status_code = 0, body = NULL, expires_at = EXCLUDED.expires_at
+			WHERE idempotency_keys.expires_at <= now()
+			RETURNING user_id
+		)
+		SELECT true, user_id, key, '', 0, NULL::bytea, now() FROM reserved
+		UNION ALL
+		SELECT false, user_id, key, fingerprint, status_code, body, expires_at FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2 AND expires_at > now() AND NOT EXISTS (SELECT 1 FROM reserved)`,
+		record.UserID, record.Key, record.Fingerprint, record.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %v", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to reserve idempotency key: %v", err)
+		}
+		return &model.IdempotencyRecord{UserID: record.UserID, Key: record.Key, Fingerprint: record.Fingerprint, ExpiresAt: record.ExpiresAt}, nil
+	}
+	var reserved bool
+	var existing model.IdempotencyRecord
+	err = rows.Scan(&reserved, &existing.UserID, &existing.Key, &existing.Fingerprint, &existing.StatusCode, &existing.Body, &existing.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %v", err)
+	}
+	if reserved {
+		return nil, nil
+	}
+
+	return &existing, nil
+}
+
+// SaveIdempotencyRecord stores the response of a request that reserved its key with ReserveIdempotencyKey.
+func (d *PostgresDatabase) SaveIdempotencyRecord(ctx context.Context, record model.IdempotencyRecord) (err error) {
+	ctx, span := startSpan(ctx, "SaveIdempotencyRecord")
+	defer endSpan(span, &err)
+
+	result, err := d.db.ExecContext(ctx, "UPDATE idempotency_keys SET status_code = $4, body = $5, expires_at = $6 WHERE user_id = $1 AND key = $2 AND fingerprint = $3 AND status_code = 0",
+		record.UserID, record.Key, record.Fingerprint, record.StatusCode, record.Body, record.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to save idempotency record: %v", err)
+	}
+	saved, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to save idempotency record: %v", err)
+	}
+	if saved == 0 {
+		return fmt.Errorf("failed to save idempotency record: key %q is no longer reserved", record.Key)
+	}
 
 	return nil
 }
+
+// ReleaseIdempotencyKey deletes the reservation of a key whose request failed, so that it can be retried.
+// Keys holding a stored response are left untouched.
+func (d *PostgresDatabase) ReleaseIdempotencyKey(ctx context.Context, userID string, key string) (err error) {
+	ctx, span := startSpan(ctx, "ReleaseIdempotencyKey")
+	defer endSpan(span, &err)
+
+	_, err = d.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND status_code = 0", userID, key)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %v", err)
+	}
+
+	return nil
+}
+
+// TakeRateLimitToken refills the token bucket stored under key at refillPerSecond, up to capacity, and takes a token
+// from it if there is one. It returns the tokens left and whether one was taken. A bucket that does not exist yet starts full.
+func (d *PostgresDatabase) TakeRateLimitToken(ctx context.Context, key string, capacity float64, refillPerSecond float64) (_ float64, _ bool, err error) {
+	ctx, span := startSpan(ctx, "TakeRateLimitToken")
+	defer endSpan(span, &err)
+
+	// The bucket row is locked while it is refilled, so that concurrent requests on other replicas take from it in turn.
+	row := d.db.QueryRowContext(ctx, `WITH refilled AS (
+			SELECT LEAST($2::float8, COALESCE((
+				SELECT tokens + EXTRACT(EPOCH FROM now() - updated_at)::float8 * $3::float8 FROM rate_limits WHERE key = $1 FOR UPDATE
+			), $2::float8)) AS tokens
+		)
+		INSERT INTO rate_limits (key, tokens, updated_at)
+		SELECT $1, CASE WHEN tokens >= 1 THEN tokens - 1 ELSE tokens END, now() FROM refilled
+		ON CONFLICT (key) DO UPDATE SET tokens = EXCLUDED.tokens, updated_at = EXCLUDED.updated_at
+		RETURNING rate_limits.tokens, (SELECT tokens >= 1 FROM refilled)`,
+		key, capacity, refillPerSecond)
+
+	var tokens float64
+	var taken bool
+	err = row.Scan(&tokens, &taken)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to take rate limit token: %v", err)
+	}
+
+	return tokens, taken, nil
+}
+
+// PruneRateLimitBuckets deletes buckets that were last used before the given time and returns how many were deleted.
+func (d *PostgresDatabase) PruneRateLimitBuckets(ctx context.Context, updatedBefore time.Time) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "PruneRateLimitBuckets")
+	defer endSpan(span, &err)
+
+	result, err := d.db.ExecContext(ctx, "DELETE FROM rate_limits WHERE updated_at < $1", updatedBefore)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune rate limit buckets: %v", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func (d *PostgresDatabase) GetTaskEvents(ctx context.Context, userID string, afterID int64) (_ *[]model.TaskEvent, err error) {
+	ctx, span := startSpan(ctx, "GetTaskEvents")
+	defer endSpan(span, &err)
+
+	rows, err := d.db.QueryContext(ctx, "SELECT id, type, user_id, task_id, task, created_at FROM task_events WHERE user_id = $1 AND id > $2 ORDER BY id LIMIT $3",
+		userID, afterID, taskEventsBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task events: %v", err)
+	}
+	defer rows.Close()
+
+	events := []model.TaskEvent{}
+	for rows.Next() {
+		var event model.TaskEvent
+		var payload sql.NullString
+		err := rows.Scan(&event.ID, &event.Type, &event.UserID, &event.TaskID, &payload, &event.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan task event: %v", err)
+		}
+		if payload.Valid {
+			event.Task = &model.Task{}
+			err = json.Unmarshal([]byte(payload.String), event.Task)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode task event: %v", err)
+			}
+		}
+		events = append(events, event)
+	}
+
+	return &events, nil
+}
+
+func (d *PostgresDatabase) GetLatestTaskEventID(ctx context.Context, userID string) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "GetLatestTaskEventID")
+	defer endSpan(span, &err)
+
+	var id int64
+	err = d.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM task_events WHERE user_id = $1", userID).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get latest task event: %v", err)
+	}
+
+	return id, nil
+}
+
+// ListenTaskEvents listens for task event notifications from any server replica until the context is done.
+// Each value received is the ID of a user with new events. An empty string is sent after the listener
+// reconnects, since notifications may have been missed and every user should check for new events.
+func (d *PostgresDatabase) ListenTaskEvents(ctx context.Context) (<-chan string, error) {
+	listener := pq.NewListener(d.connStr, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			d.logger.Warn("Task event listener error", "error", err)
+		}
+	})
+	err := listener.Listen(taskEventsChannel)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen for task events: %v", err)
+	}
+
+	users := make(chan string)
+	go func() {
+		defer close(users)
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case notification := <-listener.Notify:
+				user := ""
+				if notification != nil {
+					user = notification.Extra
+				}
+				select {
+				case users <- user:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return users, nil
+}
+
+// GetTaskChanges returns the tasks of a user changed and deleted after the change sequence since. Both are read
+// from one snapshot, so that a change committed between the two reads cannot be skipped by a sequence that
+// includes a later one.
+func (d *PostgresDatabase) GetTaskChanges(ctx context.Context, userID string, since int64) (_ *model.TaskChanges, err error) {
+	ctx, span := startSpan(ctx, "GetTaskChanges")
+	defer endSpan(span, &err)
+
+	changes := model.TaskChanges{
+		Tasks:    []model.Task{},
+		Deleted:  []string{},
+		Sequence: since,
+	}
+
+	tx, err := d.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task changes: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT "+taskColumns+" FROM tasks WHERE user_id = $1 AND change_seq > $2 ORDER BY change_seq", userID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task changes: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan task: %v", err)
+		}
+		changes.Tasks = append(changes.Tasks, task)
+		changes.Sequence = max(changes.Sequence, task.Version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get task changes: %v", err)
+	}
+
+	tombstones, err := tx.QueryContext(ctx, "SELECT task_id, change_seq FROM task_tombstones WHERE user_id = $1 AND change_seq > $2 ORDER BY change_seq", userID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task changes: %v", err)
+	}
+	defer tombstones.Close()
+	for tombstones.Next() {
+		var id string
+		var seq int64
+		err := tombstones.Scan(&id, &seq)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan tombstone: %v", err)
+		}
+		changes.Deleted = append(changes.Deleted, id)
+		changes.Sequence = max(changes.Sequence, seq)
+	}
+	if err := tombstones.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get task changes: %v", err)
+	}
+
+	return &changes, nil
+}
+
+func (d *PostgresDatabase) GetWebhooksByUserID(ctx context.Context, userID string) (_ *[]model.Webhook, err error) {
+	ctx, span := startSpan(ctx, "GetWebhooksByUserID")
+	defer endSpan(span, &err)
+
+	rows, err := d.db.QueryContext(ctx, "SELECT id, user_id, url, secret, events, created_at FROM webhooks WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhooks: %v", err)
+	}
+	defer rows.Close()
+	webhooks := []model.Webhook{}
+	for rows.Next() {
+		var webhook model.Webhook
+		err := rows.Scan(&webhook.ID, &webhook.UserID, &webhook.URL, &webhook.Secret, pq.Array(&webhook.Events), &webhook.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %v", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return &webhooks, nil
+}
+
+func (d *PostgresDatabase) GetWebhookByID(ctx context.Context, id string) (_ *model.Webhook, err error) {
+	ctx, span := startSpan(ctx, "GetWebhookByID")
+	defer endSpan(span, &err)
+
+	row := d.db.QueryRowContext(ctx, "SELECT id, user_id, url, secret, events, created_at FROM webhooks WHERE id = $1", id)
+
+	var webhook model.Webhook
+	err = row.Scan(&webhook.ID, &webhook.UserID, &webhook.URL, &webhook.Secret, pq.Array(&webhook.Events), &webhook.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get webhook: %v", err)
+	}
+
+	return &webhook, nil
+}
+
+func (d *PostgresDatabase) CreateWebhook(ctx context.Context, webhook model.Webhook) (err error) {
+	ctx, span := startSpan(ctx, "CreateWebhook")
+	defer endSpan(span, &err)
+
+	_, err = d.db.ExecContext(ctx, "INSERT INTO webhooks (id, user_id, url, secret, events) VALUES ($1, $2, $3, $4, $5)",
+		webhook.ID, webhook.UserID, webhook.URL, webhook.Secret, pq.Array(webhook.Events))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook: %v", err)
+	}
+
+	return nil
+}
+
+func (d *PostgresDatabase) UpdateWebhook(ctx context.Context, webhook model.Webhook) (err error) {
+	ctx, span := startSpan(ctx, "UpdateWebhook")
+	defer endSpan(span, &err)
+
+	_, err = d.db.ExecContext(ctx, "UPDATE webhooks SET url = $1, events = $2 WHERE id = $3",
+		webhook.URL, pq.Array(webhook.Events), webhook.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook: %v", err)
+	}
+
+	return nil
+}
+
+func (d *PostgresDatabase) DeleteWebhook(ctx context.Context, webhook model.Webhook) (err error) {
+	ctx, span := startSpan(ctx, "DeleteWebhook")
+	defer endSpan(span, &err)
+
+	_, err = d.db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1", webhook.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %v", err)
+	}
+
+	return nil
+}
+
+func (d *PostgresDatabase) CreateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) (err error) {
+	ctx, span := startSpan(ctx, "CreateWebhookDelivery")
+	defer endSpan(span, &err)
+
+	_, err = d.db.ExecContext(ctx, "INSERT INTO webhook_deliveries (webhook_id, event, payload) VALUES ($1, $2, $3)",
+		delivery.WebhookID, delivery.Event, string(delivery.Payload))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook delivery: %v", err)
+	}
+
+	return nil
+}
+
+// apiTokenColumns lists the API token columns in the order scanAPIToken reads them.
+const apiTokenColumns = "id, user_id, name, token_hash, scopes, expires_at, last_used_at, created_at"
+
+// scanAPIToken reads a row selected with apiTokenColumns into an API token.
+func scanAPIToken(row rowScanner) (model.APIToken, error) {
+	var token model.APIToken
+	var lastUsedAt sql.NullTime
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.Hash, pq.Array(&token.Scopes), &token.ExpiresAt, &lastUsedAt, &token.CreatedAt)
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	return token, err
+}
+
+func (d *PostgresDatabase) GetAPITokensByUserID(ctx context.Context, userID string) (_ *[]model.APIToken, err error) {
+	ctx, span := startSpan(ctx, "GetAPITokensByUserID")
+	defer endSpan(span, &err)
+
+	rows, err := d.db.QueryContext(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API tokens: %v", err)
+	}
+	defer rows.Close()
+	tokens := []model.APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API token: %v", err)
+		}
+		tokens = append(tokens, token)
+	}
+	return &tokens, nil
+}
+
+func (d *PostgresDatabase) GetAPITokenByID(ctx context.Context, id string) (_ *model.APIToken, err error) {
+	ctx, span := startSpan(ctx, "GetAPITokenByID")
+	defer endSpan(span, &err)
+
+	token, err := scanAPIToken(d.db.QueryRowContext(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE id = $1", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get API token: %v", err)
+	}
+
+	return &token, nil
+}
+
+// GetAPITokenByHash returns the API token whose secret has the given hash, or nil if there is none.
+func (d *PostgresDatabase) GetAPITokenByHash(ctx context.Context, hash string) (_ *model.APIToken, err error) {
+	ctx, span := startSpan(ctx, "GetAPITokenByHash")
+	defer endSpan(span, &err)
+
+	token, err := scanAPIToken(d.db.QueryRowContext(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = $1", hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get API token: %v", err)
+	}
+
+	return &token, nil
+}
+
+func (d *PostgresDatabase) CreateAPIToken(ctx context.Context, token model.APIToken) (err error) {
+	ctx, span := startSpan(ctx, "CreateAPIToken")
+	defer endSpan(span, &err)
+
+	_, err = d.db.ExecContext(ctx, "INSERT INTO api_tokens (id, user_id, name, token_hash, scopes, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		token.ID, token.UserID, token.Name, token.Hash, pq.Array(token.Scopes), token.ExpiresAt, token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create API token: %v", err)
+	}
+
+	return nil
+}
+
+func (d *PostgresDatabase) DeleteAPIToken(ctx context.Context, token model.APIToken) (err error) {
+	ctx, span := startSpan(ctx, "DeleteAPIToken")
+	defer endSpan(span, &err)
+
+	_, err = d.db.ExecContext(ctx, "DELETE FROM api_tokens WHERE id = $1", token.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete API token: %v", err)
+	}
+
+	return nil
+}
+
+// TouchAPIToken records that an API token was used at the given time, unless it was used later than that.
+func (d *PostgresDatabase) TouchAPIToken(ctx context.Context, id string, usedAt time.Time) (err error) {
+	ctx, span := startSpan(ctx, "TouchAPIToken")
+	defer endSpan(span, &err)
+
+	_, err = d.db.ExecContext(ctx, "UPDATE api_tokens SET last_used_at = $2 WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2)", id, usedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record API token use: %v", err)
+	}
+
+	return nil
+}
+
+// GetUser returns the user with the given ID, or nil if there is none.
+func (d *PostgresDatabase) GetUser(ctx context.Context, id string) (_ *model.User, err error) {
+	ctx, span := startSpan(ctx, "GetUser")
+	defer endSpan(span, &err)
+
+	var user model.User
+	var email, name, timezone sql.NullString
+	err = d.db.QueryRowContext(ctx, "SELECT id, email, name, timezone, created_at, updated_at FROM users WHERE id = $1", id).
+		Scan(&user.ID, &email, &name, &timezone, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get user: %v", err)
+	}
+	user.Email, user.Name, user.Timezone = email.String, name.String, timezone.String
+
+	return &user, nil
+}
+
+// UpsertUser creates the user if they do not exist yet, and otherwise records the email from their profile.
+// The name and timezone of the profile are only stored if the user has never had them, since they are the
+// user's to change with UpdateUser. Empty fields of the profile are not stored.
+func (d *PostgresDatabase) UpsertUser(ctx context.Context, user model.User) (err error) {
+	ctx, span := startSpan(ctx, "UpsertUser")
+	defer endSpan(span, &err)
+
+	_, err = d.db.ExecContext(ctx, `INSERT INTO users (id, email, name, timezone) VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''))
+		ON CONFLICT (id) DO UPDATE SET email = COALESCE(EXCLUDED.email, users.email), name = COALESCE(users.name, EXCLUDED.name),
+			timezone = COALESCE(users.timezone, EXCLUDED.timezone), updated_at = now()
+		WHERE users.email IS DISTINCT FROM COALESCE(EXCLUDED.email, users.email)
+			OR (users.name IS NULL AND EXCLUDED.name IS NOT NULL)
+			OR (users.timezone IS NULL AND EXCLUDED.timezone IS NOT NULL)`,
+		user.ID, user.Email, user.Name, user.Timezone)
+	if err != nil {
+		return fmt.Errorf("failed to provision user: %v", err)
+	}
+
+	return nil
+}
+
+// UpdateUser stores the name and timezone of the user. Unlike in UpsertUser, empty values are stored,
+// so that later profiles do not fill them in again.
+func (d *PostgresDatabase) UpdateUser(ctx context.Context, user model.User) (err error) {
+	ctx, span := startSpan(ctx, "UpdateUser")
+	defer endSpan(span, &err)
+
+	_, err = d.db.ExecContext(ctx, "UPDATE users SET name = $1, timezone = $2, updated_at = now() WHERE id = $3", user.Name, user.Timezone, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %v", err)
+	}
+
+	return nil
+}
+
+// webhookDeliveryColumns lists the delivery columns in the order scanWebhookDelivery reads them.
+const webhookDeliveryColumns = "id, webhook_id, event, payload, status, attempts, next_attempt_at, last_error, response_status, created_at"
+
+// scanWebhookDelivery reads a row selected with webhookDeliveryColumns, followed by any extra destinations.
+func scanWebhookDelivery(row rowScanner, extra ...interface{}) (model.WebhookDelivery, error) {
+	var delivery model.WebhookDelivery
+	var payload string
+	var lastError sql.NullString
+	var responseStatus sql.NullInt64
+	dest := append([]interface{}{&delivery.ID, &delivery.WebhookID, &delivery.Event, &payload, &delivery.Status, &delivery.Attempts,
+		&delivery.NextAttemptAt, &lastError, &responseStatus, &delivery.CreatedAt}, extra...)
+	err := row.Scan(dest...)
+	if err != nil {
+		return delivery, err
+	}
+	delivery.Payload = json.RawMessage(payload)
+	delivery.LastError = lastError.String
+	delivery.ResponseStatus = int(responseStatus.Int64)
+	return delivery, nil
+}
+
+// GetWebhookDeliveries returns the most recent deliveries for a webhook, optionally only those with the given status.
+func (d *PostgresDatabase) GetWebhookDeliveries(ctx context.Context, webhookID string, status string) (_ *[]model.WebhookDelivery, err error) {
+	ctx, span := startSpan(ctx, "GetWebhookDeliveries")
+	defer endSpan(span, &err)
+
+	rows, err := d.db.QueryContext(ctx, "SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE webhook_id = $1 AND ($2 = '' OR status = $2) ORDER BY created_at DESC LIMIT 100",
+		webhookID, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+	deliveries := []model.WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %v", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return &deliveries, nil
+}
+
+// ClaimWebhookDeliveries returns up to limit pending deliveries that are due, along with their webhook's URL and secret.
+// Claimed deliveries are not due again until the lease expires, so other replicas skip them while they are being sent.
+func (d *PostgresDatabase) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) (_ *[]model.WebhookDelivery, err error) {
+	ctx, span := startSpan(ctx, "ClaimWebhookDeliveries")
+	defer endSpan(span, &err)
+
+	rows, err := d.db.QueryContext(ctx, `UPDATE webhook_deliveries d SET next_attempt_at = now() + $2 * interval '1 millisecond'
+		FROM webhooks w
+		WHERE d.webhook_id = w.id AND d.id IN (
+			SELECT id FROM webhook_deliveries WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED)
+		RETURNING d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at, d.last_error, d.response_status, d.created_at, w.url, w.secret`,
+		limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+	deliveries := []model.WebhookDelivery{}
+	for rows.Next() {
+		var url, secret string
+		delivery, err := scanWebhookDelivery(rows, &url, &secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %v", err)
+		}
+		delivery.URL = url
+		delivery.Secret = secret
+		deliveries = append(deliveries, delivery)
+	}
+	return &deliveries, nil
+}
+
+func (d *PostgresDatabase) UpdateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) (err error) {
+	ctx, span := startSpan(ctx, "UpdateWebhookDelivery")
+	defer endSpan(span, &err)
+
+	_, err = d.db.ExecContext(ctx, "UPDATE webhook_deliveries SET status = $1, attempts = $2, next_attempt_at = $3, last_error = $4, response_status = $5 WHERE id = $6",
+		delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastError, delivery.ResponseStatus, delivery.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %v", err)
+	}
+
+	return nil
+}
+
+// ClaimOutboxMessages returns up to limit unpublished outbox messages, oldest first.
+// Claimed messages are not returned again until the lease expires, so relays on other replicas skip them.
+func (d *PostgresDatabase) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) (_ *[]model.OutboxMessage, err error) {
+	ctx, span := startSpan(ctx, "ClaimOutboxMessages")
+	defer endSpan(span, &err)
+
+	rows, err := d.db.QueryContext(ctx, `UPDATE outbox SET locked_until = now() + $2 * interval '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM outbox WHERE published_at IS NULL AND (locked_until IS NULL OR locked_until <= now())
+			ORDER BY seq LIMIT $1 FOR UPDATE SKIP LOCKED)
+		RETURNING id, seq, topic, key, payload, created_at`,
+		limit, lease.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox messages: %v", err)
+	}
+	defer rows.Close()
+	messages := []model.OutboxMessage{}
+	for rows.Next() {
+		var message model.OutboxMessage
+		var payload string
+		err := rows.Scan(&message.ID, &message.Seq, &message.Topic, &message.Key, &payload, &message.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan outbox message: %v", err)
+		}
+		message.Payload = json.RawMessage(payload)
+		messages = append(messages, message)
+	}
+	// The update returns rows in no particular order.
+	slices.SortFunc(messages, func(a, b model.OutboxMessage) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+	return &messages, nil
+}
+
+func (d *PostgresDatabase) MarkOutboxMessagesPublished(ctx context.Context, ids []string) (err error) {
+	ctx, span := startSpan(ctx, "MarkOutboxMessagesPublished")
+	defer endSpan(span, &err)
+
+	_, err = d.db.ExecContext(ctx, "UPDATE outbox SET published_at = now(), locked_until = NULL WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox messages published: %v", err)
+	}
+
+	return nil
+}
+
+// PruneOutboxMessages deletes messages published before the given time and returns how many were deleted.
+func (d *PostgresDatabase) PruneOutboxMessages(ctx context.Context, publishedBefore time.Time) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "PruneOutboxMessages")
+	defer endSpan(span, &err)
+
+	result, err := d.db.ExecContext(ctx, "DELETE FROM outbox WHERE published_at < $1", publishedBefore)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune outbox messages: %v", err)
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,215 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// changeRow is a row of the tasks or task_tombstones table in a changeStore.
+type changeRow struct {
+	id  string
+	seq int64
+}
+
+// changeStore is an in-memory stand-in for the tasks and task_tombstones tables of a single user, served through
+// database/sql. Transactions at repeatable read see the tables as they were when they began, as in Postgres,
+// and every other query sees the latest rows. afterQuery is called after each query, to make writes between reads.
+type changeStore struct {
+	mu         sync.Mutex
+	tasks      []changeRow
+	tombstones []changeRow
+	afterQuery func(s *changeStore)
+	// failAfter makes a cursor over the tasks fail after this many rows, if set.
+	failAfter int
+}
+
+// update gives the task the next change sequence, as UpdateTask does.
+func (s *changeStore) update(id string, seq int64) {
+	for i, task := range s.tasks {
+		if task.id == id {
+			s.tasks[i].seq = seq
+		}
+	}
+}
+
+// delete replaces the task with a tombstone, as DeleteTask does.
+func (s *changeStore) delete(id string, seq int64) {
+	for i, task := range s.tasks {
+		if task.id == id {
+			s.tasks = append(s.tasks[:i], s.tasks[i+1:]...)
+			break
+		}
+	}
+	s.tombstones = append(s.tombstones, changeRow{id: id, seq: seq})
+}
+
+func (s *changeStore) Connect(ctx context.Context) (driver.Conn, error) {
+	return &changeConn{store: s}, nil
+}
+func (s *changeStore) Driver() driver.Driver { return nil }
+
+// changeConn is a connection to a changeStore.
+type changeConn struct {
+	store *changeStore
+	// snapshot holds the tables seen by the current repeatable read transaction, if there is one.
+	snapshot *changeStore
+}
+
+func (c *changeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (c *changeConn) Close() error { return nil }
+func (c *changeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *changeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if sql.IsolationLevel(opts.Isolation) == sql.LevelRepeatableRead {
+		c.store.mu.Lock()
+		c.snapshot = &changeStore{
+			tasks:      append([]changeRow(nil), c.store.tasks...),
+			tombstones: append([]changeRow(nil), c.store.tombstones...),
+		}
+		c.store.mu.Unlock()
+	}
+	return c, nil
+}
+
+func (c *changeConn) Commit() error   { c.snapshot = nil; return nil }
+func (c *changeConn) Rollback() error { c.snapshot = nil; return nil }
+
+func (c *changeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	tables := c.store
+	if c.snapshot != nil {
+		tables = c.snapshot
+	}
+	since := args[1].Value.(int64)
+
+	rows := &changeRows{failAfter: -1}
+	switch {
+	case strings.Contains(query, "FROM tasks"):
+		rows.columns = strings.Split(taskColumns, ", ")
+		for _, task := range tables.tasks {
+			if task.seq > since {
+				rows.values = append(rows.values, []driver.Value{task.id, "user-1", "", false, nil, nil, task.seq, time.Unix(0, 0)})
+			}
+		}
+		if c.store.failAfter > 0 {
+			rows.failAfter = c.store.failAfter
+		}
+	case strings.Contains(query, "FROM task_tombstones"):
+		rows.columns = []string{"task_id", "change_seq"}
+		for _, tombstone := range tables.tombstones {
+			if tombstone.seq > since {
+				rows.values = append(rows.values, []driver.Value{tombstone.id, tombstone.seq})
+			}
+		}
+	default:
+		return nil, errors.New("unexpected query: " + query)
+	}
+	if c.store.afterQuery != nil {
+		c.store.afterQuery(c.store)
+	}
+	return rows, nil
+}
+
+// changeRows are the rows of a query to a changeStore.
+type changeRows struct {
+	columns   []string
+	values    [][]driver.Value
+	next      int
+	failAfter int
+}
+
+func (r *changeRows) Columns() []string { return r.columns }
+func (r *changeRows) Close() error      { return nil }
+
+func (r *changeRows) Next(dest []driver.Value) error {
+	if r.next == r.failAfter {
+		return errors.New("connection reset")
+	}
+	if r.next >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.next])
+	r.next++
+	return nil
+}
+
+func TestGetTaskChanges(t *testing.T) {
+	tests := []struct {
+		name             string
+		failAfter        int
+		afterQuery       func(s *changeStore)
+		expectedTasks    []string
+		expectedDeleted  []string
+		expectedSequence int64
+		expectedErr      bool
+	}{
+		{
+			name:             "GetTaskChanges_OK",
+			expectedTasks:    []string{"task-1", "task-2"},
+			expectedDeleted:  []string{"task-3"},
+			expectedSequence: 3,
+		},
+		{
+			// An update and a delete committed between the two reads are both left for the next pull,
+			// rather than the delete advancing the sequence past the update.
+			name: "GetTaskChanges_ChangedBetweenReads",
+			afterQuery: func(s *changeStore) {
+				if len(s.tombstones) == 1 {
+					s.update("task-1", 4)
+					s.delete("task-2", 5)
+				}
+			},
+			expectedTasks:    []string{"task-1", "task-2"},
+			expectedDeleted:  []string{"task-3"},
+			expectedSequence: 3,
+		},
+		{
+			name:        "GetTaskChanges_CursorError",
+			failAfter:   1,
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &changeStore{
+				tasks:      []changeRow{{id: "task-1", seq: 1}, {id: "task-2", seq: 2}},
+				tombstones: []changeRow{{id: "task-3", seq: 3}},
+				afterQuery: tt.afterQuery,
+				failAfter:  tt.failAfter,
+			}
+			db := sql.OpenDB(store)
+			defer db.Close()
+			d := &PostgresDatabase{db: db, stopMonitor: func() {}}
+
+			changes, err := d.GetTaskChanges(context.Background(), "user-1", 0)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				assert.Nil(t, changes)
+				return
+			}
+			assert.NoError(t, err)
+			var ids []string
+			for _, task := range changes.Tasks {
+				ids = append(ids, task.ID)
+			}
+			assert.Equal(t, tt.expectedTasks, ids)
+			assert.Equal(t, tt.expectedDeleted, changes.Deleted)
+			assert.Equal(t, tt.expectedSequence, changes.Sequence)
+		})
+	}
+}
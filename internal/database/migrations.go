@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// migrationFiles holds the SQL of each schema version, named by the version it brings the schema to,
+// such as 0002_rate_limits.sql.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationsLockID is the key of the advisory lock held while migrating, so that replicas starting together
+// apply each migration once. It is arbitrary, but must not be used for another lock.
+const migrationsLockID = 4_038_001
+
+// migration brings the schema from the version before it to Version.
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// migrations returns the embedded migrations in version order.
+func migrations() ([]migration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	var result []migration
+	for _, name := range names {
+		base := strings.TrimPrefix(name, "migrations/")
+		prefix, _, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s is not named after its version", base)
+		}
+		sql, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, migration{Version: version, Name: base, SQL: string(sql)})
+	}
+	slices.SortFunc(result, func(a, b migration) int { return a.Version - b.Version })
+	return result, nil
+}
+
+// Migrate applies the migrations the database has not recorded in schema_migrations, each in its own transaction,
+// until the schema is at SchemaVersion.
+func (d *PostgresDatabase) Migrate(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "Migrate")
+	defer endSpan(span, &err)
+
+	pending, err := migrations()
+	if err != nil {
+		return fmt.Errorf("failed to read migrations: %v", err)
+	}
+
+	// The lock is held by the session, so every statement runs on the same connection.
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to migrate: %v", err)
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationsLockID)
+	if err != nil {
+		return fmt.Errorf("failed to lock migrations: %v", err)
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", migrationsLockID)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+	var version int
+	err = conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return fmt.Errorf("failed to get schema version: %v", err)
+	}
+
+	for _, m := range pending {
+		if m.Version <= version {
+			continue
+		}
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %s: %v", m.Name, err)
+		}
+		_, err = tx.ExecContext(ctx, m.SQL)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", m.Version)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %s: %v", m.Name, err)
+		}
+		d.logger.InfoContext(ctx, "Applied schema migration", "version", m.Version, "name", m.Name)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+Version 1: the schema the readiness check was added with. Every statement is safe to run on a database created
+from an earlier local/tasks.sql, whose tables are kept, so that such databases can be brought under migrations.
+*/
+
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+CREATE TABLE IF NOT EXISTS users (
+  id UUID PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS reminders (
+  id UUID PRIMARY KEY,
+  date BIGINT,
+  send_alert BOOLEAN DEFAULT FALSE
+);
+
+CREATE SEQUENCE IF NOT EXISTS task_change_seq;
+
+CREATE TABLE IF NOT EXISTS tasks (
+  id UUID PRIMARY KEY,
+  user_id UUID REFERENCES users(id),
+  body TEXT,
+  completed BOOLEAN DEFAULT FALSE,
+  parent UUID REFERENCES tasks(id),
+  reminder UUID REFERENCES reminders(id)
+);
+
+ALTER TABLE tasks
+  ADD COLUMN IF NOT EXISTS change_seq BIGINT NOT NULL DEFAULT nextval('task_change_seq'),
+  ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS tasks_user_id_change_seq_idx ON tasks (user_id, change_seq);
+
+CREATE TABLE IF NOT EXISTS task_tombstones (
+  task_id UUID PRIMARY KEY,
+  user_id UUID,
+  change_seq BIGINT NOT NULL DEFAULT nextval('task_change_seq'),
+  deleted_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS task_tombstones_user_id_change_seq_idx ON task_tombstones (user_id, change_seq);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  user_id TEXT NOT NULL,
+  key TEXT NOT NULL,
+  fingerprint TEXT NOT NULL,
+  status_code INT NOT NULL,
+  body BYTEA,
+  expires_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (user_id, key)
+);
+
+CREATE TABLE IF NOT EXISTS task_events (
+  id BIGSERIAL PRIMARY KEY,
+  type TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  task_id UUID NOT NULL,
+  task JSONB,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS task_events_user_id_idx ON task_events (user_id, id);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+  id UUID PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  url TEXT NOT NULL,
+  secret TEXT NOT NULL,
+  events TEXT[] NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+  event TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending',
+  attempts INT NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_error TEXT,
+  response_status INT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, created_at);
+
+CREATE TABLE IF NOT EXISTS outbox (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  topic TEXT NOT NULL,
+  key TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp(),
+  locked_until TIMESTAMPTZ,
+  published_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (created_at) WHERE published_at IS NULL;
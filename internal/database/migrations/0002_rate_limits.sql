@@ -0,0 +1,9 @@
+/*
+Version 2: the token buckets of the postgres rate limit store.
+*/
+
+CREATE TABLE rate_limits (
+  key TEXT PRIMARY KEY,
+  tokens DOUBLE PRECISION NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,19 @@
+/*
+Version 4: users are identified by the user ID of their token, which is not a UUID, and keep their profile.
+The foreign key from tasks is dropped while both sides change type.
+*/
+
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_user_id_fkey;
+
+ALTER TABLE users
+  ALTER COLUMN id TYPE TEXT USING id::text,
+  ADD COLUMN email TEXT,
+  ADD COLUMN name TEXT,
+  ADD COLUMN timezone TEXT,
+  ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+ALTER TABLE tasks ALTER COLUMN user_id TYPE TEXT USING user_id::text;
+ALTER TABLE tasks ADD CONSTRAINT tasks_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+
+ALTER TABLE task_tombstones ALTER COLUMN user_id TYPE TEXT USING user_id::text;
@@ -0,0 +1,9 @@
+/*
+Version 5: outbox messages are numbered as they are written, so that the relay publishes them in that order.
+created_at is the time of the insert, which can tie or run backwards within a transaction.
+*/
+
+ALTER TABLE outbox ADD COLUMN seq BIGSERIAL;
+
+DROP INDEX IF EXISTS outbox_unpublished_idx;
+CREATE INDEX outbox_unpublished_idx ON outbox (seq) WHERE published_at IS NULL;
@@ -0,0 +1,20 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := migrations()
+	assert.NoError(t, err)
+
+	// Every version up to SchemaVersion needs exactly one migration, or readiness never passes.
+	if assert.Len(t, migrations, SchemaVersion) {
+		for i, m := range migrations {
+			assert.Equal(t, i+1, m.Version, m.Name)
+			assert.NotEmpty(t, m.SQL, m.Name)
+		}
+	}
+}
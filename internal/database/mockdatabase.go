@@ -1,40 +1,239 @@
 package database
 
 import (
+	"context"
+	"time"
+
 	"github.com/SevvyP/tasks_v1/pkg/model"
 	"github.com/stretchr/testify/mock"
 )
 
+// MockDatabase is a TaskDatabase for tests. Expectations are set without the context argument.
+// Task writes that succeed are recorded in the session of the context, as they are by PostgresDatabase.
 type MockDatabase struct {
 	mock.Mock
 }
 
-func (m *MockDatabase) GetTasks() (*[]model.Task, error) {
+func (m *MockDatabase) GetTasks(ctx context.Context) (*[]model.Task, error) {
 	args := m.Called()
 	return args.Get(0).(*[]model.Task), args.Error(1)
 }
 
-func (m *MockDatabase) GetTaskByID(id string) (*model.Task, error) {
+func (m *MockDatabase) GetTaskByID(ctx context.Context, id string) (*model.Task, error) {
 	args := m.Called(id)
 	return args.Get(0).(*model.Task), args.Error(1)
 }
 
-func (m *MockDatabase) GetTasksByUserID(userID string) (*[]model.Task, error) {
+func (m *MockDatabase) GetTaskByIDFromPrimary(ctx context.Context, id string) (*model.Task, error) {
+	args := m.Called(id)
+	return args.Get(0).(*model.Task), args.Error(1)
+}
+
+func (m *MockDatabase) GetTasksByUserID(ctx context.Context, userID string) (*[]model.Task, error) {
 	args := m.Called(userID)
 	return args.Get(0).(*[]model.Task), args.Error(1)
 }
 
-func (m *MockDatabase) CreateTask(task model.Task) error {
+func (m *MockDatabase) GetTasksByIDs(ctx context.Context, ids []string) (*[]model.Task, error) {
+	args := m.Called(ids)
+	return args.Get(0).(*[]model.Task), args.Error(1)
+}
+
+func (m *MockDatabase) GetTasksByParentIDs(ctx context.Context, parentIDs []string) (*[]model.Task, error) {
+	args := m.Called(parentIDs)
+	return args.Get(0).(*[]model.Task), args.Error(1)
+}
+
+func (m *MockDatabase) GetRemindersByIDs(ctx context.Context, ids []string) (*[]model.Reminder, error) {
+	args := m.Called(ids)
+	return args.Get(0).(*[]model.Reminder), args.Error(1)
+}
+
+func (m *MockDatabase) CreateTask(ctx context.Context, task model.Task) error {
 	args := m.Called(task)
+	if args.Error(0) == nil {
+		markWrite(ctx)
+	}
 	return args.Error(0)
 }
 
-func (m *MockDatabase) UpdateTask(task model.Task) error {
+func (m *MockDatabase) UpdateTask(ctx context.Context, task model.Task) error {
 	args := m.Called(task)
+	if args.Error(0) == nil {
+		markWrite(ctx)
+	}
 	return args.Error(0)
 }
 
-func (m *MockDatabase) DeleteTask(task model.Task) error {
+func (m *MockDatabase) DeleteTask(ctx context.Context, task model.Task) error {
 	args := m.Called(task)
+	if args.Error(0) == nil {
+		markWrite(ctx)
+	}
+	return args.Error(0)
+}
+
+func (m *MockDatabase) ReserveIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (*model.IdempotencyRecord, error) {
+	args := m.Called(record)
+	return args.Get(0).(*model.IdempotencyRecord), args.Error(1)
+}
+
+func (m *MockDatabase) SaveIdempotencyRecord(ctx context.Context, record model.IdempotencyRecord) error {
+	args := m.Called(record)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) ReleaseIdempotencyKey(ctx context.Context, userID string, key string) error {
+	args := m.Called(userID, key)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) TakeRateLimitToken(ctx context.Context, key string, capacity float64, refillPerSecond float64) (float64, bool, error) {
+	args := m.Called(key, capacity, refillPerSecond)
+	return args.Get(0).(float64), args.Bool(1), args.Error(2)
+}
+
+func (m *MockDatabase) PruneRateLimitBuckets(ctx context.Context, updatedBefore time.Time) (int64, error) {
+	args := m.Called(updatedBefore)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDatabase) GetTaskEvents(ctx context.Context, userID string, afterID int64) (*[]model.TaskEvent, error) {
+	args := m.Called(userID, afterID)
+	return args.Get(0).(*[]model.TaskEvent), args.Error(1)
+}
+
+func (m *MockDatabase) GetLatestTaskEventID(ctx context.Context, userID string) (int64, error) {
+	args := m.Called(userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDatabase) ListenTaskEvents(ctx context.Context) (<-chan string, error) {
+	args := m.Called()
+	return args.Get(0).(<-chan string), args.Error(1)
+}
+
+func (m *MockDatabase) GetTaskChanges(ctx context.Context, userID string, since int64) (*model.TaskChanges, error) {
+	args := m.Called(userID, since)
+	return args.Get(0).(*model.TaskChanges), args.Error(1)
+}
+
+func (m *MockDatabase) GetWebhooksByUserID(ctx context.Context, userID string) (*[]model.Webhook, error) {
+	args := m.Called(userID)
+	return args.Get(0).(*[]model.Webhook), args.Error(1)
+}
+
+func (m *MockDatabase) GetWebhookByID(ctx context.Context, id string) (*model.Webhook, error) {
+	args := m.Called(id)
+	return args.Get(0).(*model.Webhook), args.Error(1)
+}
+
+func (m *MockDatabase) CreateWebhook(ctx context.Context, webhook model.Webhook) error {
+	args := m.Called(webhook)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) UpdateWebhook(ctx context.Context, webhook model.Webhook) error {
+	args := m.Called(webhook)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) DeleteWebhook(ctx context.Context, webhook model.Webhook) error {
+	args := m.Called(webhook)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) CreateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error {
+	args := m.Called(delivery)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) GetWebhookDeliveries(ctx context.Context, webhookID string, status string) (*[]model.WebhookDelivery, error) {
+	args := m.Called(webhookID, status)
+	return args.Get(0).(*[]model.WebhookDelivery), args.Error(1)
+}
+
+func (m *MockDatabase) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) (*[]model.WebhookDelivery, error) {
+	args := m.Called(limit, lease)
+	return args.Get(0).(*[]model.WebhookDelivery), args.Error(1)
+}
+
+func (m *MockDatabase) UpdateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error {
+	args := m.Called(delivery)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) GetAPITokensByUserID(ctx context.Context, userID string) (*[]model.APIToken, error) {
+	args := m.Called(userID)
+	return args.Get(0).(*[]model.APIToken), args.Error(1)
+}
+
+func (m *MockDatabase) GetAPITokenByID(ctx context.Context, id string) (*model.APIToken, error) {
+	args := m.Called(id)
+	return args.Get(0).(*model.APIToken), args.Error(1)
+}
+
+func (m *MockDatabase) GetAPITokenByHash(ctx context.Context, hash string) (*model.APIToken, error) {
+	args := m.Called(hash)
+	return args.Get(0).(*model.APIToken), args.Error(1)
+}
+
+func (m *MockDatabase) CreateAPIToken(ctx context.Context, token model.APIToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) DeleteAPIToken(ctx context.Context, token model.APIToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) TouchAPIToken(ctx context.Context, id string, usedAt time.Time) error {
+	args := m.Called(id, usedAt)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) GetUser(ctx context.Context, id string) (*model.User, error) {
+	args := m.Called(id)
+	return args.Get(0).(*model.User), args.Error(1)
+}
+
+func (m *MockDatabase) UpsertUser(ctx context.Context, user model.User) error {
+	args := m.Called(user)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) UpdateUser(ctx context.Context, user model.User) error {
+	args := m.Called(user)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) (*[]model.OutboxMessage, error) {
+	args := m.Called(limit, lease)
+	return args.Get(0).(*[]model.OutboxMessage), args.Error(1)
+}
+
+func (m *MockDatabase) MarkOutboxMessagesPublished(ctx context.Context, ids []string) error {
+	args := m.Called(ids)
+	return args.Error(0)
+}
+
+func (m *MockDatabase) PruneOutboxMessages(ctx context.Context, publishedBefore time.Time) (int64, error) {
+	args := m.Called(publishedBefore)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockDatabase) Ping(ctx context.Context) error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockDatabase) GetSchemaVersion(ctx context.Context) (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockDatabase) Close() error {
+	args := m.Called()
 	return args.Error(0)
 }
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// replicaLagQuery returns how many seconds a replica is behind the primary. A replica that has replayed
+// everything it received is not behind, however long ago the last transaction was.
+const replicaLagQuery = `SELECT CASE
+	WHEN NOT pg_is_in_recovery() THEN 0
+	WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END`
+
+// replica is a read replica of the database. Reads are only routed to it while it is healthy.
+type replica struct {
+	host    string
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// Session is what the database knows about the client making a request, used to send its reads to the primary
+// when a replica might not have its latest writes yet. The server attaches one to each request with WithSession.
+type Session struct {
+	// LastWrite is when the client last wrote, as given by its session token, or zero if it has not written recently.
+	LastWrite time.Time
+	wrote     atomic.Bool
+}
+
+// Wrote reports whether tasks were written with the session since it was attached.
+func (s *Session) Wrote() bool {
+	return s.wrote.Load()
+}
+
+type sessionKey struct{}
+
+// WithSession returns a copy of ctx carrying the session.
+func WithSession(ctx context.Context, session *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// markWrite records in the session of ctx, if there is one, that tasks were written.
+func markWrite(ctx context.Context) {
+	if session, ok := ctx.Value(sessionKey{}).(*Session); ok {
+		session.wrote.Store(true)
+	}
+}
+
+// reader returns the connection pool that task reads made with ctx should use. Reads are spread over the healthy
+// replicas, unless the session wrote within the read-your-writes window, in which case they go to the primary.
+func (d *PostgresDatabase) reader(ctx context.Context) *sql.DB {
+	if len(d.replicas) == 0 {
+		return d.db
+	}
+	if session, ok := ctx.Value(sessionKey{}).(*Session); ok {
+		if session.Wrote() || time.Since(session.LastWrite) < d.readYourWritesWindow {
+			return d.db
+		}
+	}
+
+	start := d.nextReplica.Add(1)
+	for i := range d.replicas {
+		replica := d.replicas[(start+uint64(i))%uint64(len(d.replicas))]
+		if replica.healthy.Load() {
+			trace.SpanFromContext(ctx).SetAttributes(attribute.String("server.address", replica.host))
+			return replica.db
+		}
+	}
+	return d.db
+}
+
+// openReplicas opens a connection pool to each replica host, with the same settings as the primary.
+// A replica host may include a port, and otherwise uses the port of the primary.
+func openReplicas(settings PostgresConfig) ([]*replica, error) {
+	replicas := make([]*replica, 0, len(settings.Replicas))
+	for _, host := range settings.Replicas {
+		replicaSettings := settings
+		replicaSettings.Host = host
+		if h, port, err := net.SplitHostPort(host); err == nil {
+			replicaSettings.Host, replicaSettings.Port = h, port
+		}
+		db, err := openPool(replicaSettings)
+		if err != nil {
+			for _, opened := range replicas {
+				opened.db.Close()
+			}
+			return nil, err
+		}
+		replicas = append(replicas, &replica{host: host, db: db})
+	}
+	return replicas, nil
+}
+
+// checkReplicas checks the lag of every replica, removing those that cannot be reached or are more than
+// maxReplicaLag behind from the rotation and adding those that have caught up back to it.
+func (d *PostgresDatabase) checkReplicas(ctx context.Context) {
+	for _, replica := range d.replicas {
+		lag, err := replicaLag(ctx, replica.db, d.replicaCheckTimeout)
+		healthy := err == nil && lag <= d.maxReplicaLag
+		if replica.healthy.Swap(healthy) == healthy {
+			continue
+		}
+		switch {
+		case healthy:
+			d.logger.Info("Replica added to rotation", "replica", replica.host, "lag", lag.String())
+		case err != nil:
+			d.logger.Warn("Replica removed from rotation", "replica", replica.host, "error", err)
+		default:
+			d.logger.Warn("Replica removed from rotation", "replica", replica.host, "lag", lag.String())
+		}
+	}
+}
+
+// replicaLag returns how far a replica is behind the primary.
+func replicaLag(ctx context.Context, db *sql.DB, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var seconds float64
+	err := db.QueryRowContext(ctx, replicaLagQuery).Scan(&seconds)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check replica lag: %v", err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// monitorReplicas checks the replicas every interval until the context is done.
+func (d *PostgresDatabase) monitorReplicas(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.checkReplicas(ctx)
+		}
+	}
+}
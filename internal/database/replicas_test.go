@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// openTestPool returns a connection pool that is never connected, to tell the pools apart.
+func openTestPool(t *testing.T) *sql.DB {
+	db, err := sql.Open("postgres", "postgres://localhost/tasks?sslmode=disable")
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestReader(t *testing.T) {
+	primary := openTestPool(t)
+	replica1 := &replica{host: "replica-1", db: openTestPool(t)}
+	replica2 := &replica{host: "replica-2", db: openTestPool(t)}
+
+	wrote := &Session{}
+	markWrite(WithSession(context.Background(), wrote))
+
+	tests := []struct {
+		name     string
+		replicas []*replica
+		healthy  []bool
+		session  *Session
+		expected []*sql.DB
+	}{
+		{
+			name:     "Reader_NoReplicas",
+			expected: []*sql.DB{primary, primary},
+		},
+		{
+			name:     "Reader_RoundRobin",
+			replicas: []*replica{replica1, replica2},
+			healthy:  []bool{true, true},
+			expected: []*sql.DB{replica2.db, replica1.db, replica2.db},
+		},
+		{
+			name:     "Reader_SkipsUnhealthy",
+			replicas: []*replica{replica1, replica2},
+			healthy:  []bool{false, true},
+			expected: []*sql.DB{replica2.db, replica2.db},
+		},
+		{
+			name:     "Reader_NoneHealthy",
+			replicas: []*replica{replica1, replica2},
+			healthy:  []bool{false, false},
+			expected: []*sql.DB{primary},
+		},
+		{
+			name:     "Reader_RecentWrite",
+			replicas: []*replica{replica1},
+			healthy:  []bool{true},
+			session:  &Session{LastWrite: time.Now().Add(-time.Second)},
+			expected: []*sql.DB{primary},
+		},
+		{
+			name:     "Reader_OldWrite",
+			replicas: []*replica{replica1},
+			healthy:  []bool{true},
+			session:  &Session{LastWrite: time.Now().Add(-time.Minute)},
+			expected: []*sql.DB{replica1.db},
+		},
+		{
+			name:     "Reader_WroteInRequest",
+			replicas: []*replica{replica1},
+			healthy:  []bool{true},
+			session:  wrote,
+			expected: []*sql.DB{primary},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, healthy := range tt.healthy {
+				tt.replicas[i].healthy.Store(healthy)
+			}
+			d := &PostgresDatabase{db: primary, replicas: tt.replicas, readYourWritesWindow: 15 * time.Second}
+			ctx := context.Background()
+			if tt.session != nil {
+				ctx = WithSession(ctx, tt.session)
+			}
+
+			for _, expected := range tt.expected {
+				assert.Same(t, expected, d.reader(ctx))
+			}
+		})
+	}
+}
+
+func TestSession(t *testing.T) {
+	session := &Session{}
+	ctx := WithSession(context.Background(), session)
+	assert.False(t, session.Wrote())
+
+	markWrite(context.Background())
+	assert.False(t, session.Wrote())
+	markWrite(ctx)
+	assert.True(t, session.Wrote())
+}
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of PostgresDatabase methods, using the globally registered tracer provider.
+var tracer = otel.Tracer("github.com/SevvyP/tasks_v1/internal/database")
+
+// startSpan starts a client span for a PostgresDatabase method, named after the method.
+func startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "PostgresDatabase."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperationName(method)))
+}
+
+// endSpan ends a span started by startSpan. It is deferred with a pointer to the method's named error result,
+// which is recorded on the span if the method fails.
+func endSpan(span trace.Span, err *error) {
+	if *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
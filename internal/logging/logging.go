@@ -0,0 +1,89 @@
+// Package logging sets up the structured logger shared by the server, its middleware and the database.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Log formats that can be selected in Config.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Config contains the configuration for the logger.
+type Config struct {
+	// Level is the lowest level logged, one of "debug", "info", "warn" or "error". It defaults to "info".
+	Level string `json:"level"`
+	// Format is "json" or "text", and defaults to "json".
+	Format string `json:"format"`
+}
+
+// New returns a logger writing to w as configured. Records logged with a context inside a span
+// carry its trace_id and span_id, so that logs can be matched with traces.
+func New(config *Config, w io.Writer) (*slog.Logger, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	var level slog.Level
+	if config.Level != "" {
+		err := level.UnmarshalText([]byte(config.Level))
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level %q", config.Level)
+		}
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch config.Format {
+	case "", FormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	case FormatText:
+		handler = slog.NewTextHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", config.Format)
+	}
+	return slog.New(traceHandler{handler}), nil
+}
+
+type loggerKey struct{}
+
+// NewContext returns a copy of ctx carrying logger.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx by NewContext, or the default logger if there is none.
+func FromContext(ctx context.Context) *slog.Logger {
+	logger, ok := ctx.Value(loggerKey{}).(*slog.Logger)
+	if !ok {
+		return slog.Default()
+	}
+	return logger
+}
+
+// traceHandler adds the IDs of the span in the context to each record.
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, record slog.Record) error {
+	span := trace.SpanContextFromContext(ctx)
+	if span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,106 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         *Config
+		expectedOutput string
+		wantErr        bool
+	}{
+		{
+			name:           "Default",
+			config:         nil,
+			expectedOutput: `{"level":"INFO","msg":"info"}` + "\n" + `{"level":"WARN","msg":"warn"}` + "\n",
+		},
+		{
+			name:           "Debug",
+			config:         &Config{Level: "debug"},
+			expectedOutput: `{"level":"DEBUG","msg":"debug"}` + "\n" + `{"level":"INFO","msg":"info"}` + "\n" + `{"level":"WARN","msg":"warn"}` + "\n",
+		},
+		{
+			name:           "Warn",
+			config:         &Config{Level: "WARN", Format: FormatJSON},
+			expectedOutput: `{"level":"WARN","msg":"warn"}` + "\n",
+		},
+		{
+			name:           "Text",
+			config:         &Config{Level: "warn", Format: FormatText},
+			expectedOutput: "level=WARN msg=warn\n",
+		},
+		{
+			name:    "InvalidLevel",
+			config:  &Config{Level: "loud"},
+			wantErr: true,
+		},
+		{
+			name:    "UnknownFormat",
+			config:  &Config{Format: "xml"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			logger, err := New(tt.config, &output)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			// Drop the time so that the output can be compared.
+			logger = slog.New(withoutTime{logger.Handler()})
+			logger.Debug("debug")
+			logger.Info("info")
+			logger.Warn("warn")
+			assert.Equal(t, tt.expectedOutput, output.String())
+		})
+	}
+}
+
+func TestTraceIDs(t *testing.T) {
+	var output bytes.Buffer
+	logger, err := New(nil, &output)
+	assert.NoError(t, err)
+
+	span := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	})
+	logger.With("request_id", "req-123").InfoContext(trace.ContextWithSpanContext(context.Background(), span), "traced")
+
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(output.Bytes(), &record))
+	assert.Equal(t, "req-123", record["request_id"])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", record["trace_id"])
+	assert.Equal(t, "00f067aa0ba902b7", record["span_id"])
+}
+
+func TestFromContext(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil))
+	assert.Same(t, logger, FromContext(NewContext(context.Background(), logger)))
+	assert.Same(t, slog.Default(), FromContext(context.Background()))
+}
+
+// withoutTime is a handler that drops the time of each record.
+type withoutTime struct {
+	slog.Handler
+}
+
+func (h withoutTime) Handle(ctx context.Context, record slog.Record) error {
+	record.Time = time.Time{}
+	return h.Handler.Handle(ctx, record)
+}
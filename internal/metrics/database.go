@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// instrumentedDatabase is a TaskDatabase that records the duration and outcome of every call,
+// labelled with the name of the method.
+type instrumentedDatabase struct {
+	db      database.TaskDatabase
+	metrics *Metrics
+}
+
+// NewDatabase returns a TaskDatabase that records metrics for every operation before passing it on to db.
+func NewDatabase(db database.TaskDatabase, m *Metrics) database.TaskDatabase {
+	return &instrumentedDatabase{db: db, metrics: m}
+}
+
+// observe records an operation that started at start. It is deferred with a pointer to the named error result.
+func (d *instrumentedDatabase) observe(operation string, start time.Time, err *error) {
+	d.metrics.ObserveDatabaseOperation(operation, *err, time.Since(start))
+}
+
+func (d *instrumentedDatabase) GetTasks(ctx context.Context) (tasks *[]model.Task, err error) {
+	defer d.observe("GetTasks", time.Now(), &err)
+	return d.db.GetTasks(ctx)
+}
+
+func (d *instrumentedDatabase) GetTaskByID(ctx context.Context, id string) (task *model.Task, err error) {
+	defer d.observe("GetTaskByID", time.Now(), &err)
+	return d.db.GetTaskByID(ctx, id)
+}
+
+func (d *instrumentedDatabase) GetTaskByIDFromPrimary(ctx context.Context, id string) (task *model.Task, err error) {
+	defer d.observe("GetTaskByIDFromPrimary", time.Now(), &err)
+	return d.db.GetTaskByIDFromPrimary(ctx, id)
+}
+
+func (d *instrumentedDatabase) GetTasksByUserID(ctx context.Context, userID string) (tasks *[]model.Task, err error) {
+	defer d.observe("GetTasksByUserID", time.Now(), &err)
+	return d.db.GetTasksByUserID(ctx, userID)
+}
+
+func (d *instrumentedDatabase) GetTasksByIDs(ctx context.Context, ids []string) (tasks *[]model.Task, err error) {
+	defer d.observe("GetTasksByIDs", time.Now(), &err)
+	return d.db.GetTasksByIDs(ctx, ids)
+}
+
+func (d *instrumentedDatabase) GetTasksByParentIDs(ctx context.Context, parentIDs []string) (tasks *[]model.Task, err error) {
+	defer d.observe("GetTasksByParentIDs", time.Now(), &err)
+	return d.db.GetTasksByParentIDs(ctx, parentIDs)
+}
+
+func (d *instrumentedDatabase) GetRemindersByIDs(ctx context.Context, ids []string) (reminders *[]model.Reminder, err error) {
+	defer d.observe("GetRemindersByIDs", time.Now(), &err)
+	return d.db.GetRemindersByIDs(ctx, ids)
+}
+
+func (d *instrumentedDatabase) CreateTask(ctx context.Context, task model.Task) (err error) {
+	defer d.observe("CreateTask", time.Now(), &err)
+	return d.db.CreateTask(ctx, task)
+}
+
+func (d *instrumentedDatabase) UpdateTask(ctx context.Context, task model.Task) (err error) {
+	defer d.observe("UpdateTask", time.Now(), &err)
+	return d.db.UpdateTask(ctx, task)
+}
+
+func (d *instrumentedDatabase) DeleteTask(ctx context.Context, task model.Task) (err error) {
+	defer d.observe("DeleteTask", time.Now(), &err)
+	return d.db.DeleteTask(ctx, task)
+}
+
+func (d *instrumentedDatabase) ReserveIdempotencyKey(ctx context.Context, record model.IdempotencyRecord) (existing *model.IdempotencyRecord, err error) {
+	defer d.observe("ReserveIdempotencyKey", time.Now(), &err)
+	return d.db.ReserveIdempotencyKey(ctx, record)
+}
+
+func (d *instrumentedDatabase) SaveIdempotencyRecord(ctx context.Context, record model.IdempotencyRecord) (err error) {
+	defer d.observe("SaveIdempotencyRecord", time.Now(), &err)
+	return d.db.SaveIdempotencyRecord(ctx, record)
+}
+
+func (d *instrumentedDatabase) ReleaseIdempotencyKey(ctx context.Context, userID string, key string) (err error) {
+	defer d.observe("ReleaseIdempotencyKey", time.Now(), &err)
+	return d.db.ReleaseIdempotencyKey(ctx, userID, key)
+}
+
+func (d *instrumentedDatabase) TakeRateLimitToken(ctx context.Context, key string, capacity float64, refillPerSecond float64) (tokens float64, taken bool, err error) {
+	defer d.observe("TakeRateLimitToken", time.Now(), &err)
+	return d.db.TakeRateLimitToken(ctx, key, capacity, refillPerSecond)
+}
+
+func (d *instrumentedDatabase) PruneRateLimitBuckets(ctx context.Context, updatedBefore time.Time) (pruned int64, err error) {
+	defer d.observe("PruneRateLimitBuckets", time.Now(), &err)
+	return d.db.PruneRateLimitBuckets(ctx, updatedBefore)
+}
+
+func (d *instrumentedDatabase) GetTaskEvents(ctx context.Context, userID string, afterID int64) (events *[]model.TaskEvent, err error) {
+	defer d.observe("GetTaskEvents", time.Now(), &err)
+	return d.db.GetTaskEvents(ctx, userID, afterID)
+}
+
+func (d *instrumentedDatabase) GetLatestTaskEventID(ctx context.Context, userID string) (id int64, err error) {
+	defer d.observe("GetLatestTaskEventID", time.Now(), &err)
+	return d.db.GetLatestTaskEventID(ctx, userID)
+}
+
+func (d *instrumentedDatabase) ListenTaskEvents(ctx context.Context) (notifications <-chan string, err error) {
+	defer d.observe("ListenTaskEvents", time.Now(), &err)
+	return d.db.ListenTaskEvents(ctx)
+}
+
+func (d *instrumentedDatabase) GetTaskChanges(ctx context.Context, userID string, since int64) (changes *model.TaskChanges, err error) {
+	defer d.observe("GetTaskChanges", time.Now(), &err)
+	return d.db.GetTaskChanges(ctx, userID, since)
+}
+
+func (d *instrumentedDatabase) GetWebhooksByUserID(ctx context.Context, userID string) (webhooks *[]model.Webhook, err error) {
+	defer d.observe("GetWebhooksByUserID", time.Now(), &err)
+	return d.db.GetWebhooksByUserID(ctx, userID)
+}
+
+func (d *instrumentedDatabase) GetWebhookByID(ctx context.Context, id string) (webhook *model.Webhook, err error) {
+	defer d.observe("GetWebhookByID", time.Now(), &err)
+	return d.db.GetWebhookByID(ctx, id)
+}
+
+func (d *instrumentedDatabase) CreateWebhook(ctx context.Context, webhook model.Webhook) (err error) {
+	defer d.observe("CreateWebhook", time.Now(), &err)
+	return d.db.CreateWebhook(ctx, webhook)
+}
+
+func (d *instrumentedDatabase) UpdateWebhook(ctx context.Context, webhook model.Webhook) (err error) {
+	defer d.observe("UpdateWebhook", time.Now(), &err)
+	return d.db.UpdateWebhook(ctx, webhook)
+}
+
+func (d *instrumentedDatabase) DeleteWebhook(ctx context.Context, webhook model.Webhook) (err error) {
+	defer d.observe("DeleteWebhook", time.Now(), &err)
+	return d.db.DeleteWebhook(ctx, webhook)
+}
+
+func (d *instrumentedDatabase) CreateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) (err error) {
+	defer d.observe("CreateWebhookDelivery", time.Now(), &err)
+	return d.db.CreateWebhookDelivery(ctx, delivery)
+}
+
+func (d *instrumentedDatabase) GetWebhookDeliveries(ctx context.Context, webhookID string, status string) (deliveries *[]model.WebhookDelivery, err error) {
+	defer d.observe("GetWebhookDeliveries", time.Now(), &err)
+	return d.db.GetWebhookDeliveries(ctx, webhookID, status)
+}
+
+func (d *instrumentedDatabase) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) (deliveries *[]model.WebhookDelivery, err error) {
+	defer d.observe("ClaimWebhookDeliveries", time.Now(), &err)
+	return d.db.ClaimWebhookDeliveries(ctx, limit, lease)
+}
+
+func (d *instrumentedDatabase) UpdateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) (err error) {
+	defer d.observe("UpdateWebhookDelivery", time.Now(), &err)
+	return d.db.UpdateWebhookDelivery(ctx, delivery)
+}
+
+func (d *instrumentedDatabase) GetAPITokensByUserID(ctx context.Context, userID string) (tokens *[]model.APIToken, err error) {
+	defer d.observe("GetAPITokensByUserID", time.Now(), &err)
+	return d.db.GetAPITokensByUserID(ctx, userID)
+}
+
+func (d *instrumentedDatabase) GetAPITokenByID(ctx context.Context, id string) (token *model.APIToken, err error) {
+	defer d.observe("GetAPITokenByID", time.Now(), &err)
+	return d.db.GetAPITokenByID(ctx, id)
+}
+
+func (d *instrumentedDatabase) GetAPITokenByHash(ctx context.Context, hash string) (token *model.APIToken, err error) {
+	defer d.observe("GetAPITokenByHash", time.Now(), &err)
+	return d.db.GetAPITokenByHash(ctx, hash)
+}
+
+func (d *instrumentedDatabase) CreateAPIToken(ctx context.Context, token model.APIToken) (err error) {
+	defer d.observe("CreateAPIToken", time.Now(), &err)
+	return d.db.CreateAPIToken(ctx, token)
+}
+
+func (d *instrumentedDatabase) DeleteAPIToken(ctx context.Context, token model.APIToken) (err error) {
+	defer d.observe("DeleteAPIToken", time.Now(), &err)
+	return d.db.DeleteAPIToken(ctx, token)
+}
+
+func (d *instrumentedDatabase) TouchAPIToken(ctx context.Context, id string, usedAt time.Time) (err error) {
+	defer d.observe("TouchAPIToken", time.Now(), &err)
+	return d.db.TouchAPIToken(ctx, id, usedAt)
+}
+
+func (d *instrumentedDatabase) GetUser(ctx context.Context, id string) (user *model.User, err error) {
+	defer d.observe("GetUser", time.Now(), &err)
+	return d.db.GetUser(ctx, id)
+}
+
+func (d *instrumentedDatabase) UpsertUser(ctx context.Context, user model.User) (err error) {
+	defer d.observe("UpsertUser", time.Now(), &err)
+	return d.db.UpsertUser(ctx, user)
+}
+
+func (d *instrumentedDatabase) UpdateUser(ctx context.Context, user model.User) (err error) {
+	defer d.observe("UpdateUser", time.Now(), &err)
+	return d.db.UpdateUser(ctx, user)
+}
+
+func (d *instrumentedDatabase) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) (messages *[]model.OutboxMessage, err error) {
+	defer d.observe("ClaimOutboxMessages", time.Now(), &err)
+	return d.db.ClaimOutboxMessages(ctx, limit, lease)
+}
+
+func (d *instrumentedDatabase) MarkOutboxMessagesPublished(ctx context.Context, ids []string) (err error) {
+	defer d.observe("MarkOutboxMessagesPublished", time.Now(), &err)
+	return d.db.MarkOutboxMessagesPublished(ctx, ids)
+}
+
+func (d *instrumentedDatabase) PruneOutboxMessages(ctx context.Context, publishedBefore time.Time) (pruned int64, err error) {
+	defer d.observe("PruneOutboxMessages", time.Now(), &err)
+	return d.db.PruneOutboxMessages(ctx, publishedBefore)
+}
+
+func (d *instrumentedDatabase) Ping(ctx context.Context) (err error) {
+	defer d.observe("Ping", time.Now(), &err)
+	return d.db.Ping(ctx)
+}
+
+func (d *instrumentedDatabase) GetSchemaVersion(ctx context.Context) (version int, err error) {
+	defer d.observe("GetSchemaVersion", time.Now(), &err)
+	return d.db.GetSchemaVersion(ctx)
+}
+
+func (d *instrumentedDatabase) Close() (err error) {
+	defer d.observe("Close", time.Now(), &err)
+	return d.db.Close()
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dbStatsCollector exports the statistics of a sql.DB connection pool, read when the metrics are scraped.
+type dbStatsCollector struct {
+	stats func() sql.DBStats
+
+	maxOpen      *prometheus.Desc
+	connections  *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+	closed       *prometheus.Desc
+}
+
+func newDBStatsCollector(stats func() sql.DBStats) *dbStatsCollector {
+	return &dbStatsCollector{
+		stats: stats,
+		maxOpen: prometheus.NewDesc(prometheus.BuildFQName(namespace, "database", "max_open_connections"),
+			"Maximum number of open connections to the database, or 0 if there is no limit.", nil, nil),
+		connections: prometheus.NewDesc(prometheus.BuildFQName(namespace, "database", "connections"),
+			"Open connections to the database, by state.", []string{"state"}, nil),
+		waitCount: prometheus.NewDesc(prometheus.BuildFQName(namespace, "database", "wait_count_total"),
+			"Times a query waited for a connection to become available.", nil, nil),
+		waitDuration: prometheus.NewDesc(prometheus.BuildFQName(namespace, "database", "wait_duration_seconds_total"),
+			"Total time queries spent waiting for a connection.", nil, nil),
+		closed: prometheus.NewDesc(prometheus.BuildFQName(namespace, "database", "closed_connections_total"),
+			"Connections closed by the pool, by reason.", []string{"reason"}, nil),
+	}
+}
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.connections
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.closed
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.stats()
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(stats.InUse), "in_use")
+	ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(stats.Idle), "idle")
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.closed, prometheus.CounterValue, float64(stats.MaxIdleClosed), "max_idle")
+	ch <- prometheus.MustNewConstMetric(c.closed, prometheus.CounterValue, float64(stats.MaxIdleTimeClosed), "max_idle_time")
+	ch <- prometheus.MustNewConstMetric(c.closed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed), "max_lifetime")
+}
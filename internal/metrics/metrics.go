@@ -0,0 +1,134 @@
+// Package metrics collects the Prometheus metrics of the server and serves them at /metrics.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the name of every metric.
+const namespace = "tasks"
+
+// Metrics holds the collectors of the server, registered with a registry of its own.
+// A nil *Metrics is valid and records nothing, so that components can be used without metrics in tests.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+	dbOperations *prometheus.CounterVec
+	dbDuration   *prometheus.HistogramVec
+	jwtFailures  *prometheus.CounterVec
+	keyFailures  *prometheus.CounterVec
+}
+
+// New returns Metrics with every collector registered, along with the Go runtime and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled, by route, method and status.",
+		}, []string{"route", "method", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to handle HTTP requests, by route, method and status. Streams are observed when they end.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		dbOperations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "database_operations_total",
+			Help:      "Database operations, by operation and outcome.",
+		}, []string{"operation", "outcome"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "database_operation_duration_seconds",
+			Help:      "Time taken by database operations, by operation.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation"}),
+		jwtFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jwt_validation_failures_total",
+			Help:      "Requests rejected because their JWT was not valid, by reason.",
+		}, []string{"reason"}),
+		keyFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "api_key_failures_total",
+			Help:      "Requests rejected because of their API key, by reason.",
+		}, []string{"reason"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.dbOperations,
+		m.dbDuration,
+		m.jwtFailures,
+		m.keyFailures,
+	)
+	return m
+}
+
+// Handler returns a handler serving the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	if m == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// ObserveHTTPRequest records a request that was handled by a route.
+func (m *Metrics) ObserveHTTPRequest(route string, method string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	labels := prometheus.Labels{"route": route, "method": method, "status": strconv.Itoa(status)}
+	m.httpRequests.With(labels).Inc()
+	m.httpDuration.With(labels).Observe(duration.Seconds())
+}
+
+// ObserveDatabaseOperation records a database operation and whether it failed.
+func (m *Metrics) ObserveDatabaseOperation(operation string, err error, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	m.dbOperations.WithLabelValues(operation, outcome).Inc()
+	m.dbDuration.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+// ObserveJWTFailure records a request rejected because of its JWT.
+func (m *Metrics) ObserveJWTFailure(reason string) {
+	if m == nil {
+		return
+	}
+	m.jwtFailures.WithLabelValues(reason).Inc()
+}
+
+// ObserveAPIKeyFailure records a request rejected because of its API key.
+func (m *Metrics) ObserveAPIKeyFailure(reason string) {
+	if m == nil {
+		return
+	}
+	m.keyFailures.WithLabelValues(reason).Inc()
+}
+
+// RegisterDBStats exports the connection pool statistics returned by stats.
+func (m *Metrics) RegisterDBStats(stats func() sql.DBStats) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(newDBStatsCollector(stats))
+}
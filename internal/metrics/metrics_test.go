@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+func TestDatabaseMetrics(t *testing.T) {
+	mockDB := new(database.MockDatabase)
+	mockDB.On("GetTaskByID", "1").Return(&model.Task{ID: "1"}, nil)
+	mockDB.On("GetTaskByID", "2").Return((*model.Task)(nil), errors.New("database unavailable"))
+	mockDB.On("CreateTask", model.Task{ID: "3"}).Return(nil)
+
+	m := New()
+	db := NewDatabase(mockDB, m)
+
+	task, err := db.GetTaskByID(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", task.ID)
+	_, err = db.GetTaskByID(context.Background(), "2")
+	assert.Error(t, err)
+	assert.NoError(t, db.CreateTask(context.Background(), model.Task{ID: "3"}))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.dbOperations.WithLabelValues("GetTaskByID", "success")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.dbOperations.WithLabelValues("GetTaskByID", "error")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.dbOperations.WithLabelValues("CreateTask", "success")))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.dbDuration))
+	mockDB.AssertExpectations(t)
+}
+
+func TestDBStats(t *testing.T) {
+	m := New()
+	m.RegisterDBStats(func() sql.DBStats {
+		return sql.DBStats{MaxOpenConnections: 10, InUse: 3, Idle: 2, WaitCount: 4, WaitDuration: 1500 * time.Millisecond}
+	})
+
+	families, err := m.registry.Gather()
+	assert.NoError(t, err)
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			name := family.GetName()
+			for _, label := range metric.GetLabel() {
+				name += "/" + label.GetValue()
+			}
+			values[name] = metric.GetGauge().GetValue() + metric.GetCounter().GetValue()
+		}
+	}
+
+	assert.Equal(t, 10.0, values["tasks_database_max_open_connections"])
+	assert.Equal(t, 3.0, values["tasks_database_connections/in_use"])
+	assert.Equal(t, 2.0, values["tasks_database_connections/idle"])
+	assert.Equal(t, 4.0, values["tasks_database_wait_count_total"])
+	assert.Equal(t, 1.5, values["tasks_database_wait_duration_seconds_total"])
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	assert.NotPanics(t, func() {
+		m.ObserveHTTPRequest("/tasks", "GET", 200, time.Second)
+		m.ObserveDatabaseOperation("GetTasks", nil, time.Second)
+		m.ObserveJWTFailure("missing")
+		m.RegisterDBStats(func() sql.DBStats { return sql.DBStats{} })
+	})
+}
@@ -0,0 +1,216 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/SevvyP/tasks_v1/internal/logging"
+	"github.com/SevvyP/tasks_v1/internal/metrics"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// APIKeyPrefix starts every API key, which tells them apart from JWTs sent in the same Authorization header.
+const APIKeyPrefix = "tasks_pat_"
+
+// lastUsedPrecision is how old the recorded last use of an API key may get before a request records it again,
+// so that a busy key does not write on every request.
+const lastUsedPrecision = time.Minute
+
+var (
+	errUnknownAPIKey = errors.New("API key does not exist")
+	errExpiredAPIKey = errors.New("API key has expired")
+	errAPIKeyScope   = errors.New("API key does not have the scope")
+)
+
+// APIKeyStore finds API keys by the hash of their secret and records when they are used.
+type APIKeyStore interface {
+	GetAPITokenByHash(ctx context.Context, hash string) (*model.APIToken, error)
+	TouchAPIToken(ctx context.Context, id string, usedAt time.Time) error
+}
+
+// HashAPIKey returns the hash an API key is stored and looked up by. Keys are random and long,
+// so a fast hash is enough to keep them from being recovered from the database.
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// GetAPIKeyID returns the ID of the API key the request was authenticated with,
+// or an empty string if it was authenticated with a JWT or not at all.
+func GetAPIKeyID(ctx context.Context) string {
+	custom, ok := getCustomClaims(ctx)
+	if !ok {
+		return ""
+	}
+	return custom.APIKeyID
+}
+
+// APIKeyAllows reports whether the caller authenticated in ctx may act with the scope. Callers authenticated with a
+// JWT always may, while an API key needs the scope. The middleware checks a scope for each request by its method,
+// so operations that do not match their request's method, such as mutations sent over a WebSocket opened with a GET,
+// must check it themselves.
+func APIKeyAllows(ctx context.Context, scope string) bool {
+	custom, ok := getCustomClaims(ctx)
+	if !ok || custom.APIKeyID == "" {
+		return true
+	}
+	return custom.HasScope(scope)
+}
+
+// EnsureValidAPIKey returns a middleware that authenticates requests whose bearer token is an API key, and passes
+// every other request to authenticate, such as EnsureValidToken. A valid key stores the same claims in the
+// context as a JWT of its owner would, with its scopes, so GetUserID works the same for both.
+// GET and HEAD requests need the read scope and others the write scope, or an HTTP 403 Forbidden is returned.
+// Keys that do not exist or have expired get an HTTP 401 Unauthorized, and are counted in m by reason.
+func EnsureValidAPIKey(keys APIKeyStore, m *metrics.Metrics, authenticate func(next http.Handler) http.Handler) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fallback := authenticate(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, _ := jwtmiddleware.AuthHeaderTokenExtractor(r)
+			if !strings.HasPrefix(key, APIKeyPrefix) {
+				fallback.ServeHTTP(w, r)
+				return
+			}
+
+			scope := model.ScopeWrite
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				scope = model.ScopeRead
+			}
+			claims, err := checkAPIKey(r.Context(), keys, key, scope)
+			if err != nil {
+				logging.FromContext(r.Context()).WarnContext(r.Context(), "Failed to validate API key", "error", err)
+				m.ObserveAPIKeyFailure(apiKeyFailureReason(err))
+				switch {
+				case errors.Is(err, errAPIKeyScope):
+					WriteProblem(w, r, http.StatusForbidden, model.ProblemForbidden, fmt.Sprintf("The API key does not have the %s scope.", scope))
+				case errors.Is(err, errUnknownAPIKey), errors.Is(err, errExpiredAPIKey):
+					WriteProblem(w, r, http.StatusUnauthorized, model.ProblemUnauthorized, "Failed to validate API key.")
+				default:
+					WriteInternalError(w, r, err)
+				}
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
+		})
+	}
+}
+
+// EnsureValidAPIKeyGRPC returns gRPC interceptors that authenticate calls whose bearer token is an API key, and
+// pass every other call to the given interceptors, such as those of EnsureValidTokenGRPC. Get, List and Watch
+// methods need the read scope and the rest the write scope, or the call fails with PERMISSION_DENIED.
+func EnsureValidAPIKeyGRPC(keys APIKeyStore, m *metrics.Metrics, unary grpc.UnaryServerInterceptor, stream grpc.StreamServerInterceptor) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	authenticate := func(ctx context.Context, method string) (context.Context, error) {
+		scope := model.ScopeWrite
+		name := method[strings.LastIndex(method, "/")+1:]
+		if strings.HasPrefix(name, "Get") || strings.HasPrefix(name, "List") || strings.HasPrefix(name, "Watch") {
+			scope = model.ScopeRead
+		}
+		claims, err := checkAPIKey(ctx, keys, bearerToken(ctx), scope)
+		if err != nil {
+			logging.FromContext(ctx).WarnContext(ctx, "Failed to validate API key", "error", err)
+			m.ObserveAPIKeyFailure(apiKeyFailureReason(err))
+			switch {
+			case errors.Is(err, errAPIKeyScope):
+				return nil, status.Errorf(grpccodes.PermissionDenied, "The API key does not have the %s scope.", scope)
+			case errors.Is(err, errUnknownAPIKey), errors.Is(err, errExpiredAPIKey):
+				return nil, status.Error(grpccodes.Unauthenticated, "Failed to validate API key.")
+			default:
+				return nil, status.Error(grpccodes.Internal, "Internal server error")
+			}
+		}
+		return WithClaims(ctx, claims), nil
+	}
+
+	apiKeyUnary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(bearerToken(ctx), APIKeyPrefix) {
+			return unary(ctx, req, info, handler)
+		}
+		ctx, err := authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	apiKeyStream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !strings.HasPrefix(bearerToken(ss.Context()), APIKeyPrefix) {
+			return stream(srv, ss, info, handler)
+		}
+		ctx, err := authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+	return apiKeyUnary, apiKeyStream
+}
+
+// checkAPIKey looks up an API key in a span and returns the claims of the user it acts for, if it has not expired
+// and grants the scope. The key's use is recorded if its last recorded use is older than lastUsedPrecision.
+func checkAPIKey(ctx context.Context, keys APIKeyStore, key string, scope string) (_ *validator.ValidatedClaims, err error) {
+	ctx, span := tracer.Start(ctx, "EnsureValidAPIKey")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, apiKeyFailureReason(err))
+		}
+		span.End()
+	}()
+
+	token, err := keys.GetAPITokenByHash(ctx, HashAPIKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, errUnknownAPIKey
+	}
+	now := time.Now().UTC()
+	if !now.Before(token.ExpiresAt) {
+		return nil, fmt.Errorf("%w: %s expired at %s", errExpiredAPIKey, token.ID, token.ExpiresAt.Format(time.RFC3339))
+	}
+	if !slices.Contains(token.Scopes, scope) {
+		return nil, fmt.Errorf("%w %s: %s", errAPIKeyScope, scope, token.ID)
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= lastUsedPrecision {
+		if err := keys.TouchAPIToken(ctx, token.ID, now); err != nil {
+			logging.FromContext(ctx).WarnContext(ctx, "Failed to record API key use", "error", err)
+		}
+	}
+
+	return &validator.ValidatedClaims{
+		RegisteredClaims: validator.RegisteredClaims{
+			Subject: token.UserID,
+			ID:      token.ID,
+			Expiry:  token.ExpiresAt.Unix(),
+		},
+		CustomClaims: &CustomClaims{Scope: strings.Join(token.Scopes, " "), APIKeyID: token.ID},
+	}, nil
+}
+
+// apiKeyFailureReason returns why an API key was rejected, as recorded in the API key failure metric.
+func apiKeyFailureReason(err error) string {
+	switch {
+	case errors.Is(err, errUnknownAPIKey):
+		return "unknown"
+	case errors.Is(err, errExpiredAPIKey):
+		return "expired"
+	case errors.Is(err, errAPIKeyScope):
+		return "missing_scope"
+	default:
+		return "unavailable"
+	}
+}
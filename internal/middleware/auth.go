@@ -2,26 +2,107 @@ package middleware
 
 import (
 	"context"
-	"log"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
 	"github.com/auth0/go-jwt-middleware/v2/jwks"
 	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/codes"
+	"gopkg.in/go-jose/go-jose.v2/jwt"
+
+	"github.com/SevvyP/tasks_v1/internal/logging"
+	"github.com/SevvyP/tasks_v1/internal/metrics"
+	"github.com/SevvyP/tasks_v1/pkg/model"
 )
 
-// AuthConfig contains the configuration for the JWT middleware.
+// AuthConfig contains the configuration for the JWT middleware. Tokens are accepted from every issuer in Issuers
+// and from the one described by the other fields, if Domain or IssuerURL is set: tokens issued by
+// https://<Domain>/, or by IssuerURL if it is set, for Audience. Their signing keys are fetched from the issuer
+// unless one of JWKSFile, PublicKeyFile or HMACSecret is set, which lets the server run without reaching an issuer.
 type AuthConfig struct {
-	Domain   string `json:"domain"`
-	Audience string `json:"audience"`
+	Domain    string `json:"domain"`
+	Audience  string `json:"audience"`
+	IssuerURL string `json:"issuer_url"`
+	// JWKSFile names a JSON Web Key Set file. Tokens must name one of its keys in their kid header.
+	JWKSFile string `json:"jwks_file"`
+	// PublicKeyFile names a PEM file holding an RSA, ECDSA or Ed25519 public key.
+	PublicKeyFile string `json:"public_key_file"`
+	// HMACSecret is a secret shared with whoever mints tokens, which are then signed with HS256.
+	// Anyone who knows it can mint tokens for any user, so it is only meant for development.
+	// HMACSecretFile names a file holding it, such as a mounted secret, and is read in place of HMACSecret.
+	HMACSecret     string         `json:"hmac_secret" secret:"true"`
+	HMACSecretFile string         `json:"hmac_secret_file"`
+	Issuers        []IssuerConfig `json:"issuers"`
+}
+
+// Issuer returns the issuer tokens must name in their iss claim to be checked against the fields of the config
+// other than Issuers.
+func (c *AuthConfig) Issuer() string {
+	if c.IssuerURL != "" {
+		return c.IssuerURL
+	}
+	return "https://" + c.Domain + "/"
+}
+
+// AllIssuers returns every issuer tokens are accepted from, starting with the one described by the fields of the
+// config other than Issuers if Domain or IssuerURL is set.
+func (c *AuthConfig) AllIssuers() []IssuerConfig {
+	var issuers []IssuerConfig
+	if c.Domain != "" || c.IssuerURL != "" {
+		issuers = append(issuers, IssuerConfig{
+			URL:           c.Issuer(),
+			Audience:      c.Audience,
+			JWKSFile:      c.JWKSFile,
+			PublicKeyFile: c.PublicKeyFile,
+			hmacSecret:    c.HMACSecret,
+		})
+	}
+	return append(issuers, c.Issuers...)
 }
 
 // CustomClaims contains custom data we want from the token.
 type CustomClaims struct {
 	Scope string `json:"scope"`
+	// Claims holds every claim of the token, so that ClaimMapping can read claims that are not known in advance.
+	Claims map[string]interface{} `json:"-"`
+	// APIKeyID is the ID of the API key the request was authenticated with, if it was not a JWT.
+	APIKeyID string `json:"-"`
+	// Email, Name and Timezone are the user's profile, as read from Claims by the issuer's ClaimMapping.
+	Email    string `json:"-"`
+	Name     string `json:"-"`
+	Timezone string `json:"-"`
+}
+
+// UnmarshalJSON decodes the claims of a token, keeping all of them in Claims.
+func (c *CustomClaims) UnmarshalJSON(data []byte) error {
+	type customClaims CustomClaims
+	if err := json.Unmarshal(data, (*customClaims)(c)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &c.Claims)
+}
+
+// claim returns the value of a string or numeric claim, named name or fallback if name is empty,
+// or an empty string if the token does not have it.
+func (c *CustomClaims) claim(name string, fallback string) string {
+	if name == "" {
+		name = fallback
+	}
+	switch value := c.Claims[name].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return ""
 }
 
 // Validate does nothing for this example, but we need
@@ -30,41 +111,108 @@ func (c CustomClaims) Validate(ctx context.Context) error {
 	return nil
 }
 
-// EnsureValidToken is a middleware that will check the validity of our JWT.
-func EnsureValidToken(config *AuthConfig) func(next http.Handler) http.Handler {
-	issuerURL, err := url.Parse("https://" + config.Domain + "/")
-	if err != nil {
-		log.Fatalf("Failed to parse the issuer url: %v", err)
+// jwksCheckInterval is how long the result of a JWKS reachability check is reused before the issuer is asked again.
+const jwksCheckInterval = time.Minute
+
+// jwksClient returns the HTTP client used to fetch the issuer's signing keys, traced so that a slow fetch
+// shows up as a span of the request that waited for it.
+func jwksClient() *http.Client {
+	return &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+}
+
+// validateToken validates a token in a span, so that time spent validating tokens, including fetching
+// signing keys, can be told apart from the handler's own work.
+func validateToken(jwtValidator *tokenValidator) jwtmiddleware.ValidateToken {
+	return func(ctx context.Context, token string) (interface{}, error) {
+		ctx, span := tracer.Start(ctx, "EnsureValidToken")
+		defer span.End()
+
+		claims, err := jwtValidator.ValidateToken(ctx, token)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, jwtFailureReason(err))
+			return nil, err
+		}
+		recordUser(ctx, claims.RegisteredClaims.Subject)
+		return claims, nil
 	}
+}
 
-	provider := jwks.NewCachingProvider(issuerURL, 5*time.Minute)
-
-	jwtValidator, err := validator.New(
-		provider.KeyFunc,
-		validator.RS256,
-		issuerURL.String(),
-		[]string{config.Audience},
-		validator.WithCustomClaims(
-			func() validator.CustomClaims {
-				return &CustomClaims{}
-			},
-		),
-		validator.WithAllowedClockSkew(time.Minute),
-	)
-	if err != nil {
-		log.Fatalf("Failed to set up the jwt validator")
+// NewJWKSCheck returns a function reporting whether the signing keys of every configured issuer can be fetched.
+// The validators keep using cached keys when an issuer is down, so this fetches them itself,
+// reusing the result for jwksCheckInterval so that frequent readiness probes do not hammer the issuers.
+// Keys from the config are never fetched, so issuers with them are not checked.
+func NewJWKSCheck(config *AuthConfig) func(ctx context.Context) error {
+	providers := map[string]*jwks.Provider{}
+	for _, issuer := range config.AllIssuers() {
+		if !issuer.localKeys() {
+			providers[issuer.URL] = jwks.NewProvider(issuerURL(issuer.URL), issuer.providerOptions()...)
+		}
+	}
+
+	var mu sync.Mutex
+	var checkedAt time.Time
+	var lastErr error
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(checkedAt) < jwksCheckInterval {
+			return lastErr
+		}
+		var errs []error
+		for issuer, provider := range providers {
+			if _, err := provider.KeyFunc(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("failed to fetch JWKS of %s: %v", issuer, err))
+			}
+		}
+		err := errors.Join(errs...)
+		checkedAt, lastErr = time.Now(), err
+		return err
 	}
+}
+
+// jwtFailureReason returns why a token was rejected, as recorded in the JWT failure metric.
+// The validator does not return typed errors for every case, so some are recognised by their message.
+func jwtFailureReason(err error) string {
+	message := err.Error()
+	switch {
+	case errors.Is(err, jwtmiddleware.ErrJWTMissing):
+		return "missing"
+	case errors.Is(err, jwt.ErrExpired):
+		return "expired"
+	case errors.Is(err, jwt.ErrNotValidYet), errors.Is(err, jwt.ErrIssuedInTheFuture):
+		return "not_yet_valid"
+	case errors.Is(err, jwt.ErrInvalidIssuer):
+		return "invalid_issuer"
+	case errors.Is(err, jwt.ErrInvalidAudience):
+		return "invalid_audience"
+	case errors.Is(err, errMissingUserID):
+		return "missing_user_id"
+	case strings.Contains(message, "error getting the keys"):
+		return "jwks_unavailable"
+	case strings.Contains(message, "error extracting token"), strings.Contains(message, "could not parse the token"):
+		return "malformed"
+	case strings.Contains(message, "signing method is invalid"), strings.Contains(message, "could not get token claims"):
+		return "invalid_signature"
+	default:
+		return "invalid"
+	}
+}
+
+// EnsureValidToken is a middleware that will check the validity of our JWT.
+// Rejected tokens are counted in m by reason.
+func EnsureValidToken(config *AuthConfig, m *metrics.Metrics) func(next http.Handler) http.Handler {
+	jwtValidator := newValidator(config)
 
 	errorHandler := func(w http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("Encountered error while validating JWT: %v", err)
+		logging.FromContext(r.Context()).WarnContext(r.Context(), "Failed to validate JWT", "error", err)
+		m.ObserveJWTFailure(jwtFailureReason(err))
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte(`{"message":"Failed to validate JWT."}`))
+		WriteProblem(w, r, http.StatusUnauthorized, model.ProblemUnauthorized, "Failed to validate JWT.")
 	}
 
 	middleware := jwtmiddleware.New(
-		jwtValidator.ValidateToken,
+		validateToken(jwtValidator),
 		jwtmiddleware.WithErrorHandler(errorHandler),
 	)
 
@@ -73,6 +221,40 @@ func EnsureValidToken(config *AuthConfig) func(next http.Handler) http.Handler {
 	}
 }
 
+// WithClaims returns a copy of ctx carrying validated JWT claims, where EnsureValidToken stores them.
+func WithClaims(ctx context.Context, claims interface{}) context.Context {
+	if validated, ok := claims.(*validator.ValidatedClaims); ok {
+		recordUser(ctx, validated.RegisteredClaims.Subject)
+	}
+	return context.WithValue(ctx, jwtmiddleware.ContextKey{}, claims)
+}
+
+// WithClaimsFrom returns a copy of ctx carrying the claims stored in from, for work that runs on a context of its
+// own but on behalf of the caller of from, such as a subscription.
+func WithClaimsFrom(ctx context.Context, from context.Context) context.Context {
+	return context.WithValue(ctx, jwtmiddleware.ContextKey{}, from.Value(jwtmiddleware.ContextKey{}))
+}
+
+// GetUserID returns the subject of the validated JWT stored in the context,
+// or an empty string if the request was not authenticated.
+func GetUserID(ctx context.Context) string {
+	claims, ok := ctx.Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
+	if !ok {
+		return ""
+	}
+	return claims.RegisteredClaims.Subject
+}
+
+// getCustomClaims returns the custom claims of the validated JWT or API key stored in the context, if there is one.
+func getCustomClaims(ctx context.Context) (*CustomClaims, bool) {
+	claims, ok := ctx.Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
+	if !ok {
+		return nil, false
+	}
+	custom, ok := claims.CustomClaims.(*CustomClaims)
+	return custom, ok
+}
+
 // HasScope checks whether our claims have a specific scope.
 func (c CustomClaims) HasScope(expectedScope string) bool {
 	result := strings.Split(c.Scope, " ")
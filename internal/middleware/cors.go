@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/tasks_v1/internal/config"
+)
+
+// CORSConfig contains the cross-origin requests browsers are allowed to make. Only the origins listed in
+// AllowedOrigins may call the API, or any origin if it is "*", which cannot be combined with AllowCredentials.
+// AllowedMethods and AllowedHeaders are what preflight requests may ask for, ExposedHeaders are the response
+// headers scripts can read, and MaxAge is how long browsers may cache the result of a preflight request.
+type CORSConfig struct {
+	AllowedOrigins   []string        `json:"allowed_origins"`
+	AllowedMethods   []string        `json:"allowed_methods"`
+	AllowedHeaders   []string        `json:"allowed_headers"`
+	ExposedHeaders   []string        `json:"exposed_headers"`
+	AllowCredentials bool            `json:"allow_credentials"`
+	MaxAge           config.Duration `json:"max_age"`
+}
+
+// CORS returns a middleware that answers preflight requests and adds CORS headers to the responses to requests
+// from allowed origins. Preflight requests are answered with an HTTP 204 No Content without calling next, so it must
+// run before authentication, which would reject them for having no token. Requests from origins that are not allowed
+// are served without CORS headers, which browsers then refuse to hand to scripts. A nil config allows no origins.
+func CORS(config *CORSConfig) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if config == nil || len(config.AllowedOrigins) == 0 {
+			return next
+		}
+		allowMethods := strings.Join(config.AllowedMethods, ", ")
+		allowHeaders := strings.Join(config.AllowedHeaders, ", ")
+		exposeHeaders := strings.Join(config.ExposedHeaders, ", ")
+		maxAge := strconv.Itoa(int(time.Duration(config.MaxAge) / time.Second))
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			w.Header().Add("Vary", "Origin")
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			allowed := origin != "" && config.AllowsOrigin(origin)
+			if allowed {
+				if slices.Contains(config.AllowedOrigins, "*") && !config.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				} else {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+				if config.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+
+			if !preflight {
+				if allowed && exposeHeaders != "" {
+					w.Header().Set("Access-Control-Expose-Headers", exposeHeaders)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+				if allowHeaders != "" {
+					w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+				}
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// AllowsOrigin reports whether requests from the origin are allowed. A nil config allows no origins.
+func (c *CORSConfig) AllowsOrigin(origin string) bool {
+	if c == nil {
+		return false
+	}
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
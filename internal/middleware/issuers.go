@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/auth0/go-jwt-middleware/v2/jwks"
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"gopkg.in/go-jose/go-jose.v2/jwt"
+)
+
+// IssuerAlgorithms are the algorithms an issuer's Algorithms may allow.
+var IssuerAlgorithms = []string{string(validator.RS256), string(validator.ES256), string(validator.EdDSA)}
+
+// errMissingUserID is returned for tokens without the claim their issuer's ClaimMapping takes the user ID from.
+var errMissingUserID = errors.New("token has no user ID claim")
+
+// IssuerConfig describes an identity provider whose tokens are accepted. Tokens must name URL in their iss claim
+// and Audience in their aud claim. Their signing keys are fetched from JWKSURL, or from the URL published in the
+// issuer's OpenID configuration if it is not set, unless they are read from JWKSFile or PublicKeyFile.
+type IssuerConfig struct {
+	URL           string `json:"url"`
+	Audience      string `json:"audience"`
+	JWKSURL       string `json:"jwks_url"`
+	JWKSFile      string `json:"jwks_file"`
+	PublicKeyFile string `json:"public_key_file"`
+	// Algorithms are the algorithms tokens may be signed with, out of IssuerAlgorithms. They default to RS256, or to
+	// the algorithm of the key when it is read from a file.
+	Algorithms []string     `json:"algorithms"`
+	Claims     ClaimMapping `json:"claims"`
+
+	// hmacSecret is AuthConfig.HMACSecret, which only the issuer described by AuthConfig's own fields can use.
+	hmacSecret string
+}
+
+// localKeys reports whether tokens are checked with keys from the config rather than ones fetched from the issuer.
+func (c IssuerConfig) localKeys() bool {
+	return c.JWKSFile != "" || c.PublicKeyFile != "" || c.hmacSecret != ""
+}
+
+// providerOptions returns the options of the providers fetching the issuer's signing keys.
+func (c IssuerConfig) providerOptions() []jwks.ProviderOption {
+	options := []jwks.ProviderOption{jwks.WithCustomClient(jwksClient())}
+	if c.JWKSURL != "" {
+		options = append(options, jwks.WithCustomJWKSURI(issuerURL(c.JWKSURL)))
+	}
+	return options
+}
+
+// ClaimMapping describes how the user a token identifies is read from its claims, so that every issuer's users
+// end up with IDs of the same form however their tokens name them.
+type ClaimMapping struct {
+	// UserID is the claim holding the user's ID, "sub" by default.
+	UserID string `json:"user_id"`
+	// UserIDPrefix is put in front of the user's ID, so that users of different issuers cannot share an ID.
+	UserIDPrefix string `json:"user_id_prefix"`
+	// Email, Name and Timezone are the claims holding the user's profile, "email", "name" and "zoneinfo" by
+	// default. Tokens without them still identify the user.
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Timezone string `json:"timezone"`
+}
+
+// userID returns the ID of the user identified by a token's claims.
+func (m ClaimMapping) userID(claims *validator.ValidatedClaims) (string, error) {
+	claim := m.UserID
+	if claim == "" {
+		claim = "sub"
+	}
+	var id string
+	if claim == "sub" {
+		id = claims.RegisteredClaims.Subject
+	} else if custom, ok := claims.CustomClaims.(*CustomClaims); ok {
+		id = custom.claim(claim, "")
+	}
+	if id == "" {
+		return "", fmt.Errorf("%w %q", errMissingUserID, claim)
+	}
+	return m.UserIDPrefix + id, nil
+}
+
+// profile copies the user's profile from a token's claims into its custom claims.
+// A timezone that is not a known IANA name is left out.
+func (m ClaimMapping) profile(claims *validator.ValidatedClaims) {
+	custom, ok := claims.CustomClaims.(*CustomClaims)
+	if !ok {
+		return
+	}
+	custom.Email = custom.claim(m.Email, "email")
+	custom.Name = custom.claim(m.Name, "name")
+	custom.Timezone = custom.claim(m.Timezone, "zoneinfo")
+	if !ValidTimezone(custom.Timezone) {
+		custom.Timezone = ""
+	}
+}
+
+// issuerURL parses the URL of an issuer or of its signing keys.
+func issuerURL(rawURL string) *url.URL {
+	issuerURL, err := url.Parse(rawURL)
+	if err != nil {
+		log.Fatalf("Failed to parse the issuer url: %v", err)
+	}
+	return issuerURL
+}
+
+// issuerValidator checks the tokens of a single issuer.
+type issuerValidator struct {
+	// validators holds a validator for each algorithm the issuer's tokens may be signed with.
+	validators map[string]*validator.Validator
+	claims     ClaimMapping
+}
+
+// tokenValidator checks tokens against the issuer they name in their iss claim.
+type tokenValidator struct {
+	issuers map[string]*issuerValidator
+}
+
+// newValidator returns a JWT validator for tokens of every configured issuer.
+func newValidator(config *AuthConfig) *tokenValidator {
+	v := &tokenValidator{issuers: map[string]*issuerValidator{}}
+	for _, issuer := range config.AllIssuers() {
+		keyFunc, algorithms, err := keySource(issuer)
+		if err != nil {
+			log.Fatalf("Failed to load the jwt signing keys of %s: %v", issuer.URL, err)
+		}
+
+		issuerValidator := &issuerValidator{validators: map[string]*validator.Validator{}, claims: issuer.Claims}
+		for _, algorithm := range algorithms {
+			jwtValidator, err := validator.New(
+				keyFunc,
+				algorithm,
+				issuer.URL,
+				[]string{issuer.Audience},
+				validator.WithCustomClaims(
+					func() validator.CustomClaims {
+						return &CustomClaims{}
+					},
+				),
+				validator.WithAllowedClockSkew(time.Minute),
+			)
+			if err != nil {
+				log.Fatalf("Failed to set up the jwt validator of %s: %v", issuer.URL, err)
+			}
+			issuerValidator.validators[string(algorithm)] = jwtValidator
+		}
+		v.issuers[issuer.URL] = issuerValidator
+	}
+	return v
+}
+
+// ValidateToken checks a token against the issuer it names, and returns its claims with the subject replaced by
+// the user ID the issuer's ClaimMapping reads from them, and the user's profile read the same way.
+func (v *tokenValidator) ValidateToken(ctx context.Context, token string) (*validator.ValidatedClaims, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the token: %w", err)
+	}
+	// The issuer is only read to pick the keys the token is checked with, which the issuer's validator then does.
+	var unverified jwt.Claims
+	if err := parsed.UnsafeClaimsWithoutVerification(&unverified); err != nil {
+		return nil, fmt.Errorf("could not parse the token: %w", err)
+	}
+	issuer, ok := v.issuers[unverified.Issuer]
+	if !ok {
+		return nil, fmt.Errorf("expected claims not validated: %w", jwt.ErrInvalidIssuer)
+	}
+	algorithm := parsed.Headers[0].Algorithm
+	jwtValidator, ok := issuer.validators[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("signing method is invalid: %q tokens are not accepted from %s", algorithm, unverified.Issuer)
+	}
+
+	result, err := jwtValidator.ValidateToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	claims := result.(*validator.ValidatedClaims)
+	claims.RegisteredClaims.Subject, err = issuer.claims.userID(claims)
+	if err != nil {
+		return nil, err
+	}
+	issuer.claims.profile(claims)
+	return claims, nil
+}
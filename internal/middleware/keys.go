@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/auth0/go-jwt-middleware/v2/jwks"
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"gopkg.in/go-jose/go-jose.v2"
+)
+
+// keySource returns the function giving the keys an issuer's tokens are checked with, and the algorithms they
+// may be signed with. Keys from the config are loaded once here; otherwise they are fetched from the issuer and cached.
+func keySource(issuer IssuerConfig) (func(ctx context.Context) (interface{}, error), []validator.SignatureAlgorithm, error) {
+	var key interface{}
+	var algorithm validator.SignatureAlgorithm
+	var err error
+	switch {
+	case issuer.hmacSecret != "":
+		key, algorithm = []byte(issuer.hmacSecret), validator.HS256
+	case issuer.PublicKeyFile != "":
+		key, algorithm, err = loadPublicKey(issuer.PublicKeyFile)
+	case issuer.JWKSFile != "":
+		key, algorithm, err = loadJWKS(issuer.JWKSFile)
+	default:
+		provider := jwks.NewCachingProvider(issuerURL(issuer.URL), 5*time.Minute, issuer.providerOptions()...)
+		return provider.KeyFunc, allowedAlgorithms(issuer, validator.RS256), nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	keyFunc := func(ctx context.Context) (interface{}, error) { return key, nil }
+	if issuer.JWKSFile != "" {
+		// A key set may hold keys of several types, and each token is checked with the one named by its kid.
+		return keyFunc, allowedAlgorithms(issuer, algorithm), nil
+	}
+	if len(issuer.Algorithms) > 0 && !slices.Contains(issuer.Algorithms, string(algorithm)) {
+		return nil, nil, fmt.Errorf("the key is for %s, which is not one of the issuer's algorithms", algorithm)
+	}
+	return keyFunc, []validator.SignatureAlgorithm{algorithm}, nil
+}
+
+// allowedAlgorithms returns the algorithms the issuer allows, or the default if it does not list any.
+func allowedAlgorithms(issuer IssuerConfig, defaultAlgorithm validator.SignatureAlgorithm) []validator.SignatureAlgorithm {
+	if len(issuer.Algorithms) == 0 {
+		return []validator.SignatureAlgorithm{defaultAlgorithm}
+	}
+	algorithms := make([]validator.SignatureAlgorithm, 0, len(issuer.Algorithms))
+	for _, algorithm := range issuer.Algorithms {
+		algorithms = append(algorithms, validator.SignatureAlgorithm(algorithm))
+	}
+	return algorithms
+}
+
+// loadPublicKey reads a PEM encoded public key, or a certificate holding one, from a file.
+func loadPublicKey(path string) (interface{}, validator.SignatureAlgorithm, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, "", fmt.Errorf("no PEM data in %s", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var certificate *x509.Certificate
+		certificate, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = certificate.PublicKey
+		}
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse the public key in %s: %v", path, err)
+	}
+	algorithm, err := keyAlgorithm(key)
+	return key, algorithm, err
+}
+
+// loadJWKS reads a JSON Web Key Set from a file. Unless the issuer lists its algorithms, tokens are checked
+// against a single one: the alg of the first key, or the algorithm for its type.
+func loadJWKS(path string) (interface{}, validator.SignatureAlgorithm, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var keySet jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &keySet); err != nil {
+		return nil, "", fmt.Errorf("failed to parse the JWKS in %s: %v", path, err)
+	}
+	if len(keySet.Keys) == 0 {
+		return nil, "", fmt.Errorf("no keys in %s", path)
+	}
+
+	first := keySet.Keys[0]
+	if first.Algorithm != "" {
+		return &keySet, validator.SignatureAlgorithm(first.Algorithm), nil
+	}
+	algorithm, err := keyAlgorithm(first.Key)
+	return &keySet, algorithm, err
+}
+
+// keyAlgorithm returns the algorithm tokens checked with a public key are signed with.
+func keyAlgorithm(key interface{}) (validator.SignatureAlgorithm, error) {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return validator.RS256, nil
+	case *ecdsa.PublicKey:
+		if key.Curve.Params().BitSize != 256 {
+			return "", fmt.Errorf("unsupported ECDSA curve %s", key.Curve.Params().Name)
+		}
+		return validator.ES256, nil
+	case ed25519.PublicKey:
+		return validator.EdDSA, nil
+	default:
+		return "", errors.New("unsupported public key type")
+	}
+}
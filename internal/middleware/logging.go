@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/SevvyP/tasks_v1/internal/logging"
+)
+
+// grpcRequestIDKey is the metadata key carrying the request ID of gRPC calls, as RequestIDHeader does for HTTP.
+const grpcRequestIDKey = "x-request-id"
+
+// accessLogEntry collects what the access log records about a request that is only known inside the handler.
+type accessLogEntry struct {
+	user string
+}
+
+type accessLogKey struct{}
+
+// recordUser notes the subject of the request's token for the access log, if the request is being logged.
+func recordUser(ctx context.Context, user string) {
+	if entry, ok := ctx.Value(accessLogKey{}).(*accessLogEntry); ok {
+		entry.user = user
+	}
+}
+
+// AccessLog returns a middleware that logs each request to a route once it has been served, with its method,
+// route, status, latency and the subject of its token. The logger, tagged with the request ID, is stored in the
+// request context for handlers to log with. RequestID must run first.
+func AccessLog(logger *slog.Logger, route string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			requestLogger := logger.With("request_id", GetRequestID(r.Context()))
+			entry := &accessLogEntry{}
+			ctx := logging.NewContext(context.WithValue(r.Context(), accessLogKey{}, entry), requestLogger)
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r.WithContext(ctx))
+
+			requestLogger.LogAttrs(ctx, slog.LevelInfo, "Request served",
+				slog.String("method", r.Method),
+				slog.String("route", route),
+				slog.Int("status", recorder.Status()),
+				slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.String("sub", entry.user),
+			)
+		})
+	}
+}
+
+// AccessLogGRPC returns gRPC interceptors that do for calls what RequestID and AccessLog do for HTTP requests.
+// The request ID is read from and returned in "x-request-id" metadata, and each call is logged with its method,
+// status code, latency and the subject of its token. They must run before the authentication interceptors.
+func AccessLogGRPC(logger *slog.Logger) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	begin := func(ctx context.Context) (context.Context, *accessLogEntry) {
+		id := ""
+		if values := metadata.ValueFromIncomingContext(ctx, grpcRequestIDKey); len(values) > 0 {
+			id = values[0]
+		}
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		grpc.SetHeader(ctx, metadata.Pairs(grpcRequestIDKey, id))
+
+		entry := &accessLogEntry{}
+		ctx = withRequestID(ctx, id)
+		ctx = context.WithValue(ctx, accessLogKey{}, entry)
+		return logging.NewContext(ctx, logger.With("request_id", id)), entry
+	}
+	end := func(ctx context.Context, method string, start time.Time, entry *accessLogEntry, err error) {
+		logging.FromContext(ctx).LogAttrs(ctx, slog.LevelInfo, "Call served",
+			slog.String("method", method),
+			slog.String("code", status.Code(err).String()),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("sub", entry.user),
+		)
+	}
+
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		ctx, entry := begin(ctx)
+		resp, err := handler(ctx, req)
+		end(ctx, info.FullMethod, start, entry, err)
+		return resp, err
+	}
+	stream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx, entry := begin(ss.Context())
+		err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		end(ctx, info.FullMethod, start, entry, err)
+		return err
+	}
+	return unary, stream
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/SevvyP/tasks_v1/internal/metrics"
+)
+
+// knownMethods are the methods recorded by name. Any other method is recorded as "OTHER",
+// so that clients cannot create a metric per made-up method.
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// InstrumentRoute returns a middleware that records the count and latency of requests to a route
+// by method and status. route is the pattern the route is registered with rather than the request path,
+// to keep the number of metrics bounded.
+func InstrumentRoute(m *metrics.Metrics, route string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+
+			method := r.Method
+			if !knownMethods[method] {
+				method = "OTHER"
+			}
+			m.ObserveHTTPRequest(route, method, recorder.Status(), time.Since(start))
+		})
+	}
+}
+
+// statusRecorder is a response writer that remembers the status of the response.
+// It passes Flush and Hijack on to the writer it wraps, so that event streams and WebSockets keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// Status returns the status of the response, which is 200 if the handler wrote a body without setting one.
+func (w *statusRecorder) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack takes over the connection, as a WebSocket upgrade does. The response is recorded as 101 Switching Protocols.
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
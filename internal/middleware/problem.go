@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/SevvyP/tasks_v1/internal/logging"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// internalErrorDetail is sent in place of the details of internal errors, which may include database messages.
+const internalErrorDetail = "An internal error occurred. Include the request ID when reporting it."
+
+// WriteProblem writes an RFC 7807 problem details response with the given status, code and detail,
+// and the fields of the request that were not valid, if any.
+func WriteProblem(w http.ResponseWriter, r *http.Request, status int, code string, detail string, fields ...model.FieldError) {
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", model.ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(model.Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: GetRequestID(r.Context()),
+		Errors:    fields,
+	})
+}
+
+// WriteInternalError logs the error with the request ID and writes an HTTP 500 Internal Server Error
+// problem that does not reveal it.
+func WriteInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	logging.FromContext(r.Context()).ErrorContext(r.Context(), "Request failed", "error", err)
+	WriteProblem(w, r, http.StatusInternalServerError, model.ProblemInternal, internalErrorDetail)
+}
@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/SevvyP/tasks_v1/internal/ratelimit"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// Headers describing the caller's budget, as in the IETF RateLimit header fields draft.
+// gRPC calls return the same values in lowercase metadata.
+const (
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitResetHeader     = "RateLimit-Reset"
+	RateLimitPolicyHeader    = "RateLimit-Policy"
+	RetryAfterHeader         = "Retry-After"
+)
+
+// RateLimitIP returns a middleware that counts each request against the IP budget of the address it was sent from.
+// It runs before authentication, so that requests are limited even when their token is missing or not valid,
+// which RateLimit cannot do. A request over budget gets an HTTP 429 Too Many Requests with the RateLimit-* headers
+// and Retry-After; the headers of allowed requests are left to RateLimit. A nil limiter limits nothing.
+func RateLimitIP(limiter *ratelimit.Limiter) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limiter == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result := limiter.Allow(r.Context(), ratelimit.BudgetIP, ipKey(r.RemoteAddr))
+			if !result.Allowed {
+				for name, value := range rateLimitHeaders(result) {
+					w.Header().Set(name, value)
+				}
+				WriteProblem(w, r, http.StatusTooManyRequests, model.ProblemRateLimited,
+					"Too many requests from this address. Retry after "+strconv.Itoa(seconds(result.RetryAfter))+" seconds.")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimitIPGRPC returns gRPC interceptors that do for calls what RateLimitIP does for HTTP requests.
+// They must run before the authentication interceptors.
+func RateLimitIPGRPC(limiter *ratelimit.Limiter) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	allow := func(ctx context.Context) error {
+		if limiter == nil {
+			return nil
+		}
+		result := limiter.Allow(ctx, ratelimit.BudgetIP, ipKey(peerAddr(ctx)))
+		if !result.Allowed {
+			md := metadata.MD{}
+			for name, value := range rateLimitHeaders(result) {
+				md.Set(name, value)
+			}
+			grpc.SetHeader(ctx, md)
+			return status.Errorf(codes.ResourceExhausted, "Too many requests from this address. Retry after %d seconds.", seconds(result.RetryAfter))
+		}
+		return nil
+	}
+
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		err := allow(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	stream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := allow(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+	return unary, stream
+}
+
+// RateLimit returns a middleware that counts each request against the caller's read budget if it is a GET or HEAD
+// and against its write budget otherwise. Callers are identified by the subject of their token, or by IP address
+// if they have none, so it must run after authentication. Every response describes the budget in RateLimit-*
+// headers, and a request over budget gets an HTTP 429 Too Many Requests with Retry-After. A nil limiter limits nothing.
+func RateLimit(limiter *ratelimit.Limiter) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limiter == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			budget := ratelimit.BudgetWrite
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				budget = ratelimit.BudgetRead
+			}
+			result := limiter.Allow(r.Context(), budget, rateLimitKey(r.Context(), r.RemoteAddr))
+			for name, value := range rateLimitHeaders(result) {
+				w.Header().Set(name, value)
+			}
+			if !result.Allowed {
+				WriteProblem(w, r, http.StatusTooManyRequests, model.ProblemRateLimited,
+					"Too many "+budget+" requests. Retry after "+strconv.Itoa(seconds(result.RetryAfter))+" seconds.")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimitGRPC returns gRPC interceptors that do for calls what RateLimit does for HTTP requests. Get, List and
+// Watch methods are reads and the rest are writes. A call over budget fails with RESOURCE_EXHAUSTED.
+// They must run after the authentication interceptors.
+func RateLimitGRPC(limiter *ratelimit.Limiter) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	allow := func(ctx context.Context, method string) error {
+		if limiter == nil {
+			return nil
+		}
+		budget := ratelimit.BudgetWrite
+		name := method[strings.LastIndex(method, "/")+1:]
+		if strings.HasPrefix(name, "Get") || strings.HasPrefix(name, "List") || strings.HasPrefix(name, "Watch") {
+			budget = ratelimit.BudgetRead
+		}
+		result := limiter.Allow(ctx, budget, rateLimitKey(ctx, peerAddr(ctx)))
+		md := metadata.MD{}
+		for name, value := range rateLimitHeaders(result) {
+			md.Set(name, value)
+		}
+		grpc.SetHeader(ctx, md)
+		if !result.Allowed {
+			return status.Errorf(codes.ResourceExhausted, "Too many %s requests. Retry after %d seconds.", budget, seconds(result.RetryAfter))
+		}
+		return nil
+	}
+
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		err := allow(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	stream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := allow(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+	return unary, stream
+}
+
+// rateLimitKey identifies the caller of a request for rate limiting: the subject of its token,
+// or else the IP address it was sent from.
+func rateLimitKey(ctx context.Context, remoteAddr string) string {
+	if user := GetUserID(ctx); user != "" {
+		return "user:" + user
+	}
+	return ipKey(remoteAddr)
+}
+
+// ipKey identifies the IP address a request was sent from for rate limiting.
+func ipKey(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return "ip:" + host
+}
+
+// peerAddr returns the address a gRPC call was sent from, or "" if it is not known.
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// rateLimitHeaders returns the headers describing the budget a request was counted against,
+// or none if the budget is not limited.
+func rateLimitHeaders(result ratelimit.Result) map[string]string {
+	if result.Limit == 0 {
+		return nil
+	}
+	headers := map[string]string{
+		RateLimitLimitHeader:     strconv.Itoa(result.Limit),
+		RateLimitRemainingHeader: strconv.Itoa(result.Remaining),
+		RateLimitResetHeader:     strconv.Itoa(seconds(result.Reset)),
+		RateLimitPolicyHeader:    strconv.Itoa(result.Limit) + ";w=" + strconv.Itoa(seconds(result.Period)),
+	}
+	if !result.Allowed {
+		headers[RetryAfterHeader] = strconv.Itoa(seconds(result.RetryAfter))
+	}
+	return headers
+}
+
+// seconds returns a duration in whole seconds, rounded up.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ContentSecurityPolicy is the policy sent with every response. API responses are data rather than pages, so they
+// may not load anything or be framed. Handlers serving pages replace it with a policy of their own.
+const ContentSecurityPolicy = "default-src 'none'; frame-ancestors 'none'"
+
+// SecurityHeaders returns a middleware that adds headers telling browsers not to sniff content types, frame
+// responses, send referrers or load anything from them. If hstsMaxAge is positive, browsers are also told to only
+// use HTTPS for that long, which should only be set when the server is reached over HTTPS.
+func SecurityHeaders(hstsMaxAge time.Duration) func(next http.Handler) http.Handler {
+	hsts := ""
+	if hstsMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(hstsMaxAge/time.Second)) + "; includeSubDomains"
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			header.Set("X-Content-Type-Options", "nosniff")
+			header.Set("X-Frame-Options", "DENY")
+			header.Set("Referrer-Policy", "no-referrer")
+			header.Set("Content-Security-Policy", ContentSecurityPolicy)
+			header.Set("Cross-Origin-Opener-Policy", "same-origin")
+			if hsts != "" {
+				header.Set("Strict-Transport-Security", hsts)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
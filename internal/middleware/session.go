@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+)
+
+// Names of the session token, which is the time of the client's last write in Unix milliseconds.
+const (
+	SessionHeader = "Tasks-Session"
+	SessionCookie = "tasks_session"
+	// grpcSessionKey is the metadata key carrying the session token of gRPC calls.
+	grpcSessionKey = "tasks-session"
+)
+
+// ReadYourWrites is a middleware that lets clients read their own writes when reads are served by replicas.
+// The time of the client's last write is taken from the Tasks-Session header, or else the tasks_session cookie,
+// and attached to the request as a database session. A response to a request that wrote tasks carries a new token
+// in both, which the client sends back with its next requests so that they read from the primary for a while.
+func ReadYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(SessionHeader)
+		if cookie, err := r.Cookie(SessionCookie); token == "" && err == nil {
+			token = cookie.Value
+		}
+		session := &database.Session{LastWrite: parseSessionToken(token)}
+		writer := &sessionWriter{ResponseWriter: w, session: session}
+		next.ServeHTTP(writer, r.WithContext(database.WithSession(r.Context(), session)))
+	})
+}
+
+// ReadYourWritesGRPC returns a gRPC interceptor that does for unary calls what ReadYourWrites does for HTTP requests,
+// with the token sent and returned in "tasks-session" metadata.
+func ReadYourWritesGRPC() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		token := ""
+		if values := metadata.ValueFromIncomingContext(ctx, grpcSessionKey); len(values) > 0 {
+			token = values[0]
+		}
+		session := &database.Session{LastWrite: parseSessionToken(token)}
+		resp, err := handler(database.WithSession(ctx, session), req)
+		if session.Wrote() {
+			grpc.SetHeader(ctx, metadata.Pairs(grpcSessionKey, newSessionToken()))
+		}
+		return resp, err
+	}
+}
+
+// parseSessionToken returns the time of the last write in a session token, or zero if the token is not valid.
+func parseSessionToken(token string) time.Time {
+	millis, err := strconv.ParseInt(token, 10, 64)
+	if err != nil || millis <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis)
+}
+
+// newSessionToken returns a session token for a write made now.
+func newSessionToken() string {
+	return strconv.FormatInt(time.Now().UnixMilli(), 10)
+}
+
+// sessionWriter is a response writer that adds a new session token to the response if the request wrote tasks.
+// Like statusRecorder, it passes Flush and Hijack on to the writer it wraps.
+type sessionWriter struct {
+	http.ResponseWriter
+	session     *database.Session
+	wroteHeader bool
+}
+
+func (w *sessionWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if w.session.Wrote() {
+			token := newSessionToken()
+			w.Header().Set(SessionHeader, token)
+			http.SetCookie(w.ResponseWriter, &http.Cookie{Name: SessionCookie, Value: token, Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode})
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *sessionWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *sessionWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+)
+
+// tracer creates the spans of the middleware, using the globally registered tracer provider.
+var tracer = otel.Tracer("github.com/SevvyP/tasks_v1/internal/middleware")
+
+// TraceRoute returns a middleware that starts a server span for each request to a route, named after its method
+// and the pattern the route is registered with. A trace started by the caller is continued from the W3C traceparent header.
+func TraceRoute(route string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return otelhttp.NewHandler(otelhttp.WithRouteTag(route, next), route, otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			return r.Method + " " + operation
+		}))
+	}
+}
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+	// The zoneinfo database is embedded so that timezones can be checked on hosts and images without one.
+	_ "time/tzdata"
+
+	"google.golang.org/grpc"
+
+	"github.com/SevvyP/tasks_v1/internal/logging"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+const (
+	// userCacheTTL is how long a user is remembered as provisioned before their profile is written again,
+	// so that a user removed from the database comes back.
+	userCacheTTL = time.Hour
+	// maxCachedUsers is how many users are remembered as provisioned at once.
+	maxCachedUsers = 10000
+)
+
+// UserStore creates and updates the users row of each caller.
+type UserStore interface {
+	UpsertUser(ctx context.Context, user model.User) error
+}
+
+// UserProvisioner makes sure every caller has a users row holding their latest profile, so that the rows
+// referencing them can be written. Each user and profile is only written once per userCacheTTL.
+type UserProvisioner struct {
+	users UserStore
+
+	mu sync.Mutex
+	// provisioned holds when each user was last written, keyed by their ID and profile.
+	provisioned map[model.User]time.Time
+}
+
+// NewUserProvisioner returns a UserProvisioner writing users to the store.
+func NewUserProvisioner(users UserStore) *UserProvisioner {
+	return &UserProvisioner{users: users, provisioned: map[model.User]time.Time{}}
+}
+
+// Provision writes the users row of the caller authenticated in ctx, unless it was recently written with the same
+// profile. Requests carry on if the row cannot be written, since only some of them need it; the failure is logged
+// and the row is written again on the caller's next request.
+func (p *UserProvisioner) Provision(ctx context.Context) {
+	user := model.User{ID: GetUserID(ctx)}
+	if user.ID == "" {
+		return
+	}
+	if custom, ok := getCustomClaims(ctx); ok {
+		user.Email, user.Name, user.Timezone = custom.Email, custom.Name, custom.Timezone
+	}
+
+	now := time.Now()
+	p.mu.Lock()
+	provisionedAt, ok := p.provisioned[user]
+	p.mu.Unlock()
+	if ok && now.Sub(provisionedAt) < userCacheTTL {
+		return
+	}
+
+	if err := p.users.UpsertUser(ctx, user); err != nil {
+		logging.FromContext(ctx).WarnContext(ctx, "Failed to provision user", "error", err)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.provisioned) >= maxCachedUsers {
+		for cached, provisionedAt := range p.provisioned {
+			if now.Sub(provisionedAt) >= userCacheTTL {
+				delete(p.provisioned, cached)
+			}
+		}
+		if len(p.provisioned) >= maxCachedUsers {
+			clear(p.provisioned)
+		}
+	}
+	p.provisioned[user] = now
+}
+
+// ProvisionUser returns a middleware that provisions the caller of each request with p, so it must run after
+// authentication. A nil provisioner provisions nothing.
+func ProvisionUser(p *UserProvisioner) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if p == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p.Provision(r.Context())
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ProvisionUserGRPC returns gRPC interceptors that do for calls what ProvisionUser does for HTTP requests.
+// They must run after the authentication interceptors.
+func ProvisionUserGRPC(p *UserProvisioner) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if p != nil {
+			p.Provision(ctx)
+		}
+		return handler(ctx, req)
+	}
+	stream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if p != nil {
+			p.Provision(ss.Context())
+		}
+		return handler(srv, ss)
+	}
+	return unary, stream
+}
+
+// ValidTimezone reports whether tz is the IANA name of a timezone, such as "Europe/Paris".
+func ValidTimezone(tz string) bool {
+	if tz == "" || tz == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(tz)
+	return err == nil
+}
@@ -0,0 +1,59 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// inProcessDedupeSize is how many recent message IDs the in-process publisher remembers to drop redeliveries.
+const inProcessDedupeSize = 1024
+
+// InProcessPublisher delivers outbox messages to handlers subscribed in the same process.
+// Redeliveries of recently published messages are dropped, so handlers see each message once.
+type InProcessPublisher struct {
+	mu       sync.Mutex
+	handlers []func(model.OutboxMessage)
+	seen     map[string]struct{}
+	order    []string
+}
+
+func NewInProcessPublisher() *InProcessPublisher {
+	return &InProcessPublisher{
+		seen: make(map[string]struct{}),
+	}
+}
+
+// Subscribe registers a handler called for every published message. Handlers are called synchronously
+// by Publish, so they should return quickly.
+func (p *InProcessPublisher) Subscribe(handler func(model.OutboxMessage)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers = append(p.handlers, handler)
+}
+
+func (p *InProcessPublisher) Publish(ctx context.Context, message model.OutboxMessage) error {
+	p.mu.Lock()
+	if _, ok := p.seen[message.ID]; ok {
+		p.mu.Unlock()
+		return nil
+	}
+	p.seen[message.ID] = struct{}{}
+	p.order = append(p.order, message.ID)
+	if len(p.order) > inProcessDedupeSize {
+		delete(p.seen, p.order[0])
+		p.order = p.order[1:]
+	}
+	handlers := p.handlers
+	p.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(message)
+	}
+	return nil
+}
+
+func (p *InProcessPublisher) Close() error {
+	return nil
+}
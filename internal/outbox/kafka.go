@@ -0,0 +1,71 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// Kafka message headers set on every published message.
+const (
+	KafkaMessageIDHeader = "message-id"
+	KafkaTopicHeader     = "topic"
+)
+
+// KafkaConfig contains the configuration for publishing to Kafka.
+type KafkaConfig struct {
+	Brokers []string `json:"brokers"`
+	// Topic receives every message, partitioned by key so each user's events stay in order.
+	// The outbox topic, such as "tasks.created", is sent in the "topic" header.
+	Topic string `json:"topic"`
+}
+
+// kafkaWriter is the part of kafka.Writer used by KafkaPublisher.
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaPublisher publishes outbox messages to Kafka, waiting for all in-sync replicas to acknowledge each one.
+// The message ID is sent in the "message-id" header for consumers to drop redeliveries.
+type KafkaPublisher struct {
+	writer kafkaWriter
+}
+
+func NewKafkaPublisher(config *KafkaConfig) (*KafkaPublisher, error) {
+	if config == nil || len(config.Brokers) == 0 || config.Topic == "" {
+		return nil, fmt.Errorf("kafka config is missing brokers or a topic")
+	}
+
+	return &KafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(config.Brokers...),
+			Topic:        config.Topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+	}, nil
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, message model.OutboxMessage) error {
+	err := p.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(message.Key),
+		Value: message.Payload,
+		Time:  message.CreatedAt,
+		Headers: []kafka.Header{
+			{Key: KafkaMessageIDHeader, Value: []byte(message.ID)},
+			{Key: KafkaTopicHeader, Value: []byte(message.Topic)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish to kafka: %v", err)
+	}
+	return nil
+}
+
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
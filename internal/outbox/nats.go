@@ -0,0 +1,78 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// NATSConfig contains the configuration for publishing to NATS JetStream.
+type NATSConfig struct {
+	URL string `json:"url"`
+	// SubjectPrefix is prepended to the message topic, so "events" publishes to subjects such as "events.tasks.created".
+	// A JetStream stream must capture these subjects.
+	SubjectPrefix string `json:"subject_prefix"`
+}
+
+// jetStreamPublisher is the part of jetstream.JetStream used by NATSPublisher.
+type jetStreamPublisher interface {
+	PublishMsg(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error)
+}
+
+// NATSPublisher publishes outbox messages to NATS JetStream, waiting for the stream to acknowledge each one.
+// The message ID is sent as the Nats-Msg-Id header, so JetStream drops redeliveries within its duplicate window.
+type NATSPublisher struct {
+	js            jetStreamPublisher
+	conn          *nats.Conn
+	subjectPrefix string
+}
+
+func NewNATSPublisher(config *NATSConfig) (*NATSPublisher, error) {
+	if config == nil || config.URL == "" {
+		return nil, fmt.Errorf("nats config is missing a url")
+	}
+
+	conn, err := nats.Connect(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats: %v", err)
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create jetstream context: %v", err)
+	}
+
+	return &NATSPublisher{
+		js:            js,
+		conn:          conn,
+		subjectPrefix: config.SubjectPrefix,
+	}, nil
+}
+
+func (p *NATSPublisher) Publish(ctx context.Context, message model.OutboxMessage) error {
+	subject := message.Topic
+	if p.subjectPrefix != "" {
+		subject = p.subjectPrefix + "." + subject
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Data = message.Payload
+	msg.Header.Set(jetstream.MsgIDHeader, message.ID)
+	msg.Header.Set("Key", message.Key)
+	_, err := p.js.PublishMsg(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("failed to publish to nats: %v", err)
+	}
+	return nil
+}
+
+func (p *NATSPublisher) Close() error {
+	if p.conn != nil {
+		return p.conn.Drain()
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// Publisher types that can be selected in Config.
+const (
+	PublisherNone  = "none"
+	PublisherNATS  = "nats"
+	PublisherKafka = "kafka"
+)
+
+// EventPublisher publishes outbox messages to consumers. Publish must only return nil once the message
+// has been accepted, since the relay marks it as published and will not send it again.
+type EventPublisher interface {
+	Publish(ctx context.Context, message model.OutboxMessage) error
+	Close() error
+}
+
+// Config contains the configuration for publishing outbox messages.
+type Config struct {
+	// Publisher is one of "none", "nats" or "kafka", and defaults to "none".
+	Publisher string       `json:"publisher"`
+	NATS      *NATSConfig  `json:"nats"`
+	Kafka     *KafkaConfig `json:"kafka"`
+}
+
+// NewPublisher creates the publisher selected in the config. It returns a nil publisher for a nil config or
+// "none", in which case no relay should run: messages then stay in the outbox until a publisher is configured,
+// rather than being marked as published with nothing to consume them. The in-process publisher is not
+// selectable, since its subscribers have to be registered in code; create it with NewInProcessPublisher instead.
+func NewPublisher(config *Config) (EventPublisher, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	switch config.Publisher {
+	case "", PublisherNone:
+		return nil, nil
+	case PublisherNATS:
+		return NewNATSPublisher(config.NATS)
+	case PublisherKafka:
+		return NewKafkaPublisher(config.Kafka)
+	default:
+		return nil, fmt.Errorf("unknown event publisher %q", config.Publisher)
+	}
+}
@@ -0,0 +1,122 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+var testMessage = model.OutboxMessage{
+	ID:        "7c1f0f5e-3a5b-4c55-9d3e-1b0c6a1f2d3e",
+	Topic:     "tasks.created",
+	Key:       "user-1",
+	Payload:   json.RawMessage(`{"id":1,"type":"created"}`),
+	CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+}
+
+// localJetStream stands in for a JetStream server, dropping messages whose ID it has already stored.
+type localJetStream struct {
+	stored []*nats.Msg
+	ids    map[string]bool
+}
+
+func (js *localJetStream) PublishMsg(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	id := msg.Header.Get(jetstream.MsgIDHeader)
+	if js.ids[id] {
+		return &jetstream.PubAck{Stream: "TASKS", Sequence: uint64(len(js.stored)), Duplicate: true}, nil
+	}
+	js.ids[id] = true
+	js.stored = append(js.stored, msg)
+	return &jetstream.PubAck{Stream: "TASKS", Sequence: uint64(len(js.stored))}, nil
+}
+
+// localKafka stands in for a Kafka cluster, storing written messages.
+type localKafka struct {
+	written []kafka.Message
+	closed  bool
+}
+
+func (k *localKafka) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	k.written = append(k.written, msgs...)
+	return nil
+}
+
+func (k *localKafka) Close() error {
+	k.closed = true
+	return nil
+}
+
+func TestInProcessPublisher(t *testing.T) {
+	publisher := NewInProcessPublisher()
+	received := []model.OutboxMessage{}
+	publisher.Subscribe(func(message model.OutboxMessage) {
+		received = append(received, message)
+	})
+
+	assert.NoError(t, publisher.Publish(context.Background(), testMessage))
+	// A redelivery of the same message is dropped.
+	assert.NoError(t, publisher.Publish(context.Background(), testMessage))
+
+	assert.Equal(t, []model.OutboxMessage{testMessage}, received)
+}
+
+func TestNATSPublisher(t *testing.T) {
+	js := &localJetStream{ids: make(map[string]bool)}
+	publisher := &NATSPublisher{js: js, subjectPrefix: "events"}
+
+	assert.NoError(t, publisher.Publish(context.Background(), testMessage))
+	// A redelivery is accepted but not stored again.
+	assert.NoError(t, publisher.Publish(context.Background(), testMessage))
+
+	assert.Len(t, js.stored, 1)
+	assert.Equal(t, "events.tasks.created", js.stored[0].Subject)
+	assert.Equal(t, []byte(testMessage.Payload), js.stored[0].Data)
+	assert.Equal(t, testMessage.ID, js.stored[0].Header.Get(jetstream.MsgIDHeader))
+	assert.Equal(t, "user-1", js.stored[0].Header.Get("Key"))
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	writer := &localKafka{}
+	publisher := &KafkaPublisher{writer: writer}
+
+	assert.NoError(t, publisher.Publish(context.Background(), testMessage))
+	assert.NoError(t, publisher.Close())
+
+	assert.Equal(t, []kafka.Message{{
+		Key:   []byte("user-1"),
+		Value: []byte(testMessage.Payload),
+		Time:  testMessage.CreatedAt,
+		Headers: []kafka.Header{
+			{Key: KafkaMessageIDHeader, Value: []byte(testMessage.ID)},
+			{Key: KafkaTopicHeader, Value: []byte("tasks.created")},
+		},
+	}}, writer.written)
+	assert.True(t, writer.closed)
+}
+
+func TestNewPublisher(t *testing.T) {
+	publisher, err := NewPublisher(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, publisher)
+
+	publisher, err = NewPublisher(&Config{Publisher: PublisherNone})
+	assert.NoError(t, err)
+	assert.Nil(t, publisher)
+
+	_, err = NewPublisher(&Config{Publisher: "inprocess"})
+	assert.EqualError(t, err, `unknown event publisher "inprocess"`)
+
+	_, err = NewPublisher(&Config{Publisher: "carrier-pigeon"})
+	assert.EqualError(t, err, `unknown event publisher "carrier-pigeon"`)
+
+	_, err = NewPublisher(&Config{Publisher: PublisherKafka})
+	assert.EqualError(t, err, "kafka config is missing brokers or a topic")
+}
@@ -0,0 +1,99 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+)
+
+// Relay publishes outbox messages written by the database until they are accepted by the publisher.
+// A message that fails to publish is retried once its lease expires, so delivery is at least once.
+type Relay struct {
+	Database     database.TaskDatabase
+	Publisher    EventPublisher
+	PollInterval time.Duration
+	BatchSize    int
+	// Lease is how long claimed messages are hidden from other relays while they are being published.
+	Lease time.Duration
+	// Retention is how long published messages are kept before they are pruned.
+	Retention time.Duration
+	Logger    *slog.Logger
+}
+
+// NewRelay creates a Relay with the default settings.
+func NewRelay(db database.TaskDatabase, publisher EventPublisher, logger *slog.Logger) *Relay {
+	return &Relay{
+		Database:     db,
+		Publisher:    publisher,
+		PollInterval: time.Second,
+		BatchSize:    100,
+		Lease:        30 * time.Second,
+		Retention:    7 * 24 * time.Hour,
+		Logger:       logger,
+	}
+}
+
+// Run relays messages until the context is done.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+
+	for {
+		// A full batch means there may be a backlog, so claim again without waiting for the ticker.
+		if r.relay(ctx) == r.BatchSize && ctx.Err() == nil {
+			continue
+		}
+
+		if time.Since(lastPrune) > time.Hour {
+			_, err := r.Database.PruneOutboxMessages(ctx, time.Now().Add(-r.Retention))
+			if err != nil {
+				r.Logger.ErrorContext(ctx, "Failed to prune outbox", "error", err)
+			}
+			lastPrune = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay publishes a batch of messages in order, returning how many were claimed, or 0 if publishing failed
+// so that the relay backs off. Publishing stops at the first failure so later messages are not published ahead of it.
+func (r *Relay) relay(ctx context.Context) int {
+	messages, err := r.Database.ClaimOutboxMessages(ctx, r.BatchSize, r.Lease)
+	if err != nil {
+		r.Logger.ErrorContext(ctx, "Failed to claim outbox messages", "error", err)
+		return 0
+	}
+
+	published := []string{}
+	var publishErr error
+	for _, message := range *messages {
+		publishErr = r.Publisher.Publish(ctx, message)
+		if publishErr != nil {
+			r.Logger.WarnContext(ctx, "Failed to publish outbox message", "message_id", message.ID, "error", publishErr)
+			break
+		}
+		published = append(published, message.ID)
+	}
+
+	if len(published) > 0 {
+		// Record what was published even if the relay is stopping, so that it is not published again.
+		err = r.Database.MarkOutboxMessagesPublished(context.WithoutCancel(ctx), published)
+		if err != nil {
+			// The messages will be published again once their lease expires.
+			r.Logger.ErrorContext(ctx, "Failed to mark outbox messages published", "error", err)
+		}
+	}
+
+	if publishErr != nil {
+		return 0
+	}
+	return len(*messages)
+}
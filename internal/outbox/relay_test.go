@@ -0,0 +1,83 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// failingPublisher records published messages and fails on the message with the given ID.
+type failingPublisher struct {
+	failOn    string
+	published []string
+}
+
+func (p *failingPublisher) Publish(ctx context.Context, message model.OutboxMessage) error {
+	if message.ID == p.failOn {
+		return fmt.Errorf("publisher unavailable")
+	}
+	p.published = append(p.published, message.ID)
+	return nil
+}
+
+func (p *failingPublisher) Close() error {
+	return nil
+}
+
+func TestRelay(t *testing.T) {
+	messages := []model.OutboxMessage{
+		{ID: "1", Topic: "tasks.created", Key: "user-1"},
+		{ID: "2", Topic: "tasks.updated", Key: "user-1"},
+		{ID: "3", Topic: "tasks.deleted", Key: "user-1"},
+	}
+
+	tests := []struct {
+		name              string
+		failOn            string
+		expectedPublished []string
+		expectedClaimed   int
+	}{
+		{
+			name:              "Relay_AllPublished",
+			failOn:            "",
+			expectedPublished: []string{"1", "2", "3"},
+			expectedClaimed:   3,
+		},
+		{
+			name:              "Relay_StopsAtFailure",
+			failOn:            "2",
+			expectedPublished: []string{"1"},
+			expectedClaimed:   0,
+		},
+		{
+			name:              "Relay_FirstFails",
+			failOn:            "1",
+			expectedPublished: nil,
+			expectedClaimed:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher := &failingPublisher{failOn: tt.failOn}
+			mockDB := new(database.MockDatabase)
+			relay := NewRelay(mockDB, publisher, slog.Default())
+			mockDB.On("ClaimOutboxMessages", relay.BatchSize, relay.Lease).Return(&messages, nil)
+			if tt.expectedPublished != nil {
+				mockDB.On("MarkOutboxMessagesPublished", tt.expectedPublished).Return(nil)
+			}
+
+			claimed := relay.relay(context.Background())
+
+			assert.Equal(t, tt.expectedClaimed, claimed)
+			assert.Equal(t, tt.expectedPublished, publisher.published)
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,201 @@
+// Package ratelimit limits how often each caller may use the API with token buckets. A caller has a bucket per
+// budget, holding up to the budget's number of requests and refilled evenly over its period, and every request
+// takes a token from the bucket of its budget. Buckets are kept in memory, or in Postgres so that every replica
+// takes from the same ones.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/SevvyP/tasks_v1/internal/config"
+	"github.com/SevvyP/tasks_v1/internal/database"
+)
+
+// Stores that can be selected in Config.
+const (
+	StoreMemory   = "memory"
+	StorePostgres = "postgres"
+)
+
+// Budgets that requests are counted against. Reads are requests that do not change anything, writes are the rest.
+// Every request is also counted against the IP budget of the address it came from before it is authenticated,
+// so that requests with tokens that are missing or not valid are limited too.
+const (
+	BudgetRead  = "read"
+	BudgetWrite = "write"
+	BudgetIP    = "ip"
+)
+
+// pruneInterval is how often Run removes buckets that have not been used for long enough to be full again.
+const pruneInterval = time.Minute
+
+// Budget is how many requests a caller may make per period. Requests may come in a burst of up to the whole budget.
+// A budget of 0 requests is not limited.
+type Budget struct {
+	Requests int             `json:"requests"`
+	Period   config.Duration `json:"period"`
+}
+
+// refillPerSecond returns how many tokens are added to a bucket of the budget every second.
+func (b Budget) refillPerSecond() float64 {
+	return float64(b.Requests) / time.Duration(b.Period).Seconds()
+}
+
+// Config contains the budgets of each caller and where their buckets are kept.
+type Config struct {
+	// Store is "memory" or "postgres", and defaults to "memory". With "memory" each replica limits callers on its own.
+	Store string `json:"store"`
+	Read  Budget `json:"read"`
+	Write Budget `json:"write"`
+	// IP is the budget of each IP address, shared by every caller using it.
+	IP Budget `json:"ip"`
+}
+
+// Store keeps token buckets by key.
+type Store interface {
+	// Take refills the bucket under key for the budget and takes a token from it if there is one.
+	// It returns the tokens left and whether one was taken. A bucket that does not exist yet starts full.
+	Take(ctx context.Context, key string, budget Budget) (float64, bool, error)
+	// Prune removes the buckets that were last used before the given time.
+	Prune(ctx context.Context, updatedBefore time.Time) error
+}
+
+// NewStore creates the store selected in the config. The Postgres store keeps its buckets in db.
+func NewStore(config *Config, db database.TaskDatabase) (Store, error) {
+	if config == nil {
+		return NewMemoryStore(), nil
+	}
+
+	switch config.Store {
+	case "", StoreMemory:
+		return NewMemoryStore(), nil
+	case StorePostgres:
+		return NewPostgresStore(db), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", config.Store)
+	}
+}
+
+// Result is the outcome of a request counted against a budget.
+type Result struct {
+	Allowed bool
+	// Limit is the number of requests in the budget, and Period the time over which they are refilled.
+	Limit  int
+	Period time.Duration
+	// Remaining is how many more requests may be made right away.
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed, if this one was not.
+	RetryAfter time.Duration
+}
+
+// Limiter counts requests against the read and write budgets of each caller, and the IP budget of each address.
+// A nil *Limiter allows every request, so that handlers can be used without rate limits in tests.
+type Limiter struct {
+	Store  Store
+	Read   Budget
+	Write  Budget
+	IP     Budget
+	Logger *slog.Logger
+}
+
+// New creates a Limiter with the budgets in the config and buckets kept in store. A nil config limits nothing.
+func New(config *Config, store Store, logger *slog.Logger) *Limiter {
+	if config == nil {
+		return nil
+	}
+	return &Limiter{Store: store, Read: config.Read, Write: config.Write, IP: config.IP, Logger: logger}
+}
+
+// Allow counts a request by the caller identified by key against the named budget.
+// If the store cannot be reached the request is allowed, since limiting is not worth failing requests for.
+func (l *Limiter) Allow(ctx context.Context, budgetName string, key string) Result {
+	budget := l.budget(budgetName)
+	if budget.Requests <= 0 {
+		return Result{Allowed: true}
+	}
+
+	tokens, taken, err := l.Store.Take(ctx, budgetName+":"+key, budget)
+	if err != nil {
+		l.logger().WarnContext(ctx, "Failed to check rate limit", "budget", budgetName, "error", err)
+		return Result{Allowed: true}
+	}
+	return newResult(budget, tokens, taken)
+}
+
+// logger returns the Limiter's logger, or the default logger if it has none.
+func (l *Limiter) logger() *slog.Logger {
+	if l.Logger == nil {
+		return slog.Default()
+	}
+	return l.Logger
+}
+
+// budget returns the budget with the given name.
+func (l *Limiter) budget(name string) Budget {
+	if l == nil {
+		return Budget{}
+	}
+	switch name {
+	case BudgetRead:
+		return l.Read
+	case BudgetIP:
+		return l.IP
+	default:
+		return l.Write
+	}
+}
+
+// newResult describes a bucket of the budget holding tokens after a request, which took one if allowed.
+func newResult(budget Budget, tokens float64, allowed bool) Result {
+	rate := budget.refillPerSecond()
+	result := Result{
+		Allowed:   allowed,
+		Limit:     budget.Requests,
+		Period:    time.Duration(budget.Period),
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     secondsToDuration((float64(budget.Requests) - tokens) / rate),
+	}
+	if !allowed {
+		result.RetryAfter = secondsToDuration((1 - tokens) / rate)
+	}
+	return result
+}
+
+// secondsToDuration returns a number of seconds as a duration, rounded up to whole seconds.
+func secondsToDuration(seconds float64) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(seconds)) * time.Second
+}
+
+// Run removes buckets that have been full for a while until the context is done, so that callers who stopped
+// making requests do not use memory or rows forever.
+func (l *Limiter) Run(ctx context.Context) {
+	if l == nil {
+		return
+	}
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	idle := max(time.Duration(l.Read.Period), time.Duration(l.Write.Period), time.Duration(l.IP.Period))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// A bucket unused for a whole period is full, and removing it is the same as keeping it.
+		err := l.Store.Prune(ctx, time.Now().Add(-idle))
+		if err != nil {
+			l.logger().ErrorContext(ctx, "Failed to prune rate limit buckets", "error", err)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/SevvyP/tasks_v1/internal/config"
+	"github.com/SevvyP/tasks_v1/internal/database"
+)
+
+func TestMemoryStore(t *testing.T) {
+	budget := Budget{Requests: 2, Period: config.Duration(time.Minute)}
+	tests := []struct {
+		name           string
+		elapsed        []time.Duration
+		expectedTokens float64
+		expectedTaken  bool
+	}{
+		{
+			name:           "MemoryStore_Full",
+			elapsed:        []time.Duration{0},
+			expectedTokens: 1,
+			expectedTaken:  true,
+		},
+		{
+			name:           "MemoryStore_Empty",
+			elapsed:        []time.Duration{0, 0, 0},
+			expectedTokens: 0,
+			expectedTaken:  false,
+		},
+		{
+			name:           "MemoryStore_Refilled",
+			elapsed:        []time.Duration{0, 0, 30 * time.Second},
+			expectedTokens: 0,
+			expectedTaken:  true,
+		},
+		{
+			name:           "MemoryStore_RefilledToCapacity",
+			elapsed:        []time.Duration{0, 0, time.Hour},
+			expectedTokens: 1,
+			expectedTaken:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(0, 0)
+			store := NewMemoryStore()
+			store.now = func() time.Time { return now }
+
+			var tokens float64
+			var taken bool
+			var err error
+			for _, elapsed := range tt.elapsed {
+				now = now.Add(elapsed)
+				tokens, taken, err = store.Take(context.Background(), "read:user:user-1", budget)
+				assert.NoError(t, err)
+			}
+			assert.InDelta(t, tt.expectedTokens, tokens, 0.001)
+			assert.Equal(t, tt.expectedTaken, taken)
+		})
+	}
+}
+
+func TestMemoryStorePrune(t *testing.T) {
+	now := time.Unix(0, 0)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	budget := Budget{Requests: 1, Period: config.Duration(time.Minute)}
+
+	store.Take(context.Background(), "old", budget)
+	now = now.Add(time.Hour)
+	store.Take(context.Background(), "new", budget)
+
+	assert.NoError(t, store.Prune(context.Background(), now.Add(-time.Minute)))
+	assert.Len(t, store.buckets, 1)
+	assert.Contains(t, store.buckets, "new")
+}
+
+// failingStore is a store that cannot be reached.
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, budget Budget) (float64, bool, error) {
+	return 0, false, errors.New("connection refused")
+}
+
+func (failingStore) Prune(ctx context.Context, updatedBefore time.Time) error {
+	return errors.New("connection refused")
+}
+
+func TestAllow(t *testing.T) {
+	budget := Budget{Requests: 2, Period: config.Duration(time.Minute)}
+	tests := []struct {
+		name           string
+		limiter        *Limiter
+		requests       int
+		budget         string
+		expectedResult Result
+	}{
+		{
+			name:           "Allow_UnderBudget",
+			limiter:        &Limiter{Store: NewMemoryStore(), Read: budget},
+			requests:       1,
+			budget:         BudgetRead,
+			expectedResult: Result{Allowed: true, Limit: 2, Period: time.Minute, Remaining: 1, Reset: 30 * time.Second},
+		},
+		{
+			name:           "Allow_OverBudget",
+			limiter:        &Limiter{Store: NewMemoryStore(), Read: budget},
+			requests:       3,
+			budget:         BudgetRead,
+			expectedResult: Result{Allowed: false, Limit: 2, Period: time.Minute, Remaining: 0, Reset: time.Minute, RetryAfter: 30 * time.Second},
+		},
+		{
+			name:           "Allow_Unlimited",
+			limiter:        &Limiter{Store: NewMemoryStore(), Read: budget},
+			requests:       3,
+			budget:         BudgetWrite,
+			expectedResult: Result{Allowed: true},
+		},
+		{
+			name:           "Allow_IPBudget",
+			limiter:        &Limiter{Store: NewMemoryStore(), Read: budget, IP: Budget{Requests: 1, Period: config.Duration(time.Minute)}},
+			requests:       2,
+			budget:         BudgetIP,
+			expectedResult: Result{Allowed: false, Limit: 1, Period: time.Minute, Remaining: 0, Reset: time.Minute, RetryAfter: time.Minute},
+		},
+		{
+			name:           "Allow_NilLimiter",
+			requests:       1,
+			budget:         BudgetRead,
+			expectedResult: Result{Allowed: true},
+		},
+		{
+			name:           "Allow_StoreError",
+			limiter:        &Limiter{Store: failingStore{}, Read: budget},
+			requests:       1,
+			budget:         BudgetRead,
+			expectedResult: Result{Allowed: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result Result
+			for i := 0; i < tt.requests; i++ {
+				result = tt.limiter.Allow(context.Background(), tt.budget, "user:user-1")
+			}
+			assert.Equal(t, tt.expectedResult, result)
+		})
+	}
+}
+
+func TestPostgresStore(t *testing.T) {
+	mockDB := new(database.MockDatabase)
+	mockDB.On("TakeRateLimitToken", "write:user:user-1", 120.0, 2.0).Return(41.5, true, nil)
+	store := NewPostgresStore(mockDB)
+
+	tokens, taken, err := store.Take(context.Background(), "write:user:user-1", Budget{Requests: 120, Period: config.Duration(time.Minute)})
+	assert.NoError(t, err)
+	assert.Equal(t, 41.5, tokens)
+	assert.True(t, taken)
+}
+
+func TestNewStore(t *testing.T) {
+	store, err := NewStore(&Config{Store: StorePostgres}, new(database.MockDatabase))
+	assert.NoError(t, err)
+	assert.IsType(t, &PostgresStore{}, store)
+
+	store, err = NewStore(nil, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &MemoryStore{}, store)
+
+	_, err = NewStore(&Config{Store: "redis"}, nil)
+	assert.EqualError(t, err, `unknown rate limit store "redis"`)
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+)
+
+// MemoryStore keeps token buckets in memory, so each replica limits the callers it serves on its own.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// now returns the current time, and is replaced in tests.
+	now func() time.Time
+}
+
+// bucket is the state of a token bucket when it was last used.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}, now: time.Now}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, budget Budget) (float64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	capacity := float64(budget.Requests)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity}
+		s.buckets[key] = b
+	} else {
+		b.tokens = min(capacity, b.tokens+now.Sub(b.updated).Seconds()*budget.refillPerSecond())
+	}
+	b.updated = now
+
+	if b.tokens < 1 {
+		return b.tokens, false, nil
+	}
+	b.tokens--
+	return b.tokens, true, nil
+}
+
+func (s *MemoryStore) Prune(ctx context.Context, updatedBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, b := range s.buckets {
+		if b.updated.Before(updatedBefore) {
+			delete(s.buckets, key)
+		}
+	}
+	return nil
+}
+
+// PostgresStore keeps token buckets in the rate_limits table, so that every replica takes from the same buckets.
+// Each request makes a round trip to the primary database.
+type PostgresStore struct {
+	db database.TaskDatabase
+}
+
+// NewPostgresStore creates a PostgresStore keeping its buckets in db.
+func NewPostgresStore(db database.TaskDatabase) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, budget Budget) (float64, bool, error) {
+	return s.db.TakeRateLimitToken(ctx, key, float64(budget.Requests), budget.refillPerSecond())
+}
+
+func (s *PostgresStore) Prune(ctx context.Context, updatedBefore time.Time) error {
+	_, err := s.db.PruneRateLimitBuckets(ctx, updatedBefore)
+	return err
+}
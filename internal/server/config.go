@@ -0,0 +1,453 @@
+package server
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SevvyP/tasks_v1/internal/config"
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/internal/logging"
+	"github.com/SevvyP/tasks_v1/internal/middleware"
+	"github.com/SevvyP/tasks_v1/internal/outbox"
+	"github.com/SevvyP/tasks_v1/internal/ratelimit"
+	"github.com/SevvyP/tasks_v1/internal/tracing"
+)
+
+// Default HTTP server settings, used for any setting left out of HTTPConfig.
+const (
+	defaultAddr            = ":8080"
+	defaultGRPCAddr        = ":9090"
+	defaultReadTimeout     = 15 * time.Second
+	defaultWriteTimeout    = 30 * time.Second
+	defaultIdleTimeout     = 2 * time.Minute
+	defaultShutdownTimeout = 30 * time.Second
+	defaultDrainDelay      = 5 * time.Second
+)
+
+// Default CORS settings, used until origins are allowed in CORSConfig. The headers are those the API reads from
+// requests and sends in responses besides the CORS-safelisted ones.
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", IdempotencyKeyHeader, "Last-Event-ID",
+		middleware.RequestIDHeader, middleware.SessionHeader}
+	defaultCORSExposedHeaders = []string{middleware.RequestIDHeader, middleware.SessionHeader, IdempotentReplayedHeader,
+		middleware.RateLimitLimitHeader, middleware.RateLimitRemainingHeader, middleware.RateLimitResetHeader,
+		middleware.RateLimitPolicyHeader, middleware.RetryAfterHeader}
+)
+
+// defaultCORSMaxAge is how long browsers may cache the result of a preflight request by default.
+const defaultCORSMaxAge = 10 * time.Minute
+
+// Default rate limits of each caller and IP address, in requests per minute.
+const (
+	defaultReadRequestsPerMinute  = 600
+	defaultWriteRequestsPerMinute = 120
+	defaultIPRequestsPerMinute    = 1200
+)
+
+// minHMACSecretLength is the shortest HS256 secret accepted, as shorter keys are weaker than the hash.
+const minHMACSecretLength = 32
+
+// HTTPConfig contains the listen addresses and timeouts of the server. Addr is where the HTTP server listens
+// and GRPCAddr is where the gRPC server listens, both as host:port where the host may be left out.
+// ShutdownTimeout is how long Shutdown waits for in-flight requests before closing their connections.
+// DrainDelay is how long the server keeps accepting requests after it starts failing readiness checks,
+// which should be longer than the interval at which the load balancer probes it. It counts towards ShutdownTimeout.
+// Event streams are not subject to WriteTimeout. HSTSMaxAge is how long browsers are told to only reach the server
+// over HTTPS, and should only be set when it is served over HTTPS; the header is left out if it is zero.
+type HTTPConfig struct {
+	Addr            string          `json:"addr"`
+	GRPCAddr        string          `json:"grpc_addr"`
+	ReadTimeout     config.Duration `json:"read_timeout"`
+	WriteTimeout    config.Duration `json:"write_timeout"`
+	IdleTimeout     config.Duration `json:"idle_timeout"`
+	ShutdownTimeout config.Duration `json:"shutdown_timeout"`
+	DrainDelay      config.Duration `json:"drain_delay"`
+	HSTSMaxAge      config.Duration `json:"hsts_max_age"`
+}
+
+// withDefaults returns a copy of the config with the default for every setting that is not set.
+func (c *HTTPConfig) withDefaults() HTTPConfig {
+	result := HTTPConfig{}
+	if c != nil {
+		result = *c
+	}
+	if result.Addr == "" {
+		result.Addr = defaultAddr
+	}
+	if result.GRPCAddr == "" {
+		result.GRPCAddr = defaultGRPCAddr
+	}
+	if result.ReadTimeout == 0 {
+		result.ReadTimeout = config.Duration(defaultReadTimeout)
+	}
+	if result.WriteTimeout == 0 {
+		result.WriteTimeout = config.Duration(defaultWriteTimeout)
+	}
+	if result.IdleTimeout == 0 {
+		result.IdleTimeout = config.Duration(defaultIdleTimeout)
+	}
+	if result.ShutdownTimeout == 0 {
+		result.ShutdownTimeout = config.Duration(defaultShutdownTimeout)
+	}
+	if result.DrainDelay == 0 {
+		result.DrainDelay = config.Duration(defaultDrainDelay)
+	}
+	return result
+}
+
+// DefaultConfig returns the config the server runs with before any file, environment variable or flag is applied.
+func DefaultConfig() *Config {
+	httpConfig := (*HTTPConfig)(nil).withDefaults()
+	postgresConfig := (*database.PostgresConfig)(nil).WithDefaults()
+	return &Config{
+		PostgresConfig: &postgresConfig,
+		AuthConfig:     &middleware.AuthConfig{},
+		EventsConfig:   &outbox.Config{Publisher: outbox.PublisherNone},
+		HTTPConfig:     &httpConfig,
+		TracingConfig:  &tracing.Config{Exporter: tracing.ExporterNone},
+		LoggingConfig:  &logging.Config{Level: "info", Format: logging.FormatJSON},
+		RateLimitConfig: &ratelimit.Config{
+			Store: ratelimit.StoreMemory,
+			Read:  ratelimit.Budget{Requests: defaultReadRequestsPerMinute, Period: config.Duration(time.Minute)},
+			Write: ratelimit.Budget{Requests: defaultWriteRequestsPerMinute, Period: config.Duration(time.Minute)},
+			IP:    ratelimit.Budget{Requests: defaultIPRequestsPerMinute, Period: config.Duration(time.Minute)},
+		},
+		CORSConfig: &middleware.CORSConfig{
+			AllowedOrigins: []string{},
+			AllowedMethods: defaultCORSMethods,
+			AllowedHeaders: defaultCORSHeaders,
+			ExposedHeaders: defaultCORSExposedHeaders,
+			MaxAge:         config.Duration(defaultCORSMaxAge),
+		},
+	}
+}
+
+// AddConfigFlags adds a flag to fs for every setting of the config, such as -postgres.host.
+func AddConfigFlags(fs *flag.FlagSet) {
+	config.AddFlags(fs, &Config{})
+}
+
+// LoadConfig returns the config with the defaults, then the settings in the file at path if it is not empty,
+// then TASKS_* environment variables and then the flags set on fs by AddConfigFlags. Secrets kept in files
+// are read, and the config is returned only if it is valid.
+func LoadConfig(path string, fs *flag.FlagSet) (*Config, error) {
+	c := DefaultConfig()
+	err := config.Load(c, path, fs)
+	if err != nil {
+		return nil, err
+	}
+	err = c.readSecretFiles()
+	if err != nil {
+		return nil, err
+	}
+	err = c.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config:\n%v", err)
+	}
+	return c, nil
+}
+
+// readSecretFiles replaces the secrets that are configured as files with the contents of the files.
+func (c *Config) readSecretFiles() error {
+	type secretFile struct {
+		setting string
+		value   *string
+		file    string
+	}
+	var secrets []secretFile
+	if postgres := c.PostgresConfig; postgres != nil {
+		secrets = append(secrets,
+			secretFile{"postgres.password", &postgres.Password, postgres.PasswordFile},
+			secretFile{"postgres.dsn", &postgres.DSN, postgres.DSNFile})
+	}
+	if auth := c.AuthConfig; auth != nil {
+		secrets = append(secrets, secretFile{"auth.hmac_secret", &auth.HMACSecret, auth.HMACSecretFile})
+	}
+
+	for _, secret := range secrets {
+		if secret.file == "" {
+			continue
+		}
+		if *secret.value != "" {
+			return fmt.Errorf("invalid config: %s and %s_file cannot both be set", secret.setting, secret.setting)
+		}
+		value, err := os.ReadFile(secret.file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s_file: %v", secret.setting, err)
+		}
+		*secret.value = strings.TrimRight(string(value), "\r\n")
+	}
+	return nil
+}
+
+// Validate checks that every setting the server needs is present and usable.
+// The error lists every setting that is not, one per line.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(setting string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", setting, fmt.Sprintf(format, args...)))
+	}
+
+	postgres := c.PostgresConfig.WithDefaults()
+	if postgres.DSN == "" {
+		if postgres.Host == "" {
+			invalid("postgres.host", "must be set unless postgres.dsn is")
+		}
+		if port, err := strconv.Atoi(postgres.Port); err != nil || port < 1 || port > 65535 {
+			invalid("postgres.port", "must be a port number, not %q", postgres.Port)
+		}
+		if postgres.Username == "" {
+			invalid("postgres.username", "must be set unless postgres.dsn is")
+		}
+		if postgres.Database == "" {
+			invalid("postgres.database", "must be set unless postgres.dsn is")
+		}
+	}
+	switch postgres.SSLMode {
+	case "", database.SSLModeDisable, database.SSLModeRequire, database.SSLModeVerifyCA, database.SSLModeVerifyFull:
+	default:
+		invalid("postgres.sslmode", "must be %q, %q, %q or %q, not %q",
+			database.SSLModeDisable, database.SSLModeRequire, database.SSLModeVerifyCA, database.SSLModeVerifyFull, postgres.SSLMode)
+	}
+	if (postgres.SSLCert == "") != (postgres.SSLKey == "") {
+		invalid("postgres.sslkey", "must be set together with postgres.sslcert")
+	}
+	for _, path := range []struct {
+		setting string
+		path    string
+	}{
+		{"postgres.sslrootcert", postgres.SSLRootCert},
+		{"postgres.sslcert", postgres.SSLCert},
+		{"postgres.sslkey", postgres.SSLKey},
+	} {
+		if _, err := os.Stat(path.path); path.path != "" && err != nil {
+			invalid(path.setting, "cannot be read: %v", err)
+		}
+	}
+	if postgres.MaxOpenConns < 0 {
+		invalid("postgres.max_open_conns", "must not be negative")
+	}
+	if postgres.MaxIdleConns < 0 || postgres.MaxIdleConns > postgres.MaxOpenConns {
+		invalid("postgres.max_idle_conns", "must be between 0 and postgres.max_open_conns")
+	}
+	if postgres.ConnectAttempts < 0 {
+		invalid("postgres.connect_attempts", "must not be negative")
+	}
+	if len(postgres.Replicas) > 0 && postgres.DSN != "" {
+		invalid("postgres.replicas", "cannot be used with postgres.dsn")
+	}
+	if postgres.ReplicaCheckInterval <= 0 {
+		invalid("postgres.replica_check_interval", "must be positive")
+	}
+	if postgres.ReadYourWritesWindow < postgres.MaxReplicaLag {
+		invalid("postgres.read_your_writes_window", "must not be shorter than postgres.max_replica_lag")
+	}
+
+	auth := c.AuthConfig
+	if auth == nil {
+		auth = &middleware.AuthConfig{}
+	}
+	legacyIssuer := auth.Domain != "" || auth.IssuerURL != ""
+	if !legacyIssuer && len(auth.Issuers) == 0 {
+		invalid("auth.domain", "must be set unless auth.issuer_url or auth.issuers is")
+	}
+	if auth.IssuerURL != "" && !isHTTPURL(auth.IssuerURL) {
+		invalid("auth.issuer_url", "must be an http or https URL, not %q", auth.IssuerURL)
+	}
+	if legacyIssuer && auth.Audience == "" {
+		invalid("auth.audience", "must be set")
+	}
+	if countSet(auth.JWKSFile, auth.PublicKeyFile, auth.HMACSecret) > 1 {
+		invalid("auth", "only one of jwks_file, public_key_file and hmac_secret may be set")
+	}
+	if auth.HMACSecret != "" && len(auth.HMACSecret) < minHMACSecretLength {
+		invalid("auth.hmac_secret", "must be at least %d bytes long", minHMACSecretLength)
+	}
+	type keyFile struct {
+		setting string
+		path    string
+	}
+	keyFiles := []keyFile{
+		{"auth.jwks_file", auth.JWKSFile},
+		{"auth.public_key_file", auth.PublicKeyFile},
+	}
+	issuerURLs := map[string]bool{}
+	if legacyIssuer {
+		issuerURLs[auth.Issuer()] = true
+	}
+	for i, issuer := range auth.Issuers {
+		setting := fmt.Sprintf("auth.issuers[%d]", i)
+		if !isHTTPURL(issuer.URL) {
+			invalid(setting+".url", "must be an http or https URL, not %q", issuer.URL)
+		} else if issuerURLs[issuer.URL] {
+			invalid(setting+".url", "%q is configured more than once", issuer.URL)
+		}
+		issuerURLs[issuer.URL] = true
+		if issuer.Audience == "" {
+			invalid(setting+".audience", "must be set")
+		}
+		if issuer.JWKSURL != "" && !isHTTPURL(issuer.JWKSURL) {
+			invalid(setting+".jwks_url", "must be an http or https URL, not %q", issuer.JWKSURL)
+		}
+		if countSet(issuer.JWKSURL, issuer.JWKSFile, issuer.PublicKeyFile) > 1 {
+			invalid(setting, "only one of jwks_url, jwks_file and public_key_file may be set")
+		}
+		for _, algorithm := range issuer.Algorithms {
+			if !slices.Contains(middleware.IssuerAlgorithms, algorithm) {
+				invalid(setting+".algorithms", "must be one of %s, not %q", strings.Join(middleware.IssuerAlgorithms, ", "), algorithm)
+			}
+		}
+		keyFiles = append(keyFiles, keyFile{setting + ".jwks_file", issuer.JWKSFile}, keyFile{setting + ".public_key_file", issuer.PublicKeyFile})
+	}
+	for _, path := range keyFiles {
+		if _, err := os.Stat(path.path); path.path != "" && err != nil {
+			invalid(path.setting, "cannot be read: %v", err)
+		}
+	}
+
+	httpConfig := c.HTTPConfig.withDefaults()
+	if _, _, err := net.SplitHostPort(httpConfig.Addr); err != nil {
+		invalid("http.addr", "must be host:port or :port, not %q", httpConfig.Addr)
+	}
+	if _, _, err := net.SplitHostPort(httpConfig.GRPCAddr); err != nil {
+		invalid("http.grpc_addr", "must be host:port or :port, not %q", httpConfig.GRPCAddr)
+	}
+	if httpConfig.Addr == httpConfig.GRPCAddr {
+		invalid("http.grpc_addr", "must differ from http.addr")
+	}
+	durations := []struct {
+		setting  string
+		duration config.Duration
+	}{
+		{"http.read_timeout", httpConfig.ReadTimeout},
+		{"http.write_timeout", httpConfig.WriteTimeout},
+		{"http.idle_timeout", httpConfig.IdleTimeout},
+		{"http.shutdown_timeout", httpConfig.ShutdownTimeout},
+		{"http.drain_delay", httpConfig.DrainDelay},
+		{"http.hsts_max_age", httpConfig.HSTSMaxAge},
+		{"postgres.conn_max_lifetime", postgres.ConnMaxLifetime},
+		{"postgres.conn_max_idle_time", postgres.ConnMaxIdleTime},
+		{"postgres.connect_timeout", postgres.ConnectTimeout},
+		{"postgres.connect_backoff", postgres.ConnectBackoff},
+		{"postgres.max_replica_lag", postgres.MaxReplicaLag},
+	}
+	for _, d := range durations {
+		if d.duration < 0 {
+			invalid(d.setting, "must not be negative")
+		}
+	}
+	if httpConfig.DrainDelay >= httpConfig.ShutdownTimeout {
+		invalid("http.drain_delay", "must be shorter than http.shutdown_timeout")
+	}
+
+	if events := c.EventsConfig; events != nil {
+		switch events.Publisher {
+		case "", outbox.PublisherNone:
+		case outbox.PublisherNATS:
+			if events.NATS == nil || events.NATS.URL == "" {
+				invalid("events.nats.url", "must be set to use the nats publisher")
+			}
+		case outbox.PublisherKafka:
+			if events.Kafka == nil || len(events.Kafka.Brokers) == 0 {
+				invalid("events.kafka.brokers", "must be set to use the kafka publisher")
+			}
+			if events.Kafka == nil || events.Kafka.Topic == "" {
+				invalid("events.kafka.topic", "must be set to use the kafka publisher")
+			}
+		default:
+			invalid("events.publisher", "must be %q, %q or %q, not %q", outbox.PublisherNone, outbox.PublisherNATS, outbox.PublisherKafka, events.Publisher)
+		}
+	}
+
+	if tracingConfig := c.TracingConfig; tracingConfig != nil {
+		switch tracingConfig.Exporter {
+		case "", tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+		default:
+			invalid("tracing.exporter", "must be %q, %q or %q, not %q", tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP, tracingConfig.Exporter)
+		}
+		if ratio := tracingConfig.SampleRatio; ratio != nil && (*ratio < 0 || *ratio > 1) {
+			invalid("tracing.sample_ratio", "must be between 0 and 1")
+		}
+	}
+
+	if cors := c.CORSConfig; cors != nil {
+		for _, origin := range cors.AllowedOrigins {
+			if origin == "*" {
+				if cors.AllowCredentials {
+					invalid("cors.allowed_origins", "cannot contain * when cors.allow_credentials is set")
+				}
+				continue
+			}
+			if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+				invalid("cors.allowed_origins", "must be * or origins such as https://app.example.com, not %q", origin)
+			}
+		}
+		if cors.MaxAge < 0 {
+			invalid("cors.max_age", "must not be negative")
+		}
+	}
+
+	if rateLimit := c.RateLimitConfig; rateLimit != nil {
+		switch rateLimit.Store {
+		case "", ratelimit.StoreMemory, ratelimit.StorePostgres:
+		default:
+			invalid("rate_limit.store", "must be %q or %q, not %q", ratelimit.StoreMemory, ratelimit.StorePostgres, rateLimit.Store)
+		}
+		for _, budget := range []struct {
+			setting string
+			budget  ratelimit.Budget
+		}{
+			{"rate_limit.read", rateLimit.Read},
+			{"rate_limit.write", rateLimit.Write},
+			{"rate_limit.ip", rateLimit.IP},
+		} {
+			if budget.budget.Requests < 0 {
+				invalid(budget.setting+".requests", "must not be negative")
+			}
+			if budget.budget.Requests > 0 && budget.budget.Period <= 0 {
+				invalid(budget.setting+".period", "must be positive")
+			}
+		}
+	}
+
+	if loggingConfig := c.LoggingConfig; loggingConfig != nil {
+		var level slog.Level
+		if loggingConfig.Level != "" && level.UnmarshalText([]byte(loggingConfig.Level)) != nil {
+			invalid("logging.level", "must be debug, info, warn or error, not %q", loggingConfig.Level)
+		}
+		switch loggingConfig.Format {
+		case "", logging.FormatJSON, logging.FormatText:
+		default:
+			invalid("logging.format", "must be %q or %q, not %q", logging.FormatJSON, logging.FormatText, loggingConfig.Format)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isHTTPURL reports whether s is an absolute http or https URL.
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// countSet returns how many of the values are not empty.
+func countSet(values ...string) int {
+	count := 0
+	for _, value := range values {
+		if value != "" {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("from-file\n"), 0o600))
+	dsnFile := filepath.Join(t.TempDir(), "dsn")
+	assert.NoError(t, os.WriteFile(dsnFile, []byte("host=db user=tasks dbname=tasks\n"), 0o600))
+	hmacSecretFile := filepath.Join(t.TempDir(), "hmac_secret")
+	assert.NoError(t, os.WriteFile(hmacSecretFile, []byte("0123456789abcdef0123456789abcdef\n"), 0o600))
+
+	required := map[string]string{
+		"TASKS_POSTGRES_HOST":     "db",
+		"TASKS_POSTGRES_USERNAME": "tasks",
+		"TASKS_POSTGRES_DATABASE": "tasks",
+		"TASKS_AUTH_DOMAIN":       "tasks.example.com",
+		"TASKS_AUTH_AUDIENCE":     "tasks",
+	}
+	tests := []struct {
+		name               string
+		env                map[string]string
+		expectedPassword   string
+		expectedDSN        string
+		expectedHMACSecret string
+		expectedAddr       string
+		expectedErrs       []string
+	}{
+		{
+			name:         "LoadConfig_Defaults",
+			env:          map[string]string{},
+			expectedAddr: ":8080",
+		},
+		{
+			name:             "LoadConfig_Env",
+			env:              map[string]string{"TASKS_POSTGRES_PASSWORD": "from-env", "TASKS_HTTP_ADDR": "127.0.0.1:8000"},
+			expectedPassword: "from-env",
+			expectedAddr:     "127.0.0.1:8000",
+		},
+		{
+			name:             "LoadConfig_PasswordFile",
+			env:              map[string]string{"TASKS_POSTGRES_PASSWORD_FILE": passwordFile},
+			expectedPassword: "from-file",
+			expectedAddr:     ":8080",
+		},
+		{
+			name:         "LoadConfig_DSN",
+			env:          map[string]string{"TASKS_POSTGRES_DSN": "host=db user=tasks dbname=tasks", "TASKS_POSTGRES_HOST": "", "TASKS_POSTGRES_DATABASE": ""},
+			expectedDSN:  "host=db user=tasks dbname=tasks",
+			expectedAddr: ":8080",
+		},
+		{
+			name:         "LoadConfig_DSNFile",
+			env:          map[string]string{"TASKS_POSTGRES_DSN_FILE": dsnFile, "TASKS_POSTGRES_HOST": "", "TASKS_POSTGRES_DATABASE": ""},
+			expectedDSN:  "host=db user=tasks dbname=tasks",
+			expectedAddr: ":8080",
+		},
+		{
+			name:               "LoadConfig_HMACSecretFile",
+			env:                map[string]string{"TASKS_AUTH_HMAC_SECRET_FILE": hmacSecretFile},
+			expectedHMACSecret: "0123456789abcdef0123456789abcdef",
+			expectedAddr:       ":8080",
+		},
+		{
+			name:         "LoadConfig_DSNAndDSNFile",
+			env:          map[string]string{"TASKS_POSTGRES_DSN": "host=db", "TASKS_POSTGRES_DSN_FILE": dsnFile},
+			expectedErrs: []string{"postgres.dsn and postgres.dsn_file cannot both be set"},
+		},
+		{
+			name:         "LoadConfig_MissingHMACSecretFile",
+			env:          map[string]string{"TASKS_AUTH_HMAC_SECRET_FILE": filepath.Join(t.TempDir(), "missing")},
+			expectedErrs: []string{"failed to read auth.hmac_secret_file"},
+		},
+		{
+			name:         "LoadConfig_PasswordAndPasswordFile",
+			env:          map[string]string{"TASKS_POSTGRES_PASSWORD": "from-env", "TASKS_POSTGRES_PASSWORD_FILE": passwordFile},
+			expectedErrs: []string{"postgres.password and postgres.password_file cannot both be set"},
+		},
+		{
+			name:         "LoadConfig_MissingPasswordFile",
+			env:          map[string]string{"TASKS_POSTGRES_PASSWORD_FILE": filepath.Join(t.TempDir(), "missing")},
+			expectedErrs: []string{"failed to read postgres.password_file"},
+		},
+		{
+			name: "LoadConfig_Issuers",
+			env: map[string]string{
+				"TASKS_AUTH_DOMAIN":   "",
+				"TASKS_AUTH_AUDIENCE": "",
+				"TASKS_AUTH_ISSUERS":  `[{"url": "https://login.acme.example.com/", "audience": "tasks", "algorithms": ["RS256", "ES256"]}]`,
+			},
+			expectedAddr: ":8080",
+		},
+		{
+			name: "LoadConfig_Invalid",
+			env: map[string]string{
+				"TASKS_POSTGRES_HOST":             "",
+				"TASKS_POSTGRES_PORT":             "postgres",
+				"TASKS_AUTH_AUDIENCE":             "",
+				"TASKS_HTTP_ADDR":                 "8080",
+				"TASKS_HTTP_DRAIN_DELAY":          "1m",
+				"TASKS_EVENTS_PUBLISHER":          "kafka",
+				"TASKS_TRACING_EXPORTER":          "jaeger",
+				"TASKS_LOGGING_LEVEL":             "verbose",
+				"TASKS_LOGGING_FORMAT":            "xml",
+				"TASKS_HTTP_WRITE_TIMEOUT":        "-1s",
+				"TASKS_POSTGRES_SSLMODE":          "prefer",
+				"TASKS_POSTGRES_SSLCERT":          "/missing/client.pem",
+				"TASKS_POSTGRES_MAX_REPLICA_LAG":  "1m",
+				"TASKS_RATE_LIMIT_STORE":          "redis",
+				"TASKS_RATE_LIMIT_WRITE_REQUESTS": "-1",
+				"TASKS_RATE_LIMIT_READ_PERIOD":    "0s",
+				"TASKS_RATE_LIMIT_IP_REQUESTS":    "-1",
+				"TASKS_CORS_ALLOWED_ORIGINS":      "*,app.example.com",
+				"TASKS_CORS_ALLOW_CREDENTIALS":    "true",
+				"TASKS_CORS_MAX_AGE":              "-1m",
+				"TASKS_AUTH_ISSUER_URL":           "issuer.example.com",
+				"TASKS_AUTH_HMAC_SECRET":          "secret",
+				"TASKS_AUTH_JWKS_FILE":            "/missing/jwks.json",
+				"TASKS_AUTH_ISSUERS":              `[{"url": "login.acme.example.com", "jwks_url": "ftp://keys.example.com", "jwks_file": "/missing/jwks.json", "algorithms": ["HS256"]}]`,
+			},
+			expectedErrs: []string{
+				"postgres.host: must be set unless postgres.dsn is",
+				`postgres.sslmode: must be "disable", "require", "verify-ca" or "verify-full", not "prefer"`,
+				"postgres.sslkey: must be set together with postgres.sslcert",
+				"postgres.sslcert: cannot be read",
+				"postgres.read_your_writes_window: must not be shorter than postgres.max_replica_lag",
+				`postgres.port: must be a port number, not "postgres"`,
+				"auth.audience: must be set",
+				`auth.issuer_url: must be an http or https URL, not "issuer.example.com"`,
+				"auth: only one of jwks_file, public_key_file and hmac_secret may be set",
+				"auth.hmac_secret: must be at least 32 bytes long",
+				"auth.jwks_file: cannot be read",
+				`auth.issuers[0].url: must be an http or https URL, not "login.acme.example.com"`,
+				"auth.issuers[0].audience: must be set",
+				`auth.issuers[0].jwks_url: must be an http or https URL, not "ftp://keys.example.com"`,
+				"auth.issuers[0]: only one of jwks_url, jwks_file and public_key_file may be set",
+				`auth.issuers[0].algorithms: must be one of RS256, ES256, EdDSA, not "HS256"`,
+				"auth.issuers[0].jwks_file: cannot be read",
+				`http.addr: must be host:port or :port, not "8080"`,
+				"http.write_timeout: must not be negative",
+				"http.drain_delay: must be shorter than http.shutdown_timeout",
+				"events.kafka.brokers: must be set to use the kafka publisher",
+				"events.kafka.topic: must be set to use the kafka publisher",
+				`tracing.exporter: must be "none", "stdout" or "otlp", not "jaeger"`,
+				`rate_limit.store: must be "memory" or "postgres", not "redis"`,
+				"rate_limit.read.period: must be positive",
+				"rate_limit.write.requests: must not be negative",
+				"rate_limit.ip.requests: must not be negative",
+				"cors.allowed_origins: cannot contain * when cors.allow_credentials is set",
+				`cors.allowed_origins: must be * or origins such as https://app.example.com, not "app.example.com"`,
+				"cors.max_age: must not be negative",
+				`logging.level: must be debug, info, warn or error, not "verbose"`,
+				`logging.format: must be "json" or "text", not "xml"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range required {
+				t.Setenv(key, value)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			config, err := LoadConfig("", nil)
+			if len(tt.expectedErrs) > 0 {
+				for _, expected := range tt.expectedErrs {
+					assert.ErrorContains(t, err, expected)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedPassword, config.PostgresConfig.Password)
+			assert.Equal(t, tt.expectedDSN, config.PostgresConfig.DSN)
+			assert.Equal(t, tt.expectedHMACSecret, config.AuthConfig.HMACSecret)
+			assert.Equal(t, tt.expectedAddr, config.HTTPConfig.Addr)
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SevvyP/tasks_v1/internal/logging"
+	"github.com/SevvyP/tasks_v1/internal/middleware"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// eventKeepAliveInterval is how often a comment is written to idle event streams so proxies keep them open.
+const eventKeepAliveInterval = 15 * time.Second
+
+// eventBroker fans out task event notifications to the streams subscribed for each user.
+// Once it is closed, the channels of every subscription are closed so that the streams end.
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+	closed      bool
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subscribers: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// subscribe registers a stream for the user. The returned channel receives a value whenever
+// the user may have new events, and is closed when the broker is, and the returned function removes the subscription.
+func (b *eventBroker) subscribe(user string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		close(ch)
+		return ch, func() {}
+	}
+	if b.subscribers[user] == nil {
+		b.subscribers[user] = make(map[chan struct{}]struct{})
+	}
+	b.subscribers[user][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers[user], ch)
+		if len(b.subscribers[user]) == 0 {
+			delete(b.subscribers, user)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// notify wakes every stream subscribed for the user, or every stream if user is empty.
+// Streams that already have a pending wake-up are skipped, since they will re-read the log anyway.
+func (b *eventBroker) notify(user string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for u, subscribers := range b.subscribers {
+		if user != "" && u != user {
+			continue
+		}
+		for ch := range subscribers {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// close ends every subscription, and any made afterwards, by closing its channel.
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, subscribers := range b.subscribers {
+		for ch := range subscribers {
+			close(ch)
+		}
+	}
+	b.subscribers = make(map[string]map[chan struct{}]struct{})
+}
+
+// run forwards notifications to subscribers until the notifications channel is closed.
+func (b *eventBroker) run(notifications <-chan string) {
+	for user := range notifications {
+		b.notify(user)
+	}
+}
+
+// StreamTaskEvents streams created, updated and deleted events for the caller's tasks as Server-Sent Events.
+// If a Last-Event-ID header is sent, the events after that ID are sent first so that a reconnecting client misses nothing,
+// otherwise only events that happen after the stream is opened are sent.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If the Last-Event-ID header is not a valid event ID, an HTTP 400 Bad Request is returned.
+// If there is an error reading the latest event ID, an HTTP 500 Internal Server Error is returned.
+func (r *Resolver) StreamTaskEvents(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUserID(req.Context())
+	if user == "" {
+		middleware.WriteProblem(w, req, http.StatusUnauthorized, model.ProblemUnauthorized, "The token does not identify a user.")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		middleware.WriteInternalError(w, req, errors.New("streaming unsupported"))
+		return
+	}
+
+	// Subscribe before reading the log so that events committed in between are not missed.
+	notify, unsubscribe := r.events.subscribe(user)
+	defer unsubscribe()
+
+	// Without a Last-Event-ID the stream starts at the latest event, so only new changes are sent.
+	var lastID int64
+	var err error
+	if header := req.Header.Get("Last-Event-ID"); header != "" {
+		lastID, err = strconv.ParseInt(header, 10, 64)
+		if err != nil {
+			middleware.WriteProblem(w, req, http.StatusBadRequest, model.ProblemInvalidRequest, "Invalid Last-Event-ID")
+			return
+		}
+	} else {
+		lastID, err = r.Database.GetLatestTaskEventID(req.Context(), user)
+		if err != nil {
+			middleware.WriteInternalError(w, req, err)
+			return
+		}
+	}
+
+	// The stream outlives the server's write timeout, so it is only ended by the client or by shutdown.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		events, err := r.Database.GetTaskEvents(req.Context(), user, lastID)
+		if err != nil {
+			// The stream is already open, so end it and let the client reconnect with its Last-Event-ID.
+			logging.FromContext(req.Context()).ErrorContext(req.Context(), "Failed to get task events", "error", err)
+			return
+		}
+		for _, event := range *events {
+			data, err := json.Marshal(event)
+			if err != nil {
+				logging.FromContext(req.Context()).ErrorContext(req.Context(), "Failed to encode task event", "error", err)
+				return
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			lastID = event.ID
+		}
+		flusher.Flush()
+		if len(*events) > 0 {
+			// Keep reading until the log is drained before waiting for new events.
+			continue
+		}
+
+		select {
+		case <-req.Context().Done():
+			return
+		case _, ok := <-notify:
+			if !ok {
+				// The server is shutting down. The client reconnects with its Last-Event-ID.
+				return
+			}
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// withUser returns a copy of the request authenticated as the given user.
+func withUser(req *http.Request, user string) *http.Request {
+	claims := &validator.ValidatedClaims{RegisteredClaims: validator.RegisteredClaims{Subject: user}}
+	return req.WithContext(context.WithValue(req.Context(), jwtmiddleware.ContextKey{}, claims))
+}
+
+func TestStreamTaskEventsHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		user           string
+		lastEventID    string
+		dbResponse     *[]model.TaskEvent
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:        "StreamTaskEvents_Resume",
+			user:        "user-1",
+			lastEventID: "5",
+			dbResponse: &[]model.TaskEvent{
+				{ID: 6, Type: model.TaskCreated, UserID: "user-1", TaskID: "1", Task: &model.Task{ID: "1", Body: "Task 1"}},
+				{ID: 7, Type: model.TaskDeleted, UserID: "user-1", TaskID: "2"},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: "id: 6\nevent: created\ndata: " +
+				`{"id":6,"type":"created","user_id":"user-1","task_id":"1","task":{"id":"1","user_id":"","body":"Task 1","completed":false,"parent":null,"reminder":null},"created_at":"0001-01-01T00:00:00Z"}` +
+				"\n\nid: 7\nevent: deleted\ndata: " +
+				`{"id":7,"type":"deleted","user_id":"user-1","task_id":"2","created_at":"0001-01-01T00:00:00Z"}` +
+				"\n\n",
+		},
+		{
+			name:        "StreamTaskEvents_Latest",
+			user:        "user-1",
+			lastEventID: "",
+			dbResponse: &[]model.TaskEvent{
+				{ID: 6, Type: model.TaskDeleted, UserID: "user-1", TaskID: "2"},
+				{ID: 7, Type: model.TaskDeleted, UserID: "user-1", TaskID: "3"},
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: "id: 6\nevent: deleted\ndata: " +
+				`{"id":6,"type":"deleted","user_id":"user-1","task_id":"2","created_at":"0001-01-01T00:00:00Z"}` +
+				"\n\nid: 7\nevent: deleted\ndata: " +
+				`{"id":7,"type":"deleted","user_id":"user-1","task_id":"3","created_at":"0001-01-01T00:00:00Z"}` +
+				"\n\n",
+		},
+		{
+			name:           "StreamTaskEvents_InvalidLastEventID",
+			user:           "user-1",
+			lastEventID:    "abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"type":"about:blank","title":"Bad Request","status":400,"detail":"Invalid Last-Event-ID","instance":"/tasks/events","code":"invalid_request"}` + "\n",
+		},
+		{
+			name:           "StreamTaskEvents_Unauthorized",
+			user:           "",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"type":"about:blank","title":"Unauthorized","status":401,"detail":"The token does not identify a user.","instance":"/tasks/events","code":"unauthorized"}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			drained := make(chan struct{})
+			if tt.dbResponse != nil && tt.lastEventID == "" {
+				mockDB.On("GetLatestTaskEventID", tt.user).Return(int64(5), nil)
+			}
+			if tt.dbResponse != nil {
+				mockDB.On("GetTaskEvents", tt.user, int64(5)).Return(tt.dbResponse, nil).Once()
+				mockDB.On("GetTaskEvents", tt.user, int64(7)).Return(&[]model.TaskEvent{}, nil).Once().
+					Run(func(_ mock.Arguments) { close(drained) })
+			}
+			resolver := &Resolver{Database: mockDB, events: newEventBroker()}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, "GET", "/tasks/events", nil)
+			assert.NoError(t, err)
+			if tt.user != "" {
+				req = withUser(req, tt.user)
+			}
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+
+			rr := httptest.NewRecorder()
+			done := make(chan struct{})
+			go func() {
+				http.HandlerFunc(resolver.StreamTaskEvents).ServeHTTP(rr, req)
+				close(done)
+			}()
+			if tt.dbResponse != nil {
+				<-drained
+				cancel()
+			}
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("handler did not return")
+			}
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedBody, rr.Body.String())
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestEventBrokerNotify(t *testing.T) {
+	broker := newEventBroker()
+	user1, unsubscribe1 := broker.subscribe("user-1")
+	defer unsubscribe1()
+	user2, unsubscribe2 := broker.subscribe("user-2")
+	defer unsubscribe2()
+
+	broker.notify("user-1")
+	assert.Len(t, user1, 1)
+	assert.Len(t, user2, 0)
+
+	// A pending wake-up is not duplicated, and an empty user wakes everyone.
+	broker.notify("")
+	assert.Len(t, user1, 1)
+	assert.Len(t, user2, 1)
+}
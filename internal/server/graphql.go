@@ -0,0 +1,483 @@
+package server
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/graph-gophers/dataloader/v7"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-transport-ws/graphqlws"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/internal/logging"
+	"github.com/SevvyP/tasks_v1/internal/middleware"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// schemaSource is the GraphQL schema served at /graphql.
+//
+//go:embed schema.graphql
+var schemaSource string
+
+// graphqlMaxDepth is the deepest a GraphQL query may nest fields, which bounds how far down a task tree one request can walk.
+const graphqlMaxDepth = 10
+
+// graphqlRequest is the body of a GraphQL query or mutation sent with POST.
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// QueryGraphQL runs a GraphQL query or mutation over the caller's tasks and sends the result as a JSON response.
+// Errors in the operation are returned in the errors of an HTTP 200 OK response, with the problem code in their extensions.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If the request body is not valid JSON or has no query, an HTTP 400 Bad Request is returned.
+// If the request body is larger than 64 KiB, an HTTP 413 Payload Too Large is returned.
+func (r *Resolver) QueryGraphQL(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUserID(req.Context())
+	if user == "" {
+		middleware.WriteProblem(w, req, http.StatusUnauthorized, model.ProblemUnauthorized, "The token does not identify a user.")
+		return
+	}
+
+	body, ok := readRequestBody(w, req, maxTaskRequestBytes)
+	if !ok {
+		return
+	}
+	var query graphqlRequest
+	if !decodeStrict(w, req, body, &query) {
+		return
+	}
+	if query.Query == "" {
+		middleware.WriteProblem(w, req, http.StatusBadRequest, model.ProblemInvalidRequest, "The request has no query",
+			model.FieldError{Field: "query", Message: "must not be empty"})
+		return
+	}
+
+	response := r.graphql().Exec(req.Context(), query.Query, query.OperationName, query.Variables)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// SubscribeGraphQL upgrades the request to a WebSocket speaking the graphql-ws subprotocol, over which clients
+// run GraphQL subscriptions, queries and mutations as the caller.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If the request is not a WebSocket upgrade for the graphql-ws subprotocol, an HTTP 400 Bad Request is returned.
+func (r *Resolver) SubscribeGraphQL(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUserID(req.Context())
+	if user == "" {
+		middleware.WriteProblem(w, req, http.StatusUnauthorized, model.ProblemUnauthorized, "The token does not identify a user.")
+		return
+	}
+
+	notUpgraded := func(w http.ResponseWriter, req *http.Request) {
+		middleware.WriteProblem(w, req, http.StatusBadRequest, model.ProblemInvalidRequest,
+			"Send queries and mutations with POST, or open a WebSocket with the graphql-ws subprotocol")
+	}
+	// Operations run on a context of the connection's own, so the caller's claims are copied into it.
+	withUser := func(ctx context.Context, req *http.Request) (context.Context, error) {
+		return middleware.WithClaimsFrom(ctx, req.Context()), nil
+	}
+	graphqlws.NewHandlerFunc(r.graphql(), http.HandlerFunc(notUpgraded),
+		graphqlws.WithContextGenerator(graphqlws.ContextGeneratorFunc(withUser)),
+		graphqlws.WithReadLimit(maxTaskRequestBytes),
+	)(w, req)
+}
+
+// graphql returns the GraphQL schema, parsing it the first time it is needed.
+func (r *Resolver) graphql() *graphql.Schema {
+	r.graphqlOnce.Do(func() {
+		r.graphqlSchema = graphql.MustParseSchema(schemaSource, &graphqlResolver{resolver: r}, graphql.MaxDepth(graphqlMaxDepth))
+	})
+	return r.graphqlSchema
+}
+
+// graphqlUser returns the caller of a GraphQL operation.
+func graphqlUser(ctx context.Context) string {
+	return middleware.GetUserID(ctx)
+}
+
+// graphqlResolver resolves the Query, Mutation and Subscription fields of the GraphQL schema.
+type graphqlResolver struct {
+	resolver *Resolver
+}
+
+// taskInput is the TaskInput of the GraphQL schema.
+type taskInput struct {
+	ID        graphql.ID
+	Body      string
+	Completed bool
+	Parent    *graphql.ID
+	Reminder  *graphql.ID
+}
+
+// task returns the task the input describes.
+func (i taskInput) task() model.Task {
+	task := model.Task{ID: string(i.ID), Body: i.Body, Completed: i.Completed}
+	if i.Parent != nil {
+		parent := string(*i.Parent)
+		task.Parent = &parent
+	}
+	if i.Reminder != nil {
+		reminder := string(*i.Reminder)
+		task.Reminder = &reminder
+	}
+	return task
+}
+
+// Tasks resolves the caller's tasks. The whole tree is read with one query and primes the loaders,
+// so the parents and children of the tasks are resolved without querying the database again.
+func (g *graphqlResolver) Tasks(ctx context.Context, args struct{ Roots bool }) ([]*taskResolver, error) {
+	user := graphqlUser(ctx)
+	tasks, err := g.resolver.Database.GetTasksByUserID(ctx, user)
+	if err != nil {
+		return nil, asMutationError(ctx, err)
+	}
+
+	loaders := newTaskLoaders(g.resolver.Database, user)
+	loaders.prime(ctx, *tasks)
+
+	resolvers := []*taskResolver{}
+	for _, task := range *tasks {
+		if args.Roots && task.Parent != nil {
+			continue
+		}
+		resolvers = append(resolvers, &taskResolver{task: task, loaders: loaders})
+	}
+	return resolvers, nil
+}
+
+// Task resolves one of the caller's tasks, or nil if the caller has no task with the ID.
+func (g *graphqlResolver) Task(ctx context.Context, args struct{ ID graphql.ID }) (*taskResolver, error) {
+	if !isUUID(string(args.ID)) {
+		return nil, &mutationError{status: http.StatusBadRequest, code: model.ProblemValidationFailed, message: "The id argument is not valid",
+			fields: []model.FieldError{{Field: "id", Message: "must be a UUID"}}}
+	}
+	loaders := newTaskLoaders(g.resolver.Database, graphqlUser(ctx))
+	task, err := loaders.tasks.Load(ctx, string(args.ID))()
+	if err != nil {
+		return nil, asMutationError(ctx, err)
+	}
+	return loaders.resolve(task), nil
+}
+
+// CreateTask creates a task for the caller and resolves it as it was stored.
+func (g *graphqlResolver) CreateTask(ctx context.Context, args struct{ Input taskInput }) (*taskResolver, error) {
+	return g.mutate(ctx, model.MutationCreate, args.Input.task())
+}
+
+// UpdateTask updates one of the caller's tasks and resolves it as it was stored.
+func (g *graphqlResolver) UpdateTask(ctx context.Context, args struct{ Input taskInput }) (*taskResolver, error) {
+	return g.mutate(ctx, model.MutationUpdate, args.Input.task())
+}
+
+// DeleteTask deletes one of the caller's tasks and resolves its ID.
+func (g *graphqlResolver) DeleteTask(ctx context.Context, args struct{ ID graphql.ID }) (graphql.ID, error) {
+	_, err := g.mutate(ctx, model.MutationDelete, model.Task{ID: string(args.ID)})
+	if err != nil {
+		return "", err
+	}
+	return args.ID, nil
+}
+
+// mutate applies a mutation to one of the caller's tasks, going through the same checks as the REST handlers,
+// and resolves the task as it was stored.
+func (g *graphqlResolver) mutate(ctx context.Context, mutation string, task model.Task) (*taskResolver, error) {
+	user := graphqlUser(ctx)
+	err := g.resolver.applyMutation(ctx, mutation, task)
+	if err != nil {
+		return nil, asMutationError(ctx, err)
+	}
+	if mutation == model.MutationDelete {
+		return nil, nil
+	}
+
+	// The task was only just written, so it is read back from the primary.
+	stored, err := g.resolver.Database.GetTaskByIDFromPrimary(ctx, task.ID)
+	if err == nil && stored == nil {
+		err = fmt.Errorf("task %s was not found after it was saved", task.ID)
+	}
+	if err != nil {
+		return nil, asMutationError(ctx, err)
+	}
+	return &taskResolver{task: *stored, loaders: newTaskLoaders(g.resolver.Database, user)}, nil
+}
+
+// TaskEvents streams created, updated and deleted events for the caller's tasks until the subscription ends.
+// If a last event ID is given, the events after it are sent first, otherwise only events that happen after
+// the subscription starts are sent.
+func (g *graphqlResolver) TaskEvents(ctx context.Context, args struct{ LastEventID *graphql.ID }) (<-chan *taskEventResolver, error) {
+	user := graphqlUser(ctx)
+
+	// Subscribe before reading the log so that events committed in between are not missed.
+	notify, unsubscribe := g.resolver.events.subscribe(user)
+
+	var lastID int64
+	var err error
+	if args.LastEventID != nil {
+		lastID, err = strconv.ParseInt(string(*args.LastEventID), 10, 64)
+		if err != nil {
+			unsubscribe()
+			return nil, &mutationError{status: http.StatusBadRequest, code: model.ProblemInvalidRequest, message: "Invalid lastEventId"}
+		}
+	} else {
+		lastID, err = g.resolver.Database.GetLatestTaskEventID(ctx, user)
+		if err != nil {
+			unsubscribe()
+			return nil, asMutationError(ctx, err)
+		}
+	}
+
+	events := make(chan *taskEventResolver)
+	go func() {
+		defer close(events)
+		defer unsubscribe()
+
+		for {
+			batch, err := g.resolver.Database.GetTaskEvents(ctx, user, lastID)
+			if err != nil {
+				// End the subscription and let the client resubscribe from the last event it received.
+				logging.FromContext(ctx).ErrorContext(ctx, "Failed to get task events", "error", err)
+				return
+			}
+			loaders := newTaskLoaders(g.resolver.Database, user)
+			for _, event := range *batch {
+				select {
+				case events <- &taskEventResolver{event: event, loaders: loaders}:
+				case <-ctx.Done():
+					return
+				}
+				lastID = event.ID
+			}
+			if len(*batch) > 0 {
+				// Keep reading until the log is drained before waiting for new events.
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-notify:
+				if !ok {
+					// The server is shutting down. The client resubscribes from the last event it received.
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// taskLoaders batch the database reads made while resolving the fields of one GraphQL operation,
+// so that resolving the parent, children or reminder of many tasks takes one query per level rather than one per task.
+// Only tasks belonging to the user are loaded.
+type taskLoaders struct {
+	user      string
+	tasks     *dataloader.Loader[string, *model.Task]
+	children  *dataloader.Loader[string, []model.Task]
+	reminders *dataloader.Loader[string, *model.Reminder]
+}
+
+func newTaskLoaders(db database.TaskDatabase, user string) *taskLoaders {
+	l := &taskLoaders{user: user}
+
+	l.tasks = dataloader.NewBatchedLoader(func(ctx context.Context, ids []string) []*dataloader.Result[*model.Task] {
+		tasks, err := db.GetTasksByIDs(ctx, ids)
+		if err != nil {
+			return failedResults[*model.Task](len(ids), err)
+		}
+		byID := make(map[string]*model.Task, len(*tasks))
+		for _, task := range *tasks {
+			if task.UserID == user {
+				byID[task.ID] = &task
+			}
+		}
+		results := make([]*dataloader.Result[*model.Task], len(ids))
+		for i, id := range ids {
+			results[i] = &dataloader.Result[*model.Task]{Data: byID[id]}
+		}
+		return results
+	})
+
+	l.children = dataloader.NewBatchedLoader(func(ctx context.Context, parentIDs []string) []*dataloader.Result[[]model.Task] {
+		tasks, err := db.GetTasksByParentIDs(ctx, parentIDs)
+		if err != nil {
+			return failedResults[[]model.Task](len(parentIDs), err)
+		}
+		byParent := make(map[string][]model.Task, len(parentIDs))
+		for _, task := range *tasks {
+			if task.UserID == user && task.Parent != nil {
+				byParent[*task.Parent] = append(byParent[*task.Parent], task)
+			}
+		}
+		results := make([]*dataloader.Result[[]model.Task], len(parentIDs))
+		for i, id := range parentIDs {
+			results[i] = &dataloader.Result[[]model.Task]{Data: byParent[id]}
+		}
+		return results
+	})
+
+	l.reminders = dataloader.NewBatchedLoader(func(ctx context.Context, ids []string) []*dataloader.Result[*model.Reminder] {
+		reminders, err := db.GetRemindersByIDs(ctx, ids)
+		if err != nil {
+			return failedResults[*model.Reminder](len(ids), err)
+		}
+		byID := make(map[string]*model.Reminder, len(*reminders))
+		for _, reminder := range *reminders {
+			byID[reminder.ID] = &reminder
+		}
+		results := make([]*dataloader.Result[*model.Reminder], len(ids))
+		for i, id := range ids {
+			results[i] = &dataloader.Result[*model.Reminder]{Data: byID[id]}
+		}
+		return results
+	})
+
+	return l
+}
+
+// failedResults returns n results with the same error, for a batch whose query failed.
+func failedResults[V any](n int, err error) []*dataloader.Result[V] {
+	results := make([]*dataloader.Result[V], n)
+	for i := range results {
+		results[i] = &dataloader.Result[V]{Error: err}
+	}
+	return results
+}
+
+// prime caches every one of the user's tasks and the children of each, given all of the user's tasks.
+func (l *taskLoaders) prime(ctx context.Context, tasks []model.Task) {
+	children := make(map[string][]model.Task, len(tasks))
+	for _, task := range tasks {
+		l.tasks.Prime(ctx, task.ID, &task)
+		if task.Parent != nil {
+			children[*task.Parent] = append(children[*task.Parent], task)
+		}
+	}
+	for _, task := range tasks {
+		l.children.Prime(ctx, task.ID, children[task.ID])
+	}
+}
+
+// resolve returns a resolver for the task, or nil if there is no task.
+func (l *taskLoaders) resolve(task *model.Task) *taskResolver {
+	if task == nil {
+		return nil
+	}
+	return &taskResolver{task: *task, loaders: l}
+}
+
+// taskResolver resolves the fields of a Task.
+type taskResolver struct {
+	task    model.Task
+	loaders *taskLoaders
+}
+
+func (t *taskResolver) ID() graphql.ID {
+	return graphql.ID(t.task.ID)
+}
+
+func (t *taskResolver) UserID() graphql.ID {
+	return graphql.ID(t.task.UserID)
+}
+
+func (t *taskResolver) Body() string {
+	return t.task.Body
+}
+
+func (t *taskResolver) Completed() bool {
+	return t.task.Completed
+}
+
+func (t *taskResolver) Parent(ctx context.Context) (*taskResolver, error) {
+	if t.task.Parent == nil {
+		return nil, nil
+	}
+	parent, err := t.loaders.tasks.Load(ctx, *t.task.Parent)()
+	if err != nil {
+		return nil, asMutationError(ctx, err)
+	}
+	return t.loaders.resolve(parent), nil
+}
+
+func (t *taskResolver) Children(ctx context.Context) ([]*taskResolver, error) {
+	children, err := t.loaders.children.Load(ctx, t.task.ID)()
+	if err != nil {
+		return nil, asMutationError(ctx, err)
+	}
+	resolvers := make([]*taskResolver, len(children))
+	for i, child := range children {
+		resolvers[i] = &taskResolver{task: child, loaders: t.loaders}
+	}
+	return resolvers, nil
+}
+
+func (t *taskResolver) Reminder(ctx context.Context) (*reminderResolver, error) {
+	if t.task.Reminder == nil {
+		return nil, nil
+	}
+	reminder, err := t.loaders.reminders.Load(ctx, *t.task.Reminder)()
+	if err != nil {
+		return nil, asMutationError(ctx, err)
+	}
+	if reminder == nil {
+		return nil, nil
+	}
+	return &reminderResolver{reminder: *reminder}, nil
+}
+
+func (t *taskResolver) UpdatedAt() *graphql.Time {
+	if t.task.UpdatedAt == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *t.task.UpdatedAt}
+}
+
+// reminderResolver resolves the fields of a Reminder.
+type reminderResolver struct {
+	reminder model.Reminder
+}
+
+func (r *reminderResolver) ID() graphql.ID {
+	return graphql.ID(r.reminder.ID)
+}
+
+func (r *reminderResolver) Date() float64 {
+	return float64(r.reminder.Date)
+}
+
+func (r *reminderResolver) SendAlert() bool {
+	return r.reminder.SendAlert
+}
+
+// taskEventResolver resolves the fields of a TaskEvent.
+type taskEventResolver struct {
+	event   model.TaskEvent
+	loaders *taskLoaders
+}
+
+func (e *taskEventResolver) ID() graphql.ID {
+	return graphql.ID(strconv.FormatInt(e.event.ID, 10))
+}
+
+func (e *taskEventResolver) Type() string {
+	return e.event.Type
+}
+
+func (e *taskEventResolver) TaskID() graphql.ID {
+	return graphql.ID(e.event.TaskID)
+}
+
+func (e *taskEventResolver) Task() *taskResolver {
+	return e.loaders.resolve(e.event.Task)
+}
+
+func (e *taskEventResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: e.event.CreatedAt}
+}
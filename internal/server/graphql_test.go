@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// sameIDs matches a slice of IDs in any order, since the order of a dataloader batch is not fixed.
+func sameIDs(ids ...string) interface{} {
+	slices.Sort(ids)
+	return mock.MatchedBy(func(batch []string) bool {
+		batch = slices.Clone(batch)
+		slices.Sort(batch)
+		return slices.Equal(ids, batch)
+	})
+}
+
+func TestQueryGraphQL(t *testing.T) {
+	parentID := taskID1
+	reminderID := webhookID1
+	root := model.Task{ID: taskID1, UserID: "user-1", Body: "Root", Reminder: &reminderID}
+	child := model.Task{ID: taskID2, UserID: "user-1", Body: "Child", Parent: &parentID, Reminder: &reminderID}
+	sibling := model.Task{ID: taskID3, UserID: "user-1", Body: "Sibling", Parent: &parentID}
+
+	tests := []struct {
+		name           string
+		query          string
+		setup          func(mockDB *database.MockDatabase)
+		expectedData   string
+		expectedCodes  []string
+		expectedFields []model.FieldError
+	}{
+		{
+			name:  "Tasks_Tree",
+			query: `{ tasks(roots: true) { id children { id parent { id } children { id } } } }`,
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTasksByUserID", "user-1").Return(&[]model.Task{root, child, sibling}, nil)
+			},
+			expectedData: `{"tasks":[{"id":"` + taskID1 + `","children":[` +
+				`{"id":"` + taskID2 + `","parent":{"id":"` + taskID1 + `"},"children":[]},` +
+				`{"id":"` + taskID3 + `","parent":{"id":"` + taskID1 + `"},"children":[]}]}]}`,
+		},
+		{
+			name:  "Tasks_RemindersBatched",
+			query: `{ tasks { id reminder { id date sendAlert } } }`,
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTasksByUserID", "user-1").Return(&[]model.Task{root, child, sibling}, nil)
+				mockDB.On("GetRemindersByIDs", []string{reminderID}).
+					Return(&[]model.Reminder{{ID: reminderID, Date: 1700000000, SendAlert: true}}, nil).Once()
+			},
+			expectedData: `{"tasks":[` +
+				`{"id":"` + taskID1 + `","reminder":{"id":"` + reminderID + `","date":1700000000,"sendAlert":true}},` +
+				`{"id":"` + taskID2 + `","reminder":{"id":"` + reminderID + `","date":1700000000,"sendAlert":true}},` +
+				`{"id":"` + taskID3 + `","reminder":null}]}`,
+		},
+		{
+			name:  "Task_ChildrenBatched",
+			query: `{ task(id: "` + taskID1 + `") { id children { id children { id } } } }`,
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTasksByIDs", []string{taskID1}).Return(&[]model.Task{root}, nil).Once()
+				mockDB.On("GetTasksByParentIDs", []string{taskID1}).Return(&[]model.Task{child, sibling}, nil).Once()
+				mockDB.On("GetTasksByParentIDs", sameIDs(taskID2, taskID3)).Return(&[]model.Task{}, nil).Once()
+			},
+			expectedData: `{"task":{"id":"` + taskID1 + `","children":[{"id":"` + taskID2 + `","children":[]},{"id":"` + taskID3 + `","children":[]}]}}`,
+		},
+		{
+			name:  "Task_OtherUsersTask",
+			query: `{ task(id: "` + taskID1 + `") { id } }`,
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTasksByIDs", []string{taskID1}).Return(&[]model.Task{{ID: taskID1, UserID: "user-2"}}, nil)
+			},
+			expectedData: `{"task":null}`,
+		},
+		{
+			name:           "Task_InvalidID",
+			query:          `{ task(id: "1") { id } }`,
+			expectedData:   `{"task":null}`,
+			expectedCodes:  []string{model.ProblemValidationFailed},
+			expectedFields: []model.FieldError{{Field: "id", Message: "must be a UUID"}},
+		},
+		{
+			name:  "Tasks_Error",
+			query: `{ tasks { id } }`,
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTasksByUserID", "user-1").Return((*[]model.Task)(nil), errors.New("database unavailable"))
+			},
+			expectedData:  `null`,
+			expectedCodes: []string{model.ProblemInternal},
+		},
+		{
+			name:  "CreateTask_Created",
+			query: `mutation { createTask(input: {id: "` + taskID2 + `", body: "Child", parent: "` + taskID1 + `"}) { id body parent { id } } }`,
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTaskByIDFromPrimary", taskID2).Return((*model.Task)(nil), nil).Once()
+				mockDB.On("GetTaskByIDFromPrimary", taskID1).Return(&root, nil)
+				mockDB.On("CreateTask", model.Task{ID: taskID2, UserID: "user-1", Body: "Child", Parent: &parentID}).Return(nil)
+				mockDB.On("GetTaskByIDFromPrimary", taskID2).Return(&child, nil).Once()
+				mockDB.On("GetTasksByIDs", []string{taskID1}).Return(&[]model.Task{root}, nil)
+			},
+			expectedData: `{"createTask":{"id":"` + taskID2 + `","body":"Child","parent":{"id":"` + taskID1 + `"}}}`,
+		},
+		{
+			name:           "CreateTask_Invalid",
+			query:          `mutation { createTask(input: {id: "` + taskID2 + `", body: " "}) { id } }`,
+			expectedData:   `null`,
+			expectedCodes:  []string{model.ProblemValidationFailed},
+			expectedFields: []model.FieldError{{Field: "body", Message: "must not be empty"}},
+		},
+		{
+			name:  "UpdateTask_OtherUsersTask",
+			query: `mutation { updateTask(input: {id: "` + taskID1 + `", body: "Mine now"}) { id } }`,
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTaskByIDFromPrimary", taskID1).Return(&model.Task{ID: taskID1, UserID: "user-2"}, nil)
+			},
+			expectedData:  `null`,
+			expectedCodes: []string{model.ProblemTaskNotFound},
+		},
+		{
+			name:  "DeleteTask_Deleted",
+			query: `mutation { deleteTask(id: "` + taskID1 + `") }`,
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTaskByIDFromPrimary", taskID1).Return(&root, nil)
+				mockDB.On("DeleteTask", model.Task{ID: taskID1, UserID: "user-1"}).Return(nil)
+			},
+			expectedData: `{"deleteTask":"` + taskID1 + `"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			if tt.setup != nil {
+				tt.setup(mockDB)
+			}
+			resolver := &Resolver{Database: mockDB}
+
+			body, err := json.Marshal(graphqlRequest{Query: tt.query})
+			assert.NoError(t, err)
+			req, err := http.NewRequest("POST", "/graphql", bytes.NewReader(body))
+			assert.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(resolver.QueryGraphQL)
+			handler.ServeHTTP(rr, withUser(req, "user-1"))
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			var response struct {
+				Data   json.RawMessage
+				Errors []struct {
+					Message    string
+					Extensions struct {
+						Code   string
+						Errors []model.FieldError
+					}
+				}
+			}
+			assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+			assert.JSONEq(t, tt.expectedData, string(response.Data))
+
+			var codes []string
+			var fields []model.FieldError
+			for _, e := range response.Errors {
+				codes = append(codes, e.Extensions.Code)
+				fields = append(fields, e.Extensions.Errors...)
+				assert.NotContains(t, e.Message, "database unavailable")
+			}
+			assert.Equal(t, tt.expectedCodes, codes)
+			assert.Equal(t, tt.expectedFields, fields)
+
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSubscribeTaskEvents(t *testing.T) {
+	mockDB := new(database.MockDatabase)
+	mockDB.On("GetLatestTaskEventID", "user-1").Return(int64(5), nil)
+	mockDB.On("GetTaskEvents", "user-1", int64(5)).Return(&[]model.TaskEvent{
+		{ID: 6, Type: model.TaskCreated, UserID: "user-1", TaskID: taskID1, Task: &model.Task{ID: taskID1, UserID: "user-1", Body: "Task 1"}},
+	}, nil).Once()
+	mockDB.On("GetTaskEvents", "user-1", int64(6)).Return(&[]model.TaskEvent{}, nil).Maybe()
+	resolver := &Resolver{Database: mockDB, events: newEventBroker()}
+
+	ctx, cancel := context.WithCancel(withUser(httptest.NewRequest("GET", "/graphql", nil), "user-1").Context())
+	defer cancel()
+	responses, err := resolver.graphql().Subscribe(ctx, `subscription { taskEvents { id type taskId task { body } } }`, "", nil)
+	assert.NoError(t, err)
+
+	select {
+	case response := <-responses:
+		result := response.(*graphql.Response)
+		assert.Empty(t, result.Errors)
+		assert.JSONEq(t, `{"taskEvents":{"id":"6","type":"created","taskId":"`+taskID1+`","task":{"body":"Task 1"}}}`, string(result.Data))
+	case <-time.After(time.Second):
+		t.Fatal("no event was sent")
+	}
+
+	cancel()
+	for range responses {
+	}
+	mockDB.AssertExpectations(t)
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/SevvyP/tasks_v1/internal/logging"
+	"github.com/SevvyP/tasks_v1/internal/middleware"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+	tasksv1 "github.com/SevvyP/tasks_v1/pkg/pb/tasks/v1"
+)
+
+const (
+	// grpcErrorDomain is the domain of the ErrorInfo detail attached to errors returned by the gRPC service.
+	grpcErrorDomain = "tasks.v1"
+	// grpcIdempotencyKey is the metadata key clients use to make a CreateTask call safe to retry,
+	// as they would use the Idempotency-Key header with the REST API.
+	grpcIdempotencyKey = "idempotency-key"
+)
+
+// grpcCodes maps the HTTP status of a rejected request to the gRPC code the service returns for it,
+// so that a call fails the same way over gRPC as it does over REST.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusConflict:              codes.AlreadyExists,
+	http.StatusRequestEntityTooLarge: codes.ResourceExhausted,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+	http.StatusUnprocessableEntity:   codes.InvalidArgument,
+	http.StatusInternalServerError:   codes.Internal,
+}
+
+// grpcServer returns a gRPC server for the tasks.v1 service, with every call rate limited by IP address,
+// authenticated by the interceptors, then rate limited by caller, and its caller provisioned.
+// Calls are traced and logged like HTTP requests, and messages are limited to the same size as REST request bodies.
+func (r *Resolver) grpcServer(unary grpc.UnaryServerInterceptor, stream grpc.StreamServerInterceptor) *grpc.Server {
+	logUnary, logStream := middleware.AccessLogGRPC(r.logger())
+	limitIPUnary, limitIPStream := middleware.RateLimitIPGRPC(r.limiter)
+	limitUnary, limitStream := middleware.RateLimitGRPC(r.limiter)
+	provisionUnary, provisionStream := middleware.ProvisionUserGRPC(r.users)
+	server := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(logUnary, middleware.ReadYourWritesGRPC(), limitIPUnary, unary, limitUnary, provisionUnary),
+		grpc.ChainStreamInterceptor(logStream, limitIPStream, stream, limitStream, provisionStream),
+		grpc.MaxRecvMsgSize(maxTaskRequestBytes),
+	)
+	tasksv1.RegisterTasksServiceServer(server, &taskService{resolver: r})
+	return server
+}
+
+// grpcError returns an error as a gRPC status. The problem code the REST API would respond with is the reason
+// of an ErrorInfo detail, and the fields that are not valid are listed in a BadRequest detail.
+func grpcError(ctx context.Context, err error) error {
+	mutationErr := asMutationError(ctx, err)
+	code, ok := grpcCodes[mutationErr.status]
+	if !ok {
+		code = codes.Unknown
+	}
+
+	st := status.New(code, mutationErr.message)
+	info := &errdetails.ErrorInfo{Reason: mutationErr.code, Domain: grpcErrorDomain}
+	if len(mutationErr.fields) == 0 {
+		st, err = st.WithDetails(info)
+	} else {
+		violations := make([]*errdetails.BadRequest_FieldViolation, len(mutationErr.fields))
+		for i, field := range mutationErr.fields {
+			violations[i] = &errdetails.BadRequest_FieldViolation{Field: field.Field, Description: field.Message}
+		}
+		st, err = st.WithDetails(info, &errdetails.BadRequest{FieldViolations: violations})
+	}
+	if err != nil {
+		logging.FromContext(ctx).ErrorContext(ctx, "Failed to attach gRPC error details", "error", err)
+		return status.Error(code, mutationErr.message)
+	}
+	return st.Err()
+}
+
+// taskService implements the tasks.v1 gRPC service. Each method mirrors a REST handler,
+// going through the same validation and returning the same errors.
+type taskService struct {
+	tasksv1.UnimplementedTasksServiceServer
+
+	resolver *Resolver
+}
+
+// ListTasks returns every task, or the tasks of a user if one is given.
+func (s *taskService) ListTasks(ctx context.Context, req *tasksv1.ListTasksRequest) (*tasksv1.ListTasksResponse, error) {
+	var tasks *[]model.Task
+	var err error
+	if req.GetUserId() != "" {
+		tasks, err = s.resolver.Database.GetTasksByUserID(ctx, req.GetUserId())
+	} else {
+		tasks, err = s.resolver.Database.GetTasks(ctx)
+	}
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	response := &tasksv1.ListTasksResponse{Tasks: make([]*tasksv1.Task, len(*tasks))}
+	for i, task := range *tasks {
+		response.Tasks[i] = taskToProto(task)
+	}
+	return response, nil
+}
+
+// GetTask returns a task by its ID.
+func (s *taskService) GetTask(ctx context.Context, req *tasksv1.GetTaskRequest) (*tasksv1.Task, error) {
+	if !isUUID(req.GetId()) {
+		return nil, grpcError(ctx, &mutationError{status: http.StatusBadRequest, code: model.ProblemValidationFailed, message: "The id query parameter is not valid",
+			fields: []model.FieldError{{Field: "id", Message: "must be a UUID"}}})
+	}
+	task, err := s.resolver.Database.GetTaskByID(ctx, req.GetId())
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	if task == nil {
+		return nil, grpcError(ctx, &mutationError{status: http.StatusNotFound, code: model.ProblemTaskNotFound, message: "Task not found"})
+	}
+	return taskToProto(*task), nil
+}
+
+// CreateTask creates a task. If idempotency-key metadata is sent, a retry with the same key and task
+// succeeds without creating the task again, and a retry with the same key but a different task, or one sent
+// while the original call is still running, is rejected.
+func (s *taskService) CreateTask(ctx context.Context, req *tasksv1.CreateTaskRequest) (*tasksv1.CreateTaskResponse, error) {
+	user := middleware.GetUserID(ctx)
+	var key, fingerprint string
+	if keys := metadata.ValueFromIncomingContext(ctx, grpcIdempotencyKey); len(keys) > 0 && keys[0] != "" {
+		key = keys[0]
+		body, err := proto.MarshalOptions{Deterministic: true}.Marshal(req.GetTask())
+		if err != nil {
+			return nil, grpcError(ctx, err)
+		}
+		fingerprint = fingerprintRequest(body)
+
+		record, err := s.resolver.reserveIdempotencyKey(ctx, user, key, fingerprint)
+		if err != nil {
+			return nil, grpcError(ctx, err)
+		}
+		if record != nil {
+			grpc.SetHeader(ctx, metadata.Pairs(IdempotentReplayedHeader, "true"))
+			return &tasksv1.CreateTaskResponse{}, nil
+		}
+	}
+
+	err := s.resolver.applyMutation(ctx, model.MutationCreate, taskFromProto(req.GetTask()))
+	if err != nil {
+		if key != "" {
+			s.resolver.releaseIdempotencyKey(ctx, user, key)
+		}
+		return nil, grpcError(ctx, err)
+	}
+	if key != "" {
+		s.resolver.saveIdempotentResponse(ctx, user, key, fingerprint, http.StatusCreated, "Task created successfully")
+	}
+	return &tasksv1.CreateTaskResponse{}, nil
+}
+
+// UpdateTask updates one of the caller's tasks.
+func (s *taskService) UpdateTask(ctx context.Context, req *tasksv1.UpdateTaskRequest) (*tasksv1.UpdateTaskResponse, error) {
+	err := s.resolver.applyMutation(ctx, model.MutationUpdate, taskFromProto(req.GetTask()))
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &tasksv1.UpdateTaskResponse{}, nil
+}
+
+// DeleteTask deletes one of the caller's tasks.
+func (s *taskService) DeleteTask(ctx context.Context, req *tasksv1.DeleteTaskRequest) (*tasksv1.DeleteTaskResponse, error) {
+	err := s.resolver.applyMutation(ctx, model.MutationDelete, model.Task{ID: req.GetId()})
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return &tasksv1.DeleteTaskResponse{}, nil
+}
+
+// WatchTasks streams created, updated and deleted events for the caller's tasks until the call ends.
+// If a last event ID is sent, the events after that ID are sent first, otherwise only events that happen
+// after the call starts are sent.
+func (s *taskService) WatchTasks(req *tasksv1.WatchTasksRequest, stream tasksv1.TasksService_WatchTasksServer) error {
+	ctx := stream.Context()
+	user := middleware.GetUserID(ctx)
+	if user == "" {
+		return grpcError(ctx, &mutationError{status: http.StatusUnauthorized, code: model.ProblemUnauthorized, message: "The token does not identify a user."})
+	}
+
+	// Subscribe before reading the log so that events committed in between are not missed.
+	notify, unsubscribe := s.resolver.events.subscribe(user)
+	defer unsubscribe()
+
+	lastID := req.GetLastEventId()
+	if req.LastEventId == nil {
+		var err error
+		lastID, err = s.resolver.Database.GetLatestTaskEventID(ctx, user)
+		if err != nil {
+			return grpcError(ctx, err)
+		}
+	}
+
+	for {
+		events, err := s.resolver.Database.GetTaskEvents(ctx, user, lastID)
+		if err != nil {
+			// End the stream and let the client call again with the last event it received.
+			return grpcError(ctx, err)
+		}
+		for _, event := range *events {
+			err = stream.Send(taskEventToProto(event))
+			if err != nil {
+				return err
+			}
+			lastID = event.ID
+		}
+		if len(*events) > 0 {
+			// Keep reading until the log is drained before waiting for new events.
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-notify:
+			if !ok {
+				return status.Error(codes.Unavailable, "The server is shutting down. Call again with the last event ID.")
+			}
+		}
+	}
+}
+
+// taskToProto returns the task as a tasks.v1 message.
+func taskToProto(task model.Task) *tasksv1.Task {
+	return &tasksv1.Task{
+		Id:        task.ID,
+		UserId:    task.UserID,
+		Body:      task.Body,
+		Completed: task.Completed,
+		Parent:    task.Parent,
+		Reminder:  task.Reminder,
+		Version:   task.Version,
+		UpdatedAt: timestampToProto(task.UpdatedAt),
+	}
+}
+
+// taskFromProto returns the task a tasks.v1 message describes. The fields set by the server are ignored.
+func taskFromProto(task *tasksv1.Task) model.Task {
+	return model.Task{
+		ID:        task.GetId(),
+		UserID:    task.GetUserId(),
+		Body:      task.GetBody(),
+		Completed: task.GetCompleted(),
+		Parent:    task.Parent,
+		Reminder:  task.Reminder,
+	}
+}
+
+// taskEventToProto returns the event as a tasks.v1 message.
+func taskEventToProto(event model.TaskEvent) *tasksv1.TaskEvent {
+	message := &tasksv1.TaskEvent{
+		Id:        event.ID,
+		Type:      event.Type,
+		UserId:    event.UserID,
+		TaskId:    event.TaskID,
+		CreatedAt: timestamppb.New(event.CreatedAt),
+	}
+	if event.Task != nil {
+		message.Task = taskToProto(*event.Task)
+	}
+	return message
+}
+
+// timestampToProto returns the time as a protobuf timestamp, or nil if there is no time.
+func timestampToProto(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
@@ -0,0 +1,374 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/internal/middleware"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+	tasksv1 "github.com/SevvyP/tasks_v1/pkg/pb/tasks/v1"
+)
+
+// grpcTestUser returns interceptors that authenticate every call as the given user.
+func grpcTestUser(user string) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	withUser := func(ctx context.Context) context.Context {
+		claims := &validator.ValidatedClaims{RegisteredClaims: validator.RegisteredClaims{Subject: user}}
+		return middleware.WithClaims(ctx, claims)
+	}
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(withUser(ctx), req)
+	}
+	stream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &userStream{ServerStream: ss, ctx: withUser(ss.Context())})
+	}
+	return unary, stream
+}
+
+type userStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *userStream) Context() context.Context {
+	return s.ctx
+}
+
+// newGRPCTestClient serves a gRPC server in memory and returns a client for it.
+func newGRPCTestClient(t *testing.T, server *grpc.Server) tasksv1.TasksServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return tasksv1.NewTasksServiceClient(conn)
+}
+
+// grpcProblem returns the problem code and invalid fields attached to a gRPC error.
+func grpcProblem(err error) (string, []model.FieldError) {
+	var code string
+	var fields []model.FieldError
+	for _, detail := range status.Convert(err).Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ErrorInfo:
+			code = detail.Reason
+		case *errdetails.BadRequest:
+			for _, violation := range detail.FieldViolations {
+				fields = append(fields, model.FieldError{Field: violation.Field, Message: violation.Description})
+			}
+		}
+	}
+	return code, fields
+}
+
+// TestGRPCMatchesREST makes the same request over REST and gRPC and checks that both fail, or succeed, the same way.
+func TestGRPCMatchesREST(t *testing.T) {
+	dbErr := errors.New("database unavailable")
+	task := model.Task{ID: taskID1, UserID: "user-1", Body: "Task 1"}
+	validTask := &tasksv1.Task{Id: taskID1, Body: "Task 1"}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		header         http.Header
+		body           string
+		call           func(ctx context.Context, client tasksv1.TasksServiceClient) error
+		setup          func(mockDB *database.MockDatabase)
+		expectedStatus int
+		expectedCode   string
+		expectedFields []model.FieldError
+	}{
+		{
+			name:   "ListTasks_OK",
+			method: "GET", path: "/tasks?user_id=user-1",
+			call: func(ctx context.Context, client tasksv1.TasksServiceClient) error {
+				_, err := client.ListTasks(ctx, &tasksv1.ListTasksRequest{UserId: "user-1"})
+				return err
+			},
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTasksByUserID", "user-1").Return(&[]model.Task{task}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "ListTasks_Error",
+			method: "GET", path: "/tasks",
+			call: func(ctx context.Context, client tasksv1.TasksServiceClient) error {
+				_, err := client.ListTasks(ctx, &tasksv1.ListTasksRequest{})
+				return err
+			},
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTasks").Return((*[]model.Task)(nil), dbErr)
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   model.ProblemInternal,
+		},
+		{
+			name:   "GetTask_InvalidID",
+			method: "GET", path: "/tasks?id=1",
+			call: func(ctx context.Context, client tasksv1.TasksServiceClient) error {
+				_, err := client.GetTask(ctx, &tasksv1.GetTaskRequest{Id: "1"})
+				return err
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   model.ProblemValidationFailed,
+			expectedFields: []model.FieldError{{Field: "id", Message: "must be a UUID"}},
+		},
+		{
+			name:   "GetTask_NotFound",
+			method: "GET", path: "/tasks?id=" + taskID2,
+			call: func(ctx context.Context, client tasksv1.TasksServiceClient) error {
+				_, err := client.GetTask(ctx, &tasksv1.GetTaskRequest{Id: taskID2})
+				return err
+			},
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTaskByID", taskID2).Return((*model.Task)(nil), nil)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   model.ProblemTaskNotFound,
+		},
+		{
+			name:   "CreateTask_Created",
+			method: "POST", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`,
+			call: func(ctx context.Context, client tasksv1.TasksServiceClient) error {
+				_, err := client.CreateTask(ctx, &tasksv1.CreateTaskRequest{Task: validTask})
+				return err
+			},
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTaskByIDFromPrimary", taskID1).Return((*model.Task)(nil), nil)
+				mockDB.On("CreateTask", model.Task{ID: taskID1, UserID: "user-1", Body: "Task 1"}).Return(nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:   "CreateTask_Exists",
+			method: "POST", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`,
+			call: func(ctx context.Context, client tasksv1.TasksServiceClient) error {
+				_, err := client.CreateTask(ctx, &tasksv1.CreateTaskRequest{Task: validTask})
+				return err
+			},
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTaskByIDFromPrimary", taskID1).Return(&model.Task{ID: taskID1, UserID: "user-2", Body: "Theirs"}, nil)
+			},
+			expectedStatus: http.StatusConflict,
+			expectedCode:   model.ProblemConflict,
+		},
+		{
+			name:   "CreateTask_Invalid",
+			method: "POST", path: "/tasks", body: `{"id":"1","body":" "}`,
+			call: func(ctx context.Context, client tasksv1.TasksServiceClient) error {
+				_, err := client.CreateTask(ctx, &tasksv1.CreateTaskRequest{Task: &tasksv1.Task{Id: "1", Body: " "}})
+				return err
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   model.ProblemValidationFailed,
+			expectedFields: []model.FieldError{{Field: "id", Message: "must be a UUID"}, {Field: "body", Message: "must not be empty"}},
+		},
+		{
+			name:   "CreateTask_KeyReused",
+			method: "POST", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`, header: http.Header{IdempotencyKeyHeader: {"k1"}},
+			call: func(ctx context.Context, client tasksv1.TasksServiceClient) error {
+				ctx = metadata.AppendToOutgoingContext(ctx, grpcIdempotencyKey, "k1")
+				_, err := client.CreateTask(ctx, &tasksv1.CreateTaskRequest{Task: validTask})
+				return err
+			},
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("ReserveIdempotencyKey", mock.Anything).Return(&model.IdempotencyRecord{Fingerprint: "other"}, nil)
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   model.ProblemConflict,
+		},
+		{
+			// The task is created before the response is stored, so failing to store it still succeeds.
+			name:   "CreateTask_IdempotencySaveError",
+			method: "POST", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`, header: http.Header{IdempotencyKeyHeader: {"k1"}},
+			call: func(ctx context.Context, client tasksv1.TasksServiceClient) error {
+				ctx = metadata.AppendToOutgoingContext(ctx, grpcIdempotencyKey, "k1")
+				_, err := client.CreateTask(ctx, &tasksv1.CreateTaskRequest{Task: validTask})
+				return err
+			},
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("ReserveIdempotencyKey", mock.Anything).Return((*model.IdempotencyRecord)(nil), nil)
+				mockDB.On("GetTaskByIDFromPrimary", taskID1).Return((*model.Task)(nil), nil)
+				mockDB.On("CreateTask", task).Return(nil)
+				mockDB.On("SaveIdempotencyRecord", mock.Anything).Return(dbErr)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:   "UpdateTask_Error",
+			method: "PUT", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`,
+			call: func(ctx context.Context, client tasksv1.TasksServiceClient) error {
+				_, err := client.UpdateTask(ctx, &tasksv1.UpdateTaskRequest{Task: validTask})
+				return err
+			},
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTaskByIDFromPrimary", taskID1).Return(&model.Task{ID: taskID1, UserID: "user-1", Body: "Task 1"}, nil)
+				mockDB.On("UpdateTask", mock.Anything).Return(dbErr)
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   model.ProblemInternal,
+		},
+		{
+			name:   "UpdateTask_OtherUser",
+			method: "PUT", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`,
+			call: func(ctx context.Context, client tasksv1.TasksServiceClient) error {
+				_, err := client.UpdateTask(ctx, &tasksv1.UpdateTaskRequest{Task: validTask})
+				return err
+			},
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTaskByIDFromPrimary", taskID1).Return(&model.Task{ID: taskID1, UserID: "user-2", Body: "Theirs"}, nil)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   model.ProblemTaskNotFound,
+		},
+		{
+			name:   "DeleteTask_OtherUser",
+			method: "DELETE", path: "/tasks", body: `{"id":"` + taskID1 + `"}`,
+			call: func(ctx context.Context, client tasksv1.TasksServiceClient) error {
+				_, err := client.DeleteTask(ctx, &tasksv1.DeleteTaskRequest{Id: taskID1})
+				return err
+			},
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTaskByIDFromPrimary", taskID1).Return(&model.Task{ID: taskID1, UserID: "user-2", Body: "Theirs"}, nil)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   model.ProblemTaskNotFound,
+		},
+		{
+			name:   "DeleteTask_Invalid",
+			method: "DELETE", path: "/tasks", body: `{"id":"1"}`,
+			call: func(ctx context.Context, client tasksv1.TasksServiceClient) error {
+				_, err := client.DeleteTask(ctx, &tasksv1.DeleteTaskRequest{Id: "1"})
+				return err
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   model.ProblemValidationFailed,
+			expectedFields: []model.FieldError{{Field: "id", Message: "must be a UUID"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// REST
+			mockDB := new(database.MockDatabase)
+			if tt.setup != nil {
+				tt.setup(mockDB)
+			}
+			resolver := &Resolver{Database: mockDB}
+			handler := resolver.handler(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					next.ServeHTTP(w, withUser(req, "user-1"))
+				})
+			})
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if rr.Code >= http.StatusBadRequest {
+				var problem model.Problem
+				assert.NoError(t, json.NewDecoder(rr.Body).Decode(&problem))
+				assert.Equal(t, tt.expectedCode, problem.Code)
+				assert.Equal(t, tt.expectedFields, problem.Errors)
+			}
+			mockDB.AssertExpectations(t)
+
+			// gRPC
+			mockDB = new(database.MockDatabase)
+			if tt.setup != nil {
+				tt.setup(mockDB)
+			}
+			resolver = &Resolver{Database: mockDB}
+			client := newGRPCTestClient(t, resolver.grpcServer(grpcTestUser("user-1")))
+			err := tt.call(context.Background(), client)
+
+			if tt.expectedStatus < http.StatusBadRequest {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, grpcCodes[tt.expectedStatus], status.Code(err))
+				code, fields := grpcProblem(err)
+				assert.Equal(t, tt.expectedCode, code)
+				assert.Equal(t, tt.expectedFields, fields)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestWatchTasks(t *testing.T) {
+	mockDB := new(database.MockDatabase)
+	mockDB.On("GetTaskEvents", "user-1", int64(5)).Return(&[]model.TaskEvent{
+		{ID: 6, Type: model.TaskCreated, UserID: "user-1", TaskID: taskID1, Task: &model.Task{ID: taskID1, UserID: "user-1", Body: "Task 1"}},
+		{ID: 7, Type: model.TaskDeleted, UserID: "user-1", TaskID: taskID2},
+	}, nil).Once()
+	mockDB.On("GetTaskEvents", "user-1", int64(7)).Return(&[]model.TaskEvent{}, nil).Maybe()
+	resolver := &Resolver{Database: mockDB, events: newEventBroker()}
+	client := newGRPCTestClient(t, resolver.grpcServer(grpcTestUser("user-1")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lastEventID := int64(5)
+	stream, err := client.WatchTasks(ctx, &tasksv1.WatchTasksRequest{LastEventId: &lastEventID})
+	assert.NoError(t, err)
+
+	event, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), event.GetId())
+	assert.Equal(t, model.TaskCreated, event.GetType())
+	assert.Equal(t, "Task 1", event.GetTask().GetBody())
+
+	event, err = stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), event.GetId())
+	assert.Equal(t, taskID2, event.GetTaskId())
+	assert.Nil(t, event.GetTask())
+
+	cancel()
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Canceled, status.Code(err))
+	mockDB.AssertExpectations(t)
+}
+
+func TestGRPCUnauthenticated(t *testing.T) {
+	mockDB := new(database.MockDatabase)
+	resolver := &Resolver{Database: mockDB, events: newEventBroker()}
+	client := newGRPCTestClient(t, resolver.grpcServer(middleware.EnsureValidTokenGRPC(&middleware.AuthConfig{Domain: "tasks.example.com", Audience: "tasks"}, nil)))
+
+	_, err := client.ListTasks(context.Background(), &tasksv1.ListTasksRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer not-a-jwt")
+	stream, err := client.WatchTasks(ctx, &tasksv1.WatchTasksRequest{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	mockDB.AssertExpectations(t)
+}
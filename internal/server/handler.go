@@ -5,12 +5,14 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/SevvyP/tasks_v1/internal/middleware"
 	"github.com/SevvyP/tasks_v1/pkg/model"
 )
 
 // GetTasks retrieves a list of tasks from the database and sends them as a JSON response.
 // If the "id" query parameter is provided, it retrieves a specific task by ID instead.
 // The retrieved tasks are encoded as JSON and sent in the response body.
+// If both query parameters are provided, or the "id" query parameter is not a UUID, an HTTP 400 Bad Request is returned.
 // If there is an error retrieving the tasks from the database, an HTTP 500 Internal Server Error is returned.
 // If a task with the specified ID is not found, an HTTP 404 Not Found is returned.
 func (r *Resolver) GetTasks(w http.ResponseWriter, req *http.Request) {
@@ -21,12 +23,17 @@ func (r *Resolver) GetTasks(w http.ResponseWriter, req *http.Request) {
 
 	// Check if both "id" and "user" query parameters are present
 	if id != "" && user != "" {
-		http.Error(w, "Cannot query by both id and user", http.StatusBadRequest)
+		middleware.WriteProblem(w, req, http.StatusBadRequest, model.ProblemValidationFailed, "Cannot query by both id and user")
 		return
 	}
 
 	// Check for "id" query parameter
 	if id != "" {
+		if !isUUID(id) {
+			middleware.WriteProblem(w, req, http.StatusBadRequest, model.ProblemValidationFailed, "The id query parameter is not valid",
+				model.FieldError{Field: "id", Message: "must be a UUID"})
+			return
+		}
 		r.GetTaskByID(w, req, id)
 		return
 	}
@@ -37,9 +44,9 @@ func (r *Resolver) GetTasks(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	tasks, err := r.Database.GetTasks()
+	tasks, err := r.Database.GetTasks(req.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		middleware.WriteInternalError(w, req, err)
 		return
 	}
 	json.NewEncoder(w).Encode(tasks)
@@ -50,13 +57,13 @@ func (r *Resolver) GetTasks(w http.ResponseWriter, req *http.Request) {
 // If the task is not found, an HTTP 404 Not Found is returned.
 // If there is an error retrieving the task from the database, an HTTP 500 Internal Server Error is returned.
 func (r *Resolver) GetTaskByID(w http.ResponseWriter, req *http.Request, id string) {
-	task, err := r.Database.GetTaskByID(id)
+	task, err := r.Database.GetTaskByID(req.Context(), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		middleware.WriteInternalError(w, req, err)
 		return
 	}
 	if task == nil {
-		http.Error(w, "Task not found", http.StatusNotFound)
+		middleware.WriteProblem(w, req, http.StatusNotFound, model.ProblemTaskNotFound, "Task not found")
 		return
 	}
 
@@ -68,48 +75,82 @@ func (r *Resolver) GetTaskByID(w http.ResponseWriter, req *http.Request, id stri
 // If the tasks are found, they are encoded as JSON and sent in the response body.
 // If there is an error retrieving the tasks from the database, an HTTP 500 Internal Server Error is returned.
 func (r *Resolver) GetTasksByUserID(w http.ResponseWriter, req *http.Request, user string) {
-	tasks, err := r.Database.GetTasksByUserID(user)
+	tasks, err := r.Database.GetTasksByUserID(req.Context(), user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		middleware.WriteInternalError(w, req, err)
 		return
 	}
 	json.NewEncoder(w).Encode(tasks)
 }
 
-// CreateTask creates a new task in the database based on the JSON request body.
+// CreateTask creates a new task for the caller in the database based on the JSON request body.
 // If the task is created successfully, an HTTP 201 Created response is returned.
+// If a task with the same ID already exists, an HTTP 409 Conflict is returned.
+// If the request body is not valid JSON or has fields a task does not have, an HTTP 400 Bad Request is returned.
+// If the request body is larger than 64 KiB, an HTTP 413 Payload Too Large is returned.
+// If the task is not valid, an HTTP 422 Unprocessable Entity is returned listing the fields that are not valid.
 // If there is an error creating the task, an HTTP 500 Internal Server Error is returned.
+// If an Idempotency-Key header is sent, a retry with the same key and body replays the original response,
+// and a retry with the same key but a different body returns an HTTP 422 Unprocessable Entity.
+// A retry sent while the original request is still running returns an HTTP 409 Conflict.
 func (r *Resolver) CreateTask(w http.ResponseWriter, req *http.Request) {
+	body, ok := readRequestBody(w, req, maxTaskRequestBytes)
+	if !ok {
+		return
+	}
+
+	user := middleware.GetUserID(req.Context())
+	key := req.Header.Get(IdempotencyKeyHeader)
+	fingerprint := fingerprintRequest(body)
+	if key != "" && r.replayIdempotentRequest(w, req, user, key, fingerprint) {
+		return
+	}
+
 	var task model.Task
-	err := json.NewDecoder(req.Body).Decode(&task)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeStrict(w, req, body, &task) {
+		if key != "" {
+			r.releaseIdempotencyKey(req.Context(), user, key)
+		}
 		return
 	}
 
-	err = r.Database.CreateTask(task)
+	err := r.applyMutation(req.Context(), model.MutationCreate, task)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if key != "" {
+			r.releaseIdempotencyKey(req.Context(), user, key)
+		}
+		writeMutationError(w, req, err)
 		return
 	}
 
+	response := "Task created successfully"
+	if key != "" {
+		r.saveIdempotentResponse(req.Context(), user, key, fingerprint, http.StatusCreated, response)
+	}
 	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, "Task created successfully")
+	fmt.Fprint(w, response)
 }
 
 // UpdateTask updates an existing task in the database based on the JSON request body.
 // If the task is updated successfully, an HTTP 200 OK response is returned.
+// If the request body is not valid JSON or has fields a task does not have, an HTTP 400 Bad Request is returned.
+// If the request body is larger than 64 KiB, an HTTP 413 Payload Too Large is returned.
+// If the task is not valid, an HTTP 422 Unprocessable Entity is returned listing the fields that are not valid.
+// If the task does not exist or belongs to another user, an HTTP 404 Not Found is returned.
 // If there is an error updating the task, an HTTP 500 Internal Server Error is returned.
 func (r *Resolver) UpdateTask(w http.ResponseWriter, req *http.Request) {
+	body, ok := readRequestBody(w, req, maxTaskRequestBytes)
+	if !ok {
+		return
+	}
 	var updatedTask model.Task
-	err := json.NewDecoder(req.Body).Decode(&updatedTask)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeStrict(w, req, body, &updatedTask) {
+		return
 	}
 
-	err = r.Database.UpdateTask(updatedTask)
+	err := r.applyMutation(req.Context(), model.MutationUpdate, updatedTask)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeMutationError(w, req, err)
 		return
 	}
 
@@ -119,18 +160,24 @@ func (r *Resolver) UpdateTask(w http.ResponseWriter, req *http.Request) {
 
 // DeleteTask deletes a task from the database based on the JSON request body.
 // If the task is deleted successfully, an HTTP 200 OK response is returned.
+// If the request body is not valid JSON or has fields a task does not have, an HTTP 400 Bad Request is returned.
+// If the request body is larger than 64 KiB, an HTTP 413 Payload Too Large is returned.
+// If the task ID is not a UUID, an HTTP 422 Unprocessable Entity is returned.
+// If the task does not exist or belongs to another user, an HTTP 404 Not Found is returned.
 // If there is an error deleting the task, an HTTP 500 Internal Server Error is returned.
 func (r *Resolver) DeleteTask(w http.ResponseWriter, req *http.Request) {
+	body, ok := readRequestBody(w, req, maxTaskRequestBytes)
+	if !ok {
+		return
+	}
 	var taskToDelete model.Task
-	err := json.NewDecoder(req.Body).Decode(&taskToDelete)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeStrict(w, req, body, &taskToDelete) {
 		return
 	}
 
-	err = r.Database.DeleteTask(taskToDelete)
+	err := r.applyMutation(req.Context(), model.MutationDelete, taskToDelete)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeMutationError(w, req, err)
 		return
 	}
 
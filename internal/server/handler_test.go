@@ -9,11 +9,19 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 
 	"github.com/SevvyP/tasks_v1/internal/database"
 	"github.com/SevvyP/tasks_v1/pkg/model"
 )
 
+// Task IDs used by the handler tests, which must be UUIDs to pass validation.
+const (
+	taskID1 = "5b3e6c1a-2f4d-4c6b-9a8e-1d2f3a4b5c61"
+	taskID2 = "5b3e6c1a-2f4d-4c6b-9a8e-1d2f3a4b5c62"
+	taskID3 = "5b3e6c1a-2f4d-4c6b-9a8e-1d2f3a4b5c63"
+)
+
 func TestGetTasksHandler(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -25,14 +33,14 @@ func TestGetTasksHandler(t *testing.T) {
 		{
 			name: "GetTasks_Success",
 			dbResponse: &[]model.Task{
-				{ID: "1", Body: "Task 1", Completed: false},
-				{ID: "2", Body: "Task 2", Completed: true},
+				{ID: taskID1, Body: "Task 1", Completed: false},
+				{ID: taskID2, Body: "Task 2", Completed: true},
 			},
 			dbError:        nil,
 			expectedStatus: http.StatusOK,
 			expectedBody: []model.Task{
-				{ID: "1", Body: "Task 1", Completed: false},
-				{ID: "2", Body: "Task 2", Completed: true},
+				{ID: taskID1, Body: "Task 1", Completed: false},
+				{ID: taskID2, Body: "Task 2", Completed: true},
 			},
 		},
 		{
@@ -80,15 +88,15 @@ func TestGetTaskByIdHandler(t *testing.T) {
 	}{
 		{
 			name:           "GetTasks_byID_Success",
-			id:             "1",
-			dbResponse:     &model.Task{ID: "1", Body: "Task 1", Completed: false},
+			id:             taskID1,
+			dbResponse:     &model.Task{ID: taskID1, Body: "Task 1", Completed: false},
 			dbError:        nil,
 			expectedStatus: http.StatusOK,
-			expectedBody:   model.Task{ID: "1", Body: "Task 1", Completed: false},
+			expectedBody:   model.Task{ID: taskID1, Body: "Task 1", Completed: false},
 		},
 		{
 			name:           "GetTasks_byID_NotFound",
-			id:             "1",
+			id:             taskID1,
 			dbResponse:     nil,
 			dbError:        nil,
 			expectedStatus: http.StatusNotFound,
@@ -96,7 +104,7 @@ func TestGetTaskByIdHandler(t *testing.T) {
 		},
 		{
 			name:           "GetTasks_byID_Error",
-			id:             "1",
+			id:             taskID1,
 			dbResponse:     nil,
 			dbError:        fmt.Errorf("database error"),
 			expectedStatus: http.StatusInternalServerError,
@@ -143,16 +151,16 @@ func TestGetTasksByUserIDHandler(t *testing.T) {
 	}{
 		{
 			name:   "GetTasks_byUserID_Success",
-			userID: "1",
+			userID: taskID1,
 			dbResponse: &[]model.Task{
-				{ID: "1", Body: "Task 1", Completed: false},
-				{ID: "2", Body: "Task 2", Completed: true},
+				{ID: taskID1, Body: "Task 1", Completed: false},
+				{ID: taskID2, Body: "Task 2", Completed: true},
 			},
 			dbError:        nil,
 			expectedStatus: http.StatusOK,
 			expectedBody: []model.Task{
-				{ID: "1", Body: "Task 1", Completed: false},
-				{ID: "2", Body: "Task 2", Completed: true},
+				{ID: taskID1, Body: "Task 1", Completed: false},
+				{ID: taskID2, Body: "Task 2", Completed: true},
 			},
 		},
 		{
@@ -209,27 +217,42 @@ func TestGetTasksByUserAndIDError(t *testing.T) {
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusBadRequest, rr.Code)
-	assert.Equal(t, "Cannot query by both id and user\n", rr.Body.String())
+	assert.Equal(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"Cannot query by both id and user","instance":"/tasks","code":"validation_failed"}`+"\n", rr.Body.String())
 }
 
 func TestCreateTaskHandler(t *testing.T) {
 	tests := []struct {
 		name           string
 		body           model.Task
+		current        *model.Task
 		dbResponse     error
 		expectedStatus int
 		expectedBody   interface{}
 	}{
 		{
 			name:           "CreateTask_Success",
-			body:           model.Task{ID: "1", Body: "Task 1", Completed: false},
+			body:           model.Task{ID: taskID1, Body: "Task 1", Completed: false},
+			dbResponse:     nil,
+			expectedStatus: http.StatusCreated,
+			expectedBody:   "Task created successfully",
+		},
+		{
+			name:           "CreateTask_ForOtherUser",
+			body:           model.Task{ID: taskID1, UserID: "user-2", Body: "Task 1", Completed: false},
 			dbResponse:     nil,
 			expectedStatus: http.StatusCreated,
 			expectedBody:   "Task created successfully",
 		},
+		{
+			name:           "CreateTask_Exists",
+			body:           model.Task{ID: taskID1, Body: "Task 1", Completed: false},
+			current:        &model.Task{ID: taskID1, UserID: "user-2", Body: "Theirs"},
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"type":"about:blank","title":"Conflict","status":409,"detail":"A task with this ID already exists","instance":"/tasks","code":"conflict"}` + "\n",
+		},
 		{
 			name:           "CreateTask_Error",
-			body:           model.Task{ID: "1", Body: "Task 1", Completed: false},
+			body:           model.Task{ID: taskID1, Body: "Task 1", Completed: false},
 			dbResponse:     fmt.Errorf("database error"),
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody:   nil,
@@ -239,7 +262,12 @@ func TestCreateTaskHandler(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockDB := new(database.MockDatabase)
-			mockDB.On("CreateTask", tt.body).Return(tt.dbResponse)
+			mockDB.On("GetTaskByIDFromPrimary", taskID1).Return(tt.current, nil)
+			if tt.current == nil {
+				created := tt.body
+				created.UserID = "user-1"
+				mockDB.On("CreateTask", created).Return(tt.dbResponse)
+			}
 			resolver := &Resolver{Database: mockDB}
 
 			bodyBytes, _ := json.Marshal(tt.body)
@@ -248,7 +276,7 @@ func TestCreateTaskHandler(t *testing.T) {
 
 			rr := httptest.NewRecorder()
 			handler := http.HandlerFunc(resolver.CreateTask)
-			handler.ServeHTTP(rr, req)
+			handler.ServeHTTP(rr, withUser(req, "user-1"))
 
 			assert.Equal(t, tt.expectedStatus, rr.Code)
 			if tt.expectedBody != nil {
@@ -260,24 +288,119 @@ func TestCreateTaskHandler(t *testing.T) {
 	}
 }
 
+func TestCreateTaskIdempotencyHandler(t *testing.T) {
+	task := model.Task{ID: taskID1, Body: "Task 1", Completed: false}
+	bodyBytes, _ := json.Marshal(task)
+	fingerprint := fingerprintRequest(bodyBytes)
+
+	tests := []struct {
+		name           string
+		record         *model.IdempotencyRecord
+		expectCreate   bool
+		expectedStatus int
+		expectedBody   string
+		expectedReplay string
+	}{
+		{
+			name:           "CreateTask_Idempotency_NewKey",
+			record:         nil,
+			expectCreate:   true,
+			expectedStatus: http.StatusCreated,
+			expectedBody:   "Task created successfully",
+			expectedReplay: "",
+		},
+		{
+			name:           "CreateTask_Idempotency_Replay",
+			record:         &model.IdempotencyRecord{Key: "key-1", Fingerprint: fingerprint, StatusCode: http.StatusCreated, Body: []byte("Task created successfully")},
+			expectCreate:   false,
+			expectedStatus: http.StatusCreated,
+			expectedBody:   "Task created successfully",
+			expectedReplay: "true",
+		},
+		{
+			name:           "CreateTask_Idempotency_InProgress",
+			record:         &model.IdempotencyRecord{Key: "key-1", Fingerprint: fingerprint},
+			expectCreate:   false,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"type":"about:blank","title":"Conflict","status":409,"detail":"A request with this Idempotency-Key is still in progress","instance":"/tasks","code":"conflict"}` + "\n",
+			expectedReplay: "",
+		},
+		{
+			name:           "CreateTask_Idempotency_Mismatch",
+			record:         &model.IdempotencyRecord{Key: "key-1", Fingerprint: "other", StatusCode: http.StatusCreated, Body: []byte("Task created successfully")},
+			expectCreate:   false,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedBody:   `{"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"Idempotency-Key was already used with a different request","instance":"/tasks","code":"conflict"}` + "\n",
+			expectedReplay: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			mockDB.On("ReserveIdempotencyKey", mock.MatchedBy(func(record model.IdempotencyRecord) bool {
+				return record.Key == "key-1" && record.Fingerprint == fingerprint && record.StatusCode == 0
+			})).Return(tt.record, nil)
+			if tt.expectCreate {
+				mockDB.On("GetTaskByIDFromPrimary", taskID1).Return((*model.Task)(nil), nil)
+				mockDB.On("CreateTask", model.Task{ID: taskID1, UserID: "user-1", Body: "Task 1"}).Return(nil)
+				mockDB.On("SaveIdempotencyRecord", mock.MatchedBy(func(record model.IdempotencyRecord) bool {
+					return record.Key == "key-1" && record.Fingerprint == fingerprint && record.StatusCode == http.StatusCreated
+				})).Return(nil)
+			}
+			resolver := &Resolver{Database: mockDB}
+
+			req, err := http.NewRequest("POST", "/tasks", bytes.NewBuffer(bodyBytes))
+			assert.NoError(t, err)
+			req.Header.Set(IdempotencyKeyHeader, "key-1")
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(resolver.CreateTask)
+			handler.ServeHTTP(rr, withUser(req, "user-1"))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedBody, rr.Body.String())
+			assert.Equal(t, tt.expectedReplay, rr.Header().Get(IdempotentReplayedHeader))
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
 func TestUpdateTaskHandler(t *testing.T) {
 	tests := []struct {
 		name           string
 		body           model.Task
+		current        *model.Task
 		dbResponse     error
 		expectedStatus int
 		expectedBody   interface{}
 	}{
 		{
 			name:           "UpdateTask_Success",
-			body:           model.Task{ID: "1", Body: "Task 1", Completed: false},
+			body:           model.Task{ID: taskID1, Body: "Task 1", Completed: false},
+			current:        &model.Task{ID: taskID1, UserID: "user-1", Body: "Task 1"},
 			dbResponse:     nil,
 			expectedStatus: http.StatusOK,
 			expectedBody:   "Task updated successfully",
 		},
+		{
+			name:           "UpdateTask_NotFound",
+			body:           model.Task{ID: taskID1, Body: "Task 1", Completed: false},
+			current:        nil,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"type":"about:blank","title":"Not Found","status":404,"detail":"Task not found","instance":"/tasks","code":"task_not_found"}` + "\n",
+		},
+		{
+			name:           "UpdateTask_OtherUsersTask",
+			body:           model.Task{ID: taskID1, Body: "Task 1", Completed: false},
+			current:        &model.Task{ID: taskID1, UserID: "user-2", Body: "Theirs"},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"type":"about:blank","title":"Not Found","status":404,"detail":"Task not found","instance":"/tasks","code":"task_not_found"}` + "\n",
+		},
 		{
 			name:           "UpdateTask_Error",
-			body:           model.Task{ID: "1", Body: "Task 1", Completed: false},
+			body:           model.Task{ID: taskID1, Body: "Task 1", Completed: false},
+			current:        &model.Task{ID: taskID1, UserID: "user-1", Body: "Task 1"},
 			dbResponse:     fmt.Errorf("database error"),
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody:   nil,
@@ -287,7 +410,12 @@ func TestUpdateTaskHandler(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockDB := new(database.MockDatabase)
-			mockDB.On("UpdateTask", tt.body).Return(tt.dbResponse)
+			mockDB.On("GetTaskByIDFromPrimary", taskID1).Return(tt.current, nil)
+			if tt.current != nil && tt.current.UserID == "user-1" {
+				updated := tt.body
+				updated.UserID = "user-1"
+				mockDB.On("UpdateTask", updated).Return(tt.dbResponse)
+			}
 			resolver := &Resolver{Database: mockDB}
 
 			bodyBytes, _ := json.Marshal(tt.body)
@@ -296,7 +424,7 @@ func TestUpdateTaskHandler(t *testing.T) {
 
 			rr := httptest.NewRecorder()
 			handler := http.HandlerFunc(resolver.UpdateTask)
-			handler.ServeHTTP(rr, req)
+			handler.ServeHTTP(rr, withUser(req, "user-1"))
 
 			assert.Equal(t, tt.expectedStatus, rr.Code)
 			if tt.expectedBody != nil {
@@ -312,20 +440,37 @@ func TestDeleteTaskHandler(t *testing.T) {
 	tests := []struct {
 		name           string
 		body           model.Task
+		current        *model.Task
 		dbResponse     error
 		expectedStatus int
 		expectedBody   interface{}
 	}{
 		{
 			name:           "DeleteTask_Success",
-			body:           model.Task{ID: "1", Body: "Task 1", Completed: false},
+			body:           model.Task{ID: taskID1, Body: "Task 1", Completed: false},
+			current:        &model.Task{ID: taskID1, UserID: "user-1", Body: "Task 1"},
 			dbResponse:     nil,
 			expectedStatus: http.StatusOK,
 			expectedBody:   "Task deleted successfully",
 		},
+		{
+			name:           "DeleteTask_NotFound",
+			body:           model.Task{ID: taskID1, Body: "Task 1", Completed: false},
+			current:        nil,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"type":"about:blank","title":"Not Found","status":404,"detail":"Task not found","instance":"/tasks","code":"task_not_found"}` + "\n",
+		},
+		{
+			name:           "DeleteTask_OtherUsersTask",
+			body:           model.Task{ID: taskID1, Body: "Task 1", Completed: false},
+			current:        &model.Task{ID: taskID1, UserID: "user-2", Body: "Theirs"},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"type":"about:blank","title":"Not Found","status":404,"detail":"Task not found","instance":"/tasks","code":"task_not_found"}` + "\n",
+		},
 		{
 			name:           "DeleteTask_Error",
-			body:           model.Task{ID: "1", Body: "Task 1", Completed: false},
+			body:           model.Task{ID: taskID1, Body: "Task 1", Completed: false},
+			current:        &model.Task{ID: taskID1, UserID: "user-1", Body: "Task 1"},
 			dbResponse:     fmt.Errorf("database error"),
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody:   nil,
@@ -335,7 +480,12 @@ func TestDeleteTaskHandler(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockDB := new(database.MockDatabase)
-			mockDB.On("DeleteTask", tt.body).Return(tt.dbResponse)
+			mockDB.On("GetTaskByIDFromPrimary", taskID1).Return(tt.current, nil)
+			if tt.current != nil && tt.current.UserID == "user-1" {
+				deleted := tt.body
+				deleted.UserID = "user-1"
+				mockDB.On("DeleteTask", deleted).Return(tt.dbResponse)
+			}
 			resolver := &Resolver{Database: mockDB}
 
 			bodyBytes, _ := json.Marshal(tt.body)
@@ -344,7 +494,7 @@ func TestDeleteTaskHandler(t *testing.T) {
 
 			rr := httptest.NewRecorder()
 			handler := http.HandlerFunc(resolver.DeleteTask)
-			handler.ServeHTTP(rr, req)
+			handler.ServeHTTP(rr, withUser(req, "user-1"))
 
 			assert.Equal(t, tt.expectedStatus, rr.Code)
 			if tt.expectedBody != nil {
@@ -355,3 +505,27 @@ func TestDeleteTaskHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestInternalErrorProblem(t *testing.T) {
+	mockDB := new(database.MockDatabase)
+	mockDB.On("GetTasks").Return((*[]model.Task)(nil), fmt.Errorf("pq: relation \"tasks\" does not exist"))
+	resolver := &Resolver{Database: mockDB}
+	handler := resolver.handler(func(next http.Handler) http.Handler { return next })
+
+	req, err := http.NewRequest("GET", "/tasks", nil)
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Equal(t, model.ProblemContentType, rr.Header().Get("Content-Type"))
+	assert.NotContains(t, rr.Body.String(), "pq:")
+
+	var problem model.Problem
+	err = json.NewDecoder(rr.Body).Decode(&problem)
+	assert.NoError(t, err)
+	assert.Equal(t, model.ProblemInternal, problem.Code)
+	assert.NotEmpty(t, problem.RequestID)
+	assert.Equal(t, rr.Header().Get("X-Request-ID"), problem.RequestID)
+	mockDB.AssertExpectations(t)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/internal/logging"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// readinessTimeout bounds how long the readiness checks may take together, so that a hung dependency
+// fails the probe rather than stalling it.
+const readinessTimeout = 2 * time.Second
+
+// GetHealth reports that the process is alive and serving requests. It checks no dependencies,
+// so that an outage of the database or the issuer does not get every replica restarted.
+func (r *Resolver) GetHealth(w http.ResponseWriter, req *http.Request) {
+	writeHealth(w, http.StatusOK, model.Health{Status: model.HealthOK})
+}
+
+// GetReadiness reports whether the server can handle requests: the database can be reached and its migrations
+// are current, and the signing keys of the JWT issuer can be fetched.
+// If a check fails, or the server is shutting down, an HTTP 503 Service Unavailable is returned.
+func (r *Resolver) GetReadiness(w http.ResponseWriter, req *http.Request) {
+	if r.shuttingDown.Load() {
+		writeHealth(w, http.StatusServiceUnavailable, model.Health{Status: model.HealthUnavailable})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]func(ctx context.Context) error{
+		"postgres":   r.Database.Ping,
+		"migrations": r.checkMigrations,
+	}
+	if r.checkJWKS != nil {
+		checks["jwks"] = r.checkJWKS
+	}
+
+	health := model.Health{Status: model.HealthOK, Checks: map[string]string{}}
+	for name, check := range checks {
+		err := check(ctx)
+		if err != nil {
+			logging.FromContext(ctx).WarnContext(ctx, "Readiness check failed", "check", name, "error", err)
+			health.Status = model.HealthUnavailable
+			health.Checks[name] = model.HealthUnavailable
+			continue
+		}
+		health.Checks[name] = model.HealthOK
+	}
+
+	status := http.StatusOK
+	if health.Status != model.HealthOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, health)
+}
+
+// checkMigrations returns an error if the database schema is older than the one this code expects.
+// A newer schema is accepted, so that replicas still running the previous release stay ready during a rollout.
+func (r *Resolver) checkMigrations(ctx context.Context) error {
+	version, err := r.Database.GetSchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version < database.SchemaVersion {
+		return fmt.Errorf("schema is at version %d, want %d", version, database.SchemaVersion)
+	}
+	return nil
+}
+
+// writeHealth sends a health response. Probes must never be cached.
+func writeHealth(w http.ResponseWriter, status int, health model.Health) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(health)
+}
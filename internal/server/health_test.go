@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+func TestGetReadiness(t *testing.T) {
+	reachable := func(ctx context.Context) error { return nil }
+	unreachable := func(ctx context.Context) error { return errors.New("connection refused") }
+
+	tests := []struct {
+		name           string
+		setup          func(mockDB *database.MockDatabase)
+		checkJWKS      func(ctx context.Context) error
+		shuttingDown   bool
+		expectedStatus int
+		expectedHealth model.Health
+	}{
+		{
+			name: "Ready",
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("Ping").Return(nil)
+				mockDB.On("GetSchemaVersion").Return(database.SchemaVersion, nil)
+			},
+			checkJWKS:      reachable,
+			expectedStatus: http.StatusOK,
+			expectedHealth: model.Health{Status: model.HealthOK, Checks: map[string]string{"postgres": "ok", "migrations": "ok", "jwks": "ok"}},
+		},
+		{
+			name: "NewerSchema",
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("Ping").Return(nil)
+				mockDB.On("GetSchemaVersion").Return(database.SchemaVersion+1, nil)
+			},
+			checkJWKS:      reachable,
+			expectedStatus: http.StatusOK,
+			expectedHealth: model.Health{Status: model.HealthOK, Checks: map[string]string{"postgres": "ok", "migrations": "ok", "jwks": "ok"}},
+		},
+		{
+			name: "DatabaseDown",
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("Ping").Return(errors.New("connection refused"))
+				mockDB.On("GetSchemaVersion").Return(0, errors.New("connection refused"))
+			},
+			checkJWKS:      reachable,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedHealth: model.Health{Status: model.HealthUnavailable, Checks: map[string]string{"postgres": "unavailable", "migrations": "unavailable", "jwks": "ok"}},
+		},
+		{
+			name: "MigrationsBehind",
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("Ping").Return(nil)
+				mockDB.On("GetSchemaVersion").Return(database.SchemaVersion-1, nil)
+			},
+			checkJWKS:      reachable,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedHealth: model.Health{Status: model.HealthUnavailable, Checks: map[string]string{"postgres": "ok", "migrations": "unavailable", "jwks": "ok"}},
+		},
+		{
+			name: "JWKSUnreachable",
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("Ping").Return(nil)
+				mockDB.On("GetSchemaVersion").Return(database.SchemaVersion, nil)
+			},
+			checkJWKS:      unreachable,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedHealth: model.Health{Status: model.HealthUnavailable, Checks: map[string]string{"postgres": "ok", "migrations": "ok", "jwks": "unavailable"}},
+		},
+		{
+			name:           "ShuttingDown",
+			checkJWKS:      reachable,
+			shuttingDown:   true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedHealth: model.Health{Status: model.HealthUnavailable},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			if tt.setup != nil {
+				tt.setup(mockDB)
+			}
+			resolver := &Resolver{Database: mockDB, checkJWKS: tt.checkJWKS}
+			resolver.shuttingDown.Store(tt.shuttingDown)
+
+			req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			rr := httptest.NewRecorder()
+			resolver.GetReadiness(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			var health model.Health
+			assert.NoError(t, json.NewDecoder(rr.Body).Decode(&health))
+			assert.Equal(t, tt.expectedHealth, health)
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/SevvyP/tasks_v1/internal/logging"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header clients use to make a request safe to retry.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses that were replayed from a stored record.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// idempotencyKeyTTL is how long a key is remembered after the original request.
+	idempotencyKeyTTL = 24 * time.Hour
+	// idempotencyReservationTTL is how long a key stays reserved for a request that never finishes,
+	// such as one whose server stopped, before a retry may run it again.
+	idempotencyReservationTTL = time.Minute
+)
+
+// fingerprintRequest returns a hash of the request body, used to detect a key being reused for a different request.
+func fingerprintRequest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// reserveIdempotencyKey reserves the key for the user's request, so that a concurrent retry does not run it
+// a second time. It returns nil if the request should run, or the record of an earlier request to replay.
+// If the key was used for a different request, or its request is still running, a mutationError is returned.
+func (r *Resolver) reserveIdempotencyKey(ctx context.Context, user string, key string, fingerprint string) (*model.IdempotencyRecord, error) {
+	record, err := r.Database.ReserveIdempotencyKey(ctx, model.IdempotencyRecord{
+		UserID:      user,
+		Key:         key,
+		Fingerprint: fingerprint,
+		ExpiresAt:   time.Now().Add(idempotencyReservationTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, nil
+	}
+	if record.Fingerprint != fingerprint {
+		return nil, &mutationError{status: http.StatusUnprocessableEntity, code: model.ProblemConflict, message: "Idempotency-Key was already used with a different request"}
+	}
+	if record.StatusCode == 0 {
+		return nil, &mutationError{status: http.StatusConflict, code: model.ProblemConflict, message: "A request with this Idempotency-Key is still in progress"}
+	}
+	return record, nil
+}
+
+// replayIdempotentRequest reserves the key for the user's request and, if an earlier request used it, writes a response for it.
+// If the record was created for a different request body, an HTTP 422 Unprocessable Entity is returned,
+// and if the earlier request is still running, an HTTP 409 Conflict.
+// It returns true if a response was written and the handler should stop.
+func (r *Resolver) replayIdempotentRequest(w http.ResponseWriter, req *http.Request, user string, key string, fingerprint string) bool {
+	record, err := r.reserveIdempotencyKey(req.Context(), user, key, fingerprint)
+	if err != nil {
+		writeMutationError(w, req, err)
+		return true
+	}
+	if record == nil {
+		return false
+	}
+
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+	return true
+}
+
+// saveIdempotentResponse stores the response for the reserved key so that retries can be replayed.
+// The task has already been created by then, so failing to store the response must not fail the request.
+// The error is logged, and the key stays reserved until idempotencyReservationTTL has passed, so retries are
+// rejected rather than run again.
+func (r *Resolver) saveIdempotentResponse(ctx context.Context, user string, key string, fingerprint string, status int, body string) {
+	err := r.Database.SaveIdempotencyRecord(ctx, model.IdempotencyRecord{
+		UserID:      user,
+		Key:         key,
+		Fingerprint: fingerprint,
+		StatusCode:  status,
+		Body:        []byte(body),
+		ExpiresAt:   time.Now().Add(idempotencyKeyTTL),
+	})
+	if err != nil {
+		logging.FromContext(ctx).ErrorContext(ctx, "Failed to save idempotent response", "error", err)
+	}
+}
+
+// releaseIdempotencyKey releases the key reserved for a request that failed, so that it can be retried.
+// Failing to release it only delays retries until the reservation expires, so the error is logged.
+func (r *Resolver) releaseIdempotencyKey(ctx context.Context, user string, key string) {
+	err := r.Database.ReleaseIdempotencyKey(ctx, user, key)
+	if err != nil {
+		logging.FromContext(ctx).ErrorContext(ctx, "Failed to release idempotency key", "error", err)
+	}
+}
@@ -0,0 +1,8 @@
+package server
+
+import "net/http"
+
+// GetMetrics sends the Prometheus metrics of the server.
+func (r *Resolver) GetMetrics(w http.ResponseWriter, req *http.Request) {
+	r.metrics.Handler().ServeHTTP(w, req)
+}
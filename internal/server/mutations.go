@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/SevvyP/tasks_v1/internal/logging"
+	"github.com/SevvyP/tasks_v1/internal/middleware"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// mutationError is returned by applyMutation when a mutation is rejected, and by the GraphQL and gRPC services for any error.
+// It carries the HTTP status and problem code the REST handlers respond with, and the fields that are not valid.
+// Any other error returned by applyMutation is an internal error.
+type mutationError struct {
+	status  int
+	code    string
+	message string
+	fields  []model.FieldError
+}
+
+func (e *mutationError) Error() string {
+	if len(e.fields) == 0 {
+		return e.message
+	}
+	details := make([]string, len(e.fields))
+	for i, field := range e.fields {
+		details[i] = fmt.Sprintf("%s %s", field.Field, field.Message)
+	}
+	return e.message + ": " + strings.Join(details, "; ")
+}
+
+// asMutationError returns an error as it should be shown to clients of the GraphQL and gRPC services.
+// Rejected mutations are returned as they are, and any other error is logged and replaced by an internal error
+// so that database messages are not revealed.
+func asMutationError(ctx context.Context, err error) *mutationError {
+	var mutationErr *mutationError
+	if errors.As(err, &mutationErr) {
+		return mutationErr
+	}
+	logging.FromContext(ctx).ErrorContext(ctx, "Request failed", "error", err)
+	return &mutationError{status: http.StatusInternalServerError, code: model.ProblemInternal, message: "An internal error occurred"}
+}
+
+// Extensions returns the problem code and the fields that are not valid, which GraphQL responses include with the error.
+func (e *mutationError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{"code": e.code}
+	if len(e.fields) > 0 {
+		extensions["errors"] = e.fields
+	}
+	return extensions
+}
+
+// applyMutation validates a task and then creates, updates or deletes it for the caller authenticated in ctx.
+// It is shared by the REST, sync, GraphQL and gRPC handlers so that all of them go through the same checks:
+// tasks are created for the caller, a task cannot be created with the ID of an existing task, and only the user
+// a task belongs to can update or delete it. The tasks of other users are reported as not found.
+// A caller authenticated with an API key needs its write scope, whichever request the mutation was sent in.
+func (r *Resolver) applyMutation(ctx context.Context, mutation string, task model.Task) error {
+	task.Version = 0
+	return r.applyMutationAtVersion(ctx, mutation, task)
+}
+
+// applyMutationAtVersion is applyMutation for a task that was read at task.Version. An update or delete then
+// returns database.ErrTaskChanged, without changing anything, if the task has changed since.
+func (r *Resolver) applyMutationAtVersion(ctx context.Context, mutation string, task model.Task) error {
+	switch mutation {
+	case model.MutationCreate, model.MutationUpdate, model.MutationDelete:
+	default:
+		return &mutationError{status: http.StatusBadRequest, code: model.ProblemValidationFailed, message: "Unknown mutation type"}
+	}
+
+	user := middleware.GetUserID(ctx)
+	if user == "" {
+		return &mutationError{status: http.StatusUnauthorized, code: model.ProblemUnauthorized, message: "The token does not identify a user."}
+	}
+	if !middleware.APIKeyAllows(ctx, model.ScopeWrite) {
+		return &mutationError{status: http.StatusForbidden, code: model.ProblemForbidden, message: fmt.Sprintf("The API key does not have the %s scope.", model.ScopeWrite)}
+	}
+	task.UserID = user
+
+	fields, err := r.validateTask(ctx, mutation, task)
+	if err != nil {
+		return err
+	}
+	if len(fields) > 0 {
+		return &mutationError{status: http.StatusUnprocessableEntity, code: model.ProblemValidationFailed, message: "The task is not valid", fields: fields}
+	}
+
+	// The checks are made against the primary, since a replica may not have the task's latest write yet.
+	current, err := r.Database.GetTaskByIDFromPrimary(ctx, task.ID)
+	if err != nil {
+		return err
+	}
+	if mutation == model.MutationCreate && current != nil {
+		return &mutationError{status: http.StatusConflict, code: model.ProblemConflict, message: "A task with this ID already exists"}
+	}
+	if mutation != model.MutationCreate && (current == nil || current.UserID != user) {
+		return &mutationError{status: http.StatusNotFound, code: model.ProblemTaskNotFound, message: "Task not found"}
+	}
+
+	switch mutation {
+	case model.MutationCreate:
+		return r.Database.CreateTask(ctx, task)
+	case model.MutationUpdate:
+		return r.Database.UpdateTask(ctx, task)
+	default:
+		return r.Database.DeleteTask(ctx, task)
+	}
+}
+
+// writeMutationError writes an error returned by applyMutation as a problem response.
+func writeMutationError(w http.ResponseWriter, req *http.Request, err error) {
+	var mutationErr *mutationError
+	if errors.As(err, &mutationErr) {
+		middleware.WriteProblem(w, req, mutationErr.status, mutationErr.code, mutationErr.message, mutationErr.fields...)
+		return
+	}
+	middleware.WriteInternalError(w, req, err)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+)
+
+// openAPISpec is the OpenAPI 3.1 description of every route the Resolver serves.
+// openapi_test.go fails when it and the handlers disagree.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// docsScript starts Swagger UI on the docs page.
+const docsScript = `
+    window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+  `
+
+// docsPage renders the OpenAPI spec served at /openapi.json with Swagger UI.
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Tasks API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>` + docsScript + `</script>
+</body>
+</html>
+`
+
+// docsContentSecurityPolicy replaces middleware.ContentSecurityPolicy on the docs page, which loads Swagger UI
+// from unpkg, runs docsScript and fetches the spec from this server.
+var docsContentSecurityPolicy = fmt.Sprintf("default-src 'none'; script-src https://unpkg.com 'sha256-%s'; "+
+	"style-src https://unpkg.com 'unsafe-inline'; img-src 'self' data:; connect-src 'self'; frame-ancestors 'none'",
+	sha256Base64(docsScript))
+
+// sha256Base64 returns the base64-encoded SHA-256 hash of s, as used in Content-Security-Policy sources.
+func sha256Base64(s string) string {
+	hash := sha256.Sum256([]byte(s))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// GetOpenAPISpec sends the OpenAPI description of the API as a JSON response.
+func (r *Resolver) GetOpenAPISpec(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// GetDocs sends an HTML page that renders the OpenAPI description with Swagger UI.
+func (r *Resolver) GetDocs(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", docsContentSecurityPolicy)
+	fmt.Fprint(w, docsPage)
+}
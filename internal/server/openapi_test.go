@@ -0,0 +1,629 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/internal/metrics"
+	"github.com/SevvyP/tasks_v1/internal/middleware"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// openAPIDocument is the part of the OpenAPI spec that is checked against the handlers.
+type openAPIDocument struct {
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Responses map[string]openAPIResponse `json:"responses"`
+		Schemas   map[string]*openAPISchema  `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Responses map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema *openAPISchema `json:"schema"`
+	} `json:"content"`
+}
+
+type openAPISchema struct {
+	Ref        string                    `json:"$ref"`
+	Type       interface{}               `json:"type"`
+	Properties map[string]*openAPISchema `json:"properties"`
+	Required   []string                  `json:"required"`
+	Items      *openAPISchema            `json:"items"`
+	OneOf      []*openAPISchema          `json:"oneOf"`
+}
+
+func loadOpenAPIDocument(t *testing.T) *openAPIDocument {
+	var doc openAPIDocument
+	err := json.Unmarshal(openAPISpec, &doc)
+	assert.NoError(t, err)
+	return &doc
+}
+
+// response returns the documented response for a status code, following a reference to a shared response.
+func (d *openAPIDocument) response(path string, method string, status int) (openAPIResponse, bool) {
+	response, ok := d.Paths[path][strings.ToLower(method)].Responses[strconv.Itoa(status)]
+	if ok && response.Ref != "" {
+		response, ok = d.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+	}
+	return response, ok
+}
+
+// validate returns a problem for each field of value, decoded from JSON, that is missing from or not described by the schema.
+func (d *openAPIDocument) validate(schema *openAPISchema, value interface{}, at string) []string {
+	if schema.Ref != "" {
+		schema = d.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	if len(schema.OneOf) > 0 {
+		for _, option := range schema.OneOf {
+			if len(d.validate(option, value, at)) == 0 {
+				return nil
+			}
+		}
+		return []string{fmt.Sprintf("%s matches none of the documented schemas", at)}
+	}
+
+	var problems []string
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if schema.Properties == nil {
+			// An object schema without properties allows any fields.
+			if schema.Type != "object" {
+				return []string{fmt.Sprintf("%s is not documented as an object", at)}
+			}
+			return nil
+		}
+		for _, field := range schema.Required {
+			if _, ok := value[field]; !ok {
+				problems = append(problems, fmt.Sprintf("%s is missing required field %q", at, field))
+			}
+		}
+		for field, fieldValue := range value {
+			property, ok := schema.Properties[field]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s has undocumented field %q", at, field))
+				continue
+			}
+			problems = append(problems, d.validate(property, fieldValue, at+"."+field)...)
+		}
+	case []interface{}:
+		if schema.Items == nil {
+			return []string{fmt.Sprintf("%s is not documented as an array", at)}
+		}
+		for i, item := range value {
+			problems = append(problems, d.validate(schema.Items, item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	}
+	return problems
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	doc := loadOpenAPIDocument(t)
+
+	documented := map[string][]string{}
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			documented[path] = append(documented[path], strings.ToUpper(method))
+		}
+		slices.Sort(documented[path])
+	}
+
+	served := map[string][]string{}
+	for _, rt := range (&Resolver{}).routes() {
+		for method := range rt.methods {
+			served[rt.path] = append(served[rt.path], method)
+		}
+		slices.Sort(served[rt.path])
+	}
+
+	assert.Equal(t, served, documented)
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	doc := loadOpenAPIDocument(t)
+
+	models := map[string]interface{}{
+		"Task":            model.Task{},
+		"TaskEvent":       model.TaskEvent{},
+		"Mutation":        model.Mutation{},
+		"SocketMessage":   model.SocketMessage{},
+		"SyncResponse":    model.SyncResponse{},
+		"SyncRequest":     model.SyncRequest{},
+		"SyncChange":      model.SyncChange{},
+		"SyncResult":      model.SyncResult{},
+		"SyncConflict":    model.SyncConflict{},
+		"Webhook":         model.Webhook{},
+		"WebhookPayload":  model.WebhookPayload{},
+		"WebhookDelivery": model.WebhookDelivery{},
+		"Health":          model.Health{},
+		"Problem":         model.Problem{},
+		"FieldError":      model.FieldError{},
+		"GraphQLRequest":  graphqlRequest{},
+		"GraphQLResponse": graphql.Response{},
+	}
+
+	for name, value := range models {
+		t.Run(name, func(t *testing.T) {
+			schema, ok := doc.Components.Schemas[name]
+			if !assert.True(t, ok, "schema %s is not documented", name) {
+				return
+			}
+
+			var fields []string
+			typ := reflect.TypeOf(value)
+			for i := 0; i < typ.NumField(); i++ {
+				field := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+				if field != "-" {
+					fields = append(fields, field)
+				}
+			}
+			var properties []string
+			for property := range schema.Properties {
+				properties = append(properties, property)
+			}
+
+			assert.ElementsMatch(t, fields, properties)
+		})
+	}
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	doc := loadOpenAPIDocument(t)
+	dbErr := errors.New("database unavailable")
+	task := model.Task{ID: taskID1, UserID: "user-1", Body: "Task 1"}
+	otherUsersTask := model.Task{ID: taskID1, UserID: "user-2", Body: "Theirs"}
+	webhook := model.Webhook{ID: webhookID1, UserID: "user-1", URL: "https://example.com/hook", Events: []string{model.WebhookTaskCreated}}
+	delivery := model.WebhookDelivery{
+		ID:        "d1",
+		WebhookID: webhookID1,
+		Event:     model.WebhookTaskCreated,
+		Payload:   json.RawMessage(`{"event":"task.created","task_id":"` + taskID1 + `","task":{"id":"` + taskID1 + `","user_id":"user-1","body":"Task 1","completed":false,"parent":null,"reminder":null},"created_at":"2024-01-01T00:00:00Z"}`),
+		Status:    model.DeliveryPending,
+	}
+	largeTaskBody := `{"id":"` + taskID1 + `","body":"` + strings.Repeat("a", maxTaskRequestBytes) + `"}`
+	invalidTaskBody := `{"id":"1","body":""}`
+	webhookBody := `{"id":"` + webhookID1 + `","url":"https://example.com/hook"}`
+	largeWebhookBody := `{"id":"` + webhookID1 + `","url":"https://example.com/` + strings.Repeat("a", maxWebhookRequestBytes) + `"}`
+
+	tokenID := "6b1f2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+	apiToken := model.APIToken{ID: tokenID, UserID: "user-1", Name: "CI", Scopes: []string{model.ScopeRead}, ExpiresAt: time.Now().Add(time.Hour)}
+	tokenBody := `{"id":"` + tokenID + `","name":"CI","scopes":["read"]}`
+	largeTokenBody := `{"id":"` + tokenID + `","name":"` + strings.Repeat("a", maxTokenRequestBytes) + `"}`
+
+	me := model.User{ID: "user-1", Email: "user-1@example.com", Name: "User One", Timezone: "Europe/Paris"}
+	largeUserBody := `{"name":"` + strings.Repeat("a", maxUserRequestBytes) + `"}`
+
+	ownedWebhook := func(m *database.MockDatabase) {
+		m.On("GetWebhookByID", webhookID1).Return(&webhook, nil)
+	}
+	missingWebhook := func(m *database.MockDatabase) {
+		m.On("GetWebhookByID", webhookID1).Return((*model.Webhook)(nil), nil)
+	}
+	streaming := func(m *database.MockDatabase) {
+		m.On("GetLatestTaskEventID", "user-1").Return(int64(0), nil)
+		m.On("GetTaskEvents", "user-1", int64(0)).Return(&[]model.TaskEvent{}, nil).Maybe()
+	}
+
+	type responseTest struct {
+		name           string
+		method         string
+		path           string
+		query          string
+		header         http.Header
+		upgrade        bool
+		body           string
+		user           string
+		limited        bool
+		setup          func(m *database.MockDatabase)
+		expectedStatus int
+	}
+	tests := []responseTest{
+		{name: "GetTasks_OK", method: "GET", path: "/tasks", user: "user-1", expectedStatus: http.StatusOK,
+			setup: func(m *database.MockDatabase) { m.On("GetTasks").Return(&[]model.Task{task}, nil) }},
+		{name: "GetTasks_ByID", method: "GET", path: "/tasks", query: "id=" + taskID1, user: "user-1", expectedStatus: http.StatusOK,
+			setup: func(m *database.MockDatabase) { m.On("GetTaskByID", taskID1).Return(&task, nil) }},
+		{name: "GetTasks_BadRequest", method: "GET", path: "/tasks", query: "id=" + taskID1 + "&user_id=user-1", user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "GetTasks_Unauthorized", method: "GET", path: "/tasks", expectedStatus: http.StatusUnauthorized},
+		{name: "GetTasks_NotFound", method: "GET", path: "/tasks", query: "id=" + taskID2, user: "user-1", expectedStatus: http.StatusNotFound,
+			setup: func(m *database.MockDatabase) { m.On("GetTaskByID", taskID2).Return((*model.Task)(nil), nil) }},
+		{name: "GetTasks_Error", method: "GET", path: "/tasks", user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) { m.On("GetTasks").Return((*[]model.Task)(nil), dbErr) }},
+
+		{name: "CreateTask_Created", method: "POST", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`, user: "user-1", expectedStatus: http.StatusCreated,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetTaskByIDFromPrimary", taskID1).Return((*model.Task)(nil), nil)
+				m.On("CreateTask", mock.Anything).Return(nil)
+			}},
+		{name: "CreateTask_Exists", method: "POST", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`, user: "user-1", expectedStatus: http.StatusConflict,
+			setup: func(m *database.MockDatabase) { m.On("GetTaskByIDFromPrimary", taskID1).Return(&otherUsersTask, nil) }},
+		{name: "CreateTask_BadRequest", method: "POST", path: "/tasks", body: `{`, user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "CreateTask_Unauthorized", method: "POST", path: "/tasks", body: `{}`, expectedStatus: http.StatusUnauthorized},
+		{name: "CreateTask_KeyReused", method: "POST", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`, user: "user-1", header: http.Header{IdempotencyKeyHeader: {"k1"}}, expectedStatus: http.StatusUnprocessableEntity,
+			setup: func(m *database.MockDatabase) {
+				m.On("ReserveIdempotencyKey", mock.Anything).Return(&model.IdempotencyRecord{Fingerprint: "other"}, nil)
+			}},
+		{name: "CreateTask_KeyInProgress", method: "POST", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`, user: "user-1", header: http.Header{IdempotencyKeyHeader: {"k1"}}, expectedStatus: http.StatusConflict,
+			setup: func(m *database.MockDatabase) {
+				m.On("ReserveIdempotencyKey", mock.Anything).Return(&model.IdempotencyRecord{Fingerprint: fingerprintRequest([]byte(`{"id":"` + taskID1 + `","body":"Task 1"}`))}, nil)
+			}},
+		{name: "CreateTask_TooLarge", method: "POST", path: "/tasks", body: largeTaskBody, user: "user-1", expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "CreateTask_Invalid", method: "POST", path: "/tasks", body: invalidTaskBody, user: "user-1", expectedStatus: http.StatusUnprocessableEntity},
+		{name: "CreateTask_Error", method: "POST", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`, user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetTaskByIDFromPrimary", taskID1).Return((*model.Task)(nil), nil)
+				m.On("CreateTask", mock.Anything).Return(dbErr)
+			}},
+
+		{name: "UpdateTask_OK", method: "PUT", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`, user: "user-1", expectedStatus: http.StatusOK,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetTaskByIDFromPrimary", taskID1).Return(&task, nil)
+				m.On("UpdateTask", mock.Anything).Return(nil)
+			}},
+		{name: "UpdateTask_NotFound", method: "PUT", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`, user: "user-1", expectedStatus: http.StatusNotFound,
+			setup: func(m *database.MockDatabase) { m.On("GetTaskByIDFromPrimary", taskID1).Return(&otherUsersTask, nil) }},
+		{name: "UpdateTask_BadRequest", method: "PUT", path: "/tasks", body: `{`, user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "UpdateTask_Unauthorized", method: "PUT", path: "/tasks", body: `{}`, expectedStatus: http.StatusUnauthorized},
+		{name: "UpdateTask_TooLarge", method: "PUT", path: "/tasks", body: largeTaskBody, user: "user-1", expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "UpdateTask_Invalid", method: "PUT", path: "/tasks", body: invalidTaskBody, user: "user-1", expectedStatus: http.StatusUnprocessableEntity},
+		{name: "UpdateTask_Error", method: "PUT", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`, user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetTaskByIDFromPrimary", taskID1).Return(&task, nil)
+				m.On("UpdateTask", mock.Anything).Return(dbErr)
+			}},
+
+		{name: "DeleteTask_OK", method: "DELETE", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`, user: "user-1", expectedStatus: http.StatusOK,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetTaskByIDFromPrimary", taskID1).Return(&task, nil)
+				m.On("DeleteTask", mock.Anything).Return(nil)
+			}},
+		{name: "DeleteTask_NotFound", method: "DELETE", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`, user: "user-1", expectedStatus: http.StatusNotFound,
+			setup: func(m *database.MockDatabase) { m.On("GetTaskByIDFromPrimary", taskID1).Return(&otherUsersTask, nil) }},
+		{name: "DeleteTask_BadRequest", method: "DELETE", path: "/tasks", body: `{`, user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "DeleteTask_Unauthorized", method: "DELETE", path: "/tasks", body: `{}`, expectedStatus: http.StatusUnauthorized},
+		{name: "DeleteTask_TooLarge", method: "DELETE", path: "/tasks", body: largeTaskBody, user: "user-1", expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "DeleteTask_Invalid", method: "DELETE", path: "/tasks", body: invalidTaskBody, user: "user-1", expectedStatus: http.StatusUnprocessableEntity},
+		{name: "DeleteTask_Error", method: "DELETE", path: "/tasks", body: `{"id":"` + taskID1 + `","body":"Task 1"}`, user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetTaskByIDFromPrimary", taskID1).Return(&task, nil)
+				m.On("DeleteTask", mock.Anything).Return(dbErr)
+			}},
+
+		{name: "StreamTaskEvents_OK", method: "GET", path: "/tasks/events", user: "user-1", expectedStatus: http.StatusOK, setup: streaming},
+		{name: "StreamTaskEvents_BadRequest", method: "GET", path: "/tasks/events", user: "user-1", header: http.Header{"Last-Event-ID": {"x"}}, expectedStatus: http.StatusBadRequest},
+		{name: "StreamTaskEvents_Unauthorized", method: "GET", path: "/tasks/events", expectedStatus: http.StatusUnauthorized},
+		{name: "StreamTaskEvents_Error", method: "GET", path: "/tasks/events", user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) { m.On("GetLatestTaskEventID", "user-1").Return(int64(0), dbErr) }},
+
+		{name: "SyncTasks_SwitchingProtocols", method: "GET", path: "/tasks/ws", upgrade: true, user: "user-1", expectedStatus: http.StatusSwitchingProtocols, setup: streaming},
+		{name: "SyncTasks_BadRequest", method: "GET", path: "/tasks/ws", upgrade: true, query: "last_event_id=x", user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "SyncTasks_Unauthorized", method: "GET", path: "/tasks/ws", upgrade: true, expectedStatus: http.StatusUnauthorized},
+		{name: "SyncTasks_Error", method: "GET", path: "/tasks/ws", upgrade: true, user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) { m.On("GetLatestTaskEventID", "user-1").Return(int64(0), dbErr) }},
+
+		{name: "PullTaskChanges_OK", method: "GET", path: "/sync", query: "since=2", user: "user-1", expectedStatus: http.StatusOK,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetTaskChanges", "user-1", int64(2)).Return(&model.TaskChanges{Tasks: []model.Task{task}, Deleted: []string{"2"}, Sequence: 3}, nil)
+			}},
+		{name: "PullTaskChanges_BadRequest", method: "GET", path: "/sync", query: "since=x", user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "PullTaskChanges_Unauthorized", method: "GET", path: "/sync", expectedStatus: http.StatusUnauthorized},
+		{name: "PullTaskChanges_Error", method: "GET", path: "/sync", user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetTaskChanges", "user-1", int64(0)).Return((*model.TaskChanges)(nil), dbErr)
+			}},
+
+		{name: "PushTaskChanges_OK", method: "POST", path: "/sync", body: `{"changes":[{"task":{"id":"` + taskID1 + `","body":"New"}},{"task":{"id":"` + taskID3 + `","body":"Mine"},"base_version":1}]}`, user: "user-1", expectedStatus: http.StatusOK,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetTaskByIDFromPrimary", taskID1).Return((*model.Task)(nil), nil)
+				m.On("GetTaskByIDFromPrimary", taskID3).Return(&model.Task{ID: taskID3, UserID: "user-2"}, nil)
+				m.On("CreateTask", mock.Anything).Return(nil)
+			}},
+		{name: "PushTaskChanges_BadRequest", method: "POST", path: "/sync", body: `{"strategy":"newest"}`, user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "PushTaskChanges_TooLarge", method: "POST", path: "/sync", body: `{"changes":[{"task":{"id":"` + taskID1 + `","body":"` + strings.Repeat("a", maxSyncRequestBytes) + `"}}]}`, user: "user-1", expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "PushTaskChanges_Unauthorized", method: "POST", path: "/sync", body: `{}`, expectedStatus: http.StatusUnauthorized},
+		{name: "PushTaskChanges_Error", method: "POST", path: "/sync", body: `{"changes":[{"task":{"id":"` + taskID1 + `"}}]}`, user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetTaskByIDFromPrimary", taskID1).Return((*model.Task)(nil), dbErr)
+			}},
+
+		{name: "GetWebhooks_OK", method: "GET", path: "/webhooks", user: "user-1", expectedStatus: http.StatusOK,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetWebhooksByUserID", "user-1").Return(&[]model.Webhook{webhook}, nil)
+			}},
+		{name: "GetWebhooks_Unauthorized", method: "GET", path: "/webhooks", expectedStatus: http.StatusUnauthorized},
+		{name: "GetWebhooks_Error", method: "GET", path: "/webhooks", user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetWebhooksByUserID", "user-1").Return((*[]model.Webhook)(nil), dbErr)
+			}},
+
+		{name: "CreateWebhook_Created", method: "POST", path: "/webhooks", body: webhookBody, user: "user-1", expectedStatus: http.StatusCreated,
+			setup: func(m *database.MockDatabase) { m.On("CreateWebhook", mock.Anything).Return(nil) }},
+		{name: "CreateWebhook_BadRequest", method: "POST", path: "/webhooks", body: `{"url":"ftp://example.com"}`, user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "CreateWebhook_UnknownField", method: "POST", path: "/webhooks", body: `{"url":"https://example.com/hook","evnts":["task.created"]}`, user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "CreateWebhook_TooLarge", method: "POST", path: "/webhooks", body: largeWebhookBody, user: "user-1", expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "CreateWebhook_Unauthorized", method: "POST", path: "/webhooks", body: webhookBody, expectedStatus: http.StatusUnauthorized},
+		{name: "CreateWebhook_Error", method: "POST", path: "/webhooks", body: webhookBody, user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) { m.On("CreateWebhook", mock.Anything).Return(dbErr) }},
+
+		{name: "UpdateWebhook_OK", method: "PUT", path: "/webhooks", body: webhookBody, user: "user-1", expectedStatus: http.StatusOK,
+			setup: func(m *database.MockDatabase) {
+				ownedWebhook(m)
+				m.On("UpdateWebhook", mock.Anything).Return(nil)
+			}},
+		{name: "UpdateWebhook_BadRequest", method: "PUT", path: "/webhooks", body: `{"id":"` + webhookID1 + `","url":"example.com"}`, user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "UpdateWebhook_TooLarge", method: "PUT", path: "/webhooks", body: largeWebhookBody, user: "user-1", expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "UpdateWebhook_Unauthorized", method: "PUT", path: "/webhooks", body: webhookBody, expectedStatus: http.StatusUnauthorized},
+		{name: "UpdateWebhook_NotFound", method: "PUT", path: "/webhooks", body: webhookBody, user: "user-1", expectedStatus: http.StatusNotFound, setup: missingWebhook},
+		{name: "UpdateWebhook_Error", method: "PUT", path: "/webhooks", body: webhookBody, user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) {
+				ownedWebhook(m)
+				m.On("UpdateWebhook", mock.Anything).Return(dbErr)
+			}},
+
+		{name: "DeleteWebhook_OK", method: "DELETE", path: "/webhooks", body: webhookBody, user: "user-1", expectedStatus: http.StatusOK,
+			setup: func(m *database.MockDatabase) {
+				ownedWebhook(m)
+				m.On("DeleteWebhook", mock.Anything).Return(nil)
+			}},
+		{name: "DeleteWebhook_BadRequest", method: "DELETE", path: "/webhooks", body: `{`, user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "DeleteWebhook_TooLarge", method: "DELETE", path: "/webhooks", body: largeWebhookBody, user: "user-1", expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "DeleteWebhook_Unauthorized", method: "DELETE", path: "/webhooks", body: webhookBody, expectedStatus: http.StatusUnauthorized},
+		{name: "DeleteWebhook_NotFound", method: "DELETE", path: "/webhooks", body: webhookBody, user: "user-1", expectedStatus: http.StatusNotFound, setup: missingWebhook},
+		{name: "DeleteWebhook_Error", method: "DELETE", path: "/webhooks", body: webhookBody, user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) {
+				ownedWebhook(m)
+				m.On("DeleteWebhook", mock.Anything).Return(dbErr)
+			}},
+
+		{name: "GetTokens_OK", method: "GET", path: "/tokens", user: "user-1", expectedStatus: http.StatusOK,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetAPITokensByUserID", "user-1").Return(&[]model.APIToken{apiToken}, nil)
+			}},
+		{name: "GetTokens_Unauthorized", method: "GET", path: "/tokens", expectedStatus: http.StatusUnauthorized},
+		{name: "GetTokens_Error", method: "GET", path: "/tokens", user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetAPITokensByUserID", "user-1").Return((*[]model.APIToken)(nil), dbErr)
+			}},
+
+		{name: "CreateToken_Created", method: "POST", path: "/tokens", body: tokenBody, user: "user-1", expectedStatus: http.StatusCreated,
+			setup: func(m *database.MockDatabase) { m.On("CreateAPIToken", mock.Anything).Return(nil) }},
+		{name: "CreateToken_BadRequest", method: "POST", path: "/tokens", body: `{"name":"CI","scopes":["admin"]}`, user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "CreateToken_UnknownField", method: "POST", path: "/tokens", body: `{"name":"CI","scope":["read"]}`, user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "CreateToken_TooLarge", method: "POST", path: "/tokens", body: largeTokenBody, user: "user-1", expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "CreateToken_Unauthorized", method: "POST", path: "/tokens", body: tokenBody, expectedStatus: http.StatusUnauthorized},
+		{name: "CreateToken_Error", method: "POST", path: "/tokens", body: tokenBody, user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) { m.On("CreateAPIToken", mock.Anything).Return(dbErr) }},
+
+		{name: "DeleteToken_OK", method: "DELETE", path: "/tokens", body: tokenBody, user: "user-1", expectedStatus: http.StatusOK,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetAPITokenByID", tokenID).Return(&apiToken, nil)
+				m.On("DeleteAPIToken", mock.Anything).Return(nil)
+			}},
+		{name: "DeleteToken_BadRequest", method: "DELETE", path: "/tokens", body: `{`, user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "DeleteToken_TooLarge", method: "DELETE", path: "/tokens", body: largeTokenBody, user: "user-1", expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "DeleteToken_Unauthorized", method: "DELETE", path: "/tokens", body: tokenBody, expectedStatus: http.StatusUnauthorized},
+		{name: "DeleteToken_NotFound", method: "DELETE", path: "/tokens", body: tokenBody, user: "user-2", expectedStatus: http.StatusNotFound,
+			setup: func(m *database.MockDatabase) { m.On("GetAPITokenByID", tokenID).Return(&apiToken, nil) }},
+		{name: "DeleteToken_Error", method: "DELETE", path: "/tokens", body: tokenBody, user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetAPITokenByID", tokenID).Return(&apiToken, nil)
+				m.On("DeleteAPIToken", mock.Anything).Return(dbErr)
+			}},
+
+		{name: "GetMe_OK", method: "GET", path: "/me", user: "user-1", expectedStatus: http.StatusOK,
+			setup: func(m *database.MockDatabase) { m.On("GetUser", "user-1").Return(&me, nil) }},
+		{name: "GetMe_Unauthorized", method: "GET", path: "/me", expectedStatus: http.StatusUnauthorized},
+		{name: "GetMe_NotFound", method: "GET", path: "/me", user: "user-1", expectedStatus: http.StatusNotFound,
+			setup: func(m *database.MockDatabase) { m.On("GetUser", "user-1").Return((*model.User)(nil), nil) }},
+		{name: "GetMe_Error", method: "GET", path: "/me", user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) { m.On("GetUser", "user-1").Return((*model.User)(nil), dbErr) }},
+
+		{name: "UpdateMe_OK", method: "PATCH", path: "/me", body: `{"timezone":"America/New_York"}`, user: "user-1", expectedStatus: http.StatusOK,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetUser", "user-1").Return(&me, nil)
+				m.On("UpdateUser", mock.Anything).Return(nil)
+			}},
+		{name: "UpdateMe_BadRequest", method: "PATCH", path: "/me", body: `{"email":"other@example.com"}`, user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "UpdateMe_Unauthorized", method: "PATCH", path: "/me", body: `{"name":"User"}`, expectedStatus: http.StatusUnauthorized},
+		{name: "UpdateMe_NotFound", method: "PATCH", path: "/me", body: `{"name":"User"}`, user: "user-1", expectedStatus: http.StatusNotFound,
+			setup: func(m *database.MockDatabase) { m.On("GetUser", "user-1").Return((*model.User)(nil), nil) }},
+		{name: "UpdateMe_TooLarge", method: "PATCH", path: "/me", body: largeUserBody, user: "user-1", expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "UpdateMe_ValidationFailed", method: "PATCH", path: "/me", body: `{"timezone":"Mars/Olympus_Mons"}`, user: "user-1", expectedStatus: http.StatusUnprocessableEntity},
+		{name: "UpdateMe_Error", method: "PATCH", path: "/me", body: `{"name":"User"}`, user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) {
+				m.On("GetUser", "user-1").Return(&me, nil)
+				m.On("UpdateUser", mock.Anything).Return(dbErr)
+			}},
+
+		{name: "GetWebhookDeliveries_OK", method: "GET", path: "/webhooks/deliveries", query: "webhook_id=" + webhookID1, user: "user-1", expectedStatus: http.StatusOK,
+			setup: func(m *database.MockDatabase) {
+				ownedWebhook(m)
+				m.On("GetWebhookDeliveries", webhookID1, "").Return(&[]model.WebhookDelivery{delivery}, nil)
+			}},
+		{name: "GetWebhookDeliveries_Unauthorized", method: "GET", path: "/webhooks/deliveries", query: "webhook_id=" + webhookID1, expectedStatus: http.StatusUnauthorized},
+		{name: "GetWebhookDeliveries_NotFound", method: "GET", path: "/webhooks/deliveries", query: "webhook_id=" + webhookID1, user: "user-1", expectedStatus: http.StatusNotFound, setup: missingWebhook},
+		{name: "GetWebhookDeliveries_Error", method: "GET", path: "/webhooks/deliveries", query: "webhook_id=" + webhookID1, user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) {
+				ownedWebhook(m)
+				m.On("GetWebhookDeliveries", webhookID1, "").Return((*[]model.WebhookDelivery)(nil), dbErr)
+			}},
+
+		{name: "SendTestWebhook_Accepted", method: "POST", path: "/webhooks/test", query: "id=" + webhookID1, user: "user-1", expectedStatus: http.StatusAccepted,
+			setup: func(m *database.MockDatabase) {
+				ownedWebhook(m)
+				m.On("CreateWebhookDelivery", mock.Anything).Return(nil)
+			}},
+		{name: "SendTestWebhook_Unauthorized", method: "POST", path: "/webhooks/test", query: "id=" + webhookID1, expectedStatus: http.StatusUnauthorized},
+		{name: "SendTestWebhook_NotFound", method: "POST", path: "/webhooks/test", query: "id=" + webhookID1, user: "user-1", expectedStatus: http.StatusNotFound, setup: missingWebhook},
+		{name: "SendTestWebhook_Error", method: "POST", path: "/webhooks/test", query: "id=" + webhookID1, user: "user-1", expectedStatus: http.StatusInternalServerError,
+			setup: func(m *database.MockDatabase) {
+				ownedWebhook(m)
+				m.On("CreateWebhookDelivery", mock.Anything).Return(dbErr)
+			}},
+
+		{name: "SubscribeGraphQL_SwitchingProtocols", method: "GET", path: "/graphql", upgrade: true, header: http.Header{"Sec-WebSocket-Protocol": {"graphql-ws"}}, user: "user-1", expectedStatus: http.StatusSwitchingProtocols},
+		{name: "SubscribeGraphQL_BadRequest", method: "GET", path: "/graphql", user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "SubscribeGraphQL_Unauthorized", method: "GET", path: "/graphql", expectedStatus: http.StatusUnauthorized},
+
+		{name: "QueryGraphQL_OK", method: "POST", path: "/graphql", body: `{"query":"{ tasks { id body children { id } } }"}`, user: "user-1", expectedStatus: http.StatusOK,
+			setup: func(m *database.MockDatabase) { m.On("GetTasksByUserID", "user-1").Return(&[]model.Task{task}, nil) }},
+		{name: "QueryGraphQL_BadRequest", method: "POST", path: "/graphql", body: `{"query":""}`, user: "user-1", expectedStatus: http.StatusBadRequest},
+		{name: "QueryGraphQL_Unauthorized", method: "POST", path: "/graphql", body: `{}`, expectedStatus: http.StatusUnauthorized},
+		{name: "QueryGraphQL_TooLarge", method: "POST", path: "/graphql", body: `{"query":"` + strings.Repeat(" ", maxTaskRequestBytes) + `{ tasks { id } }"}`, user: "user-1", expectedStatus: http.StatusRequestEntityTooLarge},
+
+		{name: "GetHealth_OK", method: "GET", path: "/healthz", expectedStatus: http.StatusOK},
+		{name: "GetReadiness_OK", method: "GET", path: "/readyz", expectedStatus: http.StatusOK,
+			setup: func(m *database.MockDatabase) {
+				m.On("Ping").Return(nil)
+				m.On("GetSchemaVersion").Return(database.SchemaVersion, nil)
+			}},
+		{name: "GetReadiness_Unavailable", method: "GET", path: "/readyz", expectedStatus: http.StatusServiceUnavailable,
+			setup: func(m *database.MockDatabase) {
+				m.On("Ping").Return(dbErr)
+				m.On("GetSchemaVersion").Return(database.SchemaVersion, nil)
+			}},
+
+		{name: "GetMetrics_OK", method: "GET", path: "/metrics", expectedStatus: http.StatusOK},
+		{name: "GetOpenAPISpec_OK", method: "GET", path: "/openapi.json", expectedStatus: http.StatusOK},
+		{name: "GetDocs_OK", method: "GET", path: "/docs", expectedStatus: http.StatusOK},
+	}
+
+	// Every authenticated operation is rejected once the caller is over budget, and when made with an API key
+	// without the scope it needs.
+	for _, rt := range (&Resolver{}).routes() {
+		if rt.public {
+			continue
+		}
+		for method := range rt.methods {
+			tests = append(tests, responseTest{name: method + rt.path + "_TooManyRequests", method: method, path: rt.path, user: "user-1",
+				limited: true, expectedStatus: http.StatusTooManyRequests})
+
+			scope := model.ScopeRead
+			if method == http.MethodGet {
+				scope = model.ScopeWrite
+			}
+			key := model.APIToken{ID: tokenID, UserID: "user-1", Scopes: []string{scope}, ExpiresAt: time.Now().Add(time.Hour), LastUsedAt: &apiToken.ExpiresAt}
+			tests = append(tests, responseTest{name: method + rt.path + "_Forbidden", method: method, path: rt.path,
+				header: http.Header{"Authorization": {"Bearer " + middleware.APIKeyPrefix + "key"}}, expectedStatus: http.StatusForbidden,
+				setup: func(m *database.MockDatabase) {
+					m.On("GetAPITokenByHash", middleware.HashAPIKey(middleware.APIKeyPrefix+"key")).Return(&key, nil)
+				}})
+		}
+	}
+
+	exercised := map[string][]int{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			if tt.setup != nil {
+				tt.setup(mockDB)
+			}
+			resolver := &Resolver{Database: mockDB, events: newEventBroker(), metrics: metrics.New()}
+			if tt.limited {
+				resolver.limiter = exhaustedLimiter()
+			}
+
+			// Requests without a user go through the real middleware, which rejects them for having no token.
+			authenticate := middleware.EnsureValidAPIKey(mockDB, nil, middleware.EnsureValidToken(&middleware.AuthConfig{Domain: "tasks.example.com", Audience: "tasks"}, nil))
+			if tt.user != "" {
+				authenticate = func(next http.Handler) http.Handler {
+					return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+						next.ServeHTTP(w, withUser(req, tt.user))
+					})
+				}
+			}
+			server := httptest.NewServer(resolver.handler(authenticate))
+			defer server.Close()
+
+			url := server.URL + tt.path
+			if tt.query != "" {
+				url += "?" + tt.query
+			}
+
+			var resp *http.Response
+			if tt.upgrade {
+				conn, wsResp, _ := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), tt.header)
+				if conn != nil {
+					defer conn.Close()
+				}
+				resp = wsResp
+			} else {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				req, err := http.NewRequestWithContext(ctx, tt.method, url, strings.NewReader(tt.body))
+				assert.NoError(t, err)
+				for name, values := range tt.header {
+					req.Header[name] = values
+				}
+				resp, err = http.DefaultClient.Do(req)
+				assert.NoError(t, err)
+			}
+			if !assert.NotNil(t, resp) {
+				return
+			}
+			defer resp.Body.Close()
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+
+			documented, ok := doc.response(tt.path, tt.method, resp.StatusCode)
+			if !assert.True(t, ok, "%s %s returned undocumented status %d", tt.method, tt.path, resp.StatusCode) {
+				return
+			}
+			exercised[tt.method+" "+tt.path] = append(exercised[tt.method+" "+tt.path], resp.StatusCode)
+
+			if len(documented.Content) == 0 {
+				return
+			}
+			mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+			content, ok := documented.Content[mediaType]
+			if !assert.True(t, ok, "%s %s returned undocumented content type %q", tt.method, tt.path, mediaType) {
+				return
+			}
+			if (mediaType != "application/json" && mediaType != model.ProblemContentType) || content.Schema == nil {
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			var value interface{}
+			assert.NoError(t, json.Unmarshal(body, &value))
+			assert.Empty(t, doc.validate(content.Schema, value, "response"))
+		})
+	}
+
+	// Every documented status must be returned by one of the requests above.
+	for path, operations := range doc.Paths {
+		for method, operation := range operations {
+			var statuses []int
+			for status := range operation.Responses {
+				code, err := strconv.Atoi(status)
+				assert.NoError(t, err)
+				statuses = append(statuses, code)
+			}
+			key := strings.ToUpper(method) + " " + path
+			returned := slices.Clone(exercised[key])
+			slices.Sort(returned)
+			assert.ElementsMatch(t, statuses, slices.Compact(returned), "statuses documented for %s", key)
+		}
+	}
+}
@@ -1,63 +1,376 @@
 package server
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	"google.golang.org/grpc"
 
 	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/internal/logging"
+	"github.com/SevvyP/tasks_v1/internal/metrics"
 	"github.com/SevvyP/tasks_v1/internal/middleware"
+	"github.com/SevvyP/tasks_v1/internal/outbox"
+	"github.com/SevvyP/tasks_v1/internal/ratelimit"
+	"github.com/SevvyP/tasks_v1/internal/tracing"
+	"github.com/SevvyP/tasks_v1/internal/webhook"
+	"github.com/SevvyP/tasks_v1/pkg/model"
 )
 
-// Resolver is the main server struct that holds the HTTP server and the database.
+// Resolver is the main server struct that holds the HTTP and gRPC servers and the database.
 type Resolver struct {
-	Server   http.Server
-	Database database.TaskDatabase
+	Server     http.Server
+	GRPCServer *grpc.Server
+	GRPCAddr   string
+	Database   database.TaskDatabase
+	// ShutdownTimeout is how long Shutdown should be given to drain connections.
+	ShutdownTimeout time.Duration
+	// DrainDelay is how long Shutdown keeps serving requests after readiness starts failing.
+	DrainDelay time.Duration
+	// Logger writes the access logs and any errors. If it is nil, the default logger is used.
+	Logger *slog.Logger
+
+	events    *eventBroker
+	publisher outbox.EventPublisher
+	metrics   *metrics.Metrics
+	// limiter counts authenticated requests against the caller's budgets. Nothing is limited if it is nil.
+	limiter *ratelimit.Limiter
+	// users provisions a users row for each authenticated caller. Nobody is provisioned if it is nil.
+	users *middleware.UserProvisioner
+	// cors is the cross-origin requests browsers may make. No origin is allowed if it is nil.
+	cors *middleware.CORSConfig
+	// hstsMaxAge is how long browsers are told to only use HTTPS. The header is left out if it is zero.
+	hstsMaxAge time.Duration
+	// stopTracing flushes the spans that have not been exported yet.
+	stopTracing func(ctx context.Context) error
+	// stopWorkers stops the background workers, and workers is done once they have all returned.
+	stopWorkers context.CancelFunc
+	workers     sync.WaitGroup
+	// shuttingDown is set once Shutdown is called, failing readiness checks.
+	shuttingDown atomic.Bool
+	// checkJWKS reports whether the JWT signing keys can be fetched. Readiness skips the check if it is nil.
+	checkJWKS func(ctx context.Context) error
+
+	graphqlOnce   sync.Once
+	graphqlSchema *graphql.Schema
 }
 
 type Config struct {
-	PostgresConfig *database.PostgresConfig `json:"postgres"`
-	AuthConfig     *middleware.AuthConfig   `json:"auth"`
+	PostgresConfig  *database.PostgresConfig `json:"postgres"`
+	AuthConfig      *middleware.AuthConfig   `json:"auth"`
+	EventsConfig    *outbox.Config           `json:"events"`
+	HTTPConfig      *HTTPConfig              `json:"http"`
+	TracingConfig   *tracing.Config          `json:"tracing"`
+	LoggingConfig   *logging.Config          `json:"logging"`
+	RateLimitConfig *ratelimit.Config        `json:"rate_limit"`
+	CORSConfig      *middleware.CORSConfig   `json:"cors"`
 }
 
 // NewResolver creates a new Resolver with a new HTTP server and database.
-// It also sets up the HTTP routes for the server.
-func NewResolver(config *Config) *Resolver {
+// It also sets up the HTTP routes for the server. The logger is shared with the database, the middleware
+// and the background workers. If anything cannot be set up, the error is logged and the process exits.
+func NewResolver(config *Config, logger *slog.Logger) *Resolver {
+	fatal := func(msg string, err error) {
+		logger.Error(msg, "error", err)
+		os.Exit(1)
+	}
 	if config == nil {
-		log.Fatal("config is nil")
+		fatal("Failed to create resolver", errors.New("config is nil"))
 	}
-	mux := http.NewServeMux()
-	database, err := database.NewDatabase(config.PostgresConfig)
+	stopTracing, err := tracing.Setup(context.Background(), config.TracingConfig)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+	postgres, err := database.NewDatabase(config.PostgresConfig, logger)
 	if err != nil {
-		log.Fatalf("Failed to create database: %v", err)
+		fatal("Failed to create database", err)
 	}
+	m := metrics.New()
+	m.RegisterDBStats(postgres.Stats)
+	database := metrics.NewDatabase(postgres, m)
+	httpConfig := config.HTTPConfig.withDefaults()
 	resolver := &Resolver{
 		Server: http.Server{
-			Addr:    ":8080",
-			Handler: mux,
+			Addr:         httpConfig.Addr,
+			ReadTimeout:  time.Duration(httpConfig.ReadTimeout),
+			WriteTimeout: time.Duration(httpConfig.WriteTimeout),
+			IdleTimeout:  time.Duration(httpConfig.IdleTimeout),
 		},
-		Database: database,
-	}
-
-	// Wrap the handler with the authentication middleware
-	mux.Handle("/tasks", middleware.EnsureValidToken(config.AuthConfig)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			resolver.GetTasks(w, r)
-		case http.MethodPost:
-			resolver.CreateTask(w, r)
-		case http.MethodPut:
-			resolver.UpdateTask(w, r)
-		case http.MethodDelete:
-			resolver.DeleteTask(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})))
+		GRPCAddr:        httpConfig.GRPCAddr,
+		Database:        database,
+		ShutdownTimeout: time.Duration(httpConfig.ShutdownTimeout),
+		DrainDelay:      time.Duration(httpConfig.DrainDelay),
+		Logger:          logger,
+		checkJWKS:       middleware.NewJWKSCheck(config.AuthConfig),
+		events:          newEventBroker(),
+		metrics:         m,
+		stopTracing:     stopTracing,
+		cors:            config.CORSConfig,
+		hstsMaxAge:      time.Duration(httpConfig.HSTSMaxAge),
+	}
+
+	publisher, err := outbox.NewPublisher(config.EventsConfig)
+	if err != nil {
+		fatal("Failed to create event publisher", err)
+	}
+	resolver.publisher = publisher
+
+	store, err := ratelimit.NewStore(config.RateLimitConfig, database)
+	if err != nil {
+		fatal("Failed to create rate limit store", err)
+	}
+	resolver.limiter = ratelimit.New(config.RateLimitConfig, store, logger)
+	resolver.users = middleware.NewUserProvisioner(database)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	resolver.stopWorkers = cancel
+	notifications, err := database.ListenTaskEvents(ctx)
+	if err != nil {
+		fatal("Failed to listen for task events", err)
+	}
+	resolver.goWorker(func() { resolver.events.run(notifications) })
+	resolver.goWorker(func() { webhook.NewDispatcher(database, logger).Run(ctx) })
+	if publisher != nil {
+		resolver.goWorker(func() { outbox.NewRelay(database, publisher, logger).Run(ctx) })
+	}
+	resolver.goWorker(func() { resolver.limiter.Run(ctx) })
+
+	// Wrap the handlers with the authentication middleware, which accepts API keys as well as JWTs
+	resolver.Server.Handler = resolver.handler(middleware.EnsureValidAPIKey(database, m, middleware.EnsureValidToken(config.AuthConfig, m)))
+	unary, stream := middleware.EnsureValidTokenGRPC(config.AuthConfig, m)
+	resolver.GRPCServer = resolver.grpcServer(middleware.EnsureValidAPIKeyGRPC(database, m, unary, stream))
 
 	return resolver
 }
 
-// Resolve starts the HTTP server and listens for incoming requests.
+// route is a path served by the Resolver and the handler for each method it allows.
+// Routes are wrapped with the authentication and rate limiting middleware unless they are public.
+type route struct {
+	path    string
+	public  bool
+	methods map[string]http.HandlerFunc
+}
+
+// routes returns every route served by the Resolver.
+func (r *Resolver) routes() []route {
+	return []route{
+		{path: "/tasks", methods: map[string]http.HandlerFunc{
+			http.MethodGet:    r.GetTasks,
+			http.MethodPost:   r.CreateTask,
+			http.MethodPut:    r.UpdateTask,
+			http.MethodDelete: r.DeleteTask,
+		}},
+		{path: "/tasks/events", methods: map[string]http.HandlerFunc{
+			http.MethodGet: r.StreamTaskEvents,
+		}},
+		{path: "/tasks/ws", methods: map[string]http.HandlerFunc{
+			http.MethodGet: r.SyncTasks,
+		}},
+		{path: "/sync", methods: map[string]http.HandlerFunc{
+			http.MethodGet:  r.PullTaskChanges,
+			http.MethodPost: r.PushTaskChanges,
+		}},
+		{path: "/webhooks", methods: map[string]http.HandlerFunc{
+			http.MethodGet:    r.GetWebhooks,
+			http.MethodPost:   r.CreateWebhook,
+			http.MethodPut:    r.UpdateWebhook,
+			http.MethodDelete: r.DeleteWebhook,
+		}},
+		{path: "/webhooks/deliveries", methods: map[string]http.HandlerFunc{
+			http.MethodGet: r.GetWebhookDeliveries,
+		}},
+		{path: "/webhooks/test", methods: map[string]http.HandlerFunc{
+			http.MethodPost: r.SendTestWebhook,
+		}},
+		{path: "/tokens", methods: map[string]http.HandlerFunc{
+			http.MethodGet:    r.GetTokens,
+			http.MethodPost:   r.CreateToken,
+			http.MethodDelete: r.DeleteToken,
+		}},
+		{path: "/me", methods: map[string]http.HandlerFunc{
+			http.MethodGet:   r.GetMe,
+			http.MethodPatch: r.UpdateMe,
+		}},
+		{path: "/graphql", methods: map[string]http.HandlerFunc{
+			http.MethodGet:  r.SubscribeGraphQL,
+			http.MethodPost: r.QueryGraphQL,
+		}},
+		{path: "/healthz", public: true, methods: map[string]http.HandlerFunc{
+			http.MethodGet: r.GetHealth,
+		}},
+		{path: "/readyz", public: true, methods: map[string]http.HandlerFunc{
+			http.MethodGet: r.GetReadiness,
+		}},
+		{path: "/metrics", public: true, methods: map[string]http.HandlerFunc{
+			http.MethodGet: r.GetMetrics,
+		}},
+		{path: "/openapi.json", public: true, methods: map[string]http.HandlerFunc{
+			http.MethodGet: r.GetOpenAPISpec,
+		}},
+		{path: "/docs", public: true, methods: map[string]http.HandlerFunc{
+			http.MethodGet: r.GetDocs,
+		}},
+	}
+}
+
+// handler returns a handler serving every route, with the routes that are not public rate limited by IP address,
+// wrapped by authenticate, then rate limited by the caller they authenticate, who is then provisioned.
+// Every request is assigned a request ID, traced, logged and recorded in the metrics of its route,
+// and requests for unknown paths get a problem response. Clients that wrote recently read from the primary database.
+// CORS preflight requests are answered before authentication, and every response carries the security headers.
+func (r *Resolver) handler(authenticate func(http.Handler) http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	cors := middleware.CORS(r.cors)
+	for _, rt := range r.routes() {
+		var handler http.Handler = rt
+		if !rt.public {
+			handler = middleware.RateLimitIP(r.limiter)(
+				authenticate(middleware.RateLimit(r.limiter)(middleware.ProvisionUser(r.users)(handler))))
+		}
+		mux.Handle(rt.path, r.instrument(rt.path, cors(handler)))
+	}
+	notFound := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		middleware.WriteProblem(w, req, http.StatusNotFound, model.ProblemNotFound, "Not found")
+	})
+	mux.Handle("/", r.instrument("unmatched", cors(notFound)))
+	return middleware.RequestID(middleware.SecurityHeaders(r.hstsMaxAge)(middleware.ReadYourWrites(mux)))
+}
+
+// instrument wraps the handler of a route with the middleware that traces, logs and measures its requests.
+func (r *Resolver) instrument(route string, handler http.Handler) http.Handler {
+	handler = middleware.InstrumentRoute(r.metrics, route)(handler)
+	handler = middleware.AccessLog(r.logger(), route)(handler)
+	return middleware.TraceRoute(route)(handler)
+}
+
+// logger returns the Resolver's logger, or the default logger if it has none.
+func (r *Resolver) logger() *slog.Logger {
+	if r.Logger == nil {
+		return slog.Default()
+	}
+	return r.Logger
+}
+
+// ServeHTTP calls the route's handler for the request method.
+// If the route does not allow the method, an HTTP 405 Method Not Allowed is returned.
+func (rt route) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	handler, ok := rt.methods[req.Method]
+	if !ok {
+		allowed := make([]string, 0, len(rt.methods))
+		for method := range rt.methods {
+			allowed = append(allowed, method)
+		}
+		slices.Sort(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		middleware.WriteProblem(w, req, http.StatusMethodNotAllowed, model.ProblemMethodNotAllowed, "Method not allowed")
+		return
+	}
+	handler(w, req)
+}
+
+// Resolve starts the gRPC and HTTP servers and listens for incoming requests.
+// It returns when the HTTP server stops.
 func (r *Resolver) Resolve() error {
-	return r.Server.ListenAndServe()
+	listener, err := net.Listen("tcp", r.GRPCAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for gRPC: %v", err)
+	}
+	go func() {
+		err := r.GRPCServer.Serve(listener)
+		if err != nil {
+			r.logger().Error("gRPC server stopped", "error", err)
+		}
+	}()
+	err = r.Server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		// Shutdown was called.
+		return nil
+	}
+	return err
+}
+
+// goWorker runs a background worker that Shutdown waits for.
+func (r *Resolver) goWorker(run func()) {
+	r.workers.Add(1)
+	go func() {
+		defer r.workers.Done()
+		run()
+	}()
+}
+
+// Shutdown stops the Resolver gracefully. Readiness checks start failing, and requests are still served
+// for DrainDelay so that load balancers stop sending new ones. Event streams and sockets are ended so that clients reconnect
+// to another replica, and the HTTP and gRPC servers stop accepting requests and wait for the ones in flight.
+// Then the background workers are stopped, the event publisher and database are closed and the remaining spans are exported.
+// If ctx is done before the servers have drained, their remaining connections are closed.
+func (r *Resolver) Shutdown(ctx context.Context) error {
+	var errs []error
+	r.shuttingDown.Store(true)
+	select {
+	case <-time.After(r.DrainDelay):
+	case <-ctx.Done():
+	}
+	r.events.close()
+
+	err := r.Server.Shutdown(ctx)
+	if err != nil {
+		r.Server.Close()
+		errs = append(errs, fmt.Errorf("failed to drain HTTP connections: %v", err))
+	}
+	if r.GRPCServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			r.GRPCServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			r.GRPCServer.Stop()
+			errs = append(errs, fmt.Errorf("failed to drain gRPC connections: %v", ctx.Err()))
+		}
+	}
+
+	if r.stopWorkers != nil {
+		r.stopWorkers()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		r.workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("failed to stop background workers: %v", ctx.Err()))
+	}
+
+	if r.publisher != nil {
+		err = r.publisher.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to close event publisher: %v", err))
+		}
+	}
+	err = r.Database.Close()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if r.stopTracing != nil {
+		err = r.stopTracing(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush spans: %v", err))
+		}
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,980 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/SevvyP/tasks_v1/internal/authtest"
+	"github.com/SevvyP/tasks_v1/internal/config"
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/internal/metrics"
+	"github.com/SevvyP/tasks_v1/internal/middleware"
+	"github.com/SevvyP/tasks_v1/internal/ratelimit"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+	tasksv1 "github.com/SevvyP/tasks_v1/pkg/pb/tasks/v1"
+)
+
+func TestShutdown(t *testing.T) {
+	mockDB := new(database.MockDatabase)
+	mockDB.On("GetLatestTaskEventID", "user-1").Return(int64(0), nil)
+	mockDB.On("GetTaskEvents", "user-1", int64(0)).Return(&[]model.TaskEvent{}, nil)
+	mockDB.On("Close").Return(nil)
+
+	resolver := &Resolver{Database: mockDB, events: newEventBroker(), DrainDelay: 500 * time.Millisecond}
+	resolver.Server.Handler = resolver.handler(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(w, withUser(req, "user-1"))
+		})
+	})
+	resolver.GRPCServer = resolver.grpcServer(grpcTestUser("user-1"))
+	client := newGRPCTestClient(t, resolver.GRPCServer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	served := make(chan error, 1)
+	go func() {
+		served <- resolver.Server.Serve(listener)
+	}()
+
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+	resolver.stopWorkers = stopWorker
+	workerStopped := false
+	resolver.goWorker(func() {
+		<-workerCtx.Done()
+		workerStopped = true
+	})
+
+	// Open an event stream over each API. Neither ends on its own.
+	resp, err := http.Get("http://" + listener.Addr().String() + "/tasks/events")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	watch, err := client.WatchTasks(context.Background(), &tasksv1.WatchTasksRequest{})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- resolver.Shutdown(ctx)
+	}()
+
+	// Requests are still served while draining, but readiness fails.
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/readyz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusServiceUnavailable
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, <-shutdown)
+
+	_, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	_, err = watch.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.ErrorIs(t, <-served, http.ErrServerClosed)
+	assert.True(t, workerStopped)
+	mockDB.AssertExpectations(t)
+}
+
+func TestRequestMetrics(t *testing.T) {
+	m := metrics.New()
+	resolver := &Resolver{Database: new(database.MockDatabase), events: newEventBroker(), metrics: m}
+	server := httptest.NewServer(resolver.handler(middleware.EnsureValidToken(&middleware.AuthConfig{Domain: "tasks.example.com", Audience: "tasks"}, m)))
+	defer server.Close()
+
+	for _, path := range []string{"/tasks", "/tasks", "/healthz", "/unknown"} {
+		resp, err := http.Get(server.URL + path)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	resp, err := http.Get(server.URL + "/metrics")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `tasks_http_requests_total{method="GET",route="/tasks",status="401"} 2`)
+	assert.Contains(t, string(body), `tasks_http_requests_total{method="GET",route="/healthz",status="200"} 1`)
+	assert.Contains(t, string(body), `tasks_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.Contains(t, string(body), `tasks_jwt_validation_failures_total{reason="missing"} 2`)
+}
+
+func TestRequestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	resolver := &Resolver{Database: new(database.MockDatabase), events: newEventBroker()}
+	server := httptest.NewServer(resolver.handler(middleware.EnsureValidToken(&middleware.AuthConfig{Domain: "tasks.example.com", Audience: "tasks"}, nil)))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/tasks", nil)
+	assert.NoError(t, err)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("Authorization", "Bearer not-a-jwt")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	request, ok := spans["GET /tasks"]
+	assert.True(t, ok)
+	validation, ok := spans["EnsureValidToken"]
+	assert.True(t, ok)
+	if request == nil || validation == nil {
+		return
+	}
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", request.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", request.Parent().SpanID().String())
+	assert.Equal(t, trace.SpanKindServer, request.SpanKind())
+	assert.Equal(t, request.SpanContext().SpanID(), validation.Parent().SpanID())
+	assert.Equal(t, "malformed", validation.Status().Description)
+}
+
+// accessLogs returns the access log records written to a JSON log.
+func accessLogs(t *testing.T, log *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range bytes.Split(bytes.TrimSpace(log.Bytes()), []byte("\n")) {
+		var record map[string]interface{}
+		assert.NoError(t, json.Unmarshal(line, &record))
+		if record["msg"] == "Request served" || record["msg"] == "Call served" {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func TestRequestLogging(t *testing.T) {
+	tests := []struct {
+		name              string
+		path              string
+		requestID         string
+		expectedRoute     string
+		expectedStatus    float64
+		expectedSub       string
+		expectedRequestID string
+	}{
+		{
+			name:              "RequestLogging_HonoursRequestID",
+			path:              "/tasks",
+			requestID:         "req-123",
+			expectedRoute:     "/tasks",
+			expectedStatus:    http.StatusOK,
+			expectedSub:       "user-1",
+			expectedRequestID: "req-123",
+		},
+		{
+			name:           "RequestLogging_InvalidRequestID",
+			path:           "/tasks",
+			requestID:      "not a valid id",
+			expectedRoute:  "/tasks",
+			expectedStatus: http.StatusOK,
+			expectedSub:    "user-1",
+		},
+		{
+			name:           "RequestLogging_Public",
+			path:           "/healthz",
+			expectedRoute:  "/healthz",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "RequestLogging_Unmatched",
+			path:           "/unknown",
+			expectedRoute:  "unmatched",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			mockDB.On("GetTasks").Return(&[]model.Task{}, nil)
+			var log bytes.Buffer
+			resolver := &Resolver{Database: mockDB, events: newEventBroker(), Logger: slog.New(slog.NewJSONHandler(&log, nil))}
+			server := httptest.NewServer(resolver.handler(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					claims := &validator.ValidatedClaims{RegisteredClaims: validator.RegisteredClaims{Subject: "user-1"}}
+					next.ServeHTTP(w, req.WithContext(middleware.WithClaims(req.Context(), claims)))
+				})
+			}))
+			defer server.Close()
+
+			req, err := http.NewRequest(http.MethodGet, server.URL+tt.path, nil)
+			assert.NoError(t, err)
+			if tt.requestID != "" {
+				req.Header.Set(middleware.RequestIDHeader, tt.requestID)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+			server.Close()
+
+			requestID := resp.Header.Get(middleware.RequestIDHeader)
+			if tt.expectedRequestID != "" {
+				assert.Equal(t, tt.expectedRequestID, requestID)
+			} else {
+				assert.NoError(t, uuid.Validate(requestID))
+			}
+			records := accessLogs(t, &log)
+			if assert.Len(t, records, 1) {
+				assert.Equal(t, "INFO", records[0]["level"])
+				assert.Equal(t, requestID, records[0]["request_id"])
+				assert.Equal(t, http.MethodGet, records[0]["method"])
+				assert.Equal(t, tt.expectedRoute, records[0]["route"])
+				assert.Equal(t, tt.expectedStatus, records[0]["status"])
+				assert.Equal(t, tt.expectedSub, records[0]["sub"])
+				assert.Contains(t, records[0], "latency_ms")
+			}
+		})
+	}
+}
+
+func TestCallLogging(t *testing.T) {
+	mockDB := new(database.MockDatabase)
+	mockDB.On("GetTasks").Return(&[]model.Task{}, nil)
+	var log bytes.Buffer
+	resolver := &Resolver{Database: mockDB, events: newEventBroker(), Logger: slog.New(slog.NewJSONHandler(&log, nil))}
+	server := resolver.grpcServer(grpcTestUser("user-1"))
+	client := newGRPCTestClient(t, server)
+
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "req-123")
+	_, err := client.ListTasks(ctx, &tasksv1.ListTasksRequest{}, grpc.Header(&header))
+	assert.NoError(t, err)
+	server.Stop()
+
+	assert.Equal(t, []string{"req-123"}, header.Get("x-request-id"))
+	records := accessLogs(t, &log)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "req-123", records[0]["request_id"])
+		assert.Equal(t, tasksv1.TasksService_ListTasks_FullMethodName, records[0]["method"])
+		assert.Equal(t, codes.OK.String(), records[0]["code"])
+		assert.Equal(t, "user-1", records[0]["sub"])
+	}
+}
+
+func TestReadYourWrites(t *testing.T) {
+	task := model.Task{ID: taskID1, Body: "Task 1"}
+	tests := []struct {
+		name          string
+		method        string
+		dbResponse    error
+		expectedToken bool
+	}{
+		{
+			name:          "ReadYourWrites_Write",
+			method:        http.MethodPost,
+			expectedToken: true,
+		},
+		{
+			name:       "ReadYourWrites_FailedWrite",
+			method:     http.MethodPost,
+			dbResponse: errors.New("database error"),
+		},
+		{
+			name:   "ReadYourWrites_Read",
+			method: http.MethodGet,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			mockDB.On("GetTaskByIDFromPrimary", taskID1).Return((*model.Task)(nil), nil)
+			mockDB.On("CreateTask", model.Task{ID: taskID1, UserID: "user-1", Body: "Task 1"}).Return(tt.dbResponse)
+			mockDB.On("GetTasks").Return(&[]model.Task{}, nil)
+			resolver := &Resolver{Database: mockDB, events: newEventBroker()}
+			server := httptest.NewServer(resolver.handler(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					next.ServeHTTP(w, withUser(req, "user-1"))
+				})
+			}))
+			defer server.Close()
+
+			body, _ := json.Marshal(task)
+			req, err := http.NewRequest(tt.method, server.URL+"/tasks", bytes.NewReader(body))
+			assert.NoError(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+
+			token := resp.Header.Get(middleware.SessionHeader)
+			if !tt.expectedToken {
+				assert.Empty(t, token)
+				assert.Empty(t, resp.Cookies())
+				return
+			}
+			millis, err := strconv.ParseInt(token, 10, 64)
+			assert.NoError(t, err)
+			assert.WithinDuration(t, time.Now(), time.UnixMilli(millis), 5*time.Second)
+			if assert.Len(t, resp.Cookies(), 1) {
+				assert.Equal(t, middleware.SessionCookie, resp.Cookies()[0].Name)
+				assert.Equal(t, token, resp.Cookies()[0].Value)
+			}
+		})
+	}
+}
+
+// deniedStore is a rate limit store whose buckets are always empty.
+type deniedStore struct{}
+
+func (deniedStore) Take(ctx context.Context, key string, budget ratelimit.Budget) (float64, bool, error) {
+	return 0, false, nil
+}
+
+func (deniedStore) Prune(ctx context.Context, updatedBefore time.Time) error {
+	return nil
+}
+
+// exhaustedLimiter returns a limiter that rejects every request.
+func exhaustedLimiter() *ratelimit.Limiter {
+	budget := ratelimit.Budget{Requests: 1, Period: config.Duration(time.Minute)}
+	return &ratelimit.Limiter{Store: deniedStore{}, Read: budget, Write: budget}
+}
+
+func TestRateLimit(t *testing.T) {
+	type request struct {
+		method         string
+		user           string
+		expectedStatus int
+	}
+	tests := []struct {
+		name                string
+		requests            []request
+		expectedRemaining   string
+		expectedRetryAfter  string
+		expectedRateLimited bool
+	}{
+		{
+			name: "RateLimit_OverBudget",
+			requests: []request{
+				{http.MethodGet, "user-1", http.StatusOK},
+				{http.MethodGet, "user-1", http.StatusOK},
+				{http.MethodGet, "user-1", http.StatusTooManyRequests},
+			},
+			expectedRemaining:   "0",
+			expectedRetryAfter:  "30",
+			expectedRateLimited: true,
+		},
+		{
+			name: "RateLimit_PerUser",
+			requests: []request{
+				{http.MethodGet, "user-1", http.StatusOK},
+				{http.MethodGet, "user-1", http.StatusOK},
+				{http.MethodGet, "user-2", http.StatusOK},
+			},
+			expectedRemaining: "1",
+		},
+		{
+			name: "RateLimit_PerIP",
+			requests: []request{
+				{http.MethodGet, "", http.StatusOK},
+				{http.MethodGet, "", http.StatusOK},
+				{http.MethodGet, "", http.StatusTooManyRequests},
+			},
+			expectedRemaining:   "0",
+			expectedRetryAfter:  "30",
+			expectedRateLimited: true,
+		},
+		{
+			name: "RateLimit_SeparateBudgets",
+			requests: []request{
+				{http.MethodGet, "user-1", http.StatusOK},
+				{http.MethodGet, "user-1", http.StatusOK},
+				{http.MethodPost, "user-1", http.StatusCreated},
+			},
+			expectedRemaining: "0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			mockDB.On("GetTasks").Return(&[]model.Task{}, nil)
+			mockDB.On("GetTaskByIDFromPrimary", taskID1).Return((*model.Task)(nil), nil)
+			mockDB.On("CreateTask", mock.Anything).Return(nil)
+			resolver := &Resolver{Database: mockDB, events: newEventBroker(), limiter: &ratelimit.Limiter{
+				Store: ratelimit.NewMemoryStore(),
+				Read:  ratelimit.Budget{Requests: 2, Period: config.Duration(time.Minute)},
+				Write: ratelimit.Budget{Requests: 1, Period: config.Duration(time.Minute)},
+			}}
+			// Requests name their user in a header, and requests without one are limited by IP address.
+			authenticate := func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					if user := req.Header.Get("X-Test-User"); user != "" {
+						req = withUser(req, user)
+					}
+					next.ServeHTTP(w, req)
+				})
+			}
+			server := httptest.NewServer(resolver.handler(authenticate))
+			defer server.Close()
+
+			var resp *http.Response
+			for _, r := range tt.requests {
+				req, err := http.NewRequest(r.method, server.URL+"/tasks", strings.NewReader(`{"id":"`+taskID1+`","body":"Task 1"}`))
+				assert.NoError(t, err)
+				req.Header.Set("X-Test-User", r.user)
+				resp, err = http.DefaultClient.Do(req)
+				assert.NoError(t, err)
+				resp.Body.Close()
+				assert.Equal(t, r.expectedStatus, resp.StatusCode)
+			}
+
+			assert.Equal(t, tt.expectedRemaining, resp.Header.Get(middleware.RateLimitRemainingHeader))
+			assert.Equal(t, tt.expectedRetryAfter, resp.Header.Get(middleware.RetryAfterHeader))
+			assert.NotEmpty(t, resp.Header.Get(middleware.RateLimitLimitHeader))
+			assert.NotEmpty(t, resp.Header.Get(middleware.RateLimitResetHeader))
+			assert.Contains(t, resp.Header.Get(middleware.RateLimitPolicyHeader), ";w=60")
+			if tt.expectedRateLimited {
+				assert.Equal(t, model.ProblemContentType, resp.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestRateLimitGRPC(t *testing.T) {
+	mockDB := new(database.MockDatabase)
+	resolver := &Resolver{Database: mockDB, events: newEventBroker(), limiter: exhaustedLimiter()}
+	client := newGRPCTestClient(t, resolver.grpcServer(grpcTestUser("user-1")))
+
+	var header metadata.MD
+	_, err := client.ListTasks(context.Background(), &tasksv1.ListTasksRequest{}, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"60"}, header.Get("retry-after"))
+	assert.Equal(t, []string{"0"}, header.Get("ratelimit-remaining"))
+	mockDB.AssertNotCalled(t, "GetTasks")
+}
+
+func TestRateLimitIP(t *testing.T) {
+	mockDB := new(database.MockDatabase)
+	resolver := &Resolver{Database: mockDB, events: newEventBroker(), limiter: &ratelimit.Limiter{
+		Store: ratelimit.NewMemoryStore(),
+		IP:    ratelimit.Budget{Requests: 2, Period: config.Duration(time.Minute)},
+	}}
+	// Requests without a token are rejected by the real middleware, after being counted against the IP budget.
+	authenticate := middleware.EnsureValidAPIKey(mockDB, nil, middleware.EnsureValidToken(&middleware.AuthConfig{Domain: "tasks.example.com", Audience: "tasks"}, nil))
+	server := httptest.NewServer(resolver.handler(authenticate))
+	defer server.Close()
+
+	var resp *http.Response
+	for _, expectedStatus := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		var err error
+		resp, err = http.Get(server.URL + "/tasks")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, expectedStatus, resp.StatusCode)
+	}
+
+	assert.Equal(t, "30", resp.Header.Get(middleware.RetryAfterHeader))
+	assert.Equal(t, "0", resp.Header.Get(middleware.RateLimitRemainingHeader))
+	assert.Equal(t, "2;w=60", resp.Header.Get(middleware.RateLimitPolicyHeader))
+	assert.Equal(t, model.ProblemContentType, resp.Header.Get("Content-Type"))
+}
+
+func TestRateLimitIPGRPC(t *testing.T) {
+	mockDB := new(database.MockDatabase)
+	budget := ratelimit.Budget{Requests: 1, Period: config.Duration(time.Minute)}
+	resolver := &Resolver{Database: mockDB, events: newEventBroker(), limiter: &ratelimit.Limiter{Store: deniedStore{}, IP: budget}}
+	authenticated := false
+	authenticate := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		authenticated = true
+		return handler(ctx, req)
+	}
+	_, stream := grpcTestUser("user-1")
+	client := newGRPCTestClient(t, resolver.grpcServer(authenticate, stream))
+
+	var header metadata.MD
+	_, err := client.ListTasks(context.Background(), &tasksv1.ListTasksRequest{}, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"60"}, header.Get("retry-after"))
+	assert.False(t, authenticated)
+	mockDB.AssertNotCalled(t, "GetTasks")
+}
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name            string
+		method          string
+		header          http.Header
+		expectedStatus  int
+		expectedHeaders map[string]string
+	}{
+		{
+			name:           "CORS_Preflight",
+			method:         http.MethodOptions,
+			header:         http.Header{"Origin": {"https://app.example.com"}, "Access-Control-Request-Method": {"POST"}},
+			expectedStatus: http.StatusNoContent,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Allow-Methods":     "GET, POST, PUT, PATCH, DELETE",
+				"Access-Control-Allow-Headers":     "Authorization, Content-Type",
+				"Access-Control-Max-Age":           "600",
+			},
+		},
+		{
+			name:           "CORS_PreflightPatch",
+			method:         http.MethodOptions,
+			header:         http.Header{"Origin": {"https://app.example.com"}, "Access-Control-Request-Method": {"PATCH"}},
+			expectedStatus: http.StatusNoContent,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.example.com",
+				"Access-Control-Allow-Methods": "GET, POST, PUT, PATCH, DELETE",
+			},
+		},
+		{
+			name:           "CORS_PreflightOtherOrigin",
+			method:         http.MethodOptions,
+			header:         http.Header{"Origin": {"https://evil.example.com"}, "Access-Control-Request-Method": {"POST"}},
+			expectedStatus: http.StatusNoContent,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "",
+				"Access-Control-Allow-Methods": "",
+			},
+		},
+		{
+			name:           "CORS_Request",
+			method:         http.MethodGet,
+			header:         http.Header{"Origin": {"https://app.example.com"}},
+			expectedStatus: http.StatusUnauthorized,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":   "https://app.example.com",
+				"Access-Control-Expose-Headers": "X-Request-ID",
+				"Access-Control-Allow-Methods":  "",
+			},
+		},
+		{
+			name:           "CORS_RequestOtherOrigin",
+			method:         http.MethodGet,
+			header:         http.Header{"Origin": {"https://evil.example.com"}},
+			expectedStatus: http.StatusUnauthorized,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":   "",
+				"Access-Control-Expose-Headers": "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &Resolver{Database: new(database.MockDatabase), events: newEventBroker(), cors: &middleware.CORSConfig{
+				AllowedOrigins:   []string{"https://app.example.com"},
+				AllowedMethods:   defaultCORSMethods,
+				AllowedHeaders:   []string{"Authorization", "Content-Type"},
+				ExposedHeaders:   []string{"X-Request-ID"},
+				AllowCredentials: true,
+				MaxAge:           config.Duration(10 * time.Minute),
+			}}
+			// Preflight requests carry no token, so they must be answered before the real middleware rejects them.
+			authenticate := middleware.EnsureValidToken(&middleware.AuthConfig{Domain: "tasks.example.com", Audience: "tasks"}, nil)
+			server := httptest.NewServer(resolver.handler(authenticate))
+			defer server.Close()
+
+			req, err := http.NewRequest(tt.method, server.URL+"/tasks", nil)
+			assert.NoError(t, err)
+			req.Header = tt.header
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+			for name, value := range tt.expectedHeaders {
+				assert.Equal(t, value, resp.Header.Get(name), name)
+			}
+			assert.Contains(t, resp.Header.Values("Vary"), "Origin")
+		})
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		hstsMaxAge  time.Duration
+		expectedCSP string
+		expectHSTS  string
+	}{
+		{name: "SecurityHeaders_API", path: "/healthz", expectedCSP: middleware.ContentSecurityPolicy},
+		{name: "SecurityHeaders_HSTS", path: "/healthz", hstsMaxAge: 365 * 24 * time.Hour, expectedCSP: middleware.ContentSecurityPolicy,
+			expectHSTS: "max-age=31536000; includeSubDomains"},
+		{name: "SecurityHeaders_NotFound", path: "/missing", expectedCSP: middleware.ContentSecurityPolicy},
+		{name: "SecurityHeaders_Docs", path: "/docs", expectedCSP: docsContentSecurityPolicy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &Resolver{Database: new(database.MockDatabase), events: newEventBroker(), hstsMaxAge: tt.hstsMaxAge}
+			server := httptest.NewServer(resolver.handler(func(next http.Handler) http.Handler { return next }))
+			defer server.Close()
+
+			resp, err := http.Get(server.URL + tt.path)
+			assert.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, "nosniff", resp.Header.Get("X-Content-Type-Options"))
+			assert.Equal(t, "DENY", resp.Header.Get("X-Frame-Options"))
+			assert.Equal(t, "no-referrer", resp.Header.Get("Referrer-Policy"))
+			assert.Equal(t, tt.expectedCSP, resp.Header.Get("Content-Security-Policy"))
+			assert.Equal(t, tt.expectHSTS, resp.Header.Get("Strict-Transport-Security"))
+		})
+	}
+}
+
+// hmacSecret is a development secret long enough to be accepted by the config.
+const hmacSecret = "0123456789abcdef0123456789abcdef"
+
+func TestAuthentication(t *testing.T) {
+	jwksIssuer := authtest.New(t)
+	pemIssuer := authtest.NewPublicKey(t)
+	hmacIssuer := authtest.NewHMAC(t, hmacSecret)
+	expired := jwksIssuer.Claims("user-1")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	otherAudience := jwksIssuer.Claims("user-1")
+	otherAudience["aud"] = []string{"billing"}
+	otherIssuer := jwksIssuer.Claims("user-1")
+	otherIssuer["iss"] = "https://tasks.example.com/"
+	enterpriseIssuer := authtest.NewPublicKey(t).WithURL("https://login.acme.example.com/")
+	enterprise := enterpriseIssuer.IssuerConfig()
+	enterprise.Claims = middleware.ClaimMapping{UserID: "oid", UserIDPrefix: "acme|"}
+	multiIssuer := &middleware.AuthConfig{Issuers: []middleware.IssuerConfig{jwksIssuer.IssuerConfig(), enterprise}}
+	enterpriseClaims := enterpriseIssuer.Claims("00000000-0000-0000-0000-000000000001")
+	enterpriseClaims["oid"] = "user-1"
+	restricted := jwksIssuer.IssuerConfig()
+	restricted.Algorithms = []string{"RS256"}
+
+	tests := []struct {
+		name           string
+		config         *middleware.AuthConfig
+		token          string
+		expectedStatus int
+		expectedUser   string
+		expectedReason string
+	}{
+		{name: "Authentication_JWKSFile", config: jwksIssuer.AuthConfig(), token: jwksIssuer.Token(t, "user-1"),
+			expectedStatus: http.StatusOK, expectedUser: "user-1"},
+		{name: "Authentication_PublicKeyFile", config: pemIssuer.AuthConfig(), token: pemIssuer.Token(t, "user-1"),
+			expectedStatus: http.StatusOK, expectedUser: "user-1"},
+		{name: "Authentication_HMACSecret", config: hmacIssuer.AuthConfig(), token: hmacIssuer.Token(t, "user-1"),
+			expectedStatus: http.StatusOK, expectedUser: "user-1"},
+		{name: "Authentication_FirstIssuer", config: multiIssuer, token: jwksIssuer.Token(t, "user-1"),
+			expectedStatus: http.StatusOK, expectedUser: "user-1"},
+		{name: "Authentication_SecondIssuer", config: multiIssuer, token: enterpriseIssuer.Sign(t, enterpriseClaims),
+			expectedStatus: http.StatusOK, expectedUser: "acme|user-1"},
+		{name: "Authentication_MissingUserID", config: multiIssuer, token: enterpriseIssuer.Token(t, "user-1"),
+			expectedStatus: http.StatusUnauthorized, expectedReason: "missing_user_id"},
+		{name: "Authentication_UnknownIssuer", config: multiIssuer, token: authtest.New(t).WithURL("https://evil.example.com/").Token(t, "user-1"),
+			expectedStatus: http.StatusUnauthorized, expectedReason: "invalid_issuer"},
+		{name: "Authentication_AlgorithmNotAllowed", config: &middleware.AuthConfig{Issuers: []middleware.IssuerConfig{restricted}},
+			token: jwksIssuer.Token(t, "user-1"), expectedStatus: http.StatusUnauthorized, expectedReason: "invalid_signature"},
+		{name: "Authentication_Missing", config: jwksIssuer.AuthConfig(), expectedStatus: http.StatusUnauthorized, expectedReason: "missing"},
+		{name: "Authentication_Expired", config: jwksIssuer.AuthConfig(), token: jwksIssuer.Sign(t, expired),
+			expectedStatus: http.StatusUnauthorized, expectedReason: "expired"},
+		{name: "Authentication_OtherAudience", config: jwksIssuer.AuthConfig(), token: jwksIssuer.Sign(t, otherAudience),
+			expectedStatus: http.StatusUnauthorized, expectedReason: "invalid_audience"},
+		{name: "Authentication_OtherIssuer", config: jwksIssuer.AuthConfig(), token: jwksIssuer.Sign(t, otherIssuer),
+			expectedStatus: http.StatusUnauthorized, expectedReason: "invalid_issuer"},
+		{name: "Authentication_OtherKey", config: jwksIssuer.AuthConfig(), token: authtest.New(t).Token(t, "user-1"),
+			expectedStatus: http.StatusUnauthorized, expectedReason: "invalid_signature"},
+		{name: "Authentication_OtherAlgorithm", config: jwksIssuer.AuthConfig(), token: hmacIssuer.Token(t, "user-1"),
+			expectedStatus: http.StatusUnauthorized, expectedReason: "invalid_signature"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			mockDB.On("GetTasks").Return(&[]model.Task{}, nil).Maybe()
+			m := metrics.New()
+			var log bytes.Buffer
+			resolver := &Resolver{Database: mockDB, events: newEventBroker(), metrics: m, Logger: slog.New(slog.NewJSONHandler(&log, nil))}
+			server := httptest.NewServer(resolver.handler(middleware.EnsureValidToken(tt.config, m)))
+			defer server.Close()
+
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/tasks", nil)
+			assert.NoError(t, err)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+			if tt.expectedUser != "" {
+				assert.Equal(t, tt.expectedUser, accessLogs(t, &log)[0]["sub"])
+			}
+
+			if tt.expectedReason != "" {
+				resp, err := http.Get(server.URL + "/metrics")
+				assert.NoError(t, err)
+				body, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				assert.NoError(t, err)
+				assert.Contains(t, string(body), `tasks_jwt_validation_failures_total{reason="`+tt.expectedReason+`"} 1`)
+			}
+		})
+	}
+}
+
+func TestAuthenticationGRPC(t *testing.T) {
+	issuer := authtest.New(t)
+	mockDB := new(database.MockDatabase)
+	mockDB.On("GetTasks").Return(&[]model.Task{}, nil).Once()
+	resolver := &Resolver{Database: mockDB, events: newEventBroker()}
+	client := newGRPCTestClient(t, resolver.grpcServer(middleware.EnsureValidTokenGRPC(issuer.AuthConfig(), nil)))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+issuer.Token(t, "user-1"))
+	_, err := client.ListTasks(ctx, &tasksv1.ListTasksRequest{})
+	assert.NoError(t, err)
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+authtest.New(t).Token(t, "user-1"))
+	_, err = client.ListTasks(ctx, &tasksv1.ListTasksRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	mockDB.AssertExpectations(t)
+}
+
+func TestAPIKeys(t *testing.T) {
+	issuer := authtest.New(t)
+	key := middleware.APIKeyPrefix + "key"
+	recently := time.Now().Add(-time.Second)
+	token := func(scopes []string, expiresIn time.Duration, lastUsedAt *time.Time) *model.APIToken {
+		return &model.APIToken{ID: "token-1", UserID: "user-1", Scopes: scopes, ExpiresAt: time.Now().Add(expiresIn), LastUsedAt: lastUsedAt}
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		apiToken       *model.APIToken
+		expectTouch    bool
+		expectedStatus int
+		expectedUser   string
+		expectedReason string
+	}{
+		{name: "APIKeys_Valid", method: http.MethodGet, path: "/tasks", token: key, apiToken: token([]string{model.ScopeRead}, time.Hour, &recently),
+			expectedStatus: http.StatusOK, expectedUser: "user-1"},
+		{name: "APIKeys_RecordsUse", method: http.MethodGet, path: "/tasks", token: key, apiToken: token([]string{model.ScopeRead}, time.Hour, nil),
+			expectTouch: true, expectedStatus: http.StatusOK, expectedUser: "user-1"},
+		{name: "APIKeys_Expired", method: http.MethodGet, path: "/tasks", token: key, apiToken: token([]string{model.ScopeRead}, -time.Hour, nil),
+			expectedStatus: http.StatusUnauthorized, expectedReason: "expired"},
+		{name: "APIKeys_Unknown", method: http.MethodGet, path: "/tasks", token: key,
+			expectedStatus: http.StatusUnauthorized, expectedReason: "unknown"},
+		{name: "APIKeys_MissingScope", method: http.MethodPost, path: "/tasks", token: key, apiToken: token([]string{model.ScopeRead}, time.Hour, &recently),
+			expectedStatus: http.StatusForbidden, expectedReason: "missing_scope"},
+		{name: "APIKeys_ManageTokens", method: http.MethodGet, path: "/tokens", token: key, apiToken: token([]string{model.ScopeRead, model.ScopeWrite}, time.Hour, &recently),
+			expectedStatus: http.StatusForbidden},
+		{name: "APIKeys_JWT", method: http.MethodGet, path: "/tasks", token: issuer.Token(t, "user-1"),
+			expectedStatus: http.StatusOK, expectedUser: "user-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			mockDB.On("GetTasks").Return(&[]model.Task{}, nil).Maybe()
+			if strings.HasPrefix(tt.token, middleware.APIKeyPrefix) {
+				mockDB.On("GetAPITokenByHash", middleware.HashAPIKey(tt.token)).Return(tt.apiToken, nil).Once()
+			}
+			if tt.expectTouch {
+				mockDB.On("TouchAPIToken", "token-1", mock.Anything).Return(nil).Once()
+			}
+			m := metrics.New()
+			var log bytes.Buffer
+			resolver := &Resolver{Database: mockDB, events: newEventBroker(), metrics: m, Logger: slog.New(slog.NewJSONHandler(&log, nil))}
+			server := httptest.NewServer(resolver.handler(middleware.EnsureValidAPIKey(mockDB, m, middleware.EnsureValidToken(issuer.AuthConfig(), m))))
+			defer server.Close()
+
+			req, err := http.NewRequest(tt.method, server.URL+tt.path, nil)
+			assert.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+			if tt.expectedUser != "" {
+				assert.Equal(t, tt.expectedUser, accessLogs(t, &log)[0]["sub"])
+			}
+
+			if tt.expectedReason != "" {
+				resp, err := http.Get(server.URL + "/metrics")
+				assert.NoError(t, err)
+				body, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				assert.NoError(t, err)
+				assert.Contains(t, string(body), `tasks_api_key_failures_total{reason="`+tt.expectedReason+`"} 1`)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAPIKeysWebSocketWrites(t *testing.T) {
+	key := middleware.APIKeyPrefix + "key"
+	mockDB := new(database.MockDatabase)
+	mockDB.On("GetAPITokenByHash", middleware.HashAPIKey(key)).
+		Return(&model.APIToken{ID: "token-1", UserID: "user-1", Scopes: []string{model.ScopeRead}, ExpiresAt: time.Now().Add(time.Hour)}, nil)
+	mockDB.On("TouchAPIToken", "token-1", mock.Anything).Return(nil)
+	mockDB.On("GetLatestTaskEventID", "user-1").Return(int64(0), nil)
+	mockDB.On("GetTaskEvents", "user-1", int64(0)).Return(&[]model.TaskEvent{}, nil).Maybe()
+	resolver := &Resolver{Database: mockDB, events: newEventBroker()}
+	server := httptest.NewServer(resolver.handler(middleware.EnsureValidAPIKey(mockDB, nil, middleware.EnsureValidToken(&middleware.AuthConfig{Domain: "tasks.example.com", Audience: "tasks"}, nil))))
+	defer server.Close()
+
+	t.Run("APIKeysWebSocketWrites_SyncSocket", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/tasks/ws", http.Header{"Authorization": {"Bearer " + key}})
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+
+		assert.NoError(t, conn.WriteJSON(model.Mutation{ID: "m1", Type: model.MutationCreate, Task: model.Task{ID: taskID1, Body: "Task 1"}}))
+		var message model.SocketMessage
+		assert.NoError(t, conn.ReadJSON(&message))
+		assert.Equal(t, model.SocketMessage{Type: model.SocketReject, MutationID: "m1", Error: "The API key does not have the write scope."}, message)
+	})
+
+	t.Run("APIKeysWebSocketWrites_GraphQL", func(t *testing.T) {
+		claims := &validator.ValidatedClaims{
+			RegisteredClaims: validator.RegisteredClaims{Subject: "user-1"},
+			CustomClaims:     &middleware.CustomClaims{Scope: model.ScopeRead, APIKeyID: "token-1"},
+		}
+		ctx := middleware.WithClaims(context.Background(), claims)
+		response := resolver.graphql().Exec(ctx, `mutation { deleteTask(id: "`+taskID1+`") }`, "", nil)
+		if assert.Len(t, response.Errors, 1) {
+			assert.Equal(t, model.ProblemForbidden, response.Errors[0].Extensions["code"])
+		}
+	})
+
+	mockDB.AssertNotCalled(t, "CreateTask", mock.Anything)
+	mockDB.AssertNotCalled(t, "DeleteTask", mock.Anything)
+}
+
+func TestAPIKeysGRPC(t *testing.T) {
+	issuer := authtest.New(t)
+	key := middleware.APIKeyPrefix + "key"
+	mockDB := new(database.MockDatabase)
+	mockDB.On("GetTasks").Return(&[]model.Task{}, nil).Once()
+	mockDB.On("GetAPITokenByHash", middleware.HashAPIKey(key)).
+		Return(&model.APIToken{ID: "token-1", UserID: "user-1", Scopes: []string{model.ScopeRead}, ExpiresAt: time.Now().Add(time.Hour)}, nil).Twice()
+	mockDB.On("TouchAPIToken", "token-1", mock.Anything).Return(nil).Once()
+	resolver := &Resolver{Database: mockDB, events: newEventBroker()}
+	unary, stream := middleware.EnsureValidTokenGRPC(issuer.AuthConfig(), nil)
+	client := newGRPCTestClient(t, resolver.grpcServer(middleware.EnsureValidAPIKeyGRPC(mockDB, nil, unary, stream)))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+key)
+	_, err := client.ListTasks(ctx, &tasksv1.ListTasksRequest{})
+	assert.NoError(t, err)
+
+	_, err = client.CreateTask(ctx, &tasksv1.CreateTaskRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	mockDB.AssertExpectations(t)
+}
+
+func TestUserProvisioning(t *testing.T) {
+	issuer := authtest.New(t)
+	profile := issuer.Claims("user-1")
+	profile["email"] = "user-1@example.com"
+	profile["name"] = "User One"
+	profile["zoneinfo"] = "Europe/Paris"
+	unknownTimezone := issuer.Claims("user-1")
+	unknownTimezone["zoneinfo"] = "Mars/Olympus_Mons"
+	enterprise := issuer.IssuerConfig()
+	enterprise.Claims = middleware.ClaimMapping{Email: "upn", Name: "given_name", Timezone: "tz"}
+	enterpriseClaims := issuer.Claims("user-1")
+	enterpriseClaims["upn"] = "user-1@acme.example.com"
+	enterpriseClaims["given_name"] = "User"
+	enterpriseClaims["tz"] = "America/New_York"
+
+	tests := []struct {
+		name         string
+		config       *middleware.AuthConfig
+		token        string
+		upsertErr    error
+		expectedUser model.User
+		// expectedUpserts is how many of two requests provision the user.
+		expectedUpserts int
+	}{
+		{name: "UserProvisioning_Profile", config: issuer.AuthConfig(), token: issuer.Sign(t, profile), expectedUpserts: 1,
+			expectedUser: model.User{ID: "user-1", Email: "user-1@example.com", Name: "User One", Timezone: "Europe/Paris"}},
+		{name: "UserProvisioning_NoProfile", config: issuer.AuthConfig(), token: issuer.Token(t, "user-1"), expectedUpserts: 1,
+			expectedUser: model.User{ID: "user-1"}},
+		{name: "UserProvisioning_UnknownTimezone", config: issuer.AuthConfig(), token: issuer.Sign(t, unknownTimezone), expectedUpserts: 1,
+			expectedUser: model.User{ID: "user-1"}},
+		{name: "UserProvisioning_ClaimMapping", config: &middleware.AuthConfig{Issuers: []middleware.IssuerConfig{enterprise}},
+			token: issuer.Sign(t, enterpriseClaims), expectedUpserts: 1,
+			expectedUser: model.User{ID: "user-1", Email: "user-1@acme.example.com", Name: "User", Timezone: "America/New_York"}},
+		{name: "UserProvisioning_Error", config: issuer.AuthConfig(), token: issuer.Token(t, "user-1"), upsertErr: errors.New("database unavailable"),
+			expectedUpserts: 2, expectedUser: model.User{ID: "user-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			mockDB.On("GetTasks").Return(&[]model.Task{}, nil).Twice()
+			mockDB.On("UpsertUser", tt.expectedUser).Return(tt.upsertErr).Times(tt.expectedUpserts)
+			resolver := &Resolver{Database: mockDB, events: newEventBroker(), users: middleware.NewUserProvisioner(mockDB)}
+			server := httptest.NewServer(resolver.handler(middleware.EnsureValidToken(tt.config, nil)))
+			defer server.Close()
+
+			for range 2 {
+				req, err := http.NewRequest(http.MethodGet, server.URL+"/tasks", nil)
+				assert.NoError(t, err)
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+				resp, err := http.DefaultClient.Do(req)
+				assert.NoError(t, err)
+				resp.Body.Close()
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUserProvisioningGRPC(t *testing.T) {
+	issuer := authtest.New(t)
+	mockDB := new(database.MockDatabase)
+	mockDB.On("GetTasks").Return(&[]model.Task{}, nil).Once()
+	mockDB.On("UpsertUser", model.User{ID: "user-1"}).Return(nil).Once()
+	resolver := &Resolver{Database: mockDB, events: newEventBroker(), users: middleware.NewUserProvisioner(mockDB)}
+	client := newGRPCTestClient(t, resolver.grpcServer(middleware.EnsureValidTokenGRPC(issuer.AuthConfig(), nil)))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+issuer.Token(t, "user-1"))
+	_, err := client.ListTasks(ctx, &tasksv1.ListTasksRequest{})
+	assert.NoError(t, err)
+	mockDB.AssertExpectations(t)
+}
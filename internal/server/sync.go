@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/internal/middleware"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// PullTaskChanges returns the caller's tasks that changed, and the IDs of the tasks that were deleted,
+// since the sync token in the "since" query parameter. Without a token every task is returned.
+// The response contains a new token to send as "since" on the next pull.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If the token is not valid, an HTTP 400 Bad Request is returned.
+// If there is an error retrieving the changes from the database, an HTTP 500 Internal Server Error is returned.
+func (r *Resolver) PullTaskChanges(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUserID(req.Context())
+	if user == "" {
+		middleware.WriteProblem(w, req, http.StatusUnauthorized, model.ProblemUnauthorized, "The token does not identify a user.")
+		return
+	}
+
+	var since int64
+	if token := req.URL.Query().Get("since"); token != "" {
+		var err error
+		since, err = strconv.ParseInt(token, 10, 64)
+		if err != nil || since < 0 {
+			middleware.WriteProblem(w, req, http.StatusBadRequest, model.ProblemInvalidRequest, "Invalid sync token")
+			return
+		}
+	}
+
+	changes, err := r.Database.GetTaskChanges(req.Context(), user, since)
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model.SyncResponse{
+		Tasks:   changes.Tasks,
+		Deleted: changes.Deleted,
+		Token:   strconv.FormatInt(changes.Sequence, 10),
+	})
+}
+
+// PushTaskChanges applies edits the caller made while offline, resolving conflicts with changes made on
+// the server using the requested strategy, which defaults to last-writer-wins. The response lists the
+// IDs of the applied edits and a conflict for each edit that was not applied.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If the request body, strategy or a changed field name is not valid, an HTTP 400 Bad Request is returned.
+// If the request body is larger than 1 MiB, an HTTP 413 Payload Too Large is returned.
+// If there is an error reading or writing tasks in the database, an HTTP 500 Internal Server Error is returned.
+func (r *Resolver) PushTaskChanges(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUserID(req.Context())
+	if user == "" {
+		middleware.WriteProblem(w, req, http.StatusUnauthorized, model.ProblemUnauthorized, "The token does not identify a user.")
+		return
+	}
+
+	body, ok := readRequestBody(w, req, maxSyncRequestBytes)
+	if !ok {
+		return
+	}
+	var sync model.SyncRequest
+	if !decodeStrict(w, req, body, &sync) {
+		return
+	}
+	if sync.Strategy == "" {
+		sync.Strategy = model.SyncLastWriterWins
+	}
+	if sync.Strategy != model.SyncLastWriterWins && sync.Strategy != model.SyncFieldMerge {
+		middleware.WriteProblem(w, req, http.StatusBadRequest, model.ProblemValidationFailed, fmt.Sprintf("Unknown strategy %q", sync.Strategy))
+		return
+	}
+	for _, change := range sync.Changes {
+		for _, field := range change.Fields {
+			if !mergeableFields[field] {
+				middleware.WriteProblem(w, req, http.StatusBadRequest, model.ProblemValidationFailed, fmt.Sprintf("Unknown field %q", field))
+				return
+			}
+		}
+	}
+
+	result := model.SyncResult{
+		Applied:   []string{},
+		Conflicts: []model.SyncConflict{},
+	}
+	for _, change := range sync.Changes {
+		change.Task.UserID = user
+		if !isUUID(change.Task.ID) {
+			result.Conflicts = append(result.Conflicts, model.SyncConflict{TaskID: change.Task.ID, Reason: model.SyncConflictRejected, Message: "id must be a UUID"})
+			continue
+		}
+		current, err := r.Database.GetTaskByIDFromPrimary(req.Context(), change.Task.ID)
+		if err != nil {
+			middleware.WriteInternalError(w, req, err)
+			return
+		}
+		if current != nil && current.UserID != user {
+			result.Conflicts = append(result.Conflicts, model.SyncConflict{TaskID: change.Task.ID, Reason: model.SyncConflictForbidden})
+			continue
+		}
+
+		mutation, task, conflict := resolveSyncChange(sync.Strategy, change, current)
+		if conflict != nil {
+			result.Conflicts = append(result.Conflicts, *conflict)
+			continue
+		}
+		if mutation != "" {
+			// The edit was resolved against current, so it is only applied while the task is still at that version.
+			task.Version = 0
+			if current != nil {
+				task.Version = current.Version
+			}
+			err = r.applyMutationAtVersion(req.Context(), mutation, task)
+			if errors.Is(err, database.ErrTaskChanged) {
+				conflict, err := r.changedSyncConflict(req.Context(), task.ID)
+				if err != nil {
+					middleware.WriteInternalError(w, req, err)
+					return
+				}
+				result.Conflicts = append(result.Conflicts, *conflict)
+				continue
+			}
+			var mutationErr *mutationError
+			if errors.As(err, &mutationErr) {
+				result.Conflicts = append(result.Conflicts, model.SyncConflict{TaskID: task.ID, Reason: model.SyncConflictRejected, Message: mutationErr.Error()})
+				continue
+			}
+			if err != nil {
+				writeMutationError(w, req, err)
+				return
+			}
+		}
+		result.Applied = append(result.Applied, change.Task.ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// changedSyncConflict returns the conflict for an edit that was not applied because the task changed after it was
+// resolved: the task was either deleted or is now stale.
+func (r *Resolver) changedSyncConflict(ctx context.Context, id string) (*model.SyncConflict, error) {
+	current, err := r.Database.GetTaskByIDFromPrimary(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return &model.SyncConflict{TaskID: id, Reason: model.SyncConflictDeleted}, nil
+	}
+	return &model.SyncConflict{TaskID: id, Reason: model.SyncConflictStale, Task: current}, nil
+}
+
+// mergeableFields are the JSON names of the task fields a client may list as changed.
+var mergeableFields = map[string]bool{
+	"body":      true,
+	"completed": true,
+	"parent":    true,
+	"reminder":  true,
+}
+
+// resolveSyncChange decides how to apply an offline edit, given the server's current task or nil if it does not exist.
+// It returns the mutation to apply and the task to apply it with, an empty mutation if there is nothing to do,
+// or a conflict if the edit should not be applied.
+func resolveSyncChange(strategy string, change model.SyncChange, current *model.Task) (string, model.Task, *model.SyncConflict) {
+	if current == nil {
+		switch {
+		case change.Deleted:
+			return "", change.Task, nil
+		case change.BaseVersion > 0:
+			return "", change.Task, &model.SyncConflict{TaskID: change.Task.ID, Reason: model.SyncConflictDeleted}
+		default:
+			return model.MutationCreate, change.Task, nil
+		}
+	}
+
+	task := change.Task
+	if strategy == model.SyncFieldMerge && len(change.Fields) > 0 {
+		task = mergeFields(*current, change.Task, change.Fields)
+	}
+
+	// The task has changed on the server since the client's base version.
+	if current.Version > change.BaseVersion {
+		merged := strategy == model.SyncFieldMerge && len(change.Fields) > 0 && !change.Deleted
+		clientNewer := current.UpdatedAt == nil || change.ModifiedAt.After(*current.UpdatedAt)
+		if !merged && !clientNewer {
+			return "", task, &model.SyncConflict{TaskID: change.Task.ID, Reason: model.SyncConflictStale, Task: current}
+		}
+	}
+
+	if change.Deleted {
+		return model.MutationDelete, task, nil
+	}
+	return model.MutationUpdate, task, nil
+}
+
+// mergeFields returns base with the named fields copied from edit.
+func mergeFields(base model.Task, edit model.Task, fields []string) model.Task {
+	for _, field := range fields {
+		switch field {
+		case "body":
+			base.Body = edit.Body
+		case "completed":
+			base.Completed = edit.Completed
+		case "parent":
+			base.Parent = edit.Parent
+		case "reminder":
+			base.Reminder = edit.Reminder
+		}
+	}
+	return base
+}
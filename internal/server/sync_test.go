@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+func TestPullTaskChangesHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		since          string
+		dbSince        int64
+		dbResponse     *model.TaskChanges
+		dbError        error
+		expectedStatus int
+		expectedBody   interface{}
+	}{
+		{
+			name:    "PullTaskChanges_Success",
+			since:   "10",
+			dbSince: 10,
+			dbResponse: &model.TaskChanges{
+				Tasks:    []model.Task{{ID: taskID1, UserID: "user-1", Body: "Task 1", Version: 12}},
+				Deleted:  []string{"2"},
+				Sequence: 13,
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: model.SyncResponse{
+				Tasks:   []model.Task{{ID: taskID1, UserID: "user-1", Body: "Task 1", Version: 12}},
+				Deleted: []string{"2"},
+				Token:   "13",
+			},
+		},
+		{
+			name:           "PullTaskChanges_NoToken",
+			since:          "",
+			dbSince:        0,
+			dbResponse:     &model.TaskChanges{Tasks: []model.Task{}, Deleted: []string{}, Sequence: 0},
+			expectedStatus: http.StatusOK,
+			expectedBody:   model.SyncResponse{Tasks: []model.Task{}, Deleted: []string{}, Token: "0"},
+		},
+		{
+			name:           "PullTaskChanges_InvalidToken",
+			since:          "abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   nil,
+		},
+		{
+			name:           "PullTaskChanges_Error",
+			since:          "10",
+			dbSince:        10,
+			dbResponse:     nil,
+			dbError:        fmt.Errorf("database error"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			if tt.dbResponse != nil || tt.dbError != nil {
+				mockDB.On("GetTaskChanges", "user-1", tt.dbSince).Return(tt.dbResponse, tt.dbError)
+			}
+			resolver := &Resolver{Database: mockDB}
+
+			req, err := http.NewRequest("GET", "/sync?since="+tt.since, nil)
+			assert.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(resolver.PullTaskChanges)
+			handler.ServeHTTP(rr, withUser(req, "user-1"))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedBody != nil {
+				var responseBody model.SyncResponse
+				err = json.NewDecoder(rr.Body).Decode(&responseBody)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedBody, responseBody)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPushTaskChangesHandler(t *testing.T) {
+	created := model.Task{ID: taskID1, UserID: "user-1", Body: "New"}
+	stale := model.Task{ID: taskID2, UserID: "user-1", Body: "Server", Version: 9, UpdatedAt: timePtr(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))}
+	other := model.Task{ID: taskID3, UserID: "user-2", Body: "Other"}
+
+	mockDB := new(database.MockDatabase)
+	mockDB.On("GetTaskByIDFromPrimary", taskID1).Return((*model.Task)(nil), nil)
+	mockDB.On("GetTaskByIDFromPrimary", taskID2).Return(&stale, nil)
+	mockDB.On("GetTaskByIDFromPrimary", taskID3).Return(&other, nil)
+	mockDB.On("CreateTask", created).Return(nil)
+	resolver := &Resolver{Database: mockDB}
+
+	body, _ := json.Marshal(model.SyncRequest{
+		Changes: []model.SyncChange{
+			{Task: model.Task{ID: taskID1, Body: "New"}},
+			{Task: model.Task{ID: taskID2, Body: "Client"}, BaseVersion: 5, ModifiedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{Task: model.Task{ID: taskID3, Body: "Mine"}, BaseVersion: 1},
+		},
+	})
+	req, err := http.NewRequest("POST", "/sync", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(resolver.PushTaskChanges)
+	handler.ServeHTTP(rr, withUser(req, "user-1"))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var result model.SyncResult
+	err = json.NewDecoder(rr.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{taskID1}, result.Applied)
+	assert.Equal(t, []model.SyncConflict{
+		{TaskID: taskID2, Reason: model.SyncConflictStale, Task: &stale},
+		{TaskID: taskID3, Reason: model.SyncConflictForbidden},
+	}, result.Conflicts)
+	mockDB.AssertExpectations(t)
+}
+
+func TestPushTaskChangesHandler_UnknownField(t *testing.T) {
+	mockDB := new(database.MockDatabase)
+	resolver := &Resolver{Database: mockDB}
+
+	body := `{"changes":[{"task":{"id":"` + taskID1 + `","body":"New","colour":"red"}}]}`
+	req, err := http.NewRequest("POST", "/sync", strings.NewReader(body))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(resolver.PushTaskChanges)
+	handler.ServeHTTP(rr, withUser(req, "user-1"))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	var problem model.Problem
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&problem))
+	assert.Equal(t, model.ProblemInvalidRequest, problem.Code)
+	assert.Equal(t, []model.FieldError{{Field: "colour", Message: "is not a known field"}}, problem.Errors)
+	mockDB.AssertNotCalled(t, "CreateTask", mock.Anything)
+}
+
+func TestPushTaskChangesHandler_ChangedWhileApplying(t *testing.T) {
+	read := model.Task{ID: taskID1, UserID: "user-1", Body: "Server", Version: 9}
+	changed := model.Task{ID: taskID1, UserID: "user-1", Body: "Changed", Version: 10}
+	deleted := model.Task{ID: taskID2, UserID: "user-1", Body: "Server", Version: 4}
+
+	mockDB := new(database.MockDatabase)
+	mockDB.On("GetTaskByIDFromPrimary", taskID1).Return(&read, nil).Twice()
+	mockDB.On("GetTaskByIDFromPrimary", taskID1).Return(&changed, nil).Once()
+	mockDB.On("GetTaskByIDFromPrimary", taskID2).Return(&deleted, nil).Twice()
+	mockDB.On("GetTaskByIDFromPrimary", taskID2).Return((*model.Task)(nil), nil).Once()
+	mockDB.On("UpdateTask", model.Task{ID: taskID1, UserID: "user-1", Body: "Client", Version: 9}).Return(database.ErrTaskChanged)
+	mockDB.On("DeleteTask", model.Task{ID: taskID2, UserID: "user-1", Version: 4}).Return(database.ErrTaskChanged)
+	resolver := &Resolver{Database: mockDB}
+
+	body, _ := json.Marshal(model.SyncRequest{
+		Changes: []model.SyncChange{
+			{Task: model.Task{ID: taskID1, Body: "Client"}, BaseVersion: 9},
+			{Task: model.Task{ID: taskID2}, Deleted: true, BaseVersion: 4},
+		},
+	})
+	req, err := http.NewRequest("POST", "/sync", bytes.NewBuffer(body))
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(resolver.PushTaskChanges)
+	handler.ServeHTTP(rr, withUser(req, "user-1"))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var result model.SyncResult
+	err = json.NewDecoder(rr.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Applied)
+	assert.Equal(t, []model.SyncConflict{
+		{TaskID: taskID1, Reason: model.SyncConflictStale, Task: &changed},
+		{TaskID: taskID2, Reason: model.SyncConflictDeleted},
+	}, result.Conflicts)
+	mockDB.AssertExpectations(t)
+}
+
+func TestResolveSyncChange(t *testing.T) {
+	serverTime := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	current := &model.Task{ID: taskID1, UserID: "user-1", Body: "Server", Completed: false, Version: 9, UpdatedAt: &serverTime}
+
+	tests := []struct {
+		name             string
+		strategy         string
+		change           model.SyncChange
+		current          *model.Task
+		expectedMutation string
+		expectedTask     model.Task
+		expectedConflict string
+	}{
+		{
+			name:             "Create",
+			strategy:         model.SyncLastWriterWins,
+			change:           model.SyncChange{Task: model.Task{ID: taskID1, Body: "New"}},
+			current:          nil,
+			expectedMutation: model.MutationCreate,
+			expectedTask:     model.Task{ID: taskID1, Body: "New"},
+		},
+		{
+			name:             "DeletedOnServer",
+			strategy:         model.SyncLastWriterWins,
+			change:           model.SyncChange{Task: model.Task{ID: taskID1, Body: "Edit"}, BaseVersion: 3},
+			current:          nil,
+			expectedConflict: model.SyncConflictDeleted,
+		},
+		{
+			name:             "AlreadyDeleted",
+			strategy:         model.SyncLastWriterWins,
+			change:           model.SyncChange{Task: model.Task{ID: taskID1}, Deleted: true, BaseVersion: 3},
+			current:          nil,
+			expectedMutation: "",
+			expectedTask:     model.Task{ID: taskID1},
+		},
+		{
+			name:             "UpdateWithoutConflict",
+			strategy:         model.SyncLastWriterWins,
+			change:           model.SyncChange{Task: model.Task{ID: taskID1, Body: "Edit"}, BaseVersion: 9},
+			current:          current,
+			expectedMutation: model.MutationUpdate,
+			expectedTask:     model.Task{ID: taskID1, Body: "Edit"},
+		},
+		{
+			name:             "LastWriterWins_ClientNewer",
+			strategy:         model.SyncLastWriterWins,
+			change:           model.SyncChange{Task: model.Task{ID: taskID1, Body: "Edit"}, BaseVersion: 5, ModifiedAt: serverTime.Add(time.Minute)},
+			current:          current,
+			expectedMutation: model.MutationUpdate,
+			expectedTask:     model.Task{ID: taskID1, Body: "Edit"},
+		},
+		{
+			name:             "LastWriterWins_ServerNewer",
+			strategy:         model.SyncLastWriterWins,
+			change:           model.SyncChange{Task: model.Task{ID: taskID1, Body: "Edit"}, BaseVersion: 5, ModifiedAt: serverTime.Add(-time.Minute)},
+			current:          current,
+			expectedConflict: model.SyncConflictStale,
+		},
+		{
+			name:             "LastWriterWins_DeleteServerNewer",
+			strategy:         model.SyncLastWriterWins,
+			change:           model.SyncChange{Task: model.Task{ID: taskID1}, Deleted: true, BaseVersion: 5, ModifiedAt: serverTime.Add(-time.Minute)},
+			current:          current,
+			expectedConflict: model.SyncConflictStale,
+		},
+		{
+			name:             "FieldMerge_ServerNewer",
+			strategy:         model.SyncFieldMerge,
+			change:           model.SyncChange{Task: model.Task{ID: taskID1, Body: "Edit", Completed: true}, BaseVersion: 5, ModifiedAt: serverTime.Add(-time.Minute), Fields: []string{"completed"}},
+			current:          current,
+			expectedMutation: model.MutationUpdate,
+			expectedTask:     model.Task{ID: taskID1, UserID: "user-1", Body: "Server", Completed: true, Version: 9, UpdatedAt: &serverTime},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mutation, task, conflict := resolveSyncChange(tt.strategy, tt.change, tt.current)
+			if tt.expectedConflict != "" {
+				assert.NotNil(t, conflict)
+				assert.Equal(t, tt.expectedConflict, conflict.Reason)
+				return
+			}
+			assert.Nil(t, conflict)
+			assert.Equal(t, tt.expectedMutation, mutation)
+			assert.Equal(t, tt.expectedTask, task)
+		})
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
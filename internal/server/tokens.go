@@ -0,0 +1,216 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/SevvyP/tasks_v1/internal/middleware"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+const (
+	// defaultTokenLifetime is how long an API token created without an expiry is valid for.
+	defaultTokenLifetime = 90 * 24 * time.Hour
+	// maxTokenLifetime is the furthest in the future an API token may expire.
+	maxTokenLifetime = 365 * 24 * time.Hour
+	// maxTokenNameLength is the most characters the name of an API token can have.
+	maxTokenNameLength = 100
+)
+
+// tokenScopes are the scopes an API token can be granted.
+var tokenScopes = []string{model.ScopeRead, model.ScopeWrite}
+
+// GetTokens retrieves the caller's API tokens from the database and sends them as a JSON response.
+// The tokens themselves are not included.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If the caller is authenticated with an API token, an HTTP 403 Forbidden is returned.
+// If there is an error retrieving the tokens from the database, an HTTP 500 Internal Server Error is returned.
+func (r *Resolver) GetTokens(w http.ResponseWriter, req *http.Request) {
+	user, ok := tokenOwner(w, req)
+	if !ok {
+		return
+	}
+
+	tokens, err := r.Database.GetAPITokensByUserID(req.Context(), user)
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// CreateToken creates an API token for the caller based on the JSON request body, generating its ID and secret.
+// A token created without an expiry expires after 90 days.
+// If the token is created successfully, an HTTP 201 Created response is returned with the token as JSON.
+// This is the only response that includes the secret, which is not stored.
+// If the body is not valid JSON or has unknown fields, or the name, scopes or expiry are not valid,
+// an HTTP 400 Bad Request is returned.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If the caller is authenticated with an API token, an HTTP 403 Forbidden is returned.
+// If the request body is larger than 16 KiB, an HTTP 413 Payload Too Large is returned.
+// If there is an error creating the token, an HTTP 500 Internal Server Error is returned.
+func (r *Resolver) CreateToken(w http.ResponseWriter, req *http.Request) {
+	user, ok := tokenOwner(w, req)
+	if !ok {
+		return
+	}
+
+	body, ok := readRequestBody(w, req, maxTokenRequestBytes)
+	if !ok {
+		return
+	}
+	var token model.APIToken
+	if !decodeStrict(w, req, body, &token) {
+		return
+	}
+	now := time.Now().UTC()
+	err := validateAPIToken(&token, now)
+	if err != nil {
+		middleware.WriteProblem(w, req, http.StatusBadRequest, model.ProblemValidationFailed, err.Error())
+		return
+	}
+
+	token.ID = uuid.NewString()
+	token.UserID = user
+	token.Token, err = newAPIKey()
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return
+	}
+	token.Hash = middleware.HashAPIKey(token.Token)
+	token.LastUsedAt = nil
+	token.CreatedAt = now
+
+	err = r.Database.CreateAPIToken(req.Context(), token)
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(token)
+}
+
+// DeleteToken revokes one of the caller's API tokens based on the JSON request body.
+// Requests made with the token are rejected as soon as it is deleted.
+// If the token is deleted successfully, an HTTP 200 OK response is returned.
+// If the body is not valid JSON or has unknown fields, an HTTP 400 Bad Request is returned.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If the caller is authenticated with an API token, an HTTP 403 Forbidden is returned.
+// If the token does not exist or belongs to another user, an HTTP 404 Not Found is returned.
+// If the request body is larger than 16 KiB, an HTTP 413 Payload Too Large is returned.
+// If there is an error deleting the token, an HTTP 500 Internal Server Error is returned.
+func (r *Resolver) DeleteToken(w http.ResponseWriter, req *http.Request) {
+	user, ok := tokenOwner(w, req)
+	if !ok {
+		return
+	}
+
+	body, ok := readRequestBody(w, req, maxTokenRequestBytes)
+	if !ok {
+		return
+	}
+	var tokenToDelete model.APIToken
+	if !decodeStrict(w, req, body, &tokenToDelete) {
+		return
+	}
+	if _, ok := r.getOwnedToken(w, req, user, tokenToDelete.ID); !ok {
+		return
+	}
+
+	err := r.Database.DeleteAPIToken(req.Context(), tokenToDelete)
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "API token deleted successfully")
+}
+
+// tokenOwner returns the caller whose API tokens a request manages. Tokens can only be managed by a signed-in
+// user, so that a leaked token cannot be used to create more tokens or outlive its own revocation.
+// If there is no such caller, an error response is written and false is returned.
+func tokenOwner(w http.ResponseWriter, req *http.Request) (string, bool) {
+	user := middleware.GetUserID(req.Context())
+	if user == "" {
+		middleware.WriteProblem(w, req, http.StatusUnauthorized, model.ProblemUnauthorized, "The token does not identify a user.")
+		return "", false
+	}
+	if middleware.GetAPIKeyID(req.Context()) != "" {
+		middleware.WriteProblem(w, req, http.StatusForbidden, model.ProblemForbidden, "API tokens cannot be managed with an API token.")
+		return "", false
+	}
+	return user, true
+}
+
+// getOwnedToken retrieves the API token with the given ID if it belongs to the user.
+// If it does not, an error response is written and false is returned.
+func (r *Resolver) getOwnedToken(w http.ResponseWriter, req *http.Request, user string, id string) (*model.APIToken, bool) {
+	if !isUUID(id) {
+		middleware.WriteProblem(w, req, http.StatusNotFound, model.ProblemTokenNotFound, "API token not found")
+		return nil, false
+	}
+
+	token, err := r.Database.GetAPITokenByID(req.Context(), id)
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return nil, false
+	}
+	if token == nil || token.UserID != user {
+		middleware.WriteProblem(w, req, http.StatusNotFound, model.ProblemTokenNotFound, "API token not found")
+		return nil, false
+	}
+
+	return token, true
+}
+
+// validateAPIToken checks that the API token has a name, only known scopes and an expiry within maxTokenLifetime
+// of now. A token without an expiry is given one defaultTokenLifetime from now.
+func validateAPIToken(token *model.APIToken, now time.Time) error {
+	if token.Name == "" || utf8.RuneCountInString(token.Name) > maxTokenNameLength {
+		return fmt.Errorf("API token name must be between 1 and %d characters", maxTokenNameLength)
+	}
+
+	if len(token.Scopes) == 0 {
+		return fmt.Errorf("API token must have at least one scope")
+	}
+	for _, scope := range token.Scopes {
+		if !slices.Contains(tokenScopes, scope) {
+			return fmt.Errorf("Unknown API token scope %q", scope)
+		}
+	}
+	slices.Sort(token.Scopes)
+	token.Scopes = slices.Compact(token.Scopes)
+
+	if token.ExpiresAt.IsZero() {
+		token.ExpiresAt = now.Add(defaultTokenLifetime)
+		return nil
+	}
+	if !token.ExpiresAt.After(now) || token.ExpiresAt.After(now.Add(maxTokenLifetime)) {
+		return fmt.Errorf("API token must expire within %d days", int(maxTokenLifetime/(24*time.Hour)))
+	}
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	return nil
+}
+
+// newAPIKey generates the secret of an API token.
+func newAPIKey() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate API token: %v", err)
+	}
+	return middleware.APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/SevvyP/tasks_v1/internal/middleware"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// maxUserNameLength is the most characters the name of a user can have.
+const maxUserNameLength = 100
+
+// GetMe retrieves the caller's profile and preferences from the database and sends them as a JSON response.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If the caller has not been provisioned, an HTTP 404 Not Found is returned.
+// If there is an error retrieving the user from the database, an HTTP 500 Internal Server Error is returned.
+func (r *Resolver) GetMe(w http.ResponseWriter, req *http.Request) {
+	user, ok := r.getCaller(w, req)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// UpdateMe changes the caller's preferences based on the JSON request body. Fields left out are not changed,
+// and the email address, which comes from the caller's token, cannot be changed.
+// If the preferences are updated successfully, an HTTP 200 OK response is returned with the user as JSON.
+// If the body is not valid JSON or has unknown fields, an HTTP 400 Bad Request is returned.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If the caller has not been provisioned, an HTTP 404 Not Found is returned.
+// If the request body is larger than 16 KiB, an HTTP 413 Payload Too Large is returned.
+// If the name or timezone are not valid, an HTTP 422 Unprocessable Entity is returned.
+// If there is an error updating the user, an HTTP 500 Internal Server Error is returned.
+func (r *Resolver) UpdateMe(w http.ResponseWriter, req *http.Request) {
+	body, ok := readRequestBody(w, req, maxUserRequestBytes)
+	if !ok {
+		return
+	}
+	var update model.UserUpdate
+	if !decodeStrict(w, req, body, &update) {
+		return
+	}
+	if fields := validateUserUpdate(update); len(fields) > 0 {
+		middleware.WriteProblem(w, req, http.StatusUnprocessableEntity, model.ProblemValidationFailed, "The user is not valid", fields...)
+		return
+	}
+
+	user, ok := r.getCaller(w, req)
+	if !ok {
+		return
+	}
+	if update.Name != nil {
+		user.Name = *update.Name
+	}
+	if update.Timezone != nil {
+		user.Timezone = *update.Timezone
+	}
+	user.UpdatedAt = time.Now().UTC()
+
+	err := r.Database.UpdateUser(req.Context(), *user)
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// getCaller retrieves the user who made the request.
+// If there is no such user, an error response is written and false is returned.
+func (r *Resolver) getCaller(w http.ResponseWriter, req *http.Request) (*model.User, bool) {
+	id := middleware.GetUserID(req.Context())
+	if id == "" {
+		middleware.WriteProblem(w, req, http.StatusUnauthorized, model.ProblemUnauthorized, "The token does not identify a user.")
+		return nil, false
+	}
+
+	user, err := r.Database.GetUser(req.Context(), id)
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return nil, false
+	}
+	if user == nil {
+		middleware.WriteProblem(w, req, http.StatusNotFound, model.ProblemUserNotFound, "User not found")
+		return nil, false
+	}
+
+	return user, true
+}
+
+// validateUserUpdate checks the preferences sent to UpdateMe, returning an error for each field that is not valid.
+// The timezone may be empty, which means UTC.
+func validateUserUpdate(update model.UserUpdate) []model.FieldError {
+	var fields []model.FieldError
+	if update.Name != nil && utf8.RuneCountInString(*update.Name) > maxUserNameLength {
+		fields = append(fields, model.FieldError{Field: "name", Message: fmt.Sprintf("must be at most %d characters", maxUserNameLength)})
+	}
+	if update.Timezone != nil && *update.Timezone != "" && !middleware.ValidTimezone(*update.Timezone) {
+		fields = append(fields, model.FieldError{Field: "timezone", Message: "must be an IANA timezone name, such as Europe/Paris"})
+	}
+	return fields
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+func TestUpdateMeHandler(t *testing.T) {
+	user := model.User{ID: "user-1", Email: "user-1@example.com", Name: "User One", Timezone: "Europe/Paris"}
+
+	tests := []struct {
+		name           string
+		body           string
+		expectUpdate   bool
+		expectedStatus int
+		expectedUser   model.User
+	}{
+		{
+			name:           "UpdateMe_Timezone",
+			body:           `{"timezone":"America/New_York"}`,
+			expectUpdate:   true,
+			expectedStatus: http.StatusOK,
+			expectedUser:   model.User{ID: "user-1", Email: "user-1@example.com", Name: "User One", Timezone: "America/New_York"},
+		},
+		{
+			name:           "UpdateMe_ClearTimezone",
+			body:           `{"name":"One","timezone":""}`,
+			expectUpdate:   true,
+			expectedStatus: http.StatusOK,
+			expectedUser:   model.User{ID: "user-1", Email: "user-1@example.com", Name: "One"},
+		},
+		{
+			name:           "UpdateMe_Email",
+			body:           `{"email":"other@example.com"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "UpdateMe_LocalTimezone",
+			body:           `{"timezone":"Local"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "UpdateMe_LongName",
+			body:           `{"name":"` + string(bytes.Repeat([]byte("a"), maxUserNameLength+1)) + `"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			if tt.expectUpdate {
+				current := user
+				mockDB.On("GetUser", "user-1").Return(&current, nil)
+				mockDB.On("UpdateUser", mock.MatchedBy(func(updated model.User) bool {
+					return updated.Name == tt.expectedUser.Name && updated.Timezone == tt.expectedUser.Timezone
+				})).Return(nil)
+			}
+			resolver := &Resolver{Database: mockDB}
+
+			req, err := http.NewRequest("PATCH", "/me", bytes.NewBufferString(tt.body))
+			assert.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(resolver.UpdateMe)
+			handler.ServeHTTP(rr, withUser(req, "user-1"))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectUpdate {
+				var responseBody model.User
+				err = json.NewDecoder(rr.Body).Decode(&responseBody)
+				assert.NoError(t, err)
+				responseBody.UpdatedAt = tt.expectedUser.UpdatedAt
+				assert.Equal(t, tt.expectedUser, responseBody)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
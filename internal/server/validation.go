@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/SevvyP/tasks_v1/internal/middleware"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+const (
+	// maxTaskRequestBytes is the largest request body accepted by the task handlers.
+	maxTaskRequestBytes = 64 << 10
+	// maxSyncRequestBytes is the largest batch of changes accepted by PushTaskChanges.
+	maxSyncRequestBytes = 1 << 20
+	// maxWebhookRequestBytes is the largest request body accepted by the webhook handlers.
+	maxWebhookRequestBytes = 16 << 10
+	// maxTokenRequestBytes is the largest request body accepted by the API token handlers.
+	maxTokenRequestBytes = 16 << 10
+	// maxUserRequestBytes is the largest request body accepted by UpdateMe.
+	maxUserRequestBytes = 16 << 10
+	// maxTaskBodyLength is the most characters the body of a task can have.
+	maxTaskBodyLength = 10000
+)
+
+// readRequestBody reads the request body, up to limit bytes.
+// If the body is too large, an HTTP 413 Payload Too Large is returned.
+// If the body cannot be read, an HTTP 400 Bad Request is returned.
+// It returns false if a response was written and the handler should stop.
+func readRequestBody(w http.ResponseWriter, req *http.Request, limit int64) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, limit))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		middleware.WriteProblem(w, req, http.StatusRequestEntityTooLarge, model.ProblemPayloadTooLarge,
+			fmt.Sprintf("Request body must not be larger than %d bytes", limit))
+		return nil, false
+	}
+	if err != nil {
+		middleware.WriteProblem(w, req, http.StatusBadRequest, model.ProblemInvalidRequest, err.Error())
+		return nil, false
+	}
+	return body, true
+}
+
+// decodeStrict decodes a JSON request body into v, rejecting fields v does not have and anything after the JSON value.
+// If the body is not valid, an HTTP 400 Bad Request is returned, listing the offending field if there is one.
+// It returns false if a response was written and the handler should stop.
+func decodeStrict(w http.ResponseWriter, req *http.Request, body []byte, v interface{}) bool {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil && decoder.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("request body must contain a single JSON value")
+	}
+	if err != nil {
+		middleware.WriteProblem(w, req, http.StatusBadRequest, model.ProblemInvalidRequest, err.Error(), jsonFieldErrors(err)...)
+		return false
+	}
+	return true
+}
+
+// jsonFieldErrors returns the field a JSON decoding error was caused by, if there is one.
+func jsonFieldErrors(err error) []model.FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return []model.FieldError{{Field: typeErr.Field, Message: fmt.Sprintf("must not be a JSON %s", typeErr.Value)}}
+	}
+	// encoding/json does not have an error type for unknown fields.
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return []model.FieldError{{Field: strings.Trim(field, `"`), Message: "is not a known field"}}
+	}
+	return nil
+}
+
+// validateTask checks a task sent to be created, updated or deleted, returning an error for each field that is not valid.
+// Deleting a task only needs its ID. The parent of a task must be another task belonging to the same user.
+func (r *Resolver) validateTask(ctx context.Context, mutation string, task model.Task) ([]model.FieldError, error) {
+	var fields []model.FieldError
+	if !isUUID(task.ID) {
+		fields = append(fields, model.FieldError{Field: "id", Message: "must be a UUID"})
+	}
+	if mutation == model.MutationDelete {
+		return fields, nil
+	}
+
+	if strings.TrimSpace(task.Body) == "" {
+		fields = append(fields, model.FieldError{Field: "body", Message: "must not be empty"})
+	} else if utf8.RuneCountInString(task.Body) > maxTaskBodyLength {
+		fields = append(fields, model.FieldError{Field: "body", Message: fmt.Sprintf("must be at most %d characters", maxTaskBodyLength)})
+	}
+	if task.Reminder != nil && !isUUID(*task.Reminder) {
+		fields = append(fields, model.FieldError{Field: "reminder", Message: "must be a UUID"})
+	}
+
+	if task.Parent != nil {
+		switch {
+		case !isUUID(*task.Parent):
+			fields = append(fields, model.FieldError{Field: "parent", Message: "must be a UUID"})
+		case *task.Parent == task.ID:
+			fields = append(fields, model.FieldError{Field: "parent", Message: "must not be the task itself"})
+		default:
+			parent, err := r.Database.GetTaskByIDFromPrimary(ctx, *task.Parent)
+			if err != nil {
+				return nil, err
+			}
+			// A parent belonging to another user is reported the same way as a missing one, so as not to reveal it.
+			if parent == nil || parent.UserID != task.UserID {
+				fields = append(fields, model.FieldError{Field: "parent", Message: "must be an existing task belonging to the same user"})
+			}
+		}
+	}
+
+	return fields, nil
+}
+
+// isUUID reports whether s is a UUID in its canonical, hyphenated form.
+func isUUID(s string) bool {
+	return len(s) == 36 && uuid.Validate(s) == nil
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+func TestCreateTaskValidation(t *testing.T) {
+	parentID := taskID2
+	selfID := taskID1
+	notUUID := "not-a-uuid"
+
+	tests := []struct {
+		name           string
+		body           string
+		setup          func(mockDB *database.MockDatabase)
+		expectedStatus int
+		expectedErrors []model.FieldError
+	}{
+		{
+			name: "CreateTask_OwnedParent",
+			body: `{"id":"` + taskID1 + `","body":"Subtask","parent":"` + parentID + `"}`,
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTaskByIDFromPrimary", parentID).Return(&model.Task{ID: parentID, UserID: "user-1"}, nil)
+				mockDB.On("GetTaskByIDFromPrimary", taskID1).Return((*model.Task)(nil), nil)
+				mockDB.On("CreateTask", mock.Anything).Return(nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "CreateTask_EmptyBody",
+			body:           `{"id":"` + taskID1 + `","body":"  "}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedErrors: []model.FieldError{{Field: "body", Message: "must not be empty"}},
+		},
+		{
+			name:           "CreateTask_BodyTooLong",
+			body:           `{"id":"` + taskID1 + `","body":"` + strings.Repeat("a", maxTaskBodyLength+1) + `"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedErrors: []model.FieldError{{Field: "body", Message: "must be at most 10000 characters"}},
+		},
+		{
+			name:           "CreateTask_InvalidIDs",
+			body:           `{"id":"1","body":"Task","parent":"` + notUUID + `","reminder":"` + notUUID + `"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedErrors: []model.FieldError{
+				{Field: "id", Message: "must be a UUID"},
+				{Field: "reminder", Message: "must be a UUID"},
+				{Field: "parent", Message: "must be a UUID"},
+			},
+		},
+		{
+			name:           "CreateTask_OwnParent",
+			body:           `{"id":"` + selfID + `","body":"Task","parent":"` + selfID + `"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedErrors: []model.FieldError{{Field: "parent", Message: "must not be the task itself"}},
+		},
+		{
+			name: "CreateTask_MissingParent",
+			body: `{"id":"` + taskID1 + `","body":"Task","parent":"` + parentID + `"}`,
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTaskByIDFromPrimary", parentID).Return((*model.Task)(nil), nil)
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedErrors: []model.FieldError{{Field: "parent", Message: "must be an existing task belonging to the same user"}},
+		},
+		{
+			name: "CreateTask_OtherUsersParent",
+			body: `{"id":"` + taskID1 + `","user_id":"someone-else","body":"Task","parent":"` + parentID + `"}`,
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetTaskByIDFromPrimary", parentID).Return(&model.Task{ID: parentID, UserID: "someone-else"}, nil)
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedErrors: []model.FieldError{{Field: "parent", Message: "must be an existing task belonging to the same user"}},
+		},
+		{
+			name:           "CreateTask_UnknownField",
+			body:           `{"id":"` + taskID1 + `","body":"Task","due":"tomorrow"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrors: []model.FieldError{{Field: "due", Message: "is not a known field"}},
+		},
+		{
+			name:           "CreateTask_WrongType",
+			body:           `{"id":"` + taskID1 + `","body":"Task","completed":"yes"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrors: []model.FieldError{{Field: "completed", Message: "must not be a JSON string"}},
+		},
+		{
+			name:           "CreateTask_TrailingData",
+			body:           `{"id":"` + taskID1 + `","body":"Task"} {}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			if tt.setup != nil {
+				tt.setup(mockDB)
+			}
+			resolver := &Resolver{Database: mockDB}
+
+			req, err := http.NewRequest("POST", "/tasks", bytes.NewBufferString(tt.body))
+			assert.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(resolver.CreateTask)
+			handler.ServeHTTP(rr, withUser(req, "user-1"))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectedStatus >= http.StatusBadRequest {
+				var problem model.Problem
+				err = json.NewDecoder(rr.Body).Decode(&problem)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedErrors, problem.Errors)
+			}
+
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
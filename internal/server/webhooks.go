@@ -0,0 +1,294 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/SevvyP/tasks_v1/internal/middleware"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// webhookEvents are the events a webhook can subscribe to. A webhook created without events subscribes to all of them.
+var webhookEvents = []string{
+	model.WebhookTaskCreated,
+	model.WebhookTaskUpdated,
+	model.WebhookTaskCompleted,
+	model.WebhookTaskDeleted,
+}
+
+// GetWebhooks retrieves the caller's webhooks from the database and sends them as a JSON response.
+// Webhook secrets are not included.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If there is an error retrieving the webhooks from the database, an HTTP 500 Internal Server Error is returned.
+func (r *Resolver) GetWebhooks(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUserID(req.Context())
+	if user == "" {
+		middleware.WriteProblem(w, req, http.StatusUnauthorized, model.ProblemUnauthorized, "The token does not identify a user.")
+		return
+	}
+
+	webhooks, err := r.Database.GetWebhooksByUserID(req.Context(), user)
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return
+	}
+	for i := range *webhooks {
+		(*webhooks)[i].Secret = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhooks)
+}
+
+// CreateWebhook creates a webhook for the caller based on the JSON request body, generating its ID and signing secret.
+// If the webhook is created successfully, an HTTP 201 Created response is returned with the webhook as JSON.
+// This is the only response that includes the secret.
+// If the body is not valid JSON or has unknown fields, or the URL or events are not valid,
+// an HTTP 400 Bad Request is returned.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If the request body is larger than 16 KiB, an HTTP 413 Payload Too Large is returned.
+// If there is an error creating the webhook, an HTTP 500 Internal Server Error is returned.
+func (r *Resolver) CreateWebhook(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUserID(req.Context())
+	if user == "" {
+		middleware.WriteProblem(w, req, http.StatusUnauthorized, model.ProblemUnauthorized, "The token does not identify a user.")
+		return
+	}
+
+	body, ok := readRequestBody(w, req, maxWebhookRequestBytes)
+	if !ok {
+		return
+	}
+	var webhook model.Webhook
+	if !decodeStrict(w, req, body, &webhook) {
+		return
+	}
+	err := validateWebhook(&webhook)
+	if err != nil {
+		middleware.WriteProblem(w, req, http.StatusBadRequest, model.ProblemValidationFailed, err.Error())
+		return
+	}
+
+	webhook.ID = uuid.NewString()
+	webhook.UserID = user
+	webhook.Secret, err = newWebhookSecret()
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return
+	}
+	webhook.CreatedAt = time.Now().UTC()
+
+	err = r.Database.CreateWebhook(req.Context(), webhook)
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// UpdateWebhook changes the URL and events of one of the caller's webhooks based on the JSON request body.
+// If the webhook is updated successfully, an HTTP 200 OK response is returned.
+// If the body is not valid JSON or has unknown fields, or the URL or events are not valid,
+// an HTTP 400 Bad Request is returned.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If the webhook does not exist or belongs to another user, an HTTP 404 Not Found is returned.
+// If the request body is larger than 16 KiB, an HTTP 413 Payload Too Large is returned.
+// If there is an error updating the webhook, an HTTP 500 Internal Server Error is returned.
+func (r *Resolver) UpdateWebhook(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUserID(req.Context())
+	if user == "" {
+		middleware.WriteProblem(w, req, http.StatusUnauthorized, model.ProblemUnauthorized, "The token does not identify a user.")
+		return
+	}
+
+	body, ok := readRequestBody(w, req, maxWebhookRequestBytes)
+	if !ok {
+		return
+	}
+	var updatedWebhook model.Webhook
+	if !decodeStrict(w, req, body, &updatedWebhook) {
+		return
+	}
+	err := validateWebhook(&updatedWebhook)
+	if err != nil {
+		middleware.WriteProblem(w, req, http.StatusBadRequest, model.ProblemValidationFailed, err.Error())
+		return
+	}
+	if _, ok := r.getOwnedWebhook(w, req, user, updatedWebhook.ID); !ok {
+		return
+	}
+
+	err = r.Database.UpdateWebhook(req.Context(), updatedWebhook)
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Webhook updated successfully")
+}
+
+// DeleteWebhook deletes one of the caller's webhooks, along with its delivery log, based on the JSON request body.
+// If the webhook is deleted successfully, an HTTP 200 OK response is returned.
+// If the body is not valid JSON or has unknown fields, an HTTP 400 Bad Request is returned.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If the webhook does not exist or belongs to another user, an HTTP 404 Not Found is returned.
+// If the request body is larger than 16 KiB, an HTTP 413 Payload Too Large is returned.
+// If there is an error deleting the webhook, an HTTP 500 Internal Server Error is returned.
+func (r *Resolver) DeleteWebhook(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUserID(req.Context())
+	if user == "" {
+		middleware.WriteProblem(w, req, http.StatusUnauthorized, model.ProblemUnauthorized, "The token does not identify a user.")
+		return
+	}
+
+	body, ok := readRequestBody(w, req, maxWebhookRequestBytes)
+	if !ok {
+		return
+	}
+	var webhookToDelete model.Webhook
+	if !decodeStrict(w, req, body, &webhookToDelete) {
+		return
+	}
+	if _, ok := r.getOwnedWebhook(w, req, user, webhookToDelete.ID); !ok {
+		return
+	}
+
+	err := r.Database.DeleteWebhook(req.Context(), webhookToDelete)
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Webhook deleted successfully")
+}
+
+// GetWebhookDeliveries sends the most recent deliveries for the webhook in the "webhook_id" query parameter as a JSON response.
+// If the "status" query parameter is provided, only deliveries with that status are returned,
+// so "dead" returns the dead-letter list of deliveries that failed every attempt.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If the webhook does not exist or belongs to another user, an HTTP 404 Not Found is returned.
+// If there is an error retrieving the deliveries from the database, an HTTP 500 Internal Server Error is returned.
+func (r *Resolver) GetWebhookDeliveries(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUserID(req.Context())
+	if user == "" {
+		middleware.WriteProblem(w, req, http.StatusUnauthorized, model.ProblemUnauthorized, "The token does not identify a user.")
+		return
+	}
+
+	id := req.URL.Query().Get("webhook_id")
+	if _, ok := r.getOwnedWebhook(w, req, user, id); !ok {
+		return
+	}
+
+	deliveries, err := r.Database.GetWebhookDeliveries(req.Context(), id, req.URL.Query().Get("status"))
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// SendTestWebhook queues a test event for delivery to the webhook in the "id" query parameter.
+// If the test event is queued successfully, an HTTP 202 Accepted response is returned,
+// and its outcome can be followed in the webhook's delivery log.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If the webhook does not exist or belongs to another user, an HTTP 404 Not Found is returned.
+// If there is an error queueing the test event, an HTTP 500 Internal Server Error is returned.
+func (r *Resolver) SendTestWebhook(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUserID(req.Context())
+	if user == "" {
+		middleware.WriteProblem(w, req, http.StatusUnauthorized, model.ProblemUnauthorized, "The token does not identify a user.")
+		return
+	}
+
+	webhook, ok := r.getOwnedWebhook(w, req, user, req.URL.Query().Get("id"))
+	if !ok {
+		return
+	}
+
+	payload, err := json.Marshal(model.WebhookPayload{Event: model.WebhookTest, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return
+	}
+	err = r.Database.CreateWebhookDelivery(req.Context(), model.WebhookDelivery{
+		WebhookID: webhook.ID,
+		Event:     model.WebhookTest,
+		Payload:   payload,
+	})
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Test event queued successfully")
+}
+
+// getOwnedWebhook retrieves the webhook with the given ID if it belongs to the user.
+// If it does not, an error response is written and false is returned.
+func (r *Resolver) getOwnedWebhook(w http.ResponseWriter, req *http.Request, user string, id string) (*model.Webhook, bool) {
+	if !isUUID(id) {
+		middleware.WriteProblem(w, req, http.StatusNotFound, model.ProblemWebhookNotFound, "Webhook not found")
+		return nil, false
+	}
+
+	webhook, err := r.Database.GetWebhookByID(req.Context(), id)
+	if err != nil {
+		middleware.WriteInternalError(w, req, err)
+		return nil, false
+	}
+	if webhook == nil || webhook.UserID != user {
+		middleware.WriteProblem(w, req, http.StatusNotFound, model.ProblemWebhookNotFound, "Webhook not found")
+		return nil, false
+	}
+
+	return webhook, true
+}
+
+// validateWebhook checks that the webhook has an absolute http or https URL and only known events.
+// A webhook without events is subscribed to all of them.
+func validateWebhook(webhook *model.Webhook) error {
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Webhook URL must be an absolute http or https URL")
+	}
+
+	if len(webhook.Events) == 0 {
+		webhook.Events = slices.Clone(webhookEvents)
+		return nil
+	}
+	for _, event := range webhook.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("Unknown webhook event %q", event)
+		}
+	}
+	slices.Sort(webhook.Events)
+	webhook.Events = slices.Compact(webhook.Events)
+	return nil
+}
+
+// newWebhookSecret generates a random secret used to sign a webhook's deliveries.
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %v", err)
+	}
+	return hex.EncodeToString(secret), nil
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// Webhook IDs used by the webhook handler tests.
+const (
+	webhookID1 = "0c9d8e7f-6a5b-4c3d-8e2f-1a0b9c8d7e61"
+	webhookID2 = "0c9d8e7f-6a5b-4c3d-8e2f-1a0b9c8d7e62"
+	webhookID3 = "0c9d8e7f-6a5b-4c3d-8e2f-1a0b9c8d7e63"
+)
+
+func TestCreateWebhookHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           model.Webhook
+		expectCreate   bool
+		expectedStatus int
+		expectedEvents []string
+	}{
+		{
+			name:           "CreateWebhook_AllEvents",
+			body:           model.Webhook{URL: "https://example.com/hook"},
+			expectCreate:   true,
+			expectedStatus: http.StatusCreated,
+			expectedEvents: webhookEvents,
+		},
+		{
+			name:           "CreateWebhook_SomeEvents",
+			body:           model.Webhook{URL: "https://example.com/hook", Events: []string{model.WebhookTaskDeleted, model.WebhookTaskCompleted, model.WebhookTaskDeleted}},
+			expectCreate:   true,
+			expectedStatus: http.StatusCreated,
+			expectedEvents: []string{model.WebhookTaskCompleted, model.WebhookTaskDeleted},
+		},
+		{
+			name:           "CreateWebhook_InvalidURL",
+			body:           model.Webhook{URL: "ftp://example.com/hook"},
+			expectCreate:   false,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "CreateWebhook_UnknownEvent",
+			body:           model.Webhook{URL: "https://example.com/hook", Events: []string{"task.archived"}},
+			expectCreate:   false,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			if tt.expectCreate {
+				mockDB.On("CreateWebhook", mock.MatchedBy(func(webhook model.Webhook) bool {
+					return webhook.UserID == "user-1" && webhook.ID != "" && len(webhook.Secret) == 64
+				})).Return(nil)
+			}
+			resolver := &Resolver{Database: mockDB}
+
+			bodyBytes, _ := json.Marshal(tt.body)
+			req, err := http.NewRequest("POST", "/webhooks", bytes.NewBuffer(bodyBytes))
+			assert.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(resolver.CreateWebhook)
+			handler.ServeHTTP(rr, withUser(req, "user-1"))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			if tt.expectCreate {
+				var responseBody model.Webhook
+				err = json.NewDecoder(rr.Body).Decode(&responseBody)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.body.URL, responseBody.URL)
+				assert.Equal(t, tt.expectedEvents, responseBody.Events)
+				assert.NotEmpty(t, responseBody.Secret)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetWebhooksHandler(t *testing.T) {
+	mockDB := new(database.MockDatabase)
+	mockDB.On("GetWebhooksByUserID", "user-1").Return(&[]model.Webhook{
+		{ID: webhookID1, UserID: "user-1", URL: "https://example.com/hook", Secret: "secret", Events: webhookEvents},
+	}, nil)
+	resolver := &Resolver{Database: mockDB}
+
+	req, err := http.NewRequest("GET", "/webhooks", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(resolver.GetWebhooks)
+	handler.ServeHTTP(rr, withUser(req, "user-1"))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var responseBody []model.Webhook
+	err = json.NewDecoder(rr.Body).Decode(&responseBody)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.Webhook{{ID: webhookID1, UserID: "user-1", URL: "https://example.com/hook", Events: webhookEvents}}, responseBody)
+	mockDB.AssertExpectations(t)
+}
+
+func TestWebhookOwnershipHandlers(t *testing.T) {
+	owned := &model.Webhook{ID: webhookID1, UserID: "user-1", URL: "https://example.com/hook", Events: webhookEvents}
+	other := &model.Webhook{ID: webhookID2, UserID: "user-2", URL: "https://example.com/hook", Events: webhookEvents}
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		body           interface{}
+		handler        func(r *Resolver) http.HandlerFunc
+		setup          func(mockDB *database.MockDatabase)
+		expectedStatus int
+	}{
+		{
+			name:    "UpdateWebhook_Success",
+			method:  "PUT",
+			url:     "/webhooks",
+			body:    model.Webhook{ID: webhookID1, URL: "https://example.com/new"},
+			handler: func(r *Resolver) http.HandlerFunc { return r.UpdateWebhook },
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetWebhookByID", webhookID1).Return(owned, nil)
+				mockDB.On("UpdateWebhook", model.Webhook{ID: webhookID1, URL: "https://example.com/new", Events: webhookEvents}).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:    "UpdateWebhook_OtherUser",
+			method:  "PUT",
+			url:     "/webhooks",
+			body:    model.Webhook{ID: webhookID2, URL: "https://example.com/new"},
+			handler: func(r *Resolver) http.HandlerFunc { return r.UpdateWebhook },
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetWebhookByID", webhookID2).Return(other, nil)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:    "DeleteWebhook_NotFound",
+			method:  "DELETE",
+			url:     "/webhooks",
+			body:    model.Webhook{ID: webhookID3},
+			handler: func(r *Resolver) http.HandlerFunc { return r.DeleteWebhook },
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetWebhookByID", webhookID3).Return((*model.Webhook)(nil), nil)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:    "GetWebhookDeliveries_DeadLetters",
+			method:  "GET",
+			url:     "/webhooks/deliveries?webhook_id=" + webhookID1 + "&status=dead",
+			handler: func(r *Resolver) http.HandlerFunc { return r.GetWebhookDeliveries },
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetWebhookByID", webhookID1).Return(owned, nil)
+				mockDB.On("GetWebhookDeliveries", webhookID1, model.DeliveryDead).Return(&[]model.WebhookDelivery{}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:    "SendTestWebhook_Success",
+			method:  "POST",
+			url:     "/webhooks/test?id=" + webhookID1,
+			handler: func(r *Resolver) http.HandlerFunc { return r.SendTestWebhook },
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetWebhookByID", webhookID1).Return(owned, nil)
+				mockDB.On("CreateWebhookDelivery", mock.MatchedBy(func(delivery model.WebhookDelivery) bool {
+					return delivery.WebhookID == webhookID1 && delivery.Event == model.WebhookTest
+				})).Return(nil)
+			},
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:    "SendTestWebhook_OtherUser",
+			method:  "POST",
+			url:     "/webhooks/test?id=" + webhookID2,
+			handler: func(r *Resolver) http.HandlerFunc { return r.SendTestWebhook },
+			setup: func(mockDB *database.MockDatabase) {
+				mockDB.On("GetWebhookByID", webhookID2).Return(other, nil)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			tt.setup(mockDB)
+			resolver := &Resolver{Database: mockDB}
+
+			bodyBytes, _ := json.Marshal(tt.body)
+			req, err := http.NewRequest(tt.method, tt.url, bytes.NewBuffer(bodyBytes))
+			assert.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			tt.handler(resolver).ServeHTTP(rr, withUser(req, "user-1"))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
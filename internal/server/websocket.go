@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/SevvyP/tasks_v1/internal/logging"
+	"github.com/SevvyP/tasks_v1/internal/middleware"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+const (
+	// socketWriteWait is the time allowed to write a message to the client.
+	socketWriteWait = 10 * time.Second
+	// socketPongWait is the time allowed to read the next pong from the client.
+	socketPongWait = 60 * time.Second
+	// socketPingPeriod is how often pings are sent, which must be less than socketPongWait.
+	socketPingPeriod = socketPongWait * 9 / 10
+	// socketMaxMessageSize is the largest mutation a client may send.
+	socketMaxMessageSize = 64 * 1024
+	// socketSendBuffer bounds the messages queued for a connection. When it is full the connection
+	// stops reading mutations and events until the client catches up, or is closed if it stops reading.
+	socketSendBuffer = 32
+)
+
+// upgrader upgrades requests to the sync socket. SyncTasks replaces its origin check with checkOrigin.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	Error: func(w http.ResponseWriter, req *http.Request, status int, reason error) {
+		middleware.WriteProblem(w, req, status, model.ProblemInvalidRequest, reason.Error())
+	},
+}
+
+// syncSocket is a single client connection to the sync socket.
+type syncSocket struct {
+	resolver *Resolver
+	// ctx is the context of the upgraded request, which lasts as long as the connection.
+	ctx  context.Context
+	user string
+	conn *websocket.Conn
+	send chan model.SocketMessage
+	done chan struct{}
+}
+
+// SyncTasks upgrades the request to a WebSocket connection used to sync the caller's tasks in both directions.
+// Clients send mutations and receive an acknowledgement or rejection for each one, along with events for
+// changes made to their tasks by any client. If a last_event_id query parameter is sent, the events after
+// that ID are sent first, otherwise only events that happen after the connection is opened are sent.
+// If the caller is not authenticated, an HTTP 401 Unauthorized is returned.
+// If the last_event_id query parameter is not a valid event ID, an HTTP 400 Bad Request is returned.
+// If there is an error reading the latest event ID, an HTTP 500 Internal Server Error is returned.
+func (r *Resolver) SyncTasks(w http.ResponseWriter, req *http.Request) {
+	user := middleware.GetUserID(req.Context())
+	if user == "" {
+		middleware.WriteProblem(w, req, http.StatusUnauthorized, model.ProblemUnauthorized, "The token does not identify a user.")
+		return
+	}
+
+	// Subscribe before reading the log so that events committed in between are not missed.
+	notify, unsubscribe := r.events.subscribe(user)
+	defer unsubscribe()
+
+	var lastID int64
+	var err error
+	if param := req.URL.Query().Get("last_event_id"); param != "" {
+		lastID, err = strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			middleware.WriteProblem(w, req, http.StatusBadRequest, model.ProblemInvalidRequest, "Invalid last_event_id")
+			return
+		}
+	} else {
+		lastID, err = r.Database.GetLatestTaskEventID(req.Context(), user)
+		if err != nil {
+			middleware.WriteInternalError(w, req, err)
+			return
+		}
+	}
+
+	socketUpgrader := upgrader
+	socketUpgrader.CheckOrigin = r.checkOrigin
+	conn, err := socketUpgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// The upgrader has already written an error response.
+		logging.FromContext(req.Context()).WarnContext(req.Context(), "Failed to upgrade sync socket", "error", err)
+		return
+	}
+
+	socket := &syncSocket{
+		resolver: r,
+		ctx:      req.Context(),
+		user:     user,
+		conn:     conn,
+		send:     make(chan model.SocketMessage, socketSendBuffer),
+		done:     make(chan struct{}),
+	}
+	go socket.writePump()
+	go socket.eventPump(notify, lastID)
+	socket.readPump()
+}
+
+// enqueue queues a message to be written to the client, blocking while the send buffer is full.
+// It returns false if the connection was closed before the message could be queued.
+func (s *syncSocket) enqueue(message model.SocketMessage) bool {
+	select {
+	case s.send <- message:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// readPump reads mutations from the client and applies them until the connection is closed.
+func (s *syncSocket) readPump() {
+	defer close(s.done)
+
+	s.conn.SetReadLimit(socketMaxMessageSize)
+	s.conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	})
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logging.FromContext(s.ctx).WarnContext(s.ctx, "Sync socket closed unexpectedly", "error", err)
+			}
+			return
+		}
+
+		// Mutations are decoded as strictly as REST request bodies, so that a misspelled field is not ignored.
+		var mutation model.Mutation
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&mutation)
+		if err != nil {
+			invalid := &mutationError{status: http.StatusBadRequest, code: model.ProblemInvalidRequest, message: "Invalid mutation", fields: jsonFieldErrors(err)}
+			if len(invalid.fields) == 0 {
+				invalid.message = err.Error()
+			}
+			if !s.enqueue(model.SocketMessage{Type: model.SocketReject, MutationID: mutation.ID, Error: invalid.Error()}) {
+				return
+			}
+			continue
+		}
+
+		reply := model.SocketMessage{Type: model.SocketAck, MutationID: mutation.ID}
+		err = s.resolver.applyMutation(s.ctx, mutation.Type, mutation.Task)
+		var mutationErr *mutationError
+		if errors.As(err, &mutationErr) {
+			reply = model.SocketMessage{Type: model.SocketReject, MutationID: mutation.ID, Error: mutationErr.Error()}
+		} else if err != nil {
+			logging.FromContext(s.ctx).ErrorContext(s.ctx, "Failed to apply sync socket mutation", "error", err)
+			reply = model.SocketMessage{Type: model.SocketReject, MutationID: mutation.ID, Error: "An internal error occurred"}
+		}
+		if !s.enqueue(reply) {
+			return
+		}
+	}
+}
+
+// writePump writes queued messages and keepalive pings to the client until the connection is closed.
+func (s *syncSocket) writePump() {
+	ticker := time.NewTicker(socketPingPeriod)
+	defer func() {
+		ticker.Stop()
+		s.conn.Close()
+	}()
+
+	for {
+		select {
+		case message := <-s.send:
+			s.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			err := s.conn.WriteJSON(message)
+			if err != nil {
+				return
+			}
+		case <-ticker.C:
+			s.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			err := s.conn.WriteMessage(websocket.PingMessage, nil)
+			if err != nil {
+				return
+			}
+		case <-s.done:
+			s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(socketWriteWait))
+			return
+		}
+	}
+}
+
+// eventPump queues the user's task events for the client, starting after lastID, until the connection is closed.
+func (s *syncSocket) eventPump(notify <-chan struct{}, lastID int64) {
+	for {
+		events, err := s.resolver.Database.GetTaskEvents(s.ctx, s.user, lastID)
+		if err != nil {
+			// Close the connection and let the client reconnect with its last_event_id.
+			logging.FromContext(s.ctx).ErrorContext(s.ctx, "Failed to get task events", "error", err)
+			s.conn.Close()
+			return
+		}
+		for i := range *events {
+			event := (*events)[i]
+			if !s.enqueue(model.SocketMessage{Type: model.SocketEvent, Event: &event}) {
+				return
+			}
+			lastID = event.ID
+		}
+		if len(*events) > 0 {
+			continue
+		}
+
+		select {
+		case <-s.done:
+			return
+		case _, ok := <-notify:
+			if !ok {
+				// The server is shutting down. The client reconnects with its last_event_id.
+				s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down"),
+					time.Now().Add(socketWriteWait))
+				s.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// checkOrigin reports whether a browser may open the sync socket from the origin of the request, which is allowed
+// if it is the server's own origin or one allowed by the CORS config. Clients other than browsers send no origin.
+func (r *Resolver) checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, req.Host) {
+		return true
+	}
+	return r.cors.AllowsOrigin(origin)
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/internal/middleware"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+func TestSyncTasksHandler(t *testing.T) {
+	task := model.Task{ID: taskID1, UserID: "user-1", Body: "Task 1", Completed: false}
+	otherTask := model.Task{ID: taskID2, UserID: "user-2", Body: "Task 2", Completed: false}
+	created := model.Task{ID: taskID3, UserID: "user-1", Body: "Task 3", Completed: false}
+	event := model.TaskEvent{ID: 6, Type: model.TaskUpdated, UserID: "user-1", TaskID: "2", Task: &model.Task{ID: "2", Body: "Task 2"}}
+
+	mockDB := new(database.MockDatabase)
+	mockDB.On("GetLatestTaskEventID", "user-1").Return(int64(5), nil)
+	mockDB.On("GetTaskEvents", "user-1", int64(5)).Return(&[]model.TaskEvent{}, nil).Once()
+	mockDB.On("GetTaskEvents", "user-1", int64(5)).Return(&[]model.TaskEvent{event}, nil).Once()
+	mockDB.On("GetTaskEvents", "user-1", int64(6)).Return(&[]model.TaskEvent{}, nil)
+	mockDB.On("CreateTask", created).Return(nil)
+	mockDB.On("GetTaskByIDFromPrimary", taskID1).Return(&task, nil)
+	mockDB.On("GetTaskByIDFromPrimary", taskID3).Return((*model.Task)(nil), nil)
+	mockDB.On("GetTaskByIDFromPrimary", taskID2).Return(&otherTask, nil)
+	mockDB.On("UpdateTask", task).Return(nil)
+	resolver := &Resolver{Database: mockDB, events: newEventBroker()}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		resolver.SyncTasks(w, withUser(req, "user-1"))
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	tests := []struct {
+		name     string
+		send     interface{}
+		expected model.SocketMessage
+	}{
+		{
+			name:     "SyncTasks_Ack",
+			send:     model.Mutation{ID: "m1", Type: model.MutationCreate, Task: created},
+			expected: model.SocketMessage{Type: model.SocketAck, MutationID: "m1"},
+		},
+		{
+			name:     "SyncTasks_CreateForOtherUser",
+			send:     model.Mutation{ID: "m5", Type: model.MutationCreate, Task: model.Task{ID: taskID3, UserID: "user-2", Body: "Task 3"}},
+			expected: model.SocketMessage{Type: model.SocketAck, MutationID: "m5"},
+		},
+		{
+			name:     "SyncTasks_RejectExistingID",
+			send:     model.Mutation{ID: "m6", Type: model.MutationCreate, Task: task},
+			expected: model.SocketMessage{Type: model.SocketReject, MutationID: "m6", Error: "A task with this ID already exists"},
+		},
+		{
+			name:     "SyncTasks_UpdateOwnTask",
+			send:     model.Mutation{ID: "m3", Type: model.MutationUpdate, Task: task},
+			expected: model.SocketMessage{Type: model.SocketAck, MutationID: "m3"},
+		},
+		{
+			name:     "SyncTasks_RejectOtherUsersTask",
+			send:     model.Mutation{ID: "m4", Type: model.MutationDelete, Task: model.Task{ID: taskID2}},
+			expected: model.SocketMessage{Type: model.SocketReject, MutationID: "m4", Error: "Task not found"},
+		},
+		{
+			name:     "SyncTasks_RejectUnknownType",
+			send:     model.Mutation{ID: "m2", Type: "archive", Task: task},
+			expected: model.SocketMessage{Type: model.SocketReject, MutationID: "m2", Error: "Unknown mutation type"},
+		},
+		{
+			name:     "SyncTasks_RejectUnknownField",
+			send:     map[string]interface{}{"id": "m7", "type": model.MutationCreate, "task": map[string]interface{}{"id": taskID3, "colour": "red"}},
+			expected: model.SocketMessage{Type: model.SocketReject, MutationID: "m7", Error: "Invalid mutation: colour is not a known field"},
+		},
+		{
+			name:     "SyncTasks_RejectInvalidJSON",
+			send:     "not a mutation",
+			expected: model.SocketMessage{Type: model.SocketReject, Error: "json: cannot unmarshal string into Go value of type model.Mutation"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, conn.WriteJSON(tt.send))
+
+			var message model.SocketMessage
+			assert.NoError(t, conn.ReadJSON(&message))
+			assert.Equal(t, tt.expected, message)
+		})
+	}
+
+	t.Run("SyncTasks_RemoteEvent", func(t *testing.T) {
+		resolver.events.notify("user-1")
+
+		var message model.SocketMessage
+		assert.NoError(t, conn.ReadJSON(&message))
+		assert.Equal(t, model.SocketMessage{Type: model.SocketEvent, Event: &event}, message)
+	})
+
+	mockDB.AssertExpectations(t)
+}
+
+func TestSyncTasksUnauthorized(t *testing.T) {
+	resolver := &Resolver{events: newEventBroker()}
+
+	req, err := http.NewRequest("GET", "/tasks/ws", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(resolver.SyncTasks)
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, `{"type":"about:blank","title":"Unauthorized","status":401,"detail":"The token does not identify a user.","instance":"/tasks/ws","code":"unauthorized"}`+"\n", rr.Body.String())
+}
+
+func TestSyncTasksOrigin(t *testing.T) {
+	tests := []struct {
+		name           string
+		origin         string
+		expectedStatus int
+	}{
+		{name: "SyncTasksOrigin_None", expectedStatus: http.StatusSwitchingProtocols},
+		{name: "SyncTasksOrigin_Allowed", origin: "https://app.example.com", expectedStatus: http.StatusSwitchingProtocols},
+		{name: "SyncTasksOrigin_Other", origin: "https://evil.example.com", expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(database.MockDatabase)
+			mockDB.On("GetLatestTaskEventID", "user-1").Return(int64(0), nil)
+			mockDB.On("GetTaskEvents", "user-1", int64(0)).Return(&[]model.TaskEvent{}, nil).Maybe()
+			resolver := &Resolver{Database: mockDB, events: newEventBroker(),
+				cors: &middleware.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}}}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				resolver.SyncTasks(w, withUser(req, "user-1"))
+			}))
+			defer server.Close()
+
+			header := http.Header{}
+			if tt.origin != "" {
+				header.Set("Origin", tt.origin)
+			}
+			conn, resp, _ := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+			if conn != nil {
+				conn.Close()
+			}
+			if assert.NotNil(t, resp) {
+				assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Package tracing sets up OpenTelemetry tracing for the server.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Exporter types that can be selected in Config.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// defaultServiceName is the service name spans are reported under if none is configured.
+const defaultServiceName = "tasks"
+
+// Config contains the configuration for exporting spans.
+type Config struct {
+	// Exporter is one of "none", "stdout" or "otlp", and defaults to "none".
+	Exporter string `json:"exporter"`
+	// Endpoint is the host and port of the OTLP gRPC collector. If it is empty, the standard
+	// OTEL_EXPORTER_OTLP_ENDPOINT environment variable is used, and then localhost:4317.
+	Endpoint string `json:"endpoint"`
+	// Insecure sends spans to the OTLP collector without TLS.
+	Insecure    bool   `json:"insecure"`
+	ServiceName string `json:"service_name"`
+	// SampleRatio is the fraction of new traces that are sampled, from 0 to 1. Traces started by a caller
+	// follow the caller's sampling decision. It defaults to sampling every trace.
+	SampleRatio *float64 `json:"sample_ratio"`
+}
+
+// Setup registers the global tracer provider and the W3C trace context and baggage propagators.
+// With no exporter, trace context is still propagated but spans are not recorded.
+// The returned function flushes any spans not yet exported and stops the exporter.
+func Setup(ctx context.Context, config *Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if config == nil {
+		config = &Config{}
+	}
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case ExporterOTLP:
+		options := []otlptracegrpc.Option{}
+		if config.Endpoint != "" {
+			options = append(options, otlptracegrpc.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(ctx, options...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", config.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %v", err)
+	}
+
+	ratio := 1.0
+	if config.SampleRatio != nil {
+		ratio = *config.SampleRatio
+	}
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
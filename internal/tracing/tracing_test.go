@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{
+			name:   "Default",
+			config: nil,
+		},
+		{
+			name:   "None",
+			config: &Config{Exporter: ExporterNone},
+		},
+		{
+			name:   "Stdout",
+			config: &Config{Exporter: ExporterStdout, ServiceName: "tasks-test"},
+		},
+		{
+			name:   "OTLP",
+			config: &Config{Exporter: ExporterOTLP, Endpoint: "localhost:4317", Insecure: true},
+		},
+		{
+			name:    "UnknownExporter",
+			config:  &Config{Exporter: "zipkin"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+			shutdown, err := Setup(context.Background(), tt.config)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, []string{"traceparent", "tracestate", "baggage"}, otel.GetTextMapPropagator().Fields())
+			assert.NoError(t, shutdown(context.Background()))
+		})
+	}
+}
+
+func TestSetupPropagatesTraceContext(t *testing.T) {
+	_, err := Setup(context.Background(), nil)
+	assert.NoError(t, err)
+
+	carrier := propagation.MapCarrier{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), carrier)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", trace.SpanContextFromContext(ctx).TraceID().String())
+}
@@ -0,0 +1,243 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+// Headers sent with every webhook delivery.
+const (
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	TimestampHeader = "X-Webhook-Timestamp"
+	// SignatureHeader carries "sha256=" followed by the hex HMAC-SHA256 of the timestamp, a ".", and the body,
+	// keyed with the webhook's secret. Receivers should also reject old timestamps to prevent replays.
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// Dispatcher sends queued webhook deliveries, retrying failures with exponential backoff
+// until MaxAttempts is reached and the delivery is moved to the dead-letter list.
+type Dispatcher struct {
+	Database     database.TaskDatabase
+	Client       *http.Client
+	PollInterval time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	// BaseBackoff is the delay after the first failed attempt, doubling with each further failure up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	Logger      *slog.Logger
+}
+
+// NewDispatcher creates a Dispatcher with the default delivery settings.
+func NewDispatcher(db database.TaskDatabase, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		Database:     db,
+		Client:       newClient(false),
+		PollInterval: 5 * time.Second,
+		BatchSize:    20,
+		MaxAttempts:  8,
+		BaseBackoff:  30 * time.Second,
+		MaxBackoff:   6 * time.Hour,
+		Logger:       logger,
+	}
+}
+
+// specialUsePrefixes are the address ranges, besides the loopback, private, link-local, multicast and unspecified
+// ones, that webhooks may not be delivered to because they are not routed on the public internet.
+var specialUsePrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001::/23"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// newClient returns the HTTP client deliveries are sent with. Redirects are not followed, and unless allowPrivate
+// is set it refuses to connect to private and special-use addresses, so that a webhook URL cannot be used to
+// reach the service's own network. The address is checked when connecting, after the host name is resolved.
+func newClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = refusePrivateAddresses
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would make the connection, so its address is the only one the dialer could check.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		Timeout:   10 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// refusePrivateAddresses is a net.Dialer Control function that fails connections to addresses that are not public.
+func refusePrivateAddresses(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !publicAddress(addr) {
+		return fmt.Errorf("webhook address %s is not public", addr)
+	}
+	return nil
+}
+
+// publicAddress reports whether addr is routed on the public internet.
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() || addr.IsMulticast() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, prefix := range specialUsePrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// Run sends due deliveries until the context is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// A full batch means there may be a backlog, so claim again without waiting for the ticker.
+		if d.dispatch(ctx) == d.BatchSize && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch claims a batch of due deliveries and sends them concurrently, returning how many were claimed.
+func (d *Dispatcher) dispatch(ctx context.Context) int {
+	// The lease outlives the client timeout so that a delivery is never sent twice at once.
+	deliveries, err := d.Database.ClaimWebhookDeliveries(ctx, d.BatchSize, d.Client.Timeout+time.Minute)
+	if err != nil {
+		d.Logger.ErrorContext(ctx, "Failed to claim webhook deliveries", "error", err)
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range *deliveries {
+		wg.Add(1)
+		go func(delivery model.WebhookDelivery) {
+			defer wg.Done()
+			// Record the attempt even if the dispatcher is stopping, so that a sent delivery is not sent again.
+			err := d.Database.UpdateWebhookDelivery(context.WithoutCancel(ctx), d.deliver(ctx, delivery))
+			if err != nil {
+				d.Logger.ErrorContext(ctx, "Failed to record webhook delivery", "delivery_id", delivery.ID, "error", err)
+			}
+		}(delivery)
+	}
+	wg.Wait()
+
+	return len(*deliveries)
+}
+
+// deliver sends a delivery and returns it updated with the outcome of the attempt.
+func (d *Dispatcher) deliver(ctx context.Context, delivery model.WebhookDelivery) model.WebhookDelivery {
+	delivery.Attempts++
+	delivery.LastError = ""
+	delivery.ResponseStatus = 0
+
+	err := d.send(ctx, &delivery)
+	if err == nil {
+		delivery.Status = model.DeliverySucceeded
+		return delivery
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= d.MaxAttempts {
+		delivery.Status = model.DeliveryDead
+		return delivery
+	}
+	delivery.Status = model.DeliveryPending
+	delivery.NextAttemptAt = time.Now().Add(d.backoff(delivery.Attempts))
+	return delivery
+}
+
+// send POSTs the signed payload to the webhook, recording the response status on the delivery.
+// Any response other than a 2xx is treated as a failure, including redirects, which are not followed.
+func (d *Dispatcher) send(ctx context.Context, delivery *model.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	delivery.ResponseStatus = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// backoff returns the delay before the next attempt after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.BaseBackoff
+	for i := 1; i < attempts && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, d.MaxBackoff)
+}
+
+// Sign returns the value of the signature header for a payload sent at the given Unix timestamp.
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
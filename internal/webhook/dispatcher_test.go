@@ -0,0 +1,172 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/SevvyP/tasks_v1/internal/database"
+	"github.com/SevvyP/tasks_v1/pkg/model"
+)
+
+func TestDispatch(t *testing.T) {
+	tests := []struct {
+		name             string
+		responseStatus   int
+		attempts         int
+		expectedStatus   string
+		expectedAttempts int
+		expectedError    string
+	}{
+		{
+			name:             "Dispatch_Success",
+			responseStatus:   http.StatusNoContent,
+			attempts:         0,
+			expectedStatus:   model.DeliverySucceeded,
+			expectedAttempts: 1,
+			expectedError:    "",
+		},
+		{
+			name:             "Dispatch_Retry",
+			responseStatus:   http.StatusInternalServerError,
+			attempts:         1,
+			expectedStatus:   model.DeliveryPending,
+			expectedAttempts: 2,
+			expectedError:    "webhook responded with status 500",
+		},
+		{
+			name:             "Dispatch_DeadLetter",
+			responseStatus:   http.StatusGone,
+			attempts:         2,
+			expectedStatus:   model.DeliveryDead,
+			expectedAttempts: 3,
+			expectedError:    "webhook responded with status 410",
+		},
+		{
+			name:             "Dispatch_Redirect",
+			responseStatus:   http.StatusFound,
+			attempts:         0,
+			expectedStatus:   model.DeliveryPending,
+			expectedAttempts: 1,
+			expectedError:    "webhook responded with status 302",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := json.RawMessage(`{"event":"task.created","task_id":"1"}`)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				body, _ := io.ReadAll(req.Body)
+				timestamp, err := strconv.ParseInt(req.Header.Get(TimestampHeader), 10, 64)
+				assert.NoError(t, err)
+				assert.Equal(t, Sign("secret", timestamp, payload), req.Header.Get(SignatureHeader))
+				assert.Equal(t, model.WebhookTaskCreated, req.Header.Get(EventHeader))
+				assert.Equal(t, "delivery-1", req.Header.Get(DeliveryHeader))
+				assert.JSONEq(t, string(payload), string(body))
+				w.Header().Set("Location", "/elsewhere")
+				w.WriteHeader(tt.responseStatus)
+			}))
+			defer server.Close()
+
+			delivery := model.WebhookDelivery{
+				ID:       "delivery-1",
+				Event:    model.WebhookTaskCreated,
+				Payload:  payload,
+				Status:   model.DeliveryPending,
+				Attempts: tt.attempts,
+				URL:      server.URL,
+				Secret:   "secret",
+			}
+			before := time.Now()
+
+			mockDB := new(database.MockDatabase)
+			dispatcher := NewDispatcher(mockDB, slog.Default())
+			// The test server listens on the loopback address.
+			dispatcher.Client = newClient(true)
+			dispatcher.MaxAttempts = 3
+			mockDB.On("ClaimWebhookDeliveries", dispatcher.BatchSize, mock.Anything).Return(&[]model.WebhookDelivery{delivery}, nil)
+			mockDB.On("UpdateWebhookDelivery", mock.MatchedBy(func(updated model.WebhookDelivery) bool {
+				retryScheduled := updated.NextAttemptAt.After(before.Add(dispatcher.BaseBackoff))
+				return updated.Status == tt.expectedStatus &&
+					updated.Attempts == tt.expectedAttempts &&
+					updated.LastError == tt.expectedError &&
+					updated.ResponseStatus == tt.responseStatus &&
+					retryScheduled == (tt.expectedStatus == model.DeliveryPending)
+			})).Return(nil)
+
+			claimed := dispatcher.dispatch(context.Background())
+
+			assert.Equal(t, 1, claimed)
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDispatch_PrivateAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Error("the webhook was delivered to a private address")
+	}))
+	defer server.Close()
+
+	dispatcher := NewDispatcher(nil, slog.Default())
+	delivery := dispatcher.deliver(context.Background(), model.WebhookDelivery{ID: "delivery-1", URL: server.URL, Secret: "secret"})
+
+	assert.Equal(t, model.DeliveryPending, delivery.Status)
+	assert.Equal(t, 0, delivery.ResponseStatus)
+	assert.Contains(t, delivery.LastError, "is not public")
+}
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		address  string
+		expected bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::ffff:127.0.0.1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"224.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			assert.Equal(t, tt.expected, publicAddress(netip.MustParseAddr(tt.address)))
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	dispatcher := NewDispatcher(nil, slog.Default())
+	dispatcher.BaseBackoff = time.Second
+	dispatcher.MaxBackoff = 10 * time.Second
+
+	assert.Equal(t, time.Second, dispatcher.backoff(1))
+	assert.Equal(t, 2*time.Second, dispatcher.backoff(2))
+	assert.Equal(t, 8*time.Second, dispatcher.backoff(4))
+	assert.Equal(t, 10*time.Second, dispatcher.backoff(5))
+	assert.Equal(t, 10*time.Second, dispatcher.backoff(50))
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '1700000000.{}' | openssl dgst -sha256 -hmac secret
+	assert.Equal(t, "sha256=b8569b78799ff9e3cbff0fc2d63a33a2b57f3282abd07c37ae5e8e7d79a5f163", Sign("secret", 1700000000, []byte("{}")))
+}
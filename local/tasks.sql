@@ -2,12 +2,22 @@
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
 
 /*
-Create users table with the following columns:
-id - uuid primary key
+Create users table with the following columns, provisioned on each user's first authenticated request:
+id - text primary key, the user ID of the user's token, such as auth0|abc123
+email - text, from the user's token
+name - text, filled in from the user's token until the user sets it
+timezone - text, an IANA timezone name, filled in from the user's token until the user sets it
+created_at - timestamptz
+updated_at - timestamptz, when the profile or preferences last changed
 */
 
 CREATE TABLE users (
-  id UUID PRIMARY KEY
+  id TEXT PRIMARY KEY,
+  email TEXT,
+  name TEXT,
+  timezone TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
 );
 
 /*
@@ -24,30 +34,229 @@ CREATE TABLE reminders (
 );
 
 
+/*
+Create task_change_seq sequence, shared by task rows and tombstones so that every
+change to a task gets a monotonically increasing number used as the sync token
+*/
+
+CREATE SEQUENCE task_change_seq;
+
 /*
 Create task table with the following columns:
 id - uuid primary key
-user_id - uuid foreign key
+user_id - text foreign key
 body - text
 completed - boolean default false
 parent - uuid foreign key
 reminder - uuid foreign key
+change_seq - bigint, the change sequence of the latest change to the task
+updated_at - timestamptz, when the latest change was made
 */
 
 CREATE TABLE tasks (
   id UUID PRIMARY KEY,
-  user_id UUID REFERENCES users(id),
+  user_id TEXT REFERENCES users(id),
   body TEXT,
   completed BOOLEAN DEFAULT FALSE,
   parent UUID REFERENCES tasks(id),
-  reminder UUID REFERENCES reminders(id)
+  reminder UUID REFERENCES reminders(id),
+  change_seq BIGINT NOT NULL DEFAULT nextval('task_change_seq'),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX tasks_user_id_change_seq_idx ON tasks (user_id, change_seq);
+
+/*
+Create task_tombstones table with the following columns, recording deleted tasks for sync:
+task_id - uuid primary key
+user_id - text, the owner of the deleted task
+change_seq - bigint, the change sequence of the delete
+deleted_at - timestamptz
+*/
+
+CREATE TABLE task_tombstones (
+  task_id UUID PRIMARY KEY,
+  user_id TEXT,
+  change_seq BIGINT NOT NULL DEFAULT nextval('task_change_seq'),
+  deleted_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX task_tombstones_user_id_change_seq_idx ON task_tombstones (user_id, change_seq);
+
+/*
+Create idempotency_keys table with the following columns:
+user_id - text, the subject of the caller that sent the request
+key - text, the Idempotency-Key header sent by the client
+fingerprint - text, hash of the original request body
+status_code - int, status of the original response
+body - bytea, body of the original response
+expires_at - timestamptz, after which the key may be reused
+*/
+
+CREATE TABLE idempotency_keys (
+  user_id TEXT NOT NULL,
+  key TEXT NOT NULL,
+  fingerprint TEXT NOT NULL,
+  status_code INT NOT NULL,
+  body BYTEA,
+  expires_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (user_id, key)
+);
+
+/*
+Create task_events table with the following columns:
+id - bigserial primary key, used as the Server-Sent Events ID
+type - text, one of created, updated or deleted
+user_id - text, the owner of the task
+task_id - uuid, the task that changed
+task - jsonb, the task after the change, null for deletes
+created_at - timestamptz
+*/
+
+CREATE TABLE task_events (
+  id BIGSERIAL PRIMARY KEY,
+  type TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  task_id UUID NOT NULL,
+  task JSONB,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX task_events_user_id_idx ON task_events (user_id, id);
+
+/*
+Create webhooks table with the following columns:
+id - uuid primary key
+user_id - text, the owner of the webhook
+url - text, where deliveries are POSTed
+secret - text, used to sign deliveries with HMAC-SHA256
+events - text array, the events the webhook is subscribed to
+created_at - timestamptz
+*/
+
+CREATE TABLE webhooks (
+  id UUID PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  url TEXT NOT NULL,
+  secret TEXT NOT NULL,
+  events TEXT[] NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
 );
 
+CREATE INDEX webhooks_user_id_idx ON webhooks (user_id);
+
+/*
+Create webhook_deliveries table with the following columns, used as the delivery queue and log:
+id - uuid primary key
+webhook_id - uuid foreign key, deliveries are deleted with their webhook
+event - text
+payload - jsonb, the body POSTed to the webhook
+status - text, one of pending, succeeded or dead
+attempts - int
+next_attempt_at - timestamptz, when a pending delivery is next due
+last_error - text
+response_status - int, the status of the last response
+created_at - timestamptz
+*/
+
+CREATE TABLE webhook_deliveries (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+  event TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending',
+  attempts INT NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_error TEXT,
+  response_status INT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, created_at);
+
+/*
+Create outbox table with the following columns, holding events written in the same
+transaction as the change they describe until the relay publishes them:
+id - uuid primary key, sent with the message so consumers can drop duplicates
+seq - bigserial, the order the messages were written in, which they are published in
+topic - text, such as tasks.created
+key - text, the owner of the task, used for partitioning
+payload - jsonb
+created_at - timestamptz
+locked_until - timestamptz, set while a relay is publishing the message
+published_at - timestamptz, null until the message is published
+*/
+
+CREATE TABLE outbox (
+  id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+  seq BIGSERIAL,
+  topic TEXT NOT NULL,
+  key TEXT NOT NULL,
+  payload JSONB NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp(),
+  locked_until TIMESTAMPTZ,
+  published_at TIMESTAMPTZ
+);
+
+CREATE INDEX outbox_unpublished_idx ON outbox (seq) WHERE published_at IS NULL;
+
+/*
+Create rate_limits table with the following columns, holding the token buckets shared by every replica:
+key - text primary key, the budget and the caller the bucket limits, such as "read:user:<sub>"
+tokens - double precision, tokens left in the bucket when it was last used
+updated_at - timestamptz, when the bucket was last used and refilled
+*/
+
+CREATE TABLE rate_limits (
+  key TEXT PRIMARY KEY,
+  tokens DOUBLE PRECISION NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL
+);
+
+/*
+Create api_tokens table with the following columns, holding the personal access tokens of users:
+id - uuid primary key
+user_id - text, the owner of the token, whom requests made with it act as
+name - text, given by the owner to tell their tokens apart
+token_hash - text, the hex SHA-256 hash of the token, which is not stored itself
+scopes - text array, read and write
+expires_at - timestamptz
+last_used_at - timestamptz, null until the token is first used
+created_at - timestamptz
+*/
+
+CREATE TABLE api_tokens (
+  id UUID PRIMARY KEY,
+  user_id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  scopes TEXT[] NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  last_used_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX api_tokens_user_id_idx ON api_tokens (user_id);
+
+/*
+Create schema_migrations table with the following columns, recording each schema version applied:
+version - int primary key, compared with database.SchemaVersion by the readiness check
+applied_at - timestamptz
+*/
+
+CREATE TABLE schema_migrations (
+  version INT PRIMARY KEY,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO schema_migrations (version) VALUES (1), (2), (3), (4), (5);
+
 /*
 populate the users table with one user
 */
 
-INSERT INTO users (id) VALUES (uuid_generate_v4());
+INSERT INTO users (id) VALUES (uuid_generate_v4()::text);
 
 /*
 populate the tasks table 3 tasks, all belonging to the user created above
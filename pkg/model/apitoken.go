@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// Scopes an API token can be granted. Read covers GET and HEAD requests and gRPC Get, List and Watch calls,
+// and write covers everything else.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// APIToken is a personal access token a user creates for scripts and integrations that cannot sign in.
+// Token is the secret sent as a bearer token, which is only returned when the token is created;
+// only its hash is stored. A token stops working when it expires or is deleted.
+type APIToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token,omitempty"`
+	Hash       string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// Task event types recorded in the change log.
+const (
+	TaskCreated = "created"
+	TaskUpdated = "updated"
+	TaskDeleted = "deleted"
+)
+
+// TaskEvent is an entry in the durable log of changes made to a user's tasks.
+// Task holds the state of the task after the change and is nil for deletes.
+type TaskEvent struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	UserID    string    `json:"user_id"`
+	TaskID    string    `json:"task_id"`
+	Task      *Task     `json:"task,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,14 @@
+package model
+
+// Statuses reported by the health and readiness endpoints, for the service as a whole and for each check.
+const (
+	HealthOK          = "ok"
+	HealthUnavailable = "unavailable"
+)
+
+// Health is the response of the health and readiness endpoints.
+// Checks maps the name of each dependency checked to its status. Why a check failed is only logged.
+type Health struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// IdempotencyRecord is the stored outcome of a request made with an Idempotency-Key,
+// used to replay the original response when the request is retried.
+// StatusCode is 0 while the original request is still in progress.
+type IdempotencyRecord struct {
+	UserID      string    `json:"user_id"`
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	StatusCode  int       `json:"status_code"`
+	Body        []byte    `json:"body"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
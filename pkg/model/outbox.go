@@ -0,0 +1,19 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OutboxMessage is an event written to the outbox in the same transaction as the change it describes,
+// then relayed to the configured publisher. Messages are delivered at least once, and ID is the same
+// on every delivery so that consumers can drop duplicates.
+type OutboxMessage struct {
+	ID        string          `json:"id"`
+	Topic     string          `json:"topic"`
+	Key       string          `json:"key"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+	// Seq is the order the message was written in, which messages are published in.
+	Seq int64 `json:"-"`
+}
@@ -0,0 +1,43 @@
+package model
+
+// ProblemContentType is the media type of error responses.
+const ProblemContentType = "application/problem+json"
+
+// Stable codes identifying the kind of error in a Problem. Clients should branch on these rather than on Detail.
+const (
+	ProblemInvalidRequest   = "invalid_request"
+	ProblemValidationFailed = "validation_failed"
+	ProblemUnauthorized     = "unauthorized"
+	ProblemForbidden        = "forbidden"
+	ProblemNotFound         = "not_found"
+	ProblemTaskNotFound     = "task_not_found"
+	ProblemWebhookNotFound  = "webhook_not_found"
+	ProblemTokenNotFound    = "token_not_found"
+	ProblemUserNotFound     = "user_not_found"
+	ProblemMethodNotAllowed = "method_not_allowed"
+	ProblemConflict         = "conflict"
+	ProblemPayloadTooLarge  = "payload_too_large"
+	ProblemRateLimited      = "rate_limited"
+	ProblemInternal         = "internal_error"
+)
+
+// Problem is an RFC 7807 problem details error response.
+// Code is one of the stable error codes above, and RequestID matches the X-Request-ID response header
+// so that an error reported by a client can be found in the server logs.
+// Errors lists the fields of the request that were not valid.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes why a field of a request is not valid. Field is the JSON name of the field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,8 @@
+package model
+
+// Reminder is an alert attached to a task. Date is when it is due, as a Unix timestamp.
+type Reminder struct {
+	ID        string `json:"id"`
+	Date      int64  `json:"date"`
+	SendAlert bool   `json:"send_alert"`
+}
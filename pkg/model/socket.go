@@ -0,0 +1,33 @@
+package model
+
+// Mutation types a client can send over the sync socket.
+const (
+	MutationCreate = "create"
+	MutationUpdate = "update"
+	MutationDelete = "delete"
+)
+
+// Mutation is a change to a task sent by a client over the sync socket.
+// ID is chosen by the client and echoed back in the acknowledgement or rejection.
+type Mutation struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Task Task   `json:"task"`
+}
+
+// Message types sent by the server over the sync socket.
+const (
+	SocketAck    = "ack"
+	SocketReject = "reject"
+	SocketEvent  = "event"
+)
+
+// SocketMessage is a message sent by the server over the sync socket.
+// Acknowledgements and rejections carry the ID of the mutation they answer,
+// and events carry a change made to one of the user's tasks by any client.
+type SocketMessage struct {
+	Type       string     `json:"type"`
+	MutationID string     `json:"mutation_id,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Event      *TaskEvent `json:"event,omitempty"`
+}
@@ -0,0 +1,71 @@
+package model
+
+import "time"
+
+// TaskChanges is the set of changes made to a user's tasks after a change sequence.
+// Sequence is the highest change sequence included, or the requested one if nothing changed.
+type TaskChanges struct {
+	Tasks    []Task
+	Deleted  []string
+	Sequence int64
+}
+
+// SyncResponse is returned to clients pulling the changes made since their last sync.
+// Token is passed back as the since parameter of the next pull.
+type SyncResponse struct {
+	Tasks   []Task   `json:"tasks"`
+	Deleted []string `json:"deleted"`
+	Token   string   `json:"token"`
+}
+
+// Conflict resolution strategies for offline edits pushed by clients.
+const (
+	// SyncLastWriterWins keeps whichever side changed the task most recently.
+	SyncLastWriterWins = "lww"
+	// SyncFieldMerge applies only the fields the client changed on top of the server's task.
+	SyncFieldMerge = "merge"
+)
+
+// SyncRequest is sent by clients pushing edits they made while offline.
+type SyncRequest struct {
+	Strategy string       `json:"strategy"`
+	Changes  []SyncChange `json:"changes"`
+}
+
+// SyncChange is a single offline edit. BaseVersion is the version of the task the edit was made to,
+// or 0 for a task created offline. ModifiedAt is when the edit was made on the client, and Fields lists
+// the JSON names of the fields it changed.
+type SyncChange struct {
+	Task        Task      `json:"task"`
+	Deleted     bool      `json:"deleted"`
+	BaseVersion int64     `json:"base_version"`
+	ModifiedAt  time.Time `json:"modified_at"`
+	Fields      []string  `json:"fields"`
+}
+
+// SyncResult reports which pushed edits were applied and which lost a conflict.
+type SyncResult struct {
+	Applied   []string       `json:"applied"`
+	Conflicts []SyncConflict `json:"conflicts"`
+}
+
+// Reasons an offline edit was not applied.
+const (
+	// SyncConflictStale means the task changed on the server more recently than the edit.
+	SyncConflictStale = "stale"
+	// SyncConflictDeleted means the task was deleted on the server after the edit's base version.
+	SyncConflictDeleted = "deleted"
+	// SyncConflictForbidden means the task belongs to another user.
+	SyncConflictForbidden = "forbidden"
+	// SyncConflictRejected means the edit failed validation.
+	SyncConflictRejected = "rejected"
+)
+
+// SyncConflict is an edit that was not applied. Task is the server's current task,
+// or nil if it does not exist on the server or belongs to another user.
+type SyncConflict struct {
+	TaskID  string `json:"task_id"`
+	Reason  string `json:"reason"`
+	Message string `json:"message,omitempty"`
+	Task    *Task  `json:"task,omitempty"`
+}
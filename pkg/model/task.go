@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type Task struct {
 	ID        string  `json:"id"`
 	UserID    string  `json:"user_id"`
@@ -7,4 +9,9 @@ type Task struct {
 	Completed bool    `json:"completed"`
 	Parent    *string `json:"parent"`
 	Reminder  *string `json:"reminder"`
+	// Version is the change sequence of the task's latest change and UpdatedAt is when it happened.
+	// Both are set by the server and ignored when creating or updating a task, except that sync only applies an edit
+	// while the task is still at the version it was resolved against.
+	Version   int64      `json:"version,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
 }
@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// User is someone who has made an authenticated request, identified by the user ID of their token.
+// Email comes from their token and is refreshed as it changes. Name and Timezone are filled in from their token
+// when the user is first seen and are then theirs to change, with an empty Timezone meaning UTC.
+type User struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	Timezone  string    `json:"timezone"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UserUpdate changes the preferences of a user. Fields left out are not changed.
+type UserUpdate struct {
+	Name     *string `json:"name"`
+	Timezone *string `json:"timezone"`
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook event types a subscription can select. task.completed is sent along with task.created or task.updated
+// whenever a task is created completed or an update completes it.
+const (
+	WebhookTaskCreated   = "task.created"
+	WebhookTaskUpdated   = "task.updated"
+	WebhookTaskCompleted = "task.completed"
+	WebhookTaskDeleted   = "task.deleted"
+	// WebhookTest is only sent by the "send test event" action and cannot be selected.
+	WebhookTest = "webhook.test"
+)
+
+// Webhook is a user's subscription to task events, delivered by POSTing to URL.
+// Secret signs each delivery and is only returned when the webhook is created.
+type Webhook struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookPayload is the JSON body POSTed to a webhook.
+type WebhookPayload struct {
+	Event     string    `json:"event"`
+	TaskID    string    `json:"task_id,omitempty"`
+	Task      *Task     `json:"task,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Webhook delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	// DeliveryDead marks a delivery that failed every attempt and was moved to the dead-letter list.
+	DeliveryDead = "dead"
+)
+
+// WebhookDelivery is a queued or completed attempt to send an event to a webhook.
+// URL and Secret are copied from the webhook when the delivery is claimed for sending.
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	WebhookID      string          `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastError      string          `json:"last_error,omitempty"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	URL            string          `json:"-"`
+	Secret         string          `json:"-"`
+}
@@ -0,0 +1,4 @@
+// Package tasksv1 is the generated code for the tasks.v1 gRPC service defined in proto/tasks/v1/tasks.proto.
+package tasksv1
+
+//go:generate protoc -I ../../../../proto --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative tasks/v1/tasks.proto